@@ -2131,3 +2131,633 @@ CREATE TABLE IF NOT EXISTS title_leaderboards (
     last_updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
+
+-- ========================================
+-- SISTEMA DE COMBATE POR OLEADAS
+-- ========================================
+
+-- Tabla de oleadas de batalla
+CREATE TABLE IF NOT EXISTS battle_waves (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    battle_id UUID NOT NULL REFERENCES battles(id) ON DELETE CASCADE,
+    wave_number INTEGER NOT NULL,
+    attacker_units JSONB DEFAULT '{}',
+    defender_units JSONB DEFAULT '{}',
+    attacker_damage INTEGER DEFAULT 0,
+    defender_damage INTEGER DEFAULT 0,
+    attacker_losses JSONB DEFAULT '{}',
+    defender_losses JSONB DEFAULT '{}',
+    combat_log JSONB DEFAULT '[]',
+    duration INTEGER DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(battle_id, wave_number)
+);
+
+-- Tabla de terrenos de batalla (bonuses/penalties por categoría de unidad)
+CREATE TABLE IF NOT EXISTS battle_terrains (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL,
+    description TEXT DEFAULT '',
+    type VARCHAR(50) NOT NULL UNIQUE,
+    bonuses TEXT DEFAULT '{}',
+    penalties TEXT DEFAULT '{}',
+    icon VARCHAR(100) DEFAULT '',
+    model VARCHAR(100) DEFAULT '',
+    color VARCHAR(7) DEFAULT '',
+    is_active BOOLEAN DEFAULT true,
+    is_advanced BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Tabla de climas de batalla (effects globales y unit_modifiers por categoría)
+CREATE TABLE IF NOT EXISTS battle_weathers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL,
+    description TEXT DEFAULT '',
+    type VARCHAR(50) NOT NULL UNIQUE,
+    effects TEXT DEFAULT '{}',
+    unit_modifiers TEXT DEFAULT '{}',
+    icon VARCHAR(100) DEFAULT '',
+    particle_effect VARCHAR(100) DEFAULT '',
+    is_active BOOLEAN DEFAULT true,
+    is_advanced BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Tabla de formaciones de batalla
+CREATE TABLE IF NOT EXISTS battle_formations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT DEFAULT '',
+    type VARCHAR(20) NOT NULL,
+    layout TEXT DEFAULT '{}',
+    bonuses TEXT DEFAULT '{}',
+    penalties TEXT DEFAULT '{}',
+    requirements TEXT DEFAULT '{}',
+    min_units INTEGER DEFAULT 0,
+    max_units INTEGER DEFAULT 0,
+    icon VARCHAR(100) DEFAULT '',
+    preview VARCHAR(255) DEFAULT '',
+    is_active BOOLEAN DEFAULT true,
+    is_advanced BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Tabla de tácticas de batalla
+CREATE TABLE IF NOT EXISTS battle_tactics (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT DEFAULT '',
+    type VARCHAR(20) NOT NULL,
+    effects TEXT DEFAULT '{}',
+    target VARCHAR(20) DEFAULT 'self',
+    duration INTEGER DEFAULT 0,
+    cost TEXT DEFAULT '{}',
+    requirements TEXT DEFAULT '{}',
+    icon VARCHAR(100) DEFAULT '',
+    animation VARCHAR(100) DEFAULT '',
+    is_active BOOLEAN DEFAULT true,
+    is_advanced BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_battle_waves_battle_id ON battle_waves(battle_id);
+
+-- Terrenos y climas básicos
+INSERT INTO battle_terrains (name, description, type, bonuses, penalties) VALUES
+('Llanura', 'Terreno abierto que favorece a la caballería', 'plain', '{"cavalry": 0.15}', '{}'),
+('Bosque', 'La cobertura favorece a los arqueros y frena a la caballería', 'forest', '{"archer": 0.2}', '{"cavalry": 0.2}'),
+('Montaña', 'Terreno escarpado, difícil para asedio y caballería', 'mountain', '{"infantry": 0.1}', '{"cavalry": 0.3, "siege": 0.2}')
+ON CONFLICT (type) DO NOTHING;
+
+INSERT INTO battle_weathers (name, description, type, effects, unit_modifiers) VALUES
+('Soleado', 'Sin efectos especiales', 'sunny', '{}', '{}'),
+('Lluvia', 'La lluvia reduce la eficacia de los arqueros', 'rainy', '{"attack": -0.05}', '{"archer": -0.2}'),
+('Niebla', 'La visibilidad reducida dificulta la defensa', 'foggy', '{"defense": -0.1}', '{"archer": -0.1}')
+ON CONFLICT (type) DO NOTHING;
+
+-- Datos de repetición de batallas (semilla y ejércitos de entrada)
+ALTER TABLE battles ADD COLUMN IF NOT EXISTS seed BIGINT DEFAULT 0 NOT NULL;
+ALTER TABLE battles ADD COLUMN IF NOT EXISTS attacker_army TEXT DEFAULT '' NOT NULL;
+ALTER TABLE battles ADD COLUMN IF NOT EXISTS defender_army TEXT DEFAULT '' NOT NULL;
+
+-- =====================================================
+-- SISTEMA DE MARCHAS
+-- =====================================================
+
+-- Tabla de marchas de tropas entre aldeas
+CREATE TABLE IF NOT EXISTS marches (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    player_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    source_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    target_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('attack', 'reinforcement', 'scout')),
+    status VARCHAR(20) NOT NULL DEFAULT 'in_transit' CHECK (status IN ('in_transit', 'returning', 'completed', 'cancelled')),
+    units TEXT NOT NULL DEFAULT '{}',
+    loot TEXT NOT NULL DEFAULT '{}',
+    distance DOUBLE PRECISION NOT NULL DEFAULT 0,
+    departure_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    arrival_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    return_time TIMESTAMP WITH TIME ZONE,
+    battle_id UUID REFERENCES battles(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_marches_player_id ON marches(player_id);
+CREATE INDEX IF NOT EXISTS idx_marches_target_village_id ON marches(target_village_id);
+CREATE INDEX IF NOT EXISTS idx_marches_arrival ON marches(status, arrival_time);
+CREATE INDEX IF NOT EXISTS idx_marches_return ON marches(status, return_time);
+
+-- El planificador reclama cada llegada pasándola a 'resolving' antes de aplicarla
+ALTER TABLE marches DROP CONSTRAINT IF EXISTS marches_status_check;
+ALTER TABLE marches ADD CONSTRAINT marches_status_check CHECK (status IN ('in_transit', 'returning', 'resolving', 'completed', 'cancelled'));
+
+-- Botín saqueado en ataques entre aldeas
+ALTER TABLE battles ADD COLUMN IF NOT EXISTS loot TEXT DEFAULT '' NOT NULL;
+
+-- Informes de exploración
+CREATE TABLE IF NOT EXISTS intel_reports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    player_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    march_id UUID NOT NULL REFERENCES marches(id) ON DELETE CASCADE,
+    source_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    target_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    target_player_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    success BOOLEAN NOT NULL DEFAULT false,
+    scouts_sent INTEGER NOT NULL DEFAULT 0,
+    scouts_lost INTEGER NOT NULL DEFAULT 0,
+    defender_scouts_lost INTEGER NOT NULL DEFAULT 0,
+    accuracy DOUBLE PRECISION NOT NULL DEFAULT 0,
+    resources TEXT NOT NULL DEFAULT 'null',
+    buildings TEXT NOT NULL DEFAULT 'null',
+    garrison TEXT NOT NULL DEFAULT 'null',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_intel_reports_player_id ON intel_reports(player_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_intel_reports_target_village_id ON intel_reports(target_village_id);
+
+-- =====================================================
+-- DEFENSAS DE ALDEA (MURALLA Y TORRES)
+-- =====================================================
+
+-- Daños de asedio pendientes de reparar
+ALTER TABLE buildings ADD COLUMN IF NOT EXISTS damage INTEGER DEFAULT 0 NOT NULL;
+
+-- Estadísticas defensivas en la configuración de edificios
+ALTER TABLE building_configs ADD COLUMN IF NOT EXISTS defense_bonus DOUBLE PRECISION DEFAULT 0 NOT NULL;
+ALTER TABLE building_configs ADD COLUMN IF NOT EXISTS tower_damage INTEGER DEFAULT 0 NOT NULL;
+ALTER TABLE building_configs ADD COLUMN IF NOT EXISTS durability INTEGER DEFAULT 0 NOT NULL;
+
+-- Muralla: +5% de defensa por nivel y 100 puntos de estructura por nivel
+INSERT INTO building_configs (type, level, wood_cost, stone_cost, food_cost, gold_cost, build_time_seconds, defense_bonus, durability)
+SELECT 'wall', lvl, 60 * lvl, 120 * lvl, 20 * lvl, 10 * lvl, 240 * lvl, 0.05 * lvl, 100 * lvl
+FROM generate_series(1, 20) AS lvl
+WHERE NOT EXISTS (SELECT 1 FROM building_configs WHERE type = 'wall' AND level = lvl);
+
+-- Torre: 150 puntos de daño previo a la batalla por nivel
+INSERT INTO building_configs (type, level, wood_cost, stone_cost, food_cost, gold_cost, build_time_seconds, tower_damage)
+SELECT 'tower', lvl, 100 * lvl, 80 * lvl, 30 * lvl, 25 * lvl, 300 * lvl, 150 * lvl
+FROM generate_series(1, 20) AS lvl
+WHERE NOT EXISTS (SELECT 1 FROM building_configs WHERE type = 'tower' AND level = lvl);
+
+-- =====================================================
+-- TROPAS DE APOYO ESTACIONADAS EN ALDEAS ALIADAS
+-- =====================================================
+
+CREATE TABLE IF NOT EXISTS stationed_troops (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    owner_player_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    origin_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    host_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    unit_type VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+    arrived_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (origin_village_id, host_village_id, unit_type)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stationed_troops_host ON stationed_troops(host_village_id);
+CREATE INDEX IF NOT EXISTS idx_stationed_troops_owner ON stationed_troops(owner_player_id);
+
+-- =====================================================
+-- COLA DE ENTRENAMIENTO DE UNIDADES
+-- =====================================================
+
+CREATE TABLE IF NOT EXISTS training_batches (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    building VARCHAR(50) NOT NULL,
+    unit_type VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    completed INTEGER NOT NULL DEFAULT 0,
+    unit_time INTEGER NOT NULL,
+    cost_wood INTEGER NOT NULL DEFAULT 0,
+    cost_stone INTEGER NOT NULL DEFAULT 0,
+    cost_food INTEGER NOT NULL DEFAULT 0,
+    cost_gold INTEGER NOT NULL DEFAULT 0,
+    position INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'training', 'completed', 'cancelled')),
+    start_time TIMESTAMP WITH TIME ZONE,
+    next_completion_time TIMESTAMP WITH TIME ZONE,
+    end_time TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_training_batches_queue ON training_batches(village_id, building, status, position);
+CREATE INDEX IF NOT EXISTS idx_training_batches_due ON training_batches(next_completion_time) WHERE status = 'training';
+
+-- =====================================================
+-- MAPA DEL MUNDO
+-- =====================================================
+
+-- Metadatos del mapa generado de cada mundo
+CREATE TABLE IF NOT EXISTS world_maps (
+    world_id UUID PRIMARY KEY REFERENCES worlds(id) ON DELETE CASCADE,
+    seed BIGINT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    chunk_size INTEGER NOT NULL,
+    generated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Terreno del mapa en bloques cuadrados (un carácter por casilla)
+CREATE TABLE IF NOT EXISTS world_map_chunks (
+    world_id UUID NOT NULL REFERENCES world_maps(world_id) ON DELETE CASCADE,
+    chunk_x INTEGER NOT NULL,
+    chunk_y INTEGER NOT NULL,
+    terrain TEXT NOT NULL,
+    PRIMARY KEY (world_id, chunk_x, chunk_y)
+);
+
+-- Oasis y campamentos bárbaros
+CREATE TABLE IF NOT EXISTS map_sites (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    world_id UUID NOT NULL REFERENCES world_maps(world_id) ON DELETE CASCADE,
+    x INTEGER NOT NULL,
+    y INTEGER NOT NULL,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('oasis', 'barbarian_camp')),
+    resource VARCHAR(20) NOT NULL DEFAULT '',
+    bonus DOUBLE PRECISION NOT NULL DEFAULT 0,
+    level INTEGER NOT NULL DEFAULT 0,
+    garrison TEXT NOT NULL DEFAULT 'null',
+    owner_village_id UUID REFERENCES villages(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (world_id, x, y)
+);
+
+CREATE INDEX IF NOT EXISTS idx_map_sites_owner ON map_sites(owner_village_id) WHERE owner_village_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_villages_world_coordinates ON villages(world_id, x_coordinate, y_coordinate);
+
+-- =====================================================
+-- PROTECCIÓN: ESCUDO DE PRINCIPIANTE, VACACIONES Y ESCUDOS DE PAZ
+-- =====================================================
+
+CREATE TABLE IF NOT EXISTS player_protections (
+    player_id UUID PRIMARY KEY REFERENCES players(id) ON DELETE CASCADE,
+    beginner_forfeited_at TIMESTAMP WITH TIME ZONE,
+    vacation_started_at TIMESTAMP WITH TIME ZONE,
+    vacation_until TIMESTAMP WITH TIME ZONE,
+    vacation_cooldown_until TIMESTAMP WITH TIME ZONE,
+    peace_until TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- =====================================================
+-- EXPANSIÓN: CULTURA, COLONIZACIÓN Y CONQUISTA
+-- =====================================================
+
+-- Cultura acumulada que limita el número de aldeas de cada jugador
+ALTER TABLE players ADD COLUMN IF NOT EXISTS culture_points DOUBLE PRECISION DEFAULT 0 NOT NULL;
+ALTER TABLE players ADD COLUMN IF NOT EXISTS culture_updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL;
+
+-- Lealtad de las aldeas; los nobles la reducen y se regenera con el tiempo
+ALTER TABLE villages ADD COLUMN IF NOT EXISTS loyalty INTEGER DEFAULT 100 NOT NULL CHECK (loyalty >= 0);
+ALTER TABLE villages ADD COLUMN IF NOT EXISTS loyalty_updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL;
+
+-- Las marchas de colonización se dirigen a una casilla libre en lugar de a una aldea
+ALTER TABLE marches ALTER COLUMN target_village_id DROP NOT NULL;
+ALTER TABLE marches ADD COLUMN IF NOT EXISTS target_x INTEGER;
+ALTER TABLE marches ADD COLUMN IF NOT EXISTS target_y INTEGER;
+ALTER TABLE marches DROP CONSTRAINT IF EXISTS marches_type_check;
+ALTER TABLE marches ADD CONSTRAINT marches_type_check CHECK (type IN ('attack', 'reinforcement', 'scout', 'settle'));
+
+-- =====================================================
+-- PRODUCCIÓN DE RECURSOS CONFIGURABLE
+-- =====================================================
+
+-- Recurso que genera cada edificio; la producción base sale de la configuración
+ALTER TABLE building_configs ADD COLUMN IF NOT EXISTS produced_resource VARCHAR(10) CHECK (produced_resource IN ('wood', 'stone', 'food', 'gold'));
+
+UPDATE building_configs SET produced_resource = 'wood' WHERE type = 'wood_cutter' AND produced_resource IS NULL;
+UPDATE building_configs SET produced_resource = 'stone' WHERE type = 'stone_quarry' AND produced_resource IS NULL;
+UPDATE building_configs SET produced_resource = 'food' WHERE type = 'farm' AND produced_resource IS NULL;
+UPDATE building_configs SET produced_resource = 'gold' WHERE type = 'gold_mine' AND produced_resource IS NULL;
+
+-- =====================================================
+-- REGLAS DE JUEGO POR MUNDO
+-- =====================================================
+
+-- Velocidades, multiplicadores y límites de cada mundo; sin fila se usan las reglas clásicas
+CREATE TABLE IF NOT EXISTS world_settings (
+    world_id UUID PRIMARY KEY REFERENCES worlds(id) ON DELETE CASCADE,
+    game_speed DOUBLE PRECISION DEFAULT 1 NOT NULL CHECK (game_speed > 0),
+    unit_speed DOUBLE PRECISION DEFAULT 1 NOT NULL CHECK (unit_speed > 0),
+    production_multiplier DOUBLE PRECISION DEFAULT 1 NOT NULL CHECK (production_multiplier > 0),
+    storage_multiplier DOUBLE PRECISION DEFAULT 1 NOT NULL CHECK (storage_multiplier > 0),
+    max_villages INTEGER DEFAULT 0 NOT NULL CHECK (max_villages >= 0),
+    morale_enabled BOOLEAN DEFAULT false NOT NULL,
+    protection_hours INTEGER DEFAULT 72 NOT NULL CHECK (protection_hours >= 0),
+    end_condition VARCHAR(20) DEFAULT 'none' NOT NULL CHECK (end_condition IN ('none', 'deadline', 'domination')),
+    ends_at TIMESTAMP WITH TIME ZONE,
+    domination_percent DOUBLE PRECISION DEFAULT 0 NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- =====================================================
+-- COLA DE CONSTRUCCIÓN
+-- =====================================================
+
+-- Órdenes de mejora de cada aldea. Las que esperan en cola pagan su coste al empezar.
+CREATE TABLE IF NOT EXISTS construction_orders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    building_type VARCHAR(50) NOT NULL,
+    target_level INTEGER NOT NULL CHECK (target_level > 0),
+    position INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'building', 'completed', 'cancelled')),
+    cost_wood INTEGER NOT NULL DEFAULT 0,
+    cost_stone INTEGER NOT NULL DEFAULT 0,
+    cost_food INTEGER NOT NULL DEFAULT 0,
+    cost_gold INTEGER NOT NULL DEFAULT 0,
+    start_time TIMESTAMP WITH TIME ZONE,
+    end_time TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_construction_orders_queue ON construction_orders(village_id, status, position);
+CREATE INDEX IF NOT EXISTS idx_construction_orders_due ON construction_orders(end_time) WHERE status = 'building';
+
+-- Huecos de construcción adicionales comprados con moneda global
+ALTER TABLE players ADD COLUMN IF NOT EXISTS construction_premium_until TIMESTAMP WITH TIME ZONE;
+
+-- =====================================================
+-- DEMOLICIÓN DE EDIFICIOS
+-- =====================================================
+
+-- Las órdenes de construcción pueden bajar un edificio de nivel (hasta 0)
+ALTER TABLE construction_orders ADD COLUMN IF NOT EXISTS action VARCHAR(20) DEFAULT 'upgrade' NOT NULL CHECK (action IN ('upgrade', 'demolish'));
+ALTER TABLE construction_orders DROP CONSTRAINT IF EXISTS construction_orders_target_level_check;
+ALTER TABLE construction_orders ADD CONSTRAINT construction_orders_target_level_check CHECK (target_level >= 0);
+
+-- Fracción del coste del nivel que se devuelve al demoler
+ALTER TABLE world_settings ADD COLUMN IF NOT EXISTS demolition_refund DOUBLE PRECISION DEFAULT 0.5 NOT NULL CHECK (demolition_refund >= 0 AND demolition_refund <= 1);
+
+-- =====================================================
+-- MERCADO DE RECURSOS CON DEPÓSITO
+-- =====================================================
+
+-- Ofertas del mercado. amount es lo que queda por vender y está retirado de la aldea del
+-- vendedor hasta que se vende o se cancela la oferta
+CREATE TABLE IF NOT EXISTS trade_offers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    seller_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    resource_type VARCHAR(20) NOT NULL CHECK (resource_type IN ('wood', 'stone', 'food')),
+    amount INTEGER NOT NULL CHECK (amount >= 0),
+    price_per_unit INTEGER NOT NULL CHECK (price_per_unit > 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'completed', 'cancelled')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_trade_offers_active ON trade_offers(resource_type, price_per_unit) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_trade_offers_seller ON trade_offers(seller_id);
+
+-- Compras realizadas sobre las ofertas; el pago es en oro
+CREATE TABLE IF NOT EXISTS trade_transactions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    offer_id UUID NOT NULL REFERENCES trade_offers(id) ON DELETE CASCADE,
+    seller_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    buyer_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    seller_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    buyer_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    resource_type VARCHAR(20) NOT NULL,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    price_per_unit INTEGER NOT NULL,
+    total_price INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_trade_transactions_seller ON trade_transactions(seller_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_trade_transactions_buyer ON trade_transactions(buyer_id, created_at DESC);
+
+-- =====================================================
+-- MERCADERES Y ENVÍOS ENTRE ALDEAS
+-- =====================================================
+
+-- Mercaderes que da el mercado y recursos que carga cada uno
+ALTER TABLE building_configs ADD COLUMN IF NOT EXISTS merchants INTEGER DEFAULT 0 NOT NULL;
+ALTER TABLE building_configs ADD COLUMN IF NOT EXISTS merchant_capacity INTEGER DEFAULT 0 NOT NULL;
+
+-- Mercado: un mercader por nivel, cada uno con 500 recursos de carga
+UPDATE building_configs SET merchants = level, merchant_capacity = 500 WHERE type = 'marketplace' AND merchants = 0;
+INSERT INTO building_configs (type, level, wood_cost, stone_cost, food_cost, gold_cost, build_time_seconds, merchants, merchant_capacity)
+SELECT 'marketplace', lvl, 120 * lvl, 100 * lvl, 60 * lvl, 0, 150 * lvl, lvl, 500
+FROM generate_series(1, 20) AS lvl
+WHERE NOT EXISTS (SELECT 1 FROM building_configs WHERE type = 'marketplace' AND level = lvl);
+
+-- Intercambios directos; lo ofrecido queda en depósito mientras están pendientes
+CREATE TABLE IF NOT EXISTS direct_trades (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    initiator_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    initiator_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    target_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    target_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    offered_resource VARCHAR(20) NOT NULL CHECK (offered_resource IN ('wood', 'stone', 'food', 'gold')),
+    offered_amount INTEGER NOT NULL CHECK (offered_amount > 0),
+    requested_resource VARCHAR(20) NOT NULL CHECK (requested_resource IN ('wood', 'stone', 'food', 'gold')),
+    requested_amount INTEGER NOT NULL CHECK (requested_amount > 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined', 'expired')),
+    message TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_direct_trades_initiator ON direct_trades(initiator_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_direct_trades_target ON direct_trades(target_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_direct_trades_expiry ON direct_trades(expires_at) WHERE status = 'pending';
+
+-- Viajes de mercaderes: la mercancía se entrega al llegar y los mercaderes siguen ocupados hasta volver
+CREATE TABLE IF NOT EXISTS merchant_shipments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    player_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    source_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    target_village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    receiver_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    transaction_id UUID REFERENCES trade_transactions(id) ON DELETE SET NULL,
+    direct_trade_id UUID REFERENCES direct_trades(id) ON DELETE SET NULL,
+    wood INTEGER NOT NULL DEFAULT 0,
+    stone INTEGER NOT NULL DEFAULT 0,
+    food INTEGER NOT NULL DEFAULT 0,
+    gold INTEGER NOT NULL DEFAULT 0,
+    merchants INTEGER NOT NULL CHECK (merchants > 0),
+    distance DOUBLE PRECISION NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'in_transit' CHECK (status IN ('in_transit', 'returning', 'completed')),
+    departure_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    arrival_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    return_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_merchant_shipments_source ON merchant_shipments(source_village_id) WHERE status IN ('in_transit', 'returning');
+CREATE INDEX IF NOT EXISTS idx_merchant_shipments_target ON merchant_shipments(target_village_id) WHERE status = 'in_transit';
+CREATE INDEX IF NOT EXISTS idx_merchant_shipments_arrival ON merchant_shipments(arrival_time) WHERE status = 'in_transit';
+CREATE INDEX IF NOT EXISTS idx_merchant_shipments_return ON merchant_shipments(return_time) WHERE status = 'returning';
+
+-- =====================================================
+-- BOLSA DE RECURSOS: LIBRO DE ÓRDENES POR MUNDO
+-- =====================================================
+
+-- Órdenes limitadas de compra y venta de cada recurso en oro. Las ventas dejan el recurso en
+-- depósito y las compras el oro de la cantidad al precio límite.
+CREATE TABLE IF NOT EXISTS market_orders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    world_id UUID NOT NULL REFERENCES worlds(id) ON DELETE CASCADE,
+    player_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    resource_type VARCHAR(20) NOT NULL CHECK (resource_type IN ('wood', 'stone', 'food')),
+    side VARCHAR(4) NOT NULL CHECK (side IN ('buy', 'sell')),
+    price INTEGER NOT NULL CHECK (price > 0),
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    filled INTEGER NOT NULL DEFAULT 0 CHECK (filled >= 0 AND filled <= quantity),
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'filled', 'cancelled')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Prioridad precio-tiempo de cada lado del libro
+CREATE INDEX IF NOT EXISTS idx_market_orders_book ON market_orders(world_id, resource_type, side, price, created_at) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS idx_market_orders_player ON market_orders(player_id, created_at DESC) WHERE status = 'open';
+
+-- Los cruces de la bolsa se registran como transacciones sin oferta
+ALTER TABLE trade_transactions ALTER COLUMN offer_id DROP NOT NULL;
+ALTER TABLE trade_transactions ADD COLUMN IF NOT EXISTS buy_order_id UUID REFERENCES market_orders(id) ON DELETE SET NULL;
+ALTER TABLE trade_transactions ADD COLUMN IF NOT EXISTS sell_order_id UUID REFERENCES market_orders(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_trade_transactions_resource ON trade_transactions(resource_type, created_at DESC);
+
+-- =====================================================
+-- HISTORIAL DE PRECIOS: VELAS OHLC DE LA BOLSA
+-- =====================================================
+
+-- Velas por mundo, recurso e intervalo (1m, 1h, 1d) agregadas desde trade_transactions.
+-- Turnover es el oro movido; turnover / volume es el precio medio ponderado de la vela.
+CREATE TABLE IF NOT EXISTS price_candles (
+    world_id UUID NOT NULL REFERENCES worlds(id) ON DELETE CASCADE,
+    resource_type VARCHAR(20) NOT NULL,
+    period VARCHAR(2) NOT NULL CHECK (period IN ('1m', '1h', '1d')),
+    bucket_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    open INTEGER NOT NULL,
+    high INTEGER NOT NULL,
+    low INTEGER NOT NULL,
+    close INTEGER NOT NULL,
+    volume BIGINT NOT NULL,
+    turnover BIGINT NOT NULL,
+    trades INTEGER NOT NULL,
+    PRIMARY KEY (world_id, resource_type, period, bucket_start)
+);
+
+-- Última vela de cada intervalo para el agregador y borrado de velas caducadas
+CREATE INDEX IF NOT EXISTS idx_price_candles_period ON price_candles(period, bucket_start);
+-- Recálculo de las velas de un minuto desde los cruces recientes
+CREATE INDEX IF NOT EXISTS idx_trade_transactions_created ON trade_transactions(created_at);
+
+-- =====================================================
+-- MERCADER NPC: RESERVAS DE PRODUCTO CONSTANTE POR MUNDO
+-- =====================================================
+
+-- Configuración del sistema de economía, con los parámetros del mercader NPC
+CREATE TABLE IF NOT EXISTS economy_system_config (
+    id SERIAL PRIMARY KEY,
+    is_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    primary_currency_name VARCHAR(50) NOT NULL DEFAULT 'Silver',
+    secondary_currency_name VARCHAR(50) NOT NULL DEFAULT 'Gold',
+    primary_currency_symbol VARCHAR(10) NOT NULL DEFAULT 'S',
+    secondary_currency_symbol VARCHAR(10) NOT NULL DEFAULT 'G',
+    exchange_rate DOUBLE PRECISION NOT NULL DEFAULT 100.0,
+    exchange_fee DOUBLE PRECISION NOT NULL DEFAULT 0.05,
+    min_exchange_amount INTEGER NOT NULL DEFAULT 100,
+    max_exchange_amount INTEGER NOT NULL DEFAULT 1000000,
+    market_tax DOUBLE PRECISION NOT NULL DEFAULT 0.02,
+    transaction_fee DOUBLE PRECISION NOT NULL DEFAULT 0.01,
+    max_price_fluctuation DOUBLE PRECISION NOT NULL DEFAULT 0.50,
+    price_update_interval INTEGER NOT NULL DEFAULT 15,
+    max_items_per_player INTEGER NOT NULL DEFAULT 100,
+    max_active_listings INTEGER NOT NULL DEFAULT 10,
+    min_listing_duration INTEGER NOT NULL DEFAULT 1,
+    max_listing_duration INTEGER NOT NULL DEFAULT 168,
+    advanced_config JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE economy_system_config ADD COLUMN IF NOT EXISTS amm_enabled BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE economy_system_config ADD COLUMN IF NOT EXISTS amm_fee DOUBLE PRECISION NOT NULL DEFAULT 0.02 CHECK (amm_fee >= 0 AND amm_fee < 1);
+ALTER TABLE economy_system_config ADD COLUMN IF NOT EXISTS amm_initial_reserve BIGINT NOT NULL DEFAULT 200000 CHECK (amm_initial_reserve > 0);
+ALTER TABLE economy_system_config ADD COLUMN IF NOT EXISTS amm_initial_gold_reserve BIGINT NOT NULL DEFAULT 50000 CHECK (amm_initial_gold_reserve > 0);
+ALTER TABLE economy_system_config ADD COLUMN IF NOT EXISTS amm_max_price_impact DOUBLE PRECISION NOT NULL DEFAULT 0.15 CHECK (amm_max_price_impact > 0 AND amm_max_price_impact <= 1);
+
+-- Reservas del mercader de cada mundo; se crean con las reservas iniciales en el primer uso
+CREATE TABLE IF NOT EXISTS market_pools (
+    world_id UUID PRIMARY KEY REFERENCES worlds(id) ON DELETE CASCADE,
+    wood BIGINT NOT NULL CHECK (wood > 0),
+    stone BIGINT NOT NULL CHECK (stone > 0),
+    food BIGINT NOT NULL CHECK (food > 0),
+    gold BIGINT NOT NULL CHECK (gold > 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Cambios ejecutados con el mercader; fee es la parte de amount_in que se queda en la reserva
+CREATE TABLE IF NOT EXISTS market_pool_swaps (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    world_id UUID NOT NULL REFERENCES worlds(id) ON DELETE CASCADE,
+    player_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    village_id UUID NOT NULL REFERENCES villages(id) ON DELETE CASCADE,
+    from_resource VARCHAR(20) NOT NULL CHECK (from_resource IN ('wood', 'stone', 'food', 'gold')),
+    to_resource VARCHAR(20) NOT NULL CHECK (to_resource IN ('wood', 'stone', 'food', 'gold')),
+    amount_in INTEGER NOT NULL CHECK (amount_in > 0),
+    amount_out INTEGER NOT NULL CHECK (amount_out > 0),
+    fee INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_market_pool_swaps_player ON market_pool_swaps(player_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_market_pool_swaps_world ON market_pool_swaps(world_id, created_at DESC);
+
+-- Los intercambios directos pendientes se cancelan cuando la aldea de una de las partes es conquistada
+ALTER TABLE direct_trades DROP CONSTRAINT IF EXISTS direct_trades_status_check;
+ALTER TABLE direct_trades ADD CONSTRAINT direct_trades_status_check CHECK (status IN ('pending', 'accepted', 'declined', 'expired', 'cancelled'));
+
+-- Dos aldeas no pueden ocupar la misma casilla: la aparición reintenta si otra se le adelanta
+DROP INDEX IF EXISTS idx_villages_world_coordinates;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_villages_world_coordinates_unique ON villages(world_id, x_coordinate, y_coordinate);
+
+-- Historial de periodos de vacaciones: la producción se congela durante todos ellos, no solo el último
+CREATE TABLE IF NOT EXISTS player_vacations (
+    player_id UUID NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    until TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (player_id, started_at)
+);
+
+INSERT INTO player_vacations (player_id, started_at, until)
+SELECT player_id, vacation_started_at, vacation_until
+FROM player_protections
+WHERE vacation_started_at IS NOT NULL AND vacation_until IS NOT NULL
+ON CONFLICT (player_id, started_at) DO NOTHING;
@@ -21,15 +21,18 @@ type AuthHandler struct {
 	logger       *zap.Logger
 	redisService *services.RedisService
 	villageRepo  *repository.VillageRepository
+
+	protectionService *services.ProtectionService
 }
 
-func NewAuthHandler(playerRepo *repository.PlayerRepository, jwtManager *auth.JWTManager, logger *zap.Logger, redisService *services.RedisService, villageRepo *repository.VillageRepository) *AuthHandler {
+func NewAuthHandler(playerRepo *repository.PlayerRepository, jwtManager *auth.JWTManager, logger *zap.Logger, redisService *services.RedisService, villageRepo *repository.VillageRepository, protectionService *services.ProtectionService) *AuthHandler {
 	return &AuthHandler{
-		playerRepo:   playerRepo,
-		jwtManager:   jwtManager,
-		logger:       logger,
-		redisService: redisService,
-		villageRepo:  villageRepo,
+		playerRepo:        playerRepo,
+		jwtManager:        jwtManager,
+		logger:            logger,
+		redisService:      redisService,
+		villageRepo:       villageRepo,
+		protectionService: protectionService,
 	}
 }
 
@@ -247,6 +250,16 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 		h.logger.Warn("No se encontraron aldeas para el jugador", zap.String("player_id", playerID.String()))
 	}
 
+	// Escudos y modo vacaciones
+	if h.protectionService != nil && len(player.Villages) > 0 {
+		protection, err := h.protectionService.GetPlayerProtection(playerID)
+		if err != nil {
+			h.logger.Warn("Error obteniendo protección del jugador", zap.Error(err))
+		} else {
+			player.Protection = protection
+		}
+	}
+
 	c.JSON(http.StatusOK, player)
 }
 
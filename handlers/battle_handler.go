@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"server-backend/models"
 	"server-backend/repository"
 	"server-backend/services"
 
+	"github.com/gin-gonic/gin"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -26,10 +28,9 @@ func NewBattleHandler(
 	battleRepo *repository.BattleRepository,
 	villageRepo *repository.VillageRepository,
 	unitRepo *repository.UnitRepository,
+	battleService *services.BattleService,
 	logger *zap.Logger,
-	redisService *services.RedisService,
 ) *BattleHandler {
-	battleService := services.NewBattleService(battleRepo, villageRepo, unitRepo, logger, redisService)
 	return &BattleHandler{
 		battleRepo:    battleRepo,
 		villageRepo:   villageRepo,
@@ -549,6 +550,97 @@ func (h *BattleHandler) GetBattleLog(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// ReplayBattle re-simula una batalla desde su semilla y devuelve un stream NDJSON
+// con un evento por oleada para que el cliente pueda animarla
+func (h *BattleHandler) ReplayBattle(c *gin.Context) {
+	battleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de batalla inválido"})
+		return
+	}
+
+	// Verificar autorización
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	battle, err := h.battleRepo.GetBattle(battleID)
+	if err != nil {
+		h.logger.Error("Error obteniendo batalla", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Batalla no encontrada"})
+		return
+	}
+
+	if battle.AttackerID != playerID && battle.DefenderID != playerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No autorizado"})
+		return
+	}
+
+	replay, err := h.battleService.ReplayBattle(battleID)
+	if err != nil {
+		h.logger.Error("Error repitiendo batalla", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error repitiendo batalla: " + err.Error()})
+		return
+	}
+
+	// La repetición se emite como flujo y puede durar más que el WriteTimeout del servidor
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.Warn("No se pudo quitar el límite de escritura de la repetición", zap.Error(err))
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	emit := func(event map[string]interface{}) {
+		encoder.Encode(event)
+		c.Writer.Flush()
+	}
+
+	emit(map[string]interface{}{
+		"type":          "replay_start",
+		"battle_id":     replay.BattleID,
+		"seed":          replay.Seed,
+		"stored_winner": replay.StoredWinner,
+		"total_waves":   len(replay.Frames),
+	})
+	for _, frame := range replay.Frames {
+		emit(map[string]interface{}{
+			"type": "wave",
+			"data": frame,
+		})
+	}
+	emit(map[string]interface{}{
+		"type":     "replay_end",
+		"winner":   replay.Winner,
+		"diverged": replay.Diverged,
+	})
+}
+
+// SimulateBattle simula un combate "qué pasaría si" sin comprometer unidades
+func (h *BattleHandler) SimulateBattle(c *gin.Context) {
+	var request models.BattleSimulationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solicitud inválida"})
+		return
+	}
+
+	report, err := h.battleService.SimulateBattle(&request)
+	if err != nil {
+		h.logger.Warn("Error simulando batalla", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error simulando batalla: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    report,
+	})
+}
+
 // CancelBattle cancela una batalla pendiente
 func (h *BattleHandler) CancelBattle(w http.ResponseWriter, r *http.Request) {
 	battleIDStr := chi.URLParam(r, "battleID")
@@ -604,30 +696,50 @@ func (h *BattleHandler) ProcessBattle(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetVillageDefenses obtiene las defensas de una aldea
-func (h *BattleHandler) GetVillageDefenses(w http.ResponseWriter, r *http.Request) {
-	villageIDStr := r.URL.Query().Get("village_id")
+func (h *BattleHandler) GetVillageDefenses(c *gin.Context) {
+	villageIDStr := c.Query("village_id")
 	if villageIDStr == "" {
-		http.Error(w, "ID de aldea requerido", http.StatusBadRequest)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de aldea requerido"})
 		return
 	}
 
 	villageID, err := uuid.Parse(villageIDStr)
 	if err != nil {
-		http.Error(w, "ID de aldea inválido", http.StatusBadRequest)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de aldea inválido"})
+		return
+	}
+
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
 		return
 	}
 
-	// Obtener unidades de la aldea (defensas)
-	// TODO: Implementar cuando se conecte con el sistema de aldeas
-	defenses := map[string]interface{}{
-		"village_id": villageID,
-		"units":      []interface{}{},
-		"buildings":  []interface{}{},
-		"traps":      []interface{}{},
+	village, err := h.villageRepo.GetVillageByID(villageID)
+	if err != nil {
+		h.logger.Error("Error obteniendo aldea", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo aldea"})
+		return
+	}
+	if village == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Aldea no encontrada"})
+		return
+	}
+	if village.Village.PlayerID != playerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No autorizado"})
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	// Guarnición y bonificaciones de muralla y torres
+	defenses, err := h.battleService.GetVillageDefenses(village)
+	if err != nil {
+		h.logger.Error("Error obteniendo defensas", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo defensas"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data":    defenses,
 	})
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"server-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type ExpansionHandler struct {
+	expansionService *services.ExpansionService
+	logger           *zap.Logger
+}
+
+func NewExpansionHandler(expansionService *services.ExpansionService, logger *zap.Logger) *ExpansionHandler {
+	return &ExpansionHandler{
+		expansionService: expansionService,
+		logger:           logger,
+	}
+}
+
+// GetExpansionStatus obtiene la cultura del jugador, las aldeas que puede tener y la lealtad de
+// cada una de sus aldeas
+func (h *ExpansionHandler) GetExpansionStatus(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	status, err := h.expansionService.GetExpansionStatus(playerID)
+	if err != nil {
+		h.logger.Error("Error obteniendo estado de expansión", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo estado de expansión"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    status,
+	})
+}
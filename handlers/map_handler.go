@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"server-backend/models"
+	"server-backend/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type MapHandler struct {
+	mapService *services.MapService
+	logger     *zap.Logger
+}
+
+func NewMapHandler(mapService *services.MapService, logger *zap.Logger) *MapHandler {
+	return &MapHandler{
+		mapService: mapService,
+		logger:     logger,
+	}
+}
+
+// GetWorldMap obtiene los metadatos del mapa de un mundo, generándolo si aún no existe
+func (h *MapHandler) GetWorldMap(c *gin.Context) {
+	worldID, err := uuid.Parse(c.Param("world_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de mundo inválido"})
+		return
+	}
+
+	worldMap, err := h.mapService.EnsureWorldMap(worldID)
+	if err != nil {
+		h.logger.Error("Error obteniendo mapa del mundo", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    worldMap,
+	})
+}
+
+// GetViewport obtiene terreno, emplazamientos y aldeas del rectángulo min_x,min_y - max_x,max_y
+func (h *MapHandler) GetViewport(c *gin.Context) {
+	worldID, err := uuid.Parse(c.Param("world_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de mundo inválido"})
+		return
+	}
+
+	bounds := make([]int, 0, 4)
+	for _, key := range []string{"min_x", "min_y", "max_x", "max_y"} {
+		value, err := strconv.Atoi(c.Query(key))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parámetro " + key + " inválido"})
+			return
+		}
+		bounds = append(bounds, value)
+	}
+
+	viewport, err := h.mapService.GetViewport(worldID, bounds[0], bounds[1], bounds[2], bounds[3])
+	if err != nil {
+		h.logger.Warn("Error obteniendo viewport del mapa", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    viewport,
+	})
+}
+
+// SearchVillages busca aldeas por radio, rectángulo o cercanía con los filtros de la consulta
+func (h *MapHandler) SearchVillages(c *gin.Context) {
+	search, err := parseVillageSearch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	villages, err := h.mapService.SearchVillages(search)
+	if err != nil {
+		h.logger.Warn("Error buscando aldeas", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    villages,
+	})
+}
+
+// FindTargets busca aldeas objetivo cercanas excluyendo las del jugador y las de su alianza
+func (h *MapHandler) FindTargets(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	search, err := parseVillageSearch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	targets, err := h.mapService.FindTargets(playerID, search)
+	if err != nil {
+		h.logger.Warn("Error buscando objetivos", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    targets,
+	})
+}
+
+// parseVillageSearch construye una búsqueda espacial a partir de los parámetros de la consulta
+func parseVillageSearch(c *gin.Context) (*models.VillageSearch, error) {
+	worldID, err := uuid.Parse(c.Param("world_id"))
+	if err != nil {
+		return nil, fmt.Errorf("ID de mundo inválido")
+	}
+	search := &models.VillageSearch{WorldID: worldID}
+
+	ints := map[string]*int{
+		"x":             &search.CenterX,
+		"y":             &search.CenterY,
+		"limit":         &search.Limit,
+		"inactive_days": &search.InactiveDays,
+		"min_score":     &search.MinScore,
+		"max_score":     &search.MaxScore,
+	}
+	for key, target := range ints {
+		if raw := c.Query(key); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 0 {
+				return nil, fmt.Errorf("parámetro %s inválido", key)
+			}
+			*target = value
+		}
+	}
+
+	optionalInts := map[string]**int{
+		"min_x": &search.MinX,
+		"min_y": &search.MinY,
+		"max_x": &search.MaxX,
+		"max_y": &search.MaxY,
+	}
+	for key, target := range optionalInts {
+		if raw := c.Query(key); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, fmt.Errorf("parámetro %s inválido", key)
+			}
+			*target = &value
+		}
+	}
+
+	if raw := c.Query("radius"); raw != "" {
+		radius, err := strconv.ParseFloat(raw, 64)
+		if err != nil || radius <= 0 {
+			return nil, fmt.Errorf("parámetro radius inválido")
+		}
+		search.Radius = radius
+	}
+
+	uuids := map[string]**uuid.UUID{
+		"player_id":           &search.PlayerID,
+		"alliance_id":         &search.AllianceID,
+		"exclude_player_id":   &search.ExcludePlayerID,
+		"exclude_alliance_id": &search.ExcludeAllianceID,
+	}
+	for key, target := range uuids {
+		if raw := c.Query(key); raw != "" {
+			value, err := uuid.Parse(raw)
+			if err != nil {
+				return nil, fmt.Errorf("parámetro %s inválido", key)
+			}
+			*target = &value
+		}
+	}
+
+	return search, nil
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"net/http"
+	"server-backend/models"
+	"server-backend/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type MarchHandler struct {
+	marchService *services.MarchService
+	logger       *zap.Logger
+}
+
+func NewMarchHandler(marchService *services.MarchService, logger *zap.Logger) *MarchHandler {
+	return &MarchHandler{
+		marchService: marchService,
+		logger:       logger,
+	}
+}
+
+// SendMarch envía tropas a otra aldea (ataque, refuerzo o exploración)
+func (h *MarchHandler) SendMarch(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	var req models.MarchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solicitud inválida"})
+		return
+	}
+	req.PlayerID = playerID
+
+	march, err := h.marchService.SendMarch(&req)
+	if err != nil {
+		h.logger.Warn("Error enviando marcha", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    march,
+	})
+}
+
+// GetMarches obtiene las marchas en curso del jugador
+func (h *MarchHandler) GetMarches(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	marches, err := h.marchService.GetPlayerMarches(playerID)
+	if err != nil {
+		h.logger.Error("Error obteniendo marchas", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    marches,
+	})
+}
+
+// GetIncomingMarches obtiene las marchas que se dirigen a una aldea del jugador
+func (h *MarchHandler) GetIncomingMarches(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	villageID, err := uuid.Parse(c.Query("village_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de aldea inválido"})
+		return
+	}
+
+	marches, err := h.marchService.GetIncomingMarches(villageID, playerID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    marches,
+	})
+}
+
+// CancelMarch cancela una marcha en tránsito y hace regresar a las tropas
+func (h *MarchHandler) CancelMarch(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	marchID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de marcha inválido"})
+		return
+	}
+
+	march, err := h.marchService.CancelMarch(marchID, playerID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    march,
+	})
+}
+
+// GetIntelReports obtiene los informes de exploración del jugador
+func (h *MarchHandler) GetIntelReports(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	limit := 20
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+
+	reports, err := h.marchService.GetIntelReports(playerID, limit)
+	if err != nil {
+		h.logger.Error("Error obteniendo informes de exploración", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    reports,
+	})
+}
+
+// GetIntelReport obtiene un informe de exploración concreto
+func (h *MarchHandler) GetIntelReport(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	reportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de informe inválido"})
+		return
+	}
+
+	report, err := h.marchService.GetIntelReport(reportID, playerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    report,
+	})
+}
+
+// GetSupportTroops obtiene las tropas de apoyo del jugador: las enviadas a aliados o, con
+// scope=hosted, las que hay estacionadas en sus aldeas
+func (h *MarchHandler) GetSupportTroops(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	var troops []*models.SupportTroops
+	switch c.DefaultQuery("scope", "sent") {
+	case "sent":
+		troops, err = h.marchService.GetSupportSent(playerID)
+	case "hosted":
+		troops, err = h.marchService.GetSupportHosted(playerID)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope debe ser 'sent' o 'hosted'"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error obteniendo tropas de apoyo", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    troops,
+	})
+}
+
+// RecallSupport devuelve tropas de apoyo a su aldea de origen
+func (h *MarchHandler) RecallSupport(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	var req models.SupportRecallRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solicitud inválida"})
+		return
+	}
+
+	march, err := h.marchService.RecallSupport(playerID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    march,
+	})
+}
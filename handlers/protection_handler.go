@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"server-backend/models"
+	"server-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type ProtectionHandler struct {
+	protectionService *services.ProtectionService
+	logger            *zap.Logger
+}
+
+func NewProtectionHandler(protectionService *services.ProtectionService, logger *zap.Logger) *ProtectionHandler {
+	return &ProtectionHandler{
+		protectionService: protectionService,
+		logger:            logger,
+	}
+}
+
+// GetProtection obtiene el estado de protección del jugador y de sus aldeas
+func (h *ProtectionHandler) GetProtection(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	info, err := h.protectionService.GetPlayerProtection(playerID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    info,
+	})
+}
+
+// ActivateVacation activa el modo vacaciones
+func (h *ProtectionHandler) ActivateVacation(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	var req models.VacationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solicitud inválida"})
+		return
+	}
+
+	info, err := h.protectionService.ActivateVacation(playerID, req.Hours)
+	if err != nil {
+		h.logger.Warn("Error activando modo vacaciones", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    info,
+	})
+}
+
+// EndVacation termina el modo vacaciones antes de tiempo
+func (h *ProtectionHandler) EndVacation(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	info, err := h.protectionService.EndVacation(playerID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    info,
+	})
+}
+
+// BuyPeaceShield compra un escudo de paz
+func (h *ProtectionHandler) BuyPeaceShield(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	var req models.PeaceShieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solicitud inválida"})
+		return
+	}
+
+	info, err := h.protectionService.BuyPeaceShield(playerID, req.Hours)
+	if err != nil {
+		h.logger.Warn("Error comprando escudo de paz", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    info,
+	})
+}
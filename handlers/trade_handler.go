@@ -1,379 +1,663 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"server-backend/models"
 	"server-backend/repository"
+	"server-backend/services"
 
-	"github.com/go-chi/chi/v5"
+	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
 type TradeHandler struct {
-	tradeRepo   *repository.TradeRepository
-	villageRepo *repository.VillageRepository
-	logger      *zap.Logger
+	tradeService       *services.TradeService
+	exchangeService    *services.ExchangeService
+	marketMakerService *services.MarketMakerService
+	logger             *zap.Logger
 }
 
-func NewTradeHandler(tradeRepo *repository.TradeRepository, villageRepo *repository.VillageRepository, logger *zap.Logger) *TradeHandler {
+func NewTradeHandler(tradeService *services.TradeService, exchangeService *services.ExchangeService, marketMakerService *services.MarketMakerService, logger *zap.Logger) *TradeHandler {
 	return &TradeHandler{
-		tradeRepo:   tradeRepo,
-		villageRepo: villageRepo,
-		logger:      logger,
+		tradeService:       tradeService,
+		exchangeService:    exchangeService,
+		marketMakerService: marketMakerService,
+		logger:             logger,
 	}
 }
 
-// CreateTradeOffer crea una nueva oferta de comercio
-func (h *TradeHandler) CreateTradeOffer(w http.ResponseWriter, r *http.Request) {
-	// Obtener playerID del contexto
-	playerIDStr := r.Context().Value("player_id").(string)
-	playerID, err := uuid.Parse(playerIDStr)
+// BuyTradeOfferRequest compra parte de una oferta para una aldea del jugador
+type BuyTradeOfferRequest struct {
+	VillageID uuid.UUID `json:"village_id" binding:"required"`
+	Amount    int       `json:"amount" binding:"required,min=1"`
+}
+
+// respondTradeError responde con el error de comercio y su código HTTP. Los errores internos se
+// registran y se ocultan tras message.
+func (h *TradeHandler) respondTradeError(c *gin.Context, err error, message string) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, repository.ErrTradeOfferNotFound), errors.Is(err, repository.ErrDirectTradeNotFound),
+		errors.Is(err, repository.ErrMarketOrderNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, repository.ErrTradeVillageNotOwned), errors.Is(err, repository.ErrMarketOrderOwnership):
+		status = http.StatusForbidden
+	case errors.Is(err, repository.ErrTradeOfferNotActive), errors.Is(err, repository.ErrTradeOfferInsufficient),
+		errors.Is(err, repository.ErrDirectTradeNotPending), errors.Is(err, repository.ErrTradeNoMerchants),
+		errors.Is(err, repository.ErrMarketOrderNotOpen), errors.Is(err, repository.ErrMarketOrderLimit),
+		errors.Is(err, repository.ErrPoolSlippage):
+		status = http.StatusConflict
+	case errors.Is(err, repository.ErrTradeOwnOffer), errors.Is(err, repository.ErrTradeInvalidResource),
+		errors.Is(err, repository.ErrTradeInvalidAmount), errors.Is(err, repository.ErrTradeInsufficientResources),
+		errors.Is(err, repository.ErrMarketOrderInvalid), errors.Is(err, services.ErrMarketplaceRequired),
+		errors.Is(err, services.ErrInvalidCandleInterval), errors.Is(err, repository.ErrPoolInvalidSwap),
+		errors.Is(err, repository.ErrPoolPriceImpact):
+		status = http.StatusBadRequest
+	case errors.Is(err, services.ErrMarketMakerDisabled):
+		status = http.StatusServiceUnavailable
+	}
+
+	if status == http.StatusInternalServerError {
+		h.logger.Error(message, zap.Error(err))
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+func (h *TradeHandler) playerID(c *gin.Context) (uuid.UUID, bool) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return uuid.Nil, false
+	}
+	return playerID, true
+}
+
+// worldID lee el mundo del parámetro world_id de la consulta
+func (h *TradeHandler) worldID(c *gin.Context) (uuid.UUID, bool) {
+	worldID, err := uuid.Parse(c.Query("world_id"))
 	if err != nil {
-		h.logger.Error("ID de jugador inválido", zap.Error(err))
-		http.Error(w, "ID de jugador inválido", http.StatusBadRequest)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de mundo inválido"})
+		return uuid.Nil, false
+	}
+	return worldID, true
+}
+
+// CreateTradeOffer crea una nueva oferta de comercio
+func (h *TradeHandler) CreateTradeOffer(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
 		return
 	}
 
 	var offer models.TradeOffer
-	if err := json.NewDecoder(r.Body).Decode(&offer); err != nil {
-		h.logger.Error("Error decodificando request", zap.Error(err))
-		http.Error(w, "Error decodificando request", http.StatusBadRequest)
+	if err := c.ShouldBindJSON(&offer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solicitud inválida"})
 		return
 	}
 
-	// Configurar valores por defecto
-	offer.SellerID = playerID
-	offer.Status = "active"
-
 	// Verificar que la oferta tenga datos válidos
 	if offer.VillageID == uuid.Nil || offer.ResourceType == "" || offer.Amount <= 0 || offer.PricePerUnit <= 0 {
-		http.Error(w, "Datos de oferta inválidos", http.StatusBadRequest)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos de oferta inválidos"})
 		return
 	}
 
-	createdOffer, err := h.tradeRepo.CreateTradeOffer(&offer)
+	// Los recursos ofrecidos quedan en depósito hasta que se venden o se cancela la oferta
+	createdOffer, err := h.tradeService.CreateOffer(playerID, &offer)
 	if err != nil {
-		h.logger.Error("Error creando oferta", zap.Error(err))
-		http.Error(w, "Error creando oferta", http.StatusInternalServerError)
+		h.respondTradeError(c, err, "Error creando oferta")
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, createdOffer)
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    createdOffer,
+	})
 }
 
 // GetTradeOffers obtiene todas las ofertas de comercio
-func (h *TradeHandler) GetTradeOffers(w http.ResponseWriter, r *http.Request) {
-	// Parámetros de filtrado
-	resourceType := r.URL.Query().Get("resource_type")
-	sellerID := r.URL.Query().Get("seller_id")
-	priceMin := r.URL.Query().Get("price_min")
-	priceMax := r.URL.Query().Get("price_max")
-
-	offers, err := h.tradeRepo.GetTradeOffers(resourceType, sellerID, priceMin, priceMax)
+func (h *TradeHandler) GetTradeOffers(c *gin.Context) {
+	offers, err := h.tradeService.GetOffers(
+		c.Query("resource_type"),
+		c.Query("seller_id"),
+		c.Query("price_min"),
+		c.Query("price_max"),
+	)
 	if err != nil {
 		h.logger.Error("Error obteniendo ofertas", zap.Error(err))
-		http.Error(w, "Error obteniendo ofertas", http.StatusInternalServerError)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo ofertas"})
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, offers)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    offers,
+	})
 }
 
 // GetTradeOffer obtiene una oferta específica
-func (h *TradeHandler) GetTradeOffer(w http.ResponseWriter, r *http.Request) {
-	offerIDStr := chi.URLParam(r, "offerID")
-	offerID, err := uuid.Parse(offerIDStr)
+func (h *TradeHandler) GetTradeOffer(c *gin.Context) {
+	offerID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		http.Error(w, "ID de oferta inválido", http.StatusBadRequest)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de oferta inválido"})
 		return
 	}
 
-	offer, err := h.tradeRepo.GetTradeOffer(offerID)
+	offer, err := h.tradeService.GetOffer(offerID)
 	if err != nil {
-		h.logger.Error("Error obteniendo oferta", zap.Error(err))
-		http.Error(w, "Oferta no encontrada", http.StatusNotFound)
+		h.respondTradeError(c, err, "Error obteniendo oferta")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, offer)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    offer,
+	})
 }
 
-// BuyTradeOffer compra una oferta de comercio
-func (h *TradeHandler) BuyTradeOffer(w http.ResponseWriter, r *http.Request) {
-	offerIDStr := chi.URLParam(r, "offerID")
-	offerID, err := uuid.Parse(offerIDStr)
+// BuyTradeOffer compra una oferta de comercio; la mercancía llega con los mercaderes del vendedor
+func (h *TradeHandler) BuyTradeOffer(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
+		return
+	}
+	offerID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		http.Error(w, "ID de oferta inválido", http.StatusBadRequest)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de oferta inválido"})
+		return
+	}
+
+	var req BuyTradeOfferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solicitud inválida"})
 		return
 	}
 
-	playerIDStr := r.Context().Value("player_id").(string)
-	playerID, err := uuid.Parse(playerIDStr)
+	transaction, shipment, err := h.tradeService.BuyOffer(playerID, offerID, req.VillageID, req.Amount)
 	if err != nil {
-		h.logger.Error("ID de jugador inválido", zap.Error(err))
-		http.Error(w, "ID de jugador inválido", http.StatusBadRequest)
+		h.respondTradeError(c, err, "Error procesando transacción")
 		return
 	}
 
-	var request struct {
-		VillageID uuid.UUID `json:"village_id"`
-		Amount    int       `json:"amount"`
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"transaction": transaction,
+			"shipment":    shipment,
+		},
+	})
+}
+
+// CancelTradeOffer cancela una oferta de comercio
+func (h *TradeHandler) CancelTradeOffer(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Error decodificando request", http.StatusBadRequest)
+	offerID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de oferta inválido"})
 		return
 	}
 
-	// Verificar que el jugador no esté comprando su propia oferta
-	offer, err := h.tradeRepo.GetTradeOffer(offerID)
+	// Lo que quedaba sin vender vuelve a la aldea del vendedor
+	cancelled, err := h.tradeService.CancelOffer(playerID, offerID)
 	if err != nil {
-		h.logger.Error("Error obteniendo oferta", zap.Error(err))
-		http.Error(w, "Oferta no encontrada", http.StatusNotFound)
+		h.respondTradeError(c, err, "Error cancelando oferta")
 		return
 	}
 
-	if offer.SellerID == playerID {
-		http.Error(w, "No puedes comprar tu propia oferta", http.StatusBadRequest)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Oferta cancelada exitosamente",
+		"data": gin.H{
+			"returned_amount": cancelled.Amount,
+			"resource_type":   cancelled.ResourceType,
+		},
+	})
+}
+
+// GetPlayerTradeOffers obtiene las ofertas del jugador
+func (h *TradeHandler) GetPlayerTradeOffers(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
 		return
 	}
 
-	// Procesar la transacción
-	transaction, err := h.tradeRepo.ProcessTrade(offerID, playerID, request.VillageID, request.Amount)
+	offers, err := h.tradeService.GetPlayerOffers(playerID)
 	if err != nil {
-		h.logger.Error("Error procesando transacción", zap.Error(err))
-		http.Error(w, "Error procesando transacción", http.StatusInternalServerError)
+		h.logger.Error("Error obteniendo ofertas del jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo ofertas"})
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, transaction)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    offers,
+	})
 }
 
-// CancelTradeOffer cancela una oferta de comercio
-func (h *TradeHandler) CancelTradeOffer(w http.ResponseWriter, r *http.Request) {
-	offerIDStr := chi.URLParam(r, "offerID")
-	offerID, err := uuid.Parse(offerIDStr)
+// GetTradeHistory obtiene el historial de transacciones
+func (h *TradeHandler) GetTradeHistory(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
+		return
+	}
+
+	// Parámetros de paginación
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	transactions, err := h.tradeService.GetHistory(playerID, limit)
 	if err != nil {
-		http.Error(w, "ID de oferta inválido", http.StatusBadRequest)
+		h.logger.Error("Error obteniendo historial", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo historial"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    transactions,
+	})
+}
+
+// GetMarketStats obtiene las estadísticas de las últimas 24 horas de cada recurso de un mundo
+func (h *TradeHandler) GetMarketStats(c *gin.Context) {
+	worldID, ok := h.worldID(c)
+	if !ok {
 		return
 	}
 
-	playerIDStr := r.Context().Value("player_id").(string)
-	playerID, err := uuid.Parse(playerIDStr)
+	stats, err := h.exchangeService.GetMarketStats(worldID)
 	if err != nil {
-		h.logger.Error("ID de jugador inválido", zap.Error(err))
-		http.Error(w, "ID de jugador inválido", http.StatusBadRequest)
+		h.respondTradeError(c, err, "Error obteniendo estadísticas del mercado")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    stats,
+	})
+}
+
+// GetResourcePrices obtiene el último precio de cada recurso de un mundo
+func (h *TradeHandler) GetResourcePrices(c *gin.Context) {
+	worldID, ok := h.worldID(c)
+	if !ok {
 		return
 	}
 
-	// Verificar que el jugador sea el vendedor
-	offer, err := h.tradeRepo.GetTradeOffer(offerID)
+	prices, err := h.exchangeService.GetResourcePrices(worldID)
 	if err != nil {
-		h.logger.Error("Error obteniendo oferta", zap.Error(err))
-		http.Error(w, "Oferta no encontrada", http.StatusNotFound)
+		h.respondTradeError(c, err, "Error obteniendo precios")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    prices,
+	})
+}
+
+// PlaceMarketOrder coloca una orden limitada de compra o venta en la bolsa
+func (h *TradeHandler) PlaceMarketOrder(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
 		return
 	}
 
-	if offer.SellerID != playerID {
-		http.Error(w, "No autorizado", http.StatusForbidden)
+	var req models.PlaceMarketOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solicitud inválida"})
 		return
 	}
 
-	if err := h.tradeRepo.CancelTradeOffer(offerID); err != nil {
-		h.logger.Error("Error cancelando oferta", zap.Error(err))
-		http.Error(w, "Error cancelando oferta", http.StatusInternalServerError)
+	result, err := h.exchangeService.PlaceOrder(playerID, &req)
+	if err != nil {
+		h.respondTradeError(c, err, "Error colocando orden")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Oferta cancelada exitosamente"})
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    result,
+	})
 }
 
-// GetPlayerTradeOffers obtiene las ofertas del jugador
-func (h *TradeHandler) GetPlayerTradeOffers(w http.ResponseWriter, r *http.Request) {
-	playerIDStr := r.Context().Value("player_id").(string)
-	playerID, err := uuid.Parse(playerIDStr)
+// CancelMarketOrder cancela una orden abierta de la bolsa
+func (h *TradeHandler) CancelMarketOrder(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
+		return
+	}
+	orderID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		h.logger.Error("ID de jugador inválido", zap.Error(err))
-		http.Error(w, "ID de jugador inválido", http.StatusBadRequest)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de orden inválido"})
 		return
 	}
 
-	offers, err := h.tradeRepo.GetPlayerTradeOffers(playerID)
+	// Lo que quedaba sin cruzar vuelve a la aldea de la orden
+	order, err := h.exchangeService.CancelOrder(playerID, orderID)
 	if err != nil {
-		h.logger.Error("Error obteniendo ofertas del jugador", zap.Error(err))
-		http.Error(w, "Error obteniendo ofertas", http.StatusInternalServerError)
+		h.respondTradeError(c, err, "Error cancelando orden")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, offers)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Orden cancelada exitosamente",
+		"data":    order,
+	})
 }
 
-// GetTradeHistory obtiene el historial de transacciones
-func (h *TradeHandler) GetTradeHistory(w http.ResponseWriter, r *http.Request) {
-	playerIDStr := r.Context().Value("player_id").(string)
-	playerID, err := uuid.Parse(playerIDStr)
+// GetPlayerMarketOrders obtiene las órdenes abiertas del jugador
+func (h *TradeHandler) GetPlayerMarketOrders(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
+		return
+	}
+
+	orders, err := h.exchangeService.GetPlayerOrders(playerID)
 	if err != nil {
-		h.logger.Error("ID de jugador inválido", zap.Error(err))
-		http.Error(w, "ID de jugador inválido", http.StatusBadRequest)
+		h.respondTradeError(c, err, "Error obteniendo órdenes")
 		return
 	}
 
-	// Parámetros de paginación
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	if limit <= 0 || limit > 100 {
-		limit = 20
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    orders,
+	})
+}
+
+// GetOrderBook obtiene el libro de órdenes de un recurso en un mundo
+func (h *TradeHandler) GetOrderBook(c *gin.Context) {
+	worldID, ok := h.worldID(c)
+	if !ok {
+		return
 	}
 
-	transactions, err := h.tradeRepo.GetTradeHistory(playerID, limit)
+	book, err := h.exchangeService.GetOrderBook(worldID, c.Query("resource_type"))
 	if err != nil {
-		h.logger.Error("Error obteniendo historial", zap.Error(err))
-		http.Error(w, "Error obteniendo historial", http.StatusInternalServerError)
+		h.respondTradeError(c, err, "Error obteniendo libro de órdenes")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, transactions)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    book,
+	})
 }
 
-// GetMarketStats obtiene estadísticas del mercado
-func (h *TradeHandler) GetMarketStats(w http.ResponseWriter, r *http.Request) {
-	// Implementación básica de estadísticas del mercado
-	stats := []models.MarketStats{
-		{
-			ResourceType: "wood",
-			AveragePrice: 150.0,
-			MinPrice:     100,
-			MaxPrice:     200,
-			TotalVolume:  10000,
-			ActiveOffers: 25,
-			LastUpdated:  time.Now(),
-		},
-		{
-			ResourceType: "stone",
-			AveragePrice: 200.0,
-			MinPrice:     150,
-			MaxPrice:     250,
-			TotalVolume:  8000,
-			ActiveOffers: 20,
-			LastUpdated:  time.Now(),
-		},
+// GetPriceCandles obtiene las velas de precio de un recurso en un mundo. Por defecto devuelve
+// velas de una hora de los últimos 7 días.
+func (h *TradeHandler) GetPriceCandles(c *gin.Context) {
+	worldID, ok := h.worldID(c)
+	if !ok {
+		return
 	}
 
-	respondWithJSON(w, http.StatusOK, stats)
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha final inválida"})
+			return
+		}
+		to = parsed
+	}
+	from := to.Add(-7 * 24 * time.Hour)
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha inicial inválida"})
+			return
+		}
+		from = parsed
+	}
+
+	candles, err := h.exchangeService.GetCandles(worldID, c.Query("resource_type"), c.DefaultQuery("interval", "1h"), from, to)
+	if err != nil {
+		h.respondTradeError(c, err, "Error obteniendo velas")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    candles,
+	})
 }
 
-// GetResourcePrices obtiene precios de recursos
-func (h *TradeHandler) GetResourcePrices(w http.ResponseWriter, r *http.Request) {
-	// Implementación básica de precios de recursos
-	prices := []models.ResourcePrice{
-		{
-			ResourceType: "wood",
-			CurrentPrice: 150,
-			Change24h:    5.2,
-			Volume24h:    10000,
-			UpdatedAt:    time.Now(),
-		},
-		{
-			ResourceType: "stone",
-			CurrentPrice: 200,
-			Change24h:    -2.1,
-			Volume24h:    8000,
-			UpdatedAt:    time.Now(),
+// GetMarketPool obtiene las reservas del mercader NPC de un mundo
+func (h *TradeHandler) GetMarketPool(c *gin.Context) {
+	worldID, ok := h.worldID(c)
+	if !ok {
+		return
+	}
+
+	pool, err := h.marketMakerService.GetPool(worldID)
+	if err != nil {
+		h.respondTradeError(c, err, "Error obteniendo reservas del mercader")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    pool,
+	})
+}
+
+// QuotePoolSwap calcula lo que se recibiría al cambiar recursos con el mercader NPC
+func (h *TradeHandler) QuotePoolSwap(c *gin.Context) {
+	worldID, ok := h.worldID(c)
+	if !ok {
+		return
+	}
+	amount, err := strconv.Atoi(c.Query("amount"))
+	if err != nil || amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cantidad inválida"})
+		return
+	}
+
+	quote, err := h.marketMakerService.Quote(worldID, c.Query("from"), c.Query("to"), amount)
+	if err != nil {
+		h.respondTradeError(c, err, "Error cotizando cambio")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    quote,
+	})
+}
+
+// SwapWithPool cambia al momento recursos de una aldea con el mercader NPC de su mundo
+func (h *TradeHandler) SwapWithPool(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
+		return
+	}
+
+	var req models.PoolSwapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solicitud inválida"})
+		return
+	}
+
+	swap, pool, err := h.marketMakerService.Swap(playerID, &req)
+	if err != nil {
+		h.respondTradeError(c, err, "Error cambiando recursos")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"swap": swap,
+			"pool": pool,
 		},
+	})
+}
+
+// GetPoolSwaps obtiene los últimos cambios del jugador con el mercader NPC
+func (h *TradeHandler) GetPoolSwaps(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
 	}
 
-	respondWithJSON(w, http.StatusOK, prices)
+	swaps, err := h.marketMakerService.GetPlayerSwaps(playerID, limit)
+	if err != nil {
+		h.respondTradeError(c, err, "Error obteniendo cambios")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    swaps,
+	})
 }
 
 // CreateDirectTrade crea un intercambio directo
-func (h *TradeHandler) CreateDirectTrade(w http.ResponseWriter, r *http.Request) {
-	playerIDStr := r.Context().Value("player_id").(string)
-	playerID, err := uuid.Parse(playerIDStr)
-	if err != nil {
-		h.logger.Error("ID de jugador inválido", zap.Error(err))
-		http.Error(w, "ID de jugador inválido", http.StatusBadRequest)
+func (h *TradeHandler) CreateDirectTrade(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
 		return
 	}
 
 	var trade models.DirectTrade
-	if err := json.NewDecoder(r.Body).Decode(&trade); err != nil {
-		h.logger.Error("Error decodificando request", zap.Error(err))
-		http.Error(w, "Error decodificando request", http.StatusBadRequest)
+	if err := c.ShouldBindJSON(&trade); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solicitud inválida"})
+		return
+	}
+	if trade.InitiatorVillageID == uuid.Nil || trade.TargetVillageID == uuid.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos de intercambio inválidos"})
 		return
 	}
 
-	// Configurar valores por defecto
-	trade.ID = uuid.New()
-	trade.InitiatorID = playerID
-	trade.Status = "pending"
-	trade.CreatedAt = time.Now()
-	trade.ExpiresAt = time.Now().Add(24 * time.Hour)
+	created, err := h.tradeService.CreateDirectTrade(playerID, &trade)
+	if err != nil {
+		h.respondTradeError(c, err, "Error creando intercambio")
+		return
+	}
 
-	// TODO: Implementar creación de intercambio directo en el repositorio
-	respondWithJSON(w, http.StatusCreated, trade)
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    created,
+	})
 }
 
-// AcceptDirectTrade acepta un intercambio directo
-func (h *TradeHandler) AcceptDirectTrade(w http.ResponseWriter, r *http.Request) {
-	tradeIDStr := chi.URLParam(r, "tradeID")
-	tradeID, err := uuid.Parse(tradeIDStr)
+// AcceptDirectTrade acepta un intercambio directo; cada aldea envía su parte con sus mercaderes
+func (h *TradeHandler) AcceptDirectTrade(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
+		return
+	}
+	tradeID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		http.Error(w, "ID de intercambio inválido", http.StatusBadRequest)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de intercambio inválido"})
 		return
 	}
 
-	playerIDStr := r.Context().Value("player_id").(string)
-	playerID, err := uuid.Parse(playerIDStr)
+	trade, shipments, err := h.tradeService.AcceptDirectTrade(playerID, tradeID)
 	if err != nil {
-		h.logger.Error("ID de jugador inválido", zap.Error(err))
-		http.Error(w, "ID de jugador inválido", http.StatusBadRequest)
+		h.respondTradeError(c, err, "Error aceptando intercambio")
 		return
 	}
 
-	// TODO: Implementar aceptación de intercambio directo usando tradeID y playerID
-	_ = tradeID
-	_ = playerID
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Intercambio aceptado"})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Intercambio aceptado",
+		"data": gin.H{
+			"trade":     trade,
+			"shipments": shipments,
+		},
+	})
 }
 
 // DeclineDirectTrade rechaza un intercambio directo
-func (h *TradeHandler) DeclineDirectTrade(w http.ResponseWriter, r *http.Request) {
-	tradeIDStr := chi.URLParam(r, "tradeID")
-	tradeID, err := uuid.Parse(tradeIDStr)
+func (h *TradeHandler) DeclineDirectTrade(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
+		return
+	}
+	tradeID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		http.Error(w, "ID de intercambio inválido", http.StatusBadRequest)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de intercambio inválido"})
 		return
 	}
 
-	playerIDStr := r.Context().Value("player_id").(string)
-	playerID, err := uuid.Parse(playerIDStr)
+	trade, err := h.tradeService.DeclineDirectTrade(playerID, tradeID)
 	if err != nil {
-		h.logger.Error("ID de jugador inválido", zap.Error(err))
-		http.Error(w, "ID de jugador inválido", http.StatusBadRequest)
+		h.respondTradeError(c, err, "Error rechazando intercambio")
 		return
 	}
 
-	// TODO: Implementar rechazo de intercambio directo usando tradeID y playerID
-	_ = tradeID
-	_ = playerID
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Intercambio rechazado"})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Intercambio rechazado",
+		"data":    trade,
+	})
 }
 
 // GetDirectTrades obtiene los intercambios directos
-func (h *TradeHandler) GetDirectTrades(w http.ResponseWriter, r *http.Request) {
-	playerIDStr := r.Context().Value("player_id").(string)
-	playerID, err := uuid.Parse(playerIDStr)
+func (h *TradeHandler) GetDirectTrades(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	trades, err := h.tradeService.GetDirectTrades(playerID, limit)
+	if err != nil {
+		h.logger.Error("Error obteniendo intercambios", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo intercambios"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    trades,
+	})
+}
+
+// GetVillageShipments obtiene los mercaderes de una aldea y sus envíos entrantes y salientes
+func (h *TradeHandler) GetVillageShipments(c *gin.Context) {
+	playerID, ok := h.playerID(c)
+	if !ok {
+		return
+	}
+	villageID, err := uuid.Parse(c.Param("villageID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de aldea inválido"})
+		return
+	}
+
+	shipments, err := h.tradeService.GetVillageShipments(playerID, villageID)
 	if err != nil {
-		h.logger.Error("ID de jugador inválido", zap.Error(err))
-		http.Error(w, "ID de jugador inválido", http.StatusBadRequest)
+		h.respondTradeError(c, err, "Error obteniendo envíos")
 		return
 	}
 
-	// TODO: Implementar obtención de intercambios directos usando playerID
-	_ = playerID
-	trades := []models.DirectTrade{}
-	respondWithJSON(w, http.StatusOK, trades)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    shipments,
+	})
 }
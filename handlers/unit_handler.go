@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"server-backend/models"
 	"server-backend/repository"
+	"server-backend/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -11,16 +13,18 @@ import (
 )
 
 type UnitHandler struct {
-	unitRepo    *repository.UnitRepository
-	villageRepo *repository.VillageRepository
-	logger      *zap.Logger
+	unitRepo        *repository.UnitRepository
+	villageRepo     *repository.VillageRepository
+	trainingService *services.TrainingService
+	logger          *zap.Logger
 }
 
-func NewUnitHandler(unitRepo *repository.UnitRepository, villageRepo *repository.VillageRepository, logger *zap.Logger) *UnitHandler {
+func NewUnitHandler(unitRepo *repository.UnitRepository, villageRepo *repository.VillageRepository, trainingService *services.TrainingService, logger *zap.Logger) *UnitHandler {
 	return &UnitHandler{
-		unitRepo:    unitRepo,
-		villageRepo: villageRepo,
-		logger:      logger,
+		unitRepo:        unitRepo,
+		villageRepo:     villageRepo,
+		trainingService: trainingService,
+		logger:          logger,
 	}
 }
 
@@ -165,22 +169,6 @@ func (h *UnitHandler) TrainUnits(c *gin.Context) {
 		return
 	}
 
-	// Verificar que la aldea pertenece al jugador
-	village, err := h.villageRepo.GetVillageByID(villageID)
-	if err != nil {
-		h.logger.Error("Error obteniendo aldea", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
-		return
-	}
-	if village == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Aldea no encontrada"})
-		return
-	}
-	if village.Village.PlayerID != playerID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "No autorizado"})
-		return
-	}
-
 	// Decodificar la solicitud
 	var req TrainUnitsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -188,77 +176,82 @@ func (h *UnitHandler) TrainUnits(c *gin.Context) {
 		return
 	}
 
-	// Validar el tipo de unidad
-	unitType, exists := models.UnitTypes[req.UnitType]
-	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Tipo de unidad inválido"})
+	// Encolar el entrenamiento (valida edificio, cobra recursos y arranca si hay hueco)
+	batch, err := h.trainingService.TrainUnits(playerID, villageID, req.UnitType, req.Quantity)
+	if err != nil {
+		h.logger.Warn("Error encolando entrenamiento", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Validar cantidad
-	if req.Quantity <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cantidad debe ser mayor a 0"})
-		return
+	// Respuesta exitosa
+	response := gin.H{
+		"message":       "Entrenamiento encolado exitosamente",
+		"unit_type":     batch.UnitType,
+		"quantity":      batch.Quantity,
+		"training_time": batch.UnitTime,
+		"batch":         batch,
 	}
 
-	// Verificar que hay suficientes recursos
-	totalCost := struct {
-		Wood  int
-		Stone int
-		Food  int
-		Gold  int
-	}{
-		Wood:  unitType.Cost.Wood * req.Quantity,
-		Stone: unitType.Cost.Stone * req.Quantity,
-		Food:  unitType.Cost.Food * req.Quantity,
-		Gold:  unitType.Cost.Gold * req.Quantity,
-	}
+	c.JSON(http.StatusOK, response)
+}
 
-	if village.Resources.Wood < totalCost.Wood ||
-		village.Resources.Stone < totalCost.Stone ||
-		village.Resources.Food < totalCost.Food ||
-		village.Resources.Gold < totalCost.Gold {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Recursos insuficientes"})
+// GetTrainingQueue obtiene la cola de entrenamiento de una aldea
+func (h *UnitHandler) GetTrainingQueue(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
 		return
 	}
 
-	// Verificar que hay cuartel para entrenar unidades
-	barracks, exists := village.Buildings["barracks"]
-	if !exists || barracks.Level < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Se requiere cuartel nivel 1 para entrenar unidades"})
+	villageID, err := uuid.Parse(c.Query("village_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de aldea inválido"})
 		return
 	}
 
-	// Iniciar entrenamiento
-	err = h.unitRepo.StartTraining(villageID, req.UnitType, req.Quantity)
+	queue, err := h.trainingService.GetTrainingQueue(playerID, villageID)
 	if err != nil {
-		h.logger.Error("Error iniciando entrenamiento", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Descontar recursos
-	newWood := village.Resources.Wood - totalCost.Wood
-	newStone := village.Resources.Stone - totalCost.Stone
-	newFood := village.Resources.Food - totalCost.Food
-	newGold := village.Resources.Gold - totalCost.Gold
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    queue,
+	})
+}
 
-	err = h.villageRepo.UpdateResources(villageID, newWood, newStone, newFood, newGold)
+// CancelTraining cancela un lote de entrenamiento con reembolso parcial
+func (h *UnitHandler) CancelTraining(c *gin.Context) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
 	if err != nil {
-		h.logger.Error("Error actualizando recursos", zap.Error(err))
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
 		return
 	}
 
-	// Respuesta exitosa
-	response := gin.H{
-		"message":       "Entrenamiento iniciado exitosamente",
-		"unit_type":     req.UnitType,
-		"quantity":      req.Quantity,
-		"training_time": unitType.TrainingTime,
+	batchID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de lote inválido"})
+		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	result, err := h.trainingService.CancelTraining(playerID, batchID)
+	if err != nil {
+		if errors.Is(err, services.ErrTrainingNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
 }
 
 func (h *UnitHandler) GetUnitTypes(c *gin.Context) {
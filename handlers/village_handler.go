@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"server-backend/models"
 	"server-backend/repository"
 	"server-backend/services"
 	"strconv"
@@ -17,6 +19,7 @@ import (
 type VillageHandler struct {
 	villageRepo         *repository.VillageRepository
 	constructionService *services.ConstructionService
+	resourceService     *services.ResourceService
 	logger              *zap.Logger
 }
 
@@ -28,6 +31,21 @@ func NewVillageHandler(villageRepo *repository.VillageRepository, constructionSe
 	}
 }
 
+// SetResourceService establece el servicio que calcula los recursos actuales de las aldeas
+func (h *VillageHandler) SetResourceService(resourceService *services.ResourceService) {
+	h.resourceService = resourceService
+}
+
+// projectResources sustituye los recursos guardados por los producidos hasta este momento
+func (h *VillageHandler) projectResources(villages []*models.VillageWithDetails) {
+	if h.resourceService == nil {
+		return
+	}
+	for _, village := range villages {
+		h.resourceService.ProjectResources(village)
+	}
+}
+
 func (h *VillageHandler) GetVillage(c *gin.Context) {
 	// Obtener el ID del jugador del contexto
 	playerIDStr := c.GetString("player_id")
@@ -54,6 +72,7 @@ func (h *VillageHandler) GetVillage(c *gin.Context) {
 
 	// Por ahora, devolver la primera aldea (se puede mejorar para manejar múltiples aldeas)
 	village := villages[0]
+	h.projectResources(villages[:1])
 
 	c.JSON(http.StatusOK, village)
 }
@@ -76,6 +95,7 @@ func (h *VillageHandler) GetPlayerVillages(c *gin.Context) {
 		return
 	}
 
+	h.projectResources(villages)
 	c.JSON(http.StatusOK, villages)
 }
 
@@ -438,6 +458,61 @@ func (h *VillageHandler) CancelUpgrade(c *gin.Context) {
 	})
 }
 
+// RepairBuilding repara los daños de asedio de un edificio
+func (h *VillageHandler) RepairBuilding(c *gin.Context) {
+	villageID, err := uuid.Parse(c.Param("villageID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de aldea inválido"})
+		return
+	}
+
+	buildingType := c.Param("buildingType")
+	if buildingType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tipo de edificio requerido"})
+		return
+	}
+
+	// Verificar permisos
+	village, err := h.villageRepo.GetVillageByID(villageID)
+	if err != nil {
+		h.logger.Error("Error obteniendo aldea", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	if village == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Aldea no encontrada"})
+		return
+	}
+
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	if village.Village.PlayerID != playerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No tienes permiso para reparar en esta aldea"})
+		return
+	}
+
+	result, err := h.constructionService.RepairBuilding(villageID, buildingType)
+	if err != nil {
+		switch err {
+		case services.ErrBuildingNotDamaged, services.ErrInvalidBuildingType, services.ErrInsufficientResources:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			h.logger.Error("Error reparando edificio", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Edificio reparado exitosamente",
+		"data":    result,
+	})
+}
+
 // CompleteBuildingUpgrade completa la mejora de un edificio
 func (h *VillageHandler) CompleteBuildingUpgrade(c *gin.Context) {
 	villageIDStr := c.Param("villageID")
@@ -707,3 +782,161 @@ func (h *VillageHandler) GetConstructionQueueStatus(c *gin.Context) {
 
 	c.JSON(http.StatusOK, queueStatus)
 }
+
+// EnqueueUpgradeRequest representa una solicitud para añadir una mejora a la cola
+type EnqueueUpgradeRequest struct {
+	BuildingType string `json:"building_type" binding:"required"`
+}
+
+// MoveConstructionOrderRequest representa una solicitud para mover una orden dentro de la cola
+type MoveConstructionOrderRequest struct {
+	Position int `json:"position" binding:"required"`
+}
+
+// EnqueueBuildingUpgrade añade una mejora a la cola de construcción de una aldea
+func (h *VillageHandler) EnqueueBuildingUpgrade(c *gin.Context) {
+	playerID, villageID, ok := h.constructionParams(c)
+	if !ok {
+		return
+	}
+
+	var req EnqueueUpgradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El tipo de edificio es requerido"})
+		return
+	}
+
+	order, err := h.constructionService.EnqueueUpgrade(playerID, villageID, req.BuildingType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    order,
+	})
+}
+
+// MoveConstructionOrder cambia la posición de una orden en espera
+func (h *VillageHandler) MoveConstructionOrder(c *gin.Context) {
+	playerID, villageID, ok := h.constructionParams(c)
+	if !ok {
+		return
+	}
+	orderID, err := uuid.Parse(c.Param("orderID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de orden inválido"})
+		return
+	}
+
+	var req MoveConstructionOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La posición es requerida"})
+		return
+	}
+
+	queue, err := h.constructionService.MoveOrder(playerID, villageID, orderID, req.Position)
+	if err != nil {
+		if errors.Is(err, services.ErrConstructionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    queue,
+	})
+}
+
+// CancelConstructionOrder cancela una orden de la cola con reembolso proporcional
+func (h *VillageHandler) CancelConstructionOrder(c *gin.Context) {
+	playerID, villageID, ok := h.constructionParams(c)
+	if !ok {
+		return
+	}
+	orderID, err := uuid.Parse(c.Param("orderID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de orden inválido"})
+		return
+	}
+
+	result, err := h.constructionService.CancelOrder(playerID, villageID, orderID)
+	if err != nil {
+		if errors.Is(err, services.ErrConstructionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// BuyConstructionPremium compra los huecos de construcción premium del jugador
+func (h *VillageHandler) BuyConstructionPremium(c *gin.Context) {
+	playerID, _, ok := h.constructionParams(c)
+	if !ok {
+		return
+	}
+
+	until, err := h.constructionService.BuyPremiumSlots(playerID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"premium_until": until,
+			"cost":          services.ConstructionPremiumCost,
+		},
+	})
+}
+
+// DemolishBuilding baja un edificio un nivel con reembolso parcial
+func (h *VillageHandler) DemolishBuilding(c *gin.Context) {
+	playerID, villageID, ok := h.constructionParams(c)
+	if !ok {
+		return
+	}
+
+	order, err := h.constructionService.DemolishBuilding(playerID, villageID, c.Param("buildingType"))
+	if err != nil {
+		if errors.Is(err, services.ErrDemolitionBlocked) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    order,
+	})
+}
+
+// constructionParams lee el jugador autenticado y la aldea de la URL
+func (h *VillageHandler) constructionParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	playerID, err := uuid.Parse(c.GetString("player_id"))
+	if err != nil {
+		h.logger.Error("Error parseando ID de jugador", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	villageID, err := uuid.Parse(c.Param("villageID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de aldea inválido"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return playerID, villageID, true
+}
@@ -196,7 +196,7 @@ func (h *WorldClientHandler) JoinWorld(w http.ResponseWriter, r *http.Request) {
 		req.VillageName = "Mi Aldea"
 	}
 	if req.StartingLocation == "" {
-		req.StartingLocation = "random"
+		req.StartingLocation = models.SpawnAuto
 	}
 
 	// Verificar que el mundo existe y está disponible
@@ -243,10 +243,10 @@ func (h *WorldClientHandler) JoinWorld(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Crear aldea inicial para el jugador con coordenadas aleatorias
-	x, y, err := h.villageRepo.GenerateRandomCoordinates(worldID)
+	// Crear aldea inicial para el jugador según la ubicación elegida
+	x, y, err := h.worldService.SpawnLocation(playerID, worldID, req.StartingLocation, req.NearPlayerID)
 	if err != nil {
-		h.logger.Error("Error generando coordenadas aleatorias", zap.Error(err))
+		h.logger.Error("Error eligiendo ubicación de la aldea inicial", zap.Error(err))
 		http.Error(w, "Error interno del servidor", http.StatusInternalServerError)
 		return
 	}
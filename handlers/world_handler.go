@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"server-backend/models"
 	"server-backend/repository"
 	"server-backend/services"
 
@@ -18,8 +19,8 @@ type WorldHandler struct {
 	logger       *zap.Logger
 }
 
-func NewWorldHandler(worldRepo *repository.WorldRepository, playerRepo *repository.PlayerRepository, villageRepo *repository.VillageRepository, allianceRepo *repository.AllianceRepository, battleRepo *repository.BattleRepository, economyRepo *repository.EconomyRepository, logger *zap.Logger) *WorldHandler {
-	worldService := services.NewWorldService(worldRepo, playerRepo, villageRepo, allianceRepo, battleRepo, economyRepo, logger)
+func NewWorldHandler(worldRepo *repository.WorldRepository, playerRepo *repository.PlayerRepository, villageRepo *repository.VillageRepository, allianceRepo *repository.AllianceRepository, battleRepo *repository.BattleRepository, economyRepo *repository.EconomyRepository, spawnService *services.SpawnService, logger *zap.Logger) *WorldHandler {
+	worldService := services.NewWorldService(worldRepo, playerRepo, villageRepo, allianceRepo, battleRepo, economyRepo, spawnService, logger)
 	return &WorldHandler{
 		worldService: worldService,
 		worldRepo:    worldRepo,
@@ -129,8 +130,17 @@ func (h *WorldHandler) JoinWorld(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Decodificar preferencias de aparición (opcional)
+	requestData := models.WorldJoinRequest{VillageName: "Mi Aldea", StartingLocation: models.SpawnAuto}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&requestData)
+	}
+	if requestData.VillageName == "" {
+		requestData.VillageName = "Mi Aldea"
+	}
+
 	// Unir al jugador al mundo específico
-	response, err := h.worldService.JoinWorld(playerID, worldID, "Mi Aldea", "random")
+	response, err := h.worldService.JoinWorld(playerID, worldID, requestData.VillageName, requestData.StartingLocation, requestData.NearPlayerID)
 	if err != nil {
 		switch err {
 		case services.ErrNoWorldsAvailable:
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"server-backend/models"
+	"server-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type WorldSettingsHandler struct {
+	worldSettingsService *services.WorldSettingsService
+	logger               *zap.Logger
+}
+
+func NewWorldSettingsHandler(worldSettingsService *services.WorldSettingsService, logger *zap.Logger) *WorldSettingsHandler {
+	return &WorldSettingsHandler{
+		worldSettingsService: worldSettingsService,
+		logger:               logger,
+	}
+}
+
+// GetWorldSettings obtiene las reglas de juego de un mundo y si ha alcanzado su condición de fin
+func (h *WorldSettingsHandler) GetWorldSettings(c *gin.Context) {
+	worldID, err := uuid.Parse(c.Param("world_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de mundo inválido"})
+		return
+	}
+
+	settings, err := h.worldSettingsService.GetSettings(worldID)
+	if err != nil {
+		h.logger.Error("Error obteniendo reglas del mundo", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	endStatus, err := h.worldSettingsService.CheckEndCondition(worldID)
+	if err != nil {
+		h.logger.Error("Error comprobando fin del mundo", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"settings":   settings,
+			"end_status": endStatus,
+		},
+	})
+}
+
+// UpdateWorldSettings cambia las reglas de un mundo que aún no ha empezado (solo administradores)
+func (h *WorldSettingsHandler) UpdateWorldSettings(c *gin.Context) {
+	worldID, err := uuid.Parse(c.Param("world_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de mundo inválido"})
+		return
+	}
+
+	var req models.UpdateWorldSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solicitud inválida"})
+		return
+	}
+
+	settings, err := h.worldSettingsService.UpdateSettings(worldID, &req)
+	if err != nil {
+		h.logger.Warn("Error actualizando reglas del mundo", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
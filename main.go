@@ -76,8 +76,9 @@ func main() {
 	// Configurar todas las rutas
 	routes.SetupAllRoutes(r, handlers, repos, services, authMiddleware, logger)
 
-	// Iniciar servicios en background
-	startBackgroundServices(services, constructionService, logger)
+	// Iniciar servicios en background; se detienen cancelando su contexto
+	backgroundCtx, cancelBackground := context.WithCancel(context.Background())
+	startBackgroundServices(backgroundCtx, services, constructionService, logger)
 
 	// Configurar servidor HTTP
 	srv := &http.Server{
@@ -105,7 +106,7 @@ func main() {
 	logger.Info("Cerrando servidor...")
 
 	// Detener servicios
-	stopBackgroundServices(services, logger)
+	stopBackgroundServices(cancelBackground, services, logger)
 
 	// Dar tiempo para que las conexiones se cierren
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -136,24 +137,98 @@ func initializeServices(db *sql.DB, cfg *config.Config, logger *zap.Logger) (*ro
 	allianceRepo := repository.NewAllianceRepository(db, logger)
 	chatRepo := repository.NewChatRepository(db, logger)
 	unitRepo := repository.NewUnitRepository(db, logger)
+	battleRepo := repository.NewBattleRepository(db, logger)
+	marchRepo := repository.NewMarchRepository(db, logger)
+	intelRepo := repository.NewIntelRepository(db, logger)
+	trainingRepo := repository.NewTrainingRepository(db, logger)
+	notificationRepo := repository.NewNotificationRepository(db)
+	playerRepo := repository.NewPlayerRepository(db, logger)
+	worldRepo := repository.NewWorldRepository(db, logger)
+	mapRepo := repository.NewMapRepository(db, logger)
+	protectionRepo := repository.NewProtectionRepository(db, logger)
+	currencyRepo := repository.NewCurrencyRepository(db, logger)
+	eventRepo := repository.NewEventRepository(db, logger)
+	heroRepo := repository.NewHeroRepository(db, logger)
+	titleRepo := repository.NewTitleRepository(db, logger)
+	worldSettingsRepo := repository.NewWorldSettingsRepository(db, logger)
+	constructionRepo := repository.NewConstructionRepository(db, logger)
+	tradeRepo := repository.NewTradeRepository(db)
+	merchantRepo := repository.NewMerchantRepository(db, logger)
+	exchangeRepo := repository.NewExchangeRepository(db, logger)
+	priceHistoryRepo := repository.NewPriceHistoryRepository(db, logger)
+	marketMakerRepo := repository.NewMarketMakerRepository(db, logger)
+	economyRepo := repository.NewEconomyRepository(db, logger)
 
 	// WebSocket Manager
 	wsManager := websocket.NewManager(chatRepo, villageRepo, unitRepo, logger, redisService)
 
 	// Servicios de dominio
-	resourceService := services.NewResourceService(villageRepo, buildingConfigRepo, logger, redisService)
-	constructionService := services.NewConstructionService(villageRepo, buildingConfigRepo, researchRepo, allianceRepo, redisService, logger, cfg.TimeZone)
+	resourceService := services.NewResourceService(villageRepo, buildingConfigRepo, unitRepo, marchRepo, logger, redisService)
+	constructionService := services.NewConstructionService(villageRepo, buildingConfigRepo, researchRepo, allianceRepo, constructionRepo, currencyRepo, redisService, logger, cfg.TimeZone)
 	chatService := services.NewChatService(chatRepo, redisService, logger)
+	battleService := services.NewBattleService(battleRepo, villageRepo, unitRepo, buildingConfigRepo, logger, redisService)
+	marchService := services.NewMarchService(marchRepo, intelRepo, villageRepo, unitRepo, buildingConfigRepo, allianceRepo, battleService, logger)
+	trainingService := services.NewTrainingService(trainingRepo, unitRepo, villageRepo, buildingConfigRepo, researchRepo, resourceService, logger)
+	notificationService := services.NewNotificationService(notificationRepo, playerRepo, wsManager, logger, redisService)
+	mapService := services.NewMapService(mapRepo, worldRepo, villageRepo, playerRepo, logger)
+	protectionService := services.NewProtectionService(protectionRepo, worldRepo, villageRepo, marchRepo, logger)
+	expansionService := services.NewExpansionService(villageRepo, playerRepo, marchRepo, mapRepo, mapService, logger)
+	worldSettingsService := services.NewWorldSettingsService(worldSettingsRepo, worldRepo, logger)
+	tradeService := services.NewTradeService(tradeRepo, merchantRepo, villageRepo, buildingConfigRepo, resourceService, logger)
+	exchangeService := services.NewExchangeService(exchangeRepo, priceHistoryRepo, villageRepo, resourceService, logger)
+	marketMakerService := services.NewMarketMakerService(marketMakerRepo, economyRepo, villageRepo, resourceService, logger)
 
 	// Configurar WebSocket en servicios
 	resourceService.SetWebSocketManager(wsManager)
 	constructionService.SetWebSocketManager(wsManager)
+	battleService.SetWebSocketManager(wsManager)
+	marchService.SetWebSocketManager(wsManager)
+	marchService.SetNotificationService(notificationService)
+	resourceService.SetNotificationService(notificationService)
+	trainingService.SetWebSocketManager(wsManager)
+	tradeService.SetWebSocketManager(wsManager)
+	exchangeService.SetWebSocketManager(wsManager)
+	battleService.SetProtectionService(protectionService)
+	marchService.SetProtectionService(protectionService)
+	resourceService.SetProtectionService(protectionService)
+	resourceService.RegisterProductionModifierProvider(services.NewResearchProductionProvider(researchRepo))
+	resourceService.RegisterProductionModifierProvider(services.NewOasisProductionProvider(mapRepo))
+	resourceService.RegisterProductionModifierProvider(services.NewEventProductionProvider(eventRepo))
+	resourceService.RegisterProductionModifierProvider(services.NewHeroProductionProvider(heroRepo))
+	resourceService.RegisterProductionModifierProvider(services.NewAllianceProductionProvider(allianceRepo))
+	resourceService.RegisterProductionModifierProvider(services.NewTitleProductionProvider(titleRepo))
+	resourceService.RegisterProductionModifierProvider(worldSettingsService)
+
+	// Reglas de mundo: velocidades, multiplicadores, límites y moral
+	resourceService.SetWorldSettingsService(worldSettingsService)
+	constructionService.SetWorldSettingsService(worldSettingsService)
+	trainingService.SetWorldSettingsService(worldSettingsService)
+	marchService.SetWorldSettingsService(worldSettingsService)
+	battleService.SetWorldSettingsService(worldSettingsService)
+	expansionService.SetWorldSettingsService(worldSettingsService)
+	protectionService.SetWorldSettingsService(worldSettingsService)
+	tradeService.SetWorldSettingsService(worldSettingsService)
+	mapService.SetProtectionService(protectionService)
+	expansionService.SetNotificationService(notificationService)
+	marchService.SetExpansionService(expansionService)
+	marchService.SetResourceService(resourceService)
+	constructionService.SetResourceService(resourceService)
 
 	return &routes.Services{
-		Resource: resourceService,
-		JWT:      jwtManager,
-		Redis:    redisService,
-		Chat:     chatService,
+		Resource:      resourceService,
+		JWT:           jwtManager,
+		Redis:         redisService,
+		Chat:          chatService,
+		March:         marchService,
+		Battle:        battleService,
+		Training:      trainingService,
+		Map:           mapService,
+		Protection:    protectionService,
+		Expansion:     expansionService,
+		WorldSettings: worldSettingsService,
+		Trade:         tradeService,
+		Exchange:      exchangeService,
+		MarketMaker:   marketMakerService,
 	}, constructionService, chatService
 }
 
@@ -164,26 +239,36 @@ func initializeRepositories(db *sql.DB, logger *zap.Logger) *routes.Repositories
 		Village:  repository.NewVillageRepository(db, logger),
 		Alliance: repository.NewAllianceRepository(db, logger),
 		Unit:     repository.NewUnitRepository(db, logger),
+		Battle:   repository.NewBattleRepository(db, logger),
 	}
 }
 
 // initializeHandlers inicializa todos los handlers
 func initializeHandlers(repos *routes.Repositories, services *routes.Services, constructionService *services.ConstructionService, chatService *services.ChatService, logger *zap.Logger) *routes.Handlers {
+	villageHandler := handlers.NewVillageHandler(repos.Village, constructionService, logger)
+	villageHandler.SetResourceService(services.Resource)
+
 	// Usar repositorios existentes (con db válido) en lugar de crear nuevos
 	return &routes.Handlers{
-		Auth:     handlers.NewAuthHandler(repos.Player, services.JWT, logger, services.Redis, repos.Village),
-		Village:  handlers.NewVillageHandler(repos.Village, constructionService, logger),
-		Chat:     handlers.NewChatHandler(chatService, logger),
-		Alliance: handlers.NewAllianceHandler(repos.Alliance, logger),
-		Unit:     handlers.NewUnitHandler(repos.Unit, repos.Village, logger),
+		Auth:          handlers.NewAuthHandler(repos.Player, services.JWT, logger, services.Redis, repos.Village, services.Protection),
+		Village:       villageHandler,
+		Chat:          handlers.NewChatHandler(chatService, logger),
+		Alliance:      handlers.NewAllianceHandler(repos.Alliance, logger),
+		Unit:          handlers.NewUnitHandler(repos.Unit, repos.Village, services.Training, logger),
+		March:         handlers.NewMarchHandler(services.March, logger),
+		Battle:        handlers.NewBattleHandler(repos.Battle, repos.Village, repos.Unit, services.Battle, logger),
+		Map:           handlers.NewMapHandler(services.Map, logger),
+		Protection:    handlers.NewProtectionHandler(services.Protection, logger),
+		Expansion:     handlers.NewExpansionHandler(services.Expansion, logger),
+		WorldSettings: handlers.NewWorldSettingsHandler(services.WorldSettings, logger),
+		Trade:         handlers.NewTradeHandler(services.Trade, services.Exchange, services.MarketMaker, logger),
 	}
 }
 
 // startBackgroundServices inicia servicios en background
-func startBackgroundServices(services *routes.Services, constructionService *services.ConstructionService, logger *zap.Logger) {
+func startBackgroundServices(ctx context.Context, services *routes.Services, constructionService *services.ConstructionService, logger *zap.Logger) {
 	// Iniciar SyncManager del ChatService
 	if services.Chat != nil {
-		ctx := context.Background()
 		if err := services.Chat.StartSyncManager(ctx); err != nil {
 			logger.Error("Error iniciando SyncManager del chat", zap.Error(err))
 		} else {
@@ -191,30 +276,44 @@ func startBackgroundServices(services *routes.Services, constructionService *ser
 		}
 	}
 
-	// Nota: Sistema de suscripción Redis para construcción implementado en el conteo automático
-	// La limpieza automática se ejecuta cuando se consulta el estado de construcción
+	// Iniciar planificador de marchas (llegadas, batallas y regresos)
+	if services.March != nil {
+		services.March.StartMarchScheduler(ctx)
+	}
 
-	// Iniciar generación de recursos
-	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				logger.Info("Ejecutando ciclo de generación de recursos híbrido")
-				// Aquí iría la lógica de generación de recursos
-			}
-		}
-	}()
+	// Iniciar planificador de entrenamiento (entrega de unidades y arranque de lotes en cola)
+	if services.Training != nil {
+		services.Training.StartTrainingScheduler(ctx)
+	}
+
+	// Iniciar planificador de construcción (mejoras terminadas y arranque de órdenes en cola)
+	if constructionService != nil {
+		constructionService.StartConstructionScheduler(ctx)
+	}
+
+	// Iniciar planificador de comercio (entrega de envíos, regreso de mercaderes e intercambios caducados)
+	if services.Trade != nil {
+		services.Trade.StartTradeScheduler(ctx)
+	}
+
+	// Iniciar agregador de velas de precio de la bolsa
+	if services.Exchange != nil {
+		services.Exchange.StartCandleAggregator(ctx)
+	}
+
+	// Los recursos no necesitan ciclo de generación: se calculan al leerlos y se materializan
+	// en cada gasto, saqueo o cambio de producción
 
 	logger.Info("Servicios en background iniciados")
 }
 
 // stopBackgroundServices detiene servicios en background
-func stopBackgroundServices(services *routes.Services, logger *zap.Logger) {
+func stopBackgroundServices(cancel context.CancelFunc, services *routes.Services, logger *zap.Logger) {
 	logger.Info("Deteniendo servicios en background...")
 
+	// Detener planificadores de marchas, entrenamiento, construcción, comercio y velas
+	cancel()
+
 	// Detener SyncManager del ChatService
 	if services.Chat != nil {
 		if err := services.Chat.StopSyncManager(); err != nil {
@@ -279,3 +279,36 @@ func (m *AuthMiddleware) RequireAdmin(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RequireAdminGin verifica que el usuario autenticado tenga rol de administrador. Debe usarse
+// después de RequireAuthGin, que deja el ID del jugador en el contexto.
+func (m *AuthMiddleware) RequireAdminGin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		playerID, err := uuid.Parse(c.GetString("player_id"))
+		if err != nil {
+			c.JSON(401, gin.H{"error": "Token inválido"})
+			c.Abort()
+			return
+		}
+
+		player, err := m.playerRepo.GetPlayerByID(playerID)
+		if err != nil || player == nil {
+			m.logger.Error("Error obteniendo usuario", zap.Error(err))
+			c.JSON(401, gin.H{"error": "Usuario no encontrado"})
+			c.Abort()
+			return
+		}
+
+		if player.Role != "admin" {
+			m.logger.Warn("Usuario intentó acceder a endpoint de administración sin permisos",
+				zap.String("username", player.Username),
+				zap.String("role", player.Role))
+			c.JSON(403, gin.H{"error": "Acceso denegado. Se requieren permisos de administrador"})
+			c.Abort()
+			return
+		}
+
+		c.Set("role", player.Role)
+		c.Next()
+	}
+}
@@ -146,6 +146,14 @@ type Battle struct {
 	AttackerTactics   string `json:"attacker_tactics" db:"attacker_tactics"`     // JSON con tácticas
 	DefenderTactics   string `json:"defender_tactics" db:"defender_tactics"`     // JSON con tácticas
 
+	// Datos de repetición
+	Seed         int64  `json:"seed" db:"seed"`                   // semilla del RNG del combate
+	AttackerArmy string `json:"attacker_army" db:"attacker_army"` // JSON con el ejército atacante de entrada
+	DefenderArmy string `json:"defender_army" db:"defender_army"` // JSON con el ejército defensor de entrada
+
+	// Botín saqueado por el atacante (JSON recurso -> cantidad)
+	Loot string `json:"loot" db:"loot"`
+
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -346,6 +354,50 @@ type BattleRequest struct {
 	AdvancedConfig    map[string]interface{} `json:"advanced_config,omitempty"`
 }
 
+// BattleSimulationRequest representa una simulación "qué pasaría si" sin comprometer unidades
+type BattleSimulationRequest struct {
+	AttackerUnits     map[string]int        `json:"attacker_units"` // tipo o ID de unidad -> cantidad
+	DefenderUnits     map[string]int        `json:"defender_units"`
+	AttackerHero      *BattleSimulationHero `json:"attacker_hero,omitempty"`
+	DefenderHero      *BattleSimulationHero `json:"defender_hero,omitempty"`
+	AttackerFormation string                `json:"attacker_formation,omitempty"`
+	DefenderFormation string                `json:"defender_formation,omitempty"`
+	Terrain           string                `json:"terrain,omitempty"`
+	Weather           string                `json:"weather,omitempty"`
+	MaxWaves          int                   `json:"max_waves,omitempty"`
+	Iterations        int                   `json:"iterations,omitempty"` // iteraciones Monte-Carlo
+	Seed              int64                 `json:"seed,omitempty"`       // 0 = semilla aleatoria
+}
+
+// BattleSimulationHero representa las estadísticas del héroe que acompaña a un ejército simulado
+type BattleSimulationHero struct {
+	Attack  int `json:"attack"`
+	Defense int `json:"defense"`
+}
+
+// BattleLossDistribution resume la distribución de bajas a lo largo de las iteraciones
+type BattleLossDistribution struct {
+	Min int `json:"min"`
+	P10 int `json:"p10"`
+	P50 int `json:"p50"`
+	P90 int `json:"p90"`
+	Max int `json:"max"`
+}
+
+// BattleSimulationReport representa el resultado agregado de una simulación Monte-Carlo
+type BattleSimulationReport struct {
+	Iterations               int                               `json:"iterations"`
+	Seed                     int64                             `json:"seed"`
+	AttackerWinProbability   float64                           `json:"attacker_win_probability"`
+	DefenderWinProbability   float64                           `json:"defender_win_probability"`
+	DrawProbability          float64                           `json:"draw_probability"`
+	AverageWaves             float64                           `json:"average_waves"`
+	ExpectedAttackerLosses   map[string]float64                `json:"expected_attacker_losses"`
+	ExpectedDefenderLosses   map[string]float64                `json:"expected_defender_losses"`
+	AttackerLossDistribution map[string]BattleLossDistribution `json:"attacker_loss_distribution"`
+	DefenderLossDistribution map[string]BattleLossDistribution `json:"defender_loss_distribution"`
+}
+
 // BattleStatistics representa estadísticas de batalla de un jugador
 type BattleStatistics struct {
 	PlayerID           uuid.UUID `json:"player_id" db:"player_id"`
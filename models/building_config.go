@@ -11,9 +11,19 @@ type BuildingConfig struct {
 	GoldCost                  int     `json:"gold_cost" db:"gold_cost"`
 	BuildTimeSeconds          int     `json:"build_time_seconds" db:"build_time_seconds"`
 	ProductionPerHour         int     `json:"production_per_hour" db:"production_per_hour"`
+	ProducedResource          string  `json:"produced_resource,omitempty" db:"produced_resource"` // recurso que genera ProductionPerHour
 	StorageCapacity           int     `json:"storage_capacity" db:"storage_capacity"`
 	TrainingSpeedModifier     float64 `json:"training_speed_modifier" db:"training_speed_modifier"`
 	ConstructionSpeedModifier float64 `json:"construction_speed_modifier" db:"construction_speed_modifier"`
+
+	// Estadísticas defensivas (muralla y torres)
+	DefenseBonus float64 `json:"defense_bonus" db:"defense_bonus"` // bonificación de defensa del defensor, 0.1 = +10%
+	TowerDamage  int     `json:"tower_damage" db:"tower_damage"`   // daño infligido al atacante antes de la primera oleada
+	Durability   int     `json:"durability" db:"durability"`       // puntos de estructura que pueden dañar las unidades de asedio
+
+	// Comercio (mercado)
+	Merchants        int `json:"merchants" db:"merchants"`                 // mercaderes disponibles para envíos
+	MerchantCapacity int `json:"merchant_capacity" db:"merchant_capacity"` // recursos que carga cada mercader
 }
 
 // BuildingConfigResponse para respuestas de API
@@ -29,4 +39,7 @@ type BuildingConfigResponse struct {
 	StorageCapacity           int     `json:"storage_capacity"`
 	TrainingSpeedModifier     float64 `json:"training_speed_modifier"`
 	ConstructionSpeedModifier float64 `json:"construction_speed_modifier"`
+	DefenseBonus              float64 `json:"defense_bonus"`
+	TowerDamage               int     `json:"tower_damage"`
+	Durability                int     `json:"durability"`
 }
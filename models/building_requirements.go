@@ -15,6 +15,15 @@ type CancelUpgradeResult struct {
 	RefundReason     string              `json:"refund_reason"`
 }
 
+// BuildingRepairResult representa el resultado de reparar un edificio dañado por asedio
+type BuildingRepairResult struct {
+	BuildingType   string              `json:"building_type"`
+	Level          int                 `json:"level"`
+	RepairedDamage int                 `json:"repaired_damage"`
+	Cost           ResourceCostsLegacy `json:"cost"`
+	RepairedAt     time.Time           `json:"repaired_at"`
+}
+
 // BuildingUpgradeResultLegacy representa el resultado de una mejora de edificio (formato legacy)
 type BuildingUpgradeResultLegacy struct {
 	BuildingType   string              `json:"building_type"`
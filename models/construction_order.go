@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Estados de una orden de construcción
+const (
+	ConstructionStatusQueued    = "queued"
+	ConstructionStatusBuilding  = "building"
+	ConstructionStatusCompleted = "completed"
+	ConstructionStatusCancelled = "cancelled"
+)
+
+// Acciones de una orden de construcción
+const (
+	ConstructionActionUpgrade  = "upgrade"
+	ConstructionActionDemolish = "demolish" // baja el edificio un nivel y reembolsa parte de su coste
+)
+
+// ConstructionOrder es una mejora o demolición de edificio en la cola de construcción de una aldea.
+// Las órdenes en espera no pagan nada hasta que ocupan un hueco; entonces se cobra el coste del
+// nivel objetivo.
+type ConstructionOrder struct {
+	ID           uuid.UUID           `json:"id" db:"id"`
+	VillageID    uuid.UUID           `json:"village_id" db:"village_id"`
+	BuildingType string              `json:"building_type" db:"building_type"`
+	Action       string              `json:"action" db:"action"` // upgrade o demolish
+	TargetLevel  int                 `json:"target_level" db:"target_level"`
+	Position     int                 `json:"position" db:"position"` // orden dentro de las órdenes en espera
+	Status       string              `json:"status" db:"status"`     // queued, building, completed, cancelled
+	Cost         ResourceCostsLegacy `json:"cost" db:"cost"`         // coste cobrado al empezar; en demoliciones, reembolso al terminar
+	StartTime    *time.Time          `json:"start_time,omitempty" db:"start_time"`
+	EndTime      *time.Time          `json:"end_time,omitempty" db:"end_time"`
+	CreatedAt    time.Time           `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time           `json:"updated_at" db:"updated_at"`
+}
+
+// IsDemolition indica si la orden baja el nivel del edificio
+func (o *ConstructionOrder) IsDemolition() bool {
+	return o.Action == ConstructionActionDemolish
+}
+
+// Pending indica si la orden sigue en la cola, en espera o en obras
+func (o *ConstructionOrder) Pending() bool {
+	return o.Status == ConstructionStatusQueued || o.Status == ConstructionStatusBuilding
+}
@@ -26,6 +26,7 @@ type EconomySystemConfig struct {
 	MaxActiveListings       int                    `json:"max_active_listings"`
 	MinListingDuration      int                    `json:"min_listing_duration"`
 	MaxListingDuration      int                    `json:"max_listing_duration"`
+	MarketMaker             MarketMakerConfig      `json:"market_maker"`
 	AdvancedConfig          map[string]interface{} `json:"advanced_config"`
 	CreatedAt               time.Time              `json:"created_at"`
 	UpdatedAt               time.Time              `json:"updated_at"`
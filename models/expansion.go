@@ -0,0 +1,72 @@
+package models
+
+import (
+	"math"
+
+	"github.com/google/uuid"
+)
+
+// Reglas de expansión: fundación de aldeas con colonos y conquista con nobles
+const (
+	// CulturePerBuildingLevel son los puntos de cultura que genera cada nivel de edificio por hora
+	CulturePerBuildingLevel = 1.0
+	// CultureVillageBaseCost escala la cultura necesaria para cada aldea adicional
+	CultureVillageBaseCost = 2000
+	// SettlersPerVillage son los colonos que se consumen al fundar una aldea
+	SettlersPerVillage = 3
+
+	// LoyaltyMax es la lealtad de una aldea que nadie intenta conquistar
+	LoyaltyMax = 100
+	// LoyaltyRegenPerHour es la lealtad que recupera una aldea por hora
+	LoyaltyRegenPerHour = 2.0
+	// LoyaltyAfterConquest es la lealtad con la que queda una aldea recién conquistada
+	LoyaltyAfterConquest = 25
+	// NobleLoyaltyMin y NobleLoyaltyMax acotan la lealtad que resta cada noble superviviente
+	NobleLoyaltyMin = 20
+	NobleLoyaltyMax = 35
+)
+
+// CultureForVillages devuelve la cultura acumulada necesaria para tener n aldeas
+func CultureForVillages(n int) float64 {
+	if n <= 1 {
+		return 0
+	}
+	return float64(CultureVillageBaseCost * (n - 1) * (n - 1))
+}
+
+// MaxVillagesForCulture devuelve cuántas aldeas permite una cantidad de cultura
+func MaxVillagesForCulture(culture float64) int {
+	if culture <= 0 {
+		return 1
+	}
+	return 1 + int(math.Floor(math.Sqrt(culture/CultureVillageBaseCost)))
+}
+
+// VillageLoyalty es la lealtad actual de una aldea
+type VillageLoyalty struct {
+	VillageID uuid.UUID `json:"village_id"`
+	Name      string    `json:"name"`
+	Loyalty   int       `json:"loyalty"`
+}
+
+// ExpansionStatus resume la capacidad de expansión de un jugador
+type ExpansionStatus struct {
+	CulturePoints      float64           `json:"culture_points"`
+	CulturePerHour     float64           `json:"culture_per_hour"`
+	Villages           int               `json:"villages"`
+	PendingSettlements int               `json:"pending_settlements"` // colonos en camino
+	MaxVillages        int               `json:"max_villages"`
+	NextVillageCulture float64           `json:"next_village_culture"`
+	CanExpand          bool              `json:"can_expand"`
+	Loyalty            []*VillageLoyalty `json:"loyalty"`
+}
+
+// ConquestResult es el efecto de los nobles de un ataque victorioso
+type ConquestResult struct {
+	VillageID     uuid.UUID `json:"village_id"`
+	LoyaltyBefore int       `json:"loyalty_before"`
+	LoyaltyAfter  int       `json:"loyalty_after"`
+	Conquered     bool      `json:"conquered"`
+	PreviousOwner uuid.UUID `json:"previous_owner"`
+	BlockedReason string    `json:"blocked_reason,omitempty"` // por qué la lealtad a cero no bastó
+}
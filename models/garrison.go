@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SupportTroops representa las tropas de apoyo que un jugador mantiene estacionadas en la aldea
+// de un aliado. Siguen perteneciendo a su aldea de origen, que es quien paga su manutención.
+type SupportTroops struct {
+	OwnerPlayerID   uuid.UUID      `json:"owner_player_id" db:"owner_player_id"`
+	OriginVillageID uuid.UUID      `json:"origin_village_id" db:"origin_village_id"`
+	HostVillageID   uuid.UUID      `json:"host_village_id" db:"host_village_id"`
+	HostPlayerID    uuid.UUID      `json:"host_player_id" db:"host_player_id"`
+	Units           map[string]int `json:"units" db:"units"` // tipo_unidad -> cantidad
+	ArrivedAt       time.Time      `json:"arrived_at" db:"arrived_at"`
+}
+
+// SupportRecallRequest representa la petición de devolver tropas de apoyo a su aldea de origen.
+// Si Units está vacío se retiran todas las tropas estacionadas.
+type SupportRecallRequest struct {
+	OriginVillageID uuid.UUID      `json:"origin_village_id"`
+	HostVillageID   uuid.UUID      `json:"host_village_id"`
+	Units           map[string]int `json:"units"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IntelReport representa el informe de una misión de exploración. Cuantos más exploradores
+// sobreviven, más secciones se revelan y más precisas son las cifras.
+type IntelReport struct {
+	ID                 uuid.UUID      `json:"id" db:"id"`
+	PlayerID           uuid.UUID      `json:"player_id" db:"player_id"`
+	MarchID            uuid.UUID      `json:"march_id" db:"march_id"`
+	SourceVillageID    uuid.UUID      `json:"source_village_id" db:"source_village_id"`
+	TargetVillageID    uuid.UUID      `json:"target_village_id" db:"target_village_id"`
+	TargetPlayerID     uuid.UUID      `json:"target_player_id" db:"target_player_id"`
+	Success            bool           `json:"success" db:"success"`
+	ScoutsSent         int            `json:"scouts_sent" db:"scouts_sent"`
+	ScoutsLost         int            `json:"scouts_lost" db:"scouts_lost"`
+	DefenderScoutsLost int            `json:"defender_scouts_lost" db:"defender_scouts_lost"`
+	Accuracy           float64        `json:"accuracy" db:"accuracy"`             // 0-1, fracción de exploradores supervivientes
+	Resources          map[string]int `json:"resources,omitempty" db:"resources"` // recurso -> cantidad estimada
+	Buildings          map[string]int `json:"buildings,omitempty" db:"buildings"` // tipo_edificio -> nivel
+	Garrison           map[string]int `json:"garrison,omitempty" db:"garrison"`   // tipo_unidad -> cantidad estimada
+	CreatedAt          time.Time      `json:"created_at" db:"created_at"`
+}
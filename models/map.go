@@ -0,0 +1,147 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tipos de terreno del mapa. Coinciden con BattleTerrain.Type para que el terreno de una casilla
+// pueda usarse directamente en las batallas que se libren en ella.
+const (
+	TerrainPlain    = "plain"
+	TerrainForest   = "forest"
+	TerrainMountain = "mountain"
+	TerrainWater    = "water"
+)
+
+// TerrainCodes asigna a cada terreno el carácter con el que se codifica en los chunks y en el viewport
+var TerrainCodes = map[string]byte{
+	TerrainPlain:    'p',
+	TerrainForest:   'f',
+	TerrainMountain: 'm',
+	TerrainWater:    'w',
+}
+
+// Tipos de emplazamientos especiales del mapa
+const (
+	MapSiteOasis         = "oasis"
+	MapSiteBarbarianCamp = "barbarian_camp"
+)
+
+// WorldMap contiene los metadatos del mapa generado de un mundo. La misma semilla produce siempre
+// el mismo mapa.
+type WorldMap struct {
+	WorldID     uuid.UUID `json:"world_id" db:"world_id"`
+	Seed        int64     `json:"seed" db:"seed"`
+	Width       int       `json:"width" db:"width"`
+	Height      int       `json:"height" db:"height"`
+	ChunkSize   int       `json:"chunk_size" db:"chunk_size"`
+	GeneratedAt time.Time `json:"generated_at" db:"generated_at"`
+}
+
+// MapChunk es un bloque cuadrado de casillas. Terrain guarda un carácter por casilla, fila a fila.
+type MapChunk struct {
+	WorldID uuid.UUID `json:"world_id" db:"world_id"`
+	ChunkX  int       `json:"chunk_x" db:"chunk_x"`
+	ChunkY  int       `json:"chunk_y" db:"chunk_y"`
+	Terrain string    `json:"terrain" db:"terrain"`
+}
+
+// MapSite representa un emplazamiento especial: un oasis con bonificación de recursos o un
+// campamento bárbaro controlado por NPCs
+type MapSite struct {
+	ID        uuid.UUID      `json:"id" db:"id"`
+	WorldID   uuid.UUID      `json:"world_id" db:"world_id"`
+	X         int            `json:"x" db:"x"`
+	Y         int            `json:"y" db:"y"`
+	Type      string         `json:"type" db:"type"`                                   // oasis, barbarian_camp
+	Resource  string         `json:"resource,omitempty" db:"resource"`                 // recurso bonificado por el oasis
+	Bonus     float64        `json:"bonus,omitempty" db:"bonus"`                       // 0.25 = +25% de producción
+	Level     int            `json:"level,omitempty" db:"level"`                       // nivel del campamento bárbaro
+	Garrison  map[string]int `json:"garrison,omitempty" db:"garrison"`                 // tipo_unidad -> cantidad
+	OwnerID   *uuid.UUID     `json:"owner_village_id,omitempty" db:"owner_village_id"` // aldea que controla el oasis
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+}
+
+// MapVillage es la vista ligera de una aldea para pintar el mapa
+type MapVillage struct {
+	ID          uuid.UUID         `json:"id"`
+	Name        string            `json:"name"`
+	X           int               `json:"x"`
+	Y           int               `json:"y"`
+	PlayerID    uuid.UUID         `json:"player_id"`
+	PlayerName  string            `json:"player_name"`
+	AllianceID  *uuid.UUID        `json:"alliance_id,omitempty"`
+	AllianceTag *string           `json:"alliance_tag,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	Protection  *ProtectionStatus `json:"protection,omitempty"`
+}
+
+// MapViewport es la porción del mapa que devuelve el endpoint de viewport. Terrain contiene una
+// cadena por fila (de MinY a MaxY) con un carácter por casilla según Legend.
+type MapViewport struct {
+	WorldID  uuid.UUID         `json:"world_id"`
+	MinX     int               `json:"min_x"`
+	MinY     int               `json:"min_y"`
+	MaxX     int               `json:"max_x"`
+	MaxY     int               `json:"max_y"`
+	Terrain  []string          `json:"terrain"`
+	Legend   map[string]string `json:"legend"`
+	Sites    []*MapSite        `json:"sites"`
+	Villages []*MapVillage     `json:"villages"`
+}
+
+// VillageSearch describe una búsqueda espacial de aldeas. Con Radius se buscan las aldeas a esa
+// distancia del centro; con el rectángulo (MinX..MaxY) las que caen dentro de él; sin ninguno de
+// los dos se devuelven las Limit aldeas más cercanas al centro.
+type VillageSearch struct {
+	WorldID uuid.UUID `json:"world_id"`
+	CenterX int       `json:"x"`
+	CenterY int       `json:"y"`
+	Radius  float64   `json:"radius,omitempty"`
+	MinX    *int      `json:"min_x,omitempty"`
+	MinY    *int      `json:"min_y,omitempty"`
+	MaxX    *int      `json:"max_x,omitempty"`
+	MaxY    *int      `json:"max_y,omitempty"`
+	Limit   int       `json:"limit"`
+
+	// Filtros
+	PlayerID          *uuid.UUID `json:"player_id,omitempty"`
+	AllianceID        *uuid.UUID `json:"alliance_id,omitempty"`
+	ExcludePlayerID   *uuid.UUID `json:"exclude_player_id,omitempty"`
+	ExcludeAllianceID *uuid.UUID `json:"exclude_alliance_id,omitempty"`
+	InactiveDays      int        `json:"inactive_days,omitempty"` // dueños sin actividad en N días
+	MinScore          int        `json:"min_score,omitempty"`
+	MaxScore          int        `json:"max_score,omitempty"` // 0 = sin límite
+}
+
+// NearbyVillage es una aldea encontrada por una búsqueda espacial
+type NearbyVillage struct {
+	MapVillage
+	Score      int        `json:"score"` // suma de los niveles de los edificios
+	LastActive *time.Time `json:"last_active,omitempty"`
+	Distance   float64    `json:"distance"`
+}
+
+// Ubicaciones de inicio que puede elegir un jugador nuevo. Los cuadrantes restringen la frontera
+// de aparición a un cuarto del mapa.
+const (
+	SpawnAuto      = "auto"   // en la frontera de aparición
+	SpawnRandom    = "random" // en cualquier punto dentro de la frontera
+	SpawnCenter    = "center" // en el anillo interior
+	SpawnEdge      = "edge"   // en el borde exterior de la frontera
+	SpawnNorthWest = "north_west"
+	SpawnNorthEast = "north_east"
+	SpawnSouthWest = "south_west"
+	SpawnSouthEast = "south_east"
+	SpawnAlliance  = "alliance" // junto a los miembros de la alianza del jugador
+)
+
+// SpawnRequest describe dónde quiere aparecer un jugador que se une a un mundo
+type SpawnRequest struct {
+	WorldID      uuid.UUID  `json:"world_id"`
+	PlayerID     uuid.UUID  `json:"player_id"`
+	Location     string     `json:"location"`
+	NearPlayerID *uuid.UUID `json:"near_player_id,omitempty"` // aparecer junto a un amigo
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tipos de marcha
+const (
+	MarchTypeAttack        = "attack"
+	MarchTypeReinforcement = "reinforcement"
+	MarchTypeScout         = "scout"
+	MarchTypeSettle        = "settle" // colonos que fundan una aldea en una casilla libre
+)
+
+// Estados de marcha
+const (
+	MarchStatusInTransit = "in_transit"
+	MarchStatusReturning = "returning"
+	MarchStatusResolving = "resolving" // el planificador está aplicando su llegada
+	MarchStatusCompleted = "completed"
+	MarchStatusCancelled = "cancelled"
+)
+
+// March representa un movimiento de tropas entre dos aldeas
+type March struct {
+	ID              uuid.UUID      `json:"id" db:"id"`
+	PlayerID        uuid.UUID      `json:"player_id" db:"player_id"`
+	SourceVillageID uuid.UUID      `json:"source_village_id" db:"source_village_id"`
+	TargetVillageID uuid.UUID      `json:"target_village_id" db:"target_village_id"` // uuid.Nil en las marchas de colonización
+	TargetX         *int           `json:"target_x,omitempty" db:"target_x"`         // casilla de destino de los colonos
+	TargetY         *int           `json:"target_y,omitempty" db:"target_y"`
+	Type            string         `json:"type" db:"type"`     // attack, reinforcement, scout, settle
+	Status          string         `json:"status" db:"status"` // in_transit, returning, resolving, completed, cancelled
+	Units           map[string]int `json:"units" db:"units"`   // tipo_unidad -> cantidad
+	Loot            map[string]int `json:"loot" db:"loot"`     // recurso -> cantidad saqueada
+	Distance        float64        `json:"distance" db:"distance"`
+	DepartureTime   time.Time      `json:"departure_time" db:"departure_time"`
+	ArrivalTime     time.Time      `json:"arrival_time" db:"arrival_time"`
+	ReturnTime      *time.Time     `json:"return_time,omitempty" db:"return_time"`
+	BattleID        *uuid.UUID     `json:"battle_id,omitempty" db:"battle_id"`
+	CreatedAt       time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// MarchRequest representa una orden de marcha
+type MarchRequest struct {
+	PlayerID        uuid.UUID      `json:"player_id"`
+	SourceVillageID uuid.UUID      `json:"source_village_id"`
+	TargetVillageID uuid.UUID      `json:"target_village_id"`
+	TargetX         *int           `json:"target_x,omitempty"` // solo para marchas de colonización
+	TargetY         *int           `json:"target_y,omitempty"`
+	Type            string         `json:"type"`
+	Units           map[string]int `json:"units"`
+}
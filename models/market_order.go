@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Lados de una orden de la bolsa
+const (
+	MarketOrderBuy  = "buy"  // compra el recurso pagando oro
+	MarketOrderSell = "sell" // vende el recurso a cambio de oro
+)
+
+// Estados de una orden de la bolsa
+const (
+	MarketOrderOpen      = "open"
+	MarketOrderFilled    = "filled"
+	MarketOrderCancelled = "cancelled"
+)
+
+// MarketOrder es una orden limitada en el libro de órdenes de un mundo. Cada recurso cotiza en
+// oro: las órdenes de venta dejan en depósito el recurso y las de compra el oro de la cantidad al
+// precio límite. Las órdenes se casan por precio y, a igual precio, por antigüedad; el precio de
+// cada cruce es el de la orden que ya estaba en el libro.
+type MarketOrder struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	WorldID      uuid.UUID `json:"world_id" db:"world_id"`
+	PlayerID     uuid.UUID `json:"player_id" db:"player_id"`
+	VillageID    uuid.UUID `json:"village_id" db:"village_id"`
+	ResourceType string    `json:"resource_type" db:"resource_type"` // wood, stone, food
+	Side         string    `json:"side" db:"side"`                   // buy, sell
+	Price        int       `json:"price" db:"price"`                 // oro por unidad
+	Quantity     int       `json:"quantity" db:"quantity"`
+	Filled       int       `json:"filled" db:"filled"`
+	Status       string    `json:"status" db:"status"` // open, filled, cancelled
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Remaining devuelve la cantidad que queda por cruzar
+func (o *MarketOrder) Remaining() int {
+	return o.Quantity - o.Filled
+}
+
+// PlaceMarketOrderRequest coloca una orden limitada desde una aldea del jugador
+type PlaceMarketOrderRequest struct {
+	VillageID    uuid.UUID `json:"village_id" binding:"required"`
+	ResourceType string    `json:"resource_type" binding:"required"`
+	Side         string    `json:"side" binding:"required"`
+	Price        int       `json:"price" binding:"required,min=1"`
+	Quantity     int       `json:"quantity" binding:"required,min=1"`
+}
+
+// MarketOrderResult es una orden recién colocada con los cruces que ha producido
+type MarketOrderResult struct {
+	Order *MarketOrder        `json:"order"`
+	Fills []*TradeTransaction `json:"fills"`
+}
+
+// OrderBookLevel agrupa las órdenes abiertas de un lado del libro a un mismo precio
+type OrderBookLevel struct {
+	Price    int `json:"price"`
+	Quantity int `json:"quantity"`
+	Orders   int `json:"orders"`
+}
+
+// OrderBook es la profundidad del libro de un recurso en un mundo
+type OrderBook struct {
+	WorldID      uuid.UUID         `json:"world_id"`
+	ResourceType string            `json:"resource_type"`
+	Bids         []*OrderBookLevel `json:"bids"` // compras, de mayor a menor precio
+	Asks         []*OrderBookLevel `json:"asks"` // ventas, de menor a mayor precio
+}
+
+// Intervalos de las velas de precio
+const (
+	CandleInterval1m = "1m"
+	CandleInterval1h = "1h"
+	CandleInterval1d = "1d"
+)
+
+// PriceCandle resume los cruces de un recurso en un intervalo de tiempo. Turnover es el oro
+// movido, de modo que Turnover/Volume es el precio medio ponderado del intervalo.
+type PriceCandle struct {
+	BucketStart time.Time `json:"bucket_start" db:"bucket_start"`
+	Open        int       `json:"open" db:"open"`
+	High        int       `json:"high" db:"high"`
+	Low         int       `json:"low" db:"low"`
+	Close       int       `json:"close" db:"close"`
+	Volume      int64     `json:"volume" db:"volume"`
+	Turnover    int64     `json:"turnover" db:"turnover"`
+	Trades      int       `json:"trades" db:"trades"`
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MarketMakerConfig son los parámetros del mercader NPC de cada mundo
+type MarketMakerConfig struct {
+	Enabled            bool    `json:"enabled"`
+	Fee                float64 `json:"fee"`                  // fracción de lo entregado que se queda en la reserva
+	InitialReserve     int64   `json:"initial_reserve"`      // reserva inicial de madera, piedra y comida
+	InitialGoldReserve int64   `json:"initial_gold_reserve"` // reserva inicial de oro
+	MaxPriceImpact     float64 `json:"max_price_impact"`     // impacto máximo de un cambio en el precio, 0-1
+}
+
+// MarketPool es el mercader NPC de un mundo. Guarda una reserva de cada recurso y cambia uno por
+// otro según una curva de producto constante entre las dos reservas del par: cuanto más se cambia
+// de una vez, peor es el precio. La comisión se queda en la reserva del recurso entregado, así que
+// el uso va engordando las reservas y suavizando la curva.
+type MarketPool struct {
+	WorldID   uuid.UUID `json:"world_id" db:"world_id"`
+	Wood      int64     `json:"wood" db:"wood"`
+	Stone     int64     `json:"stone" db:"stone"`
+	Food      int64     `json:"food" db:"food"`
+	Gold      int64     `json:"gold" db:"gold"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// reserve devuelve la reserva de un recurso, o nil si el mercader no lo comercia
+func (p *MarketPool) reserve(resourceType string) *int64 {
+	switch resourceType {
+	case "wood":
+		return &p.Wood
+	case "stone":
+		return &p.Stone
+	case "food":
+		return &p.Food
+	case "gold":
+		return &p.Gold
+	}
+	return nil
+}
+
+// Quote calcula lo que se recibe por amountIn unidades de from. Devuelve nil si el par no es
+// válido o la reserva de origen está vacía.
+func (p *MarketPool) Quote(from, to string, amountIn int, fee float64) *SwapQuote {
+	reserveIn, reserveOut := p.reserve(from), p.reserve(to)
+	if reserveIn == nil || reserveOut == nil || from == to || amountIn <= 0 || *reserveIn <= 0 {
+		return nil
+	}
+
+	feeAmount := int64(math.Ceil(float64(amountIn) * fee))
+	netIn := int64(amountIn) - feeAmount
+	if netIn < 0 {
+		netIn = 0
+	}
+	// x·y = k: lo que sale deja el producto de las reservas igual al de antes sin la comisión
+	amountOut := *reserveOut * netIn / (*reserveIn + netIn)
+
+	quote := &SwapQuote{
+		FromResource: from,
+		ToResource:   to,
+		AmountIn:     amountIn,
+		AmountOut:    int(amountOut),
+		Fee:          int(feeAmount),
+		SpotPrice:    float64(*reserveOut) / float64(*reserveIn),
+		PriceImpact:  float64(netIn) / float64(*reserveIn+netIn),
+	}
+	quote.EffectivePrice = float64(quote.AmountOut) / float64(amountIn)
+	return quote
+}
+
+// Apply mueve las reservas según un cambio ya cotizado: entra todo lo entregado, comisión incluida,
+// y sale lo recibido
+func (p *MarketPool) Apply(quote *SwapQuote) {
+	*p.reserve(quote.FromResource) += int64(quote.AmountIn)
+	*p.reserve(quote.ToResource) -= int64(quote.AmountOut)
+}
+
+// SwapQuote es el resultado de cambiar una cantidad de un recurso con el mercader NPC
+type SwapQuote struct {
+	FromResource   string  `json:"from_resource"`
+	ToResource     string  `json:"to_resource"`
+	AmountIn       int     `json:"amount_in"`
+	AmountOut      int     `json:"amount_out"`
+	Fee            int     `json:"fee"`             // parte de amount_in que se queda en la reserva
+	SpotPrice      float64 `json:"spot_price"`      // unidades de destino por unidad de origen antes del cambio
+	EffectivePrice float64 `json:"effective_price"` // amount_out / amount_in
+	PriceImpact    float64 `json:"price_impact"`    // empeoramiento del precio por el tamaño del cambio, 0-1
+}
+
+// PoolSwapRequest cambia recursos de una aldea con el mercader NPC de su mundo. MinAmountOut es la
+// cantidad mínima aceptada: si el precio empeora antes de ejecutar el cambio, se rechaza.
+type PoolSwapRequest struct {
+	VillageID    uuid.UUID `json:"village_id" binding:"required"`
+	FromResource string    `json:"from_resource" binding:"required"`
+	ToResource   string    `json:"to_resource" binding:"required"`
+	Amount       int       `json:"amount" binding:"required,min=1"`
+	MinAmountOut int       `json:"min_amount_out" binding:"min=0"`
+}
+
+// PoolSwap es un cambio ejecutado con el mercader NPC
+type PoolSwap struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	WorldID      uuid.UUID `json:"world_id" db:"world_id"`
+	PlayerID     uuid.UUID `json:"player_id" db:"player_id"`
+	VillageID    uuid.UUID `json:"village_id" db:"village_id"`
+	FromResource string    `json:"from_resource" db:"from_resource"`
+	ToResource   string    `json:"to_resource" db:"to_resource"`
+	AmountIn     int       `json:"amount_in" db:"amount_in"`
+	AmountOut    int       `json:"amount_out" db:"amount_out"`
+	Fee          int       `json:"fee" db:"fee"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
@@ -30,9 +30,10 @@ type Player struct {
 	UpdatedAt    time.Time  `json:"updatedAt"`
 
 	// Campos relacionados (opcionales)
-	Villages     []VillageWithDetails `json:"villages,omitempty"`
-	Achievements []SimpleAchievement  `json:"achievements,omitempty"`
-	Titles       []Title              `json:"titles,omitempty"`
+	Villages     []VillageWithDetails  `json:"villages,omitempty"`
+	Achievements []SimpleAchievement   `json:"achievements,omitempty"`
+	Titles       []Title               `json:"titles,omitempty"`
+	Protection   *PlayerProtectionInfo `json:"protection,omitempty"`
 }
 
 // SimpleAchievement representa un logro simple para el dashboard
@@ -0,0 +1,96 @@
+package models
+
+// Fuentes de modificadores de producción
+const (
+	ProductionSourceResearch = "research"
+	ProductionSourceHero     = "hero"
+	ProductionSourceAlliance = "alliance"
+	ProductionSourceEvent    = "event"
+	ProductionSourceTitle    = "title"
+	ProductionSourceOasis    = "oasis"
+	ProductionSourceWorld    = "world"
+)
+
+// ProductionResourceAll indica que un modificador afecta a los cuatro recursos
+const ProductionResourceAll = "all"
+
+// ProductionModifier es una bonificación de producción aportada por una fuente. La producción de
+// cada recurso es (base + Σ Flat) × (1 + Σ Percent) × Π Multiplier.
+type ProductionModifier struct {
+	Source     string  `json:"source"`
+	Name       string  `json:"name"`                 // qué la origina: tecnología, oasis, evento...
+	Resource   string  `json:"resource"`             // wood, stone, food, gold o all
+	Flat       float64 `json:"flat,omitempty"`       // unidades por hora sumadas a la base
+	Percent    float64 `json:"percent,omitempty"`    // 0.1 = +10% sobre la base
+	Multiplier float64 `json:"multiplier,omitempty"` // factor aplicado al final; 0 = sin efecto
+}
+
+// Applies indica si el modificador afecta al recurso
+func (m ProductionModifier) Applies(resource string) bool {
+	return m.Resource == resource || m.Resource == ProductionResourceAll
+}
+
+// ProductionEffectSource es un héroe activo o un título equipado con sus efectos en JSON, tal como
+// los leen los proveedores de producción
+type ProductionEffectSource struct {
+	Name    string
+	Level   int
+	Effects string
+}
+
+// ProductionAmounts es una producción por hora de cada recurso
+type ProductionAmounts struct {
+	Wood  int `json:"wood"`
+	Stone int `json:"stone"`
+	Food  int `json:"food"`
+	Gold  int `json:"gold"`
+}
+
+// Get devuelve la producción de un recurso
+func (a ProductionAmounts) Get(resource string) int {
+	switch resource {
+	case "wood":
+		return a.Wood
+	case "stone":
+		return a.Stone
+	case "food":
+		return a.Food
+	case "gold":
+		return a.Gold
+	}
+	return 0
+}
+
+// Set fija la producción de un recurso
+func (a *ProductionAmounts) Set(resource string, amount int) {
+	switch resource {
+	case "wood":
+		a.Wood = amount
+	case "stone":
+		a.Stone = amount
+	case "food":
+		a.Food = amount
+	case "gold":
+		a.Gold = amount
+	}
+}
+
+// Add suma producción a un recurso
+func (a *ProductionAmounts) Add(resource string, amount int) {
+	a.Set(resource, a.Get(resource)+amount)
+}
+
+// ProductionContribution es la producción que aporta una fuente de modificadores
+type ProductionContribution struct {
+	Source    string               `json:"source"`
+	Amounts   ProductionAmounts    `json:"amounts"`
+	Modifiers []ProductionModifier `json:"modifiers"`
+}
+
+// ProductionBreakdown desglosa la producción bruta de una aldea: la base de los edificios y lo que
+// suma cada fuente. Base más las aportaciones de todas las fuentes es igual a Total.
+type ProductionBreakdown struct {
+	Base    ProductionAmounts         `json:"base"`
+	Sources []*ProductionContribution `json:"sources"`
+	Total   ProductionAmounts         `json:"total"`
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tipos de protección contra ataques
+const (
+	ProtectionBeginner = "beginner" // escudo de principiante de las aldeas nuevas
+	ProtectionVacation = "vacation" // modo vacaciones: sin ataques ni producción
+	ProtectionPeace    = "peace"    // escudo de paz comprado
+)
+
+// PeaceShieldOption es una duración de escudo de paz que se puede comprar con moneda global
+type PeaceShieldOption struct {
+	Hours int   `json:"hours"`
+	Cost  int64 `json:"cost"`
+}
+
+// ProtectionRules son las reglas de protección de un tipo de mundo
+type ProtectionRules struct {
+	AttacksAllowed      bool                `json:"attacks_allowed"`
+	BeginnerShield      time.Duration       `json:"beginner_shield"`
+	VacationEnabled     bool                `json:"vacation_enabled"`
+	VacationMinDuration time.Duration       `json:"vacation_min_duration"`
+	VacationMaxDuration time.Duration       `json:"vacation_max_duration"`
+	VacationCooldown    time.Duration       `json:"vacation_cooldown"` // desde que termina el modo vacaciones
+	PeaceShields        []PeaceShieldOption `json:"peace_shields,omitempty"`
+}
+
+// WorldProtectionRules asigna las reglas de protección a cada World.WorldType
+var WorldProtectionRules = map[string]ProtectionRules{
+	"normal": {
+		AttacksAllowed:      true,
+		BeginnerShield:      72 * time.Hour,
+		VacationEnabled:     true,
+		VacationMinDuration: 48 * time.Hour,
+		VacationMaxDuration: 14 * 24 * time.Hour,
+		VacationCooldown:    7 * 24 * time.Hour,
+		PeaceShields: []PeaceShieldOption{
+			{Hours: 8, Cost: 50},
+			{Hours: 24, Cost: 120},
+			{Hours: 72, Cost: 300},
+		},
+	},
+	"pvp": {
+		AttacksAllowed:      true,
+		BeginnerShield:      24 * time.Hour,
+		VacationEnabled:     true,
+		VacationMinDuration: 48 * time.Hour,
+		VacationMaxDuration: 7 * 24 * time.Hour,
+		VacationCooldown:    14 * 24 * time.Hour,
+	},
+	"peaceful": {
+		AttacksAllowed: false,
+	},
+}
+
+// GetProtectionRules devuelve las reglas de un tipo de mundo; los tipos desconocidos usan las de "normal"
+func GetProtectionRules(worldType string) ProtectionRules {
+	if rules, exists := WorldProtectionRules[worldType]; exists {
+		return rules
+	}
+	return WorldProtectionRules["normal"]
+}
+
+// PlayerProtection es el estado de protección persistido de un jugador
+type PlayerProtection struct {
+	PlayerID              uuid.UUID  `json:"player_id" db:"player_id"`
+	BeginnerForfeitedAt   *time.Time `json:"beginner_forfeited_at,omitempty" db:"beginner_forfeited_at"` // el jugador atacó y perdió el escudo
+	VacationStartedAt     *time.Time `json:"vacation_started_at,omitempty" db:"vacation_started_at"`
+	VacationUntil         *time.Time `json:"vacation_until,omitempty" db:"vacation_until"`
+	VacationCooldownUntil *time.Time `json:"vacation_cooldown_until,omitempty" db:"vacation_cooldown_until"`
+	PeaceUntil            *time.Time `json:"peace_until,omitempty" db:"peace_until"`
+	UpdatedAt             time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// ProtectionStatus es la protección vigente de una aldea
+type ProtectionStatus struct {
+	Type  string    `json:"type"`
+	Until time.Time `json:"until"`
+}
+
+// PlayerProtectionInfo resume la protección de un jugador para su perfil
+type PlayerProtectionInfo struct {
+	WorldType             string                          `json:"world_type"`
+	AttacksAllowed        bool                            `json:"attacks_allowed"`
+	Villages              map[uuid.UUID]*ProtectionStatus `json:"villages"`
+	OnVacation            bool                            `json:"on_vacation"`
+	VacationUntil         *time.Time                      `json:"vacation_until,omitempty"`
+	VacationAvailableAt   *time.Time                      `json:"vacation_available_at,omitempty"`
+	PeaceUntil            *time.Time                      `json:"peace_until,omitempty"`
+	BeginnerShieldForfeit bool                            `json:"beginner_shield_forfeited"`
+	Rules                 ProtectionRules                 `json:"rules"`
+}
+
+// VacationRequest es la solicitud para activar el modo vacaciones
+type VacationRequest struct {
+	Hours int `json:"hours" binding:"required,min=1"`
+}
+
+// PeaceShieldRequest es la solicitud para comprar un escudo de paz
+type PeaceShieldRequest struct {
+	Hours int `json:"hours" binding:"required,min=1"`
+}
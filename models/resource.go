@@ -42,12 +42,15 @@ type ResourceMetrics struct {
 
 // ResourceProduction representa la producción de recursos de una aldea
 type ResourceProduction struct {
-	VillageID  uuid.UUID `json:"village_id"`
-	Wood       int       `json:"wood"`
-	Stone      int       `json:"stone"`
-	Food       int       `json:"food"`
-	Gold       int       `json:"gold"`
-	LastUpdate time.Time `json:"last_update"`
+	VillageID      uuid.UUID            `json:"village_id"`
+	Wood           int                  `json:"wood"`
+	Stone          int                  `json:"stone"`
+	Food           int                  `json:"food"` // producción neta: FoodProduction - FoodUpkeep
+	Gold           int                  `json:"gold"`
+	FoodProduction int                  `json:"food_production,omitempty"`
+	FoodUpkeep     int                  `json:"food_upkeep,omitempty"`
+	Breakdown      *ProductionBreakdown `json:"breakdown,omitempty"` // de dónde sale la producción
+	LastUpdate     time.Time            `json:"last_update"`
 }
 
 // ResourceStorage representa la capacidad de almacenamiento de una aldea
@@ -6,12 +6,21 @@ import (
 	"github.com/google/uuid"
 )
 
-// TradeOffer representa una oferta en el mercado
+// Estados de una oferta de comercio
+const (
+	TradeOfferActive    = "active"
+	TradeOfferCompleted = "completed"
+	TradeOfferCancelled = "cancelled"
+)
+
+// TradeOffer representa una oferta en el mercado. Al publicarla se retiran de la aldea del vendedor
+// los recursos ofrecidos y quedan en depósito hasta que se venden o se cancela la oferta; Amount es
+// lo que queda por vender. El comprador paga en oro.
 type TradeOffer struct {
 	ID           uuid.UUID `json:"id" db:"id"`
 	SellerID     uuid.UUID `json:"seller_id" db:"seller_id"`
 	VillageID    uuid.UUID `json:"village_id" db:"village_id"`
-	ResourceType string    `json:"resource_type" db:"resource_type"` // wood, stone, food, gold
+	ResourceType string    `json:"resource_type" db:"resource_type"` // wood, stone, food
 	Amount       int       `json:"amount" db:"amount"`
 	PricePerUnit int       `json:"price_per_unit" db:"price_per_unit"`
 	Status       string    `json:"status" db:"status"` // active, completed, cancelled
@@ -19,22 +28,35 @@ type TradeOffer struct {
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
 
-// TradeTransaction representa una transacción de comercio
+// TradeTransaction representa una transacción de comercio: la compra de una oferta del mercado o
+// un cruce de la bolsa entre una orden de compra y otra de venta
 type TradeTransaction struct {
-	ID              uuid.UUID `json:"id" db:"id"`
-	OfferID         uuid.UUID `json:"offer_id" db:"offer_id"`
-	BuyerID         uuid.UUID `json:"buyer_id" db:"buyer_id"`
-	SellerID        uuid.UUID `json:"seller_id" db:"seller_id"`
-	BuyerVillageID  uuid.UUID `json:"buyer_village_id" db:"buyer_village_id"`
-	SellerVillageID uuid.UUID `json:"seller_village_id" db:"seller_village_id"`
-	ResourceType    string    `json:"resource_type" db:"resource_type"`
-	Amount          int       `json:"amount" db:"amount"`
-	PricePerUnit    int       `json:"price_per_unit" db:"price_per_unit"`
-	TotalPrice      int       `json:"total_price" db:"total_price"`
-	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	ID              uuid.UUID  `json:"id" db:"id"`
+	OfferID         *uuid.UUID `json:"offer_id,omitempty" db:"offer_id"`
+	BuyOrderID      *uuid.UUID `json:"buy_order_id,omitempty" db:"buy_order_id"`
+	SellOrderID     *uuid.UUID `json:"sell_order_id,omitempty" db:"sell_order_id"`
+	BuyerID         uuid.UUID  `json:"buyer_id" db:"buyer_id"`
+	SellerID        uuid.UUID  `json:"seller_id" db:"seller_id"`
+	BuyerVillageID  uuid.UUID  `json:"buyer_village_id" db:"buyer_village_id"`
+	SellerVillageID uuid.UUID  `json:"seller_village_id" db:"seller_village_id"`
+	ResourceType    string     `json:"resource_type" db:"resource_type"`
+	Amount          int        `json:"amount" db:"amount"`
+	PricePerUnit    int        `json:"price_per_unit" db:"price_per_unit"`
+	TotalPrice      int        `json:"total_price" db:"total_price"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
 }
 
-// DirectTrade representa un intercambio directo entre jugadores
+// Estados de un intercambio directo
+const (
+	DirectTradePending   = "pending"
+	DirectTradeAccepted  = "accepted"
+	DirectTradeDeclined  = "declined"
+	DirectTradeExpired   = "expired"
+	DirectTradeCancelled = "cancelled" // la aldea de una de las partes ha cambiado de dueño
+)
+
+// DirectTrade representa un intercambio directo entre jugadores. Lo ofrecido queda en depósito
+// en la aldea del iniciador hasta que el destinatario acepta, rechaza o el intercambio caduca.
 type DirectTrade struct {
 	ID                 uuid.UUID `json:"id" db:"id"`
 	InitiatorID        uuid.UUID `json:"initiator_id" db:"initiator_id"`
@@ -95,3 +117,64 @@ type TradeNotification struct {
 	Read      bool      `json:"read" db:"read"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
+
+// Estados de un envío de mercaderes
+const (
+	ShipmentInTransit = "in_transit" // los mercaderes viajan con la mercancía
+	ShipmentReturning = "returning"  // la mercancía está entregada y los mercaderes vuelven
+	ShipmentCompleted = "completed"  // los mercaderes están de nuevo disponibles
+)
+
+// MerchantShipment es un viaje de mercaderes que lleva recursos de una aldea a otra. Los
+// mercaderes siguen ocupados hasta que vuelven a su aldea.
+type MerchantShipment struct {
+	ID              uuid.UUID           `json:"id" db:"id"`
+	PlayerID        uuid.UUID           `json:"player_id" db:"player_id"` // dueño de los mercaderes
+	SourceVillageID uuid.UUID           `json:"source_village_id" db:"source_village_id"`
+	TargetVillageID uuid.UUID           `json:"target_village_id" db:"target_village_id"`
+	ReceiverID      uuid.UUID           `json:"receiver_id" db:"receiver_id"`
+	TransactionID   *uuid.UUID          `json:"transaction_id,omitempty" db:"transaction_id"`   // compra en el mercado
+	DirectTradeID   *uuid.UUID          `json:"direct_trade_id,omitempty" db:"direct_trade_id"` // intercambio directo
+	Goods           ResourceCostsLegacy `json:"goods" db:"goods"`                               // mercancía transportada
+	Merchants       int                 `json:"merchants" db:"merchants"`
+	Distance        float64             `json:"distance" db:"distance"`
+	Status          string              `json:"status" db:"status"` // in_transit, returning, completed
+	DepartureTime   time.Time           `json:"departure_time" db:"departure_time"`
+	ArrivalTime     time.Time           `json:"arrival_time" db:"arrival_time"`
+	ReturnTime      time.Time           `json:"return_time" db:"return_time"`
+	CreatedAt       time.Time           `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time           `json:"updated_at" db:"updated_at"`
+}
+
+// MerchantDispatch describe los mercaderes con los que una aldea puede atender un envío
+type MerchantDispatch struct {
+	VillageID  uuid.UUID     `json:"village_id"`
+	Merchants  int           `json:"merchants"` // mercaderes que da el mercado de la aldea
+	Capacity   int           `json:"capacity"`  // recursos que carga cada mercader
+	Distance   float64       `json:"distance"`
+	TravelTime time.Duration `json:"travel_time"` // duración del viaje de ida
+}
+
+// MerchantsNeeded devuelve los mercaderes necesarios para transportar una carga
+func (d *MerchantDispatch) MerchantsNeeded(load int) int {
+	if d.Capacity <= 0 {
+		return 0
+	}
+	return (load + d.Capacity - 1) / d.Capacity
+}
+
+// MerchantStatus resume los mercaderes de una aldea
+type MerchantStatus struct {
+	Total     int `json:"total"`
+	Busy      int `json:"busy"`
+	Available int `json:"available"`
+	Capacity  int `json:"capacity"` // carga por mercader
+}
+
+// VillageShipments son los envíos de mercaderes que salen de una aldea o se dirigen a ella
+type VillageShipments struct {
+	VillageID uuid.UUID           `json:"village_id"`
+	Merchants MerchantStatus      `json:"merchants"`
+	Outgoing  []*MerchantShipment `json:"outgoing"`
+	Incoming  []*MerchantShipment `json:"incoming"`
+}
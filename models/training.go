@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Estados de un lote de entrenamiento
+const (
+	TrainingStatusQueued    = "queued"
+	TrainingStatusTraining  = "training"
+	TrainingStatusCompleted = "completed"
+	TrainingStatusCancelled = "cancelled"
+)
+
+// TrainingBatch representa un lote de unidades en la cola de entrenamiento de un edificio.
+// Las unidades salen de una en una: cada UnitTime segundos desde StartTime se completa una.
+type TrainingBatch struct {
+	ID                 uuid.UUID           `json:"id" db:"id"`
+	VillageID          uuid.UUID           `json:"village_id" db:"village_id"`
+	Building           string              `json:"building" db:"building"`
+	UnitType           string              `json:"unit_type" db:"unit_type"`
+	Quantity           int                 `json:"quantity" db:"quantity"`
+	Completed          int                 `json:"completed" db:"completed"`
+	UnitTime           int                 `json:"unit_time" db:"unit_time"` // segundos por unidad, con bonificaciones
+	UnitCost           ResourceCostsLegacy `json:"unit_cost" db:"unit_cost"` // coste pagado por unidad
+	Position           int                 `json:"position" db:"position"`   // orden dentro de la cola del edificio
+	Status             string              `json:"status" db:"status"`       // queued, training, completed, cancelled
+	StartTime          *time.Time          `json:"start_time,omitempty" db:"start_time"`
+	NextCompletionTime *time.Time          `json:"next_completion_time,omitempty" db:"next_completion_time"`
+	EndTime            *time.Time          `json:"end_time,omitempty" db:"end_time"`
+	CreatedAt          time.Time           `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time           `json:"updated_at" db:"updated_at"`
+}
+
+// Remaining devuelve las unidades del lote que aún no se han entrenado
+func (b *TrainingBatch) Remaining() int {
+	return b.Quantity - b.Completed
+}
+
+// Start pone en marcha el lote en startAt, o al crearse si se encoló después
+func (b *TrainingBatch) Start(startAt time.Time) {
+	start := startAt
+	if b.CreatedAt.After(start) {
+		start = b.CreatedAt
+	}
+	next := start.Add(time.Duration(b.UnitTime) * time.Second)
+	end := start.Add(time.Duration(b.UnitTime*b.Quantity) * time.Second)
+	b.Status = TrainingStatusTraining
+	b.StartTime = &start
+	b.NextCompletionTime = &next
+	b.EndTime = &end
+}
+
+// TrainingCancelResult representa el resultado de cancelar un lote de entrenamiento
+type TrainingCancelResult struct {
+	BatchID          uuid.UUID           `json:"batch_id"`
+	UnitType         string              `json:"unit_type"`
+	UnitsCancelled   int                 `json:"units_cancelled"`
+	UnitsTrained     int                 `json:"units_trained"`
+	Refund           ResourceCostsLegacy `json:"refund"`
+	RefundPercentage float64             `json:"refund_percentage"`
+	CancelledAt      time.Time           `json:"cancelled_at"`
+}
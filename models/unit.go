@@ -21,10 +21,14 @@ type UnitType struct {
 	Type        string `json:"type"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	Category    string `json:"category"` // infantry, cavalry, archer, scout (categoría de combate)
 	Attack      int    `json:"attack"`
 	Defense     int    `json:"defense"`
+	Health      int    `json:"health"`
 	Speed       int    `json:"speed"`
 	Capacity    int    `json:"capacity"`
+	Upkeep      int    `json:"upkeep"`       // comida consumida por unidad y hora
+	SiegeDamage int    `json:"siege_damage"` // puntos de estructura que inflige a la muralla cada superviviente
 	Cost        struct {
 		Wood  int `json:"wood"`
 		Stone int `json:"stone"`
@@ -32,6 +36,10 @@ type UnitType struct {
 		Gold  int `json:"gold"`
 	} `json:"cost"`
 	TrainingTime int `json:"training_time"` // en segundos
+
+	// Edificio que entrena la unidad y nivel mínimo requerido
+	Building      string `json:"building"`
+	RequiredLevel int    `json:"required_level"`
 }
 
 // Definir tipos de unidades disponibles
@@ -40,10 +48,13 @@ var UnitTypes = map[string]UnitType{
 		Type:        "warrior",
 		Name:        "Guerrero",
 		Description: "Unidad básica de combate",
+		Category:    "infantry",
 		Attack:      10,
 		Defense:     8,
+		Health:      50,
 		Speed:       5,
 		Capacity:    10,
+		Upkeep:      1,
 		Cost: struct {
 			Wood  int `json:"wood"`
 			Stone int `json:"stone"`
@@ -55,16 +66,21 @@ var UnitTypes = map[string]UnitType{
 			Food:  20,
 			Gold:  10,
 		},
-		TrainingTime: 60,
+		TrainingTime:  60,
+		Building:      "barracks",
+		RequiredLevel: 1,
 	},
 	"archer": {
 		Type:        "archer",
 		Name:        "Arquero",
 		Description: "Unidad de ataque a distancia",
+		Category:    "archer",
 		Attack:      15,
 		Defense:     5,
+		Health:      35,
 		Speed:       6,
 		Capacity:    8,
+		Upkeep:      1,
 		Cost: struct {
 			Wood  int `json:"wood"`
 			Stone int `json:"stone"`
@@ -76,16 +92,21 @@ var UnitTypes = map[string]UnitType{
 			Food:  25,
 			Gold:  15,
 		},
-		TrainingTime: 90,
+		TrainingTime:  90,
+		Building:      "barracks",
+		RequiredLevel: 2,
 	},
 	"knight": {
 		Type:        "knight",
 		Name:        "Caballero",
 		Description: "Unidad de élite con alta defensa",
+		Category:    "cavalry",
 		Attack:      12,
 		Defense:     15,
+		Health:      80,
 		Speed:       4,
 		Capacity:    15,
+		Upkeep:      2,
 		Cost: struct {
 			Wood  int `json:"wood"`
 			Stone int `json:"stone"`
@@ -97,16 +118,21 @@ var UnitTypes = map[string]UnitType{
 			Food:  40,
 			Gold:  30,
 		},
-		TrainingTime: 120,
+		TrainingTime:  120,
+		Building:      "barracks",
+		RequiredLevel: 5,
 	},
 	"scout": {
 		Type:        "scout",
 		Name:        "Explorador",
 		Description: "Unidad rápida para exploración",
+		Category:    "scout",
 		Attack:      5,
 		Defense:     3,
+		Health:      20,
 		Speed:       10,
 		Capacity:    5,
+		Upkeep:      1,
 		Cost: struct {
 			Wood  int `json:"wood"`
 			Stone int `json:"stone"`
@@ -118,6 +144,87 @@ var UnitTypes = map[string]UnitType{
 			Food:  15,
 			Gold:  5,
 		},
-		TrainingTime: 45,
+		TrainingTime:  45,
+		Building:      "barracks",
+		RequiredLevel: 1,
+	},
+	"ram": {
+		Type:        "ram",
+		Name:        "Ariete",
+		Description: "Máquina de asedio que daña las murallas",
+		Category:    "siege",
+		Attack:      4,
+		Defense:     2,
+		Health:      60,
+		Speed:       3,
+		Capacity:    0,
+		Upkeep:      3,
+		SiegeDamage: 20,
+		Cost: struct {
+			Wood  int `json:"wood"`
+			Stone int `json:"stone"`
+			Food  int `json:"food"`
+			Gold  int `json:"gold"`
+		}{
+			Wood:  120,
+			Stone: 80,
+			Food:  40,
+			Gold:  20,
+		},
+		TrainingTime:  180,
+		Building:      "barracks",
+		RequiredLevel: 8,
+	},
+	"settler": {
+		Type:        "settler",
+		Name:        "Colono",
+		Description: "Funda una nueva aldea en una casilla libre del mapa",
+		Category:    "civilian",
+		Attack:      0,
+		Defense:     5,
+		Health:      40,
+		Speed:       3,
+		Capacity:    0,
+		Upkeep:      2,
+		Cost: struct {
+			Wood  int `json:"wood"`
+			Stone int `json:"stone"`
+			Food  int `json:"food"`
+			Gold  int `json:"gold"`
+		}{
+			Wood:  1500,
+			Stone: 1500,
+			Food:  1200,
+			Gold:  800,
+		},
+		TrainingTime:  3600,
+		Building:      "town_hall",
+		RequiredLevel: 10,
+	},
+	"noble": {
+		Type:        "noble",
+		Name:        "Noble",
+		Description: "Reduce la lealtad de las aldeas enemigas hasta conquistarlas",
+		Category:    "noble",
+		Attack:      20,
+		Defense:     10,
+		Health:      70,
+		Speed:       4,
+		Capacity:    0,
+		Upkeep:      4,
+		Cost: struct {
+			Wood  int `json:"wood"`
+			Stone int `json:"stone"`
+			Food  int `json:"food"`
+			Gold  int `json:"gold"`
+		}{
+			Wood:  2500,
+			Stone: 2000,
+			Food:  2000,
+			Gold:  2500,
+		},
+		TrainingTime:  7200,
+		Building:      "town_hall",
+		RequiredLevel: 15,
 	},
 }
@@ -33,6 +33,7 @@ type Building struct {
 	Level                 int        `json:"level"`
 	IsUpgrading           bool       `json:"is_upgrading"`
 	UpgradeCompletionTime *time.Time `json:"upgrade_completion_time,omitempty"`
+	Damage                int        `json:"damage"` // puntos de estructura perdidos por asedio (murallas)
 }
 
 type VillageWithDetails struct {
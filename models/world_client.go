@@ -34,8 +34,9 @@ type WorldFeatures struct {
 
 // WorldJoinRequest representa la solicitud para unirse a un mundo
 type WorldJoinRequest struct {
-	VillageName      string `json:"villageName" validate:"required,min=3,max=50"`
-	StartingLocation string `json:"startingLocation" validate:"oneof=random center edge"`
+	VillageName      string     `json:"villageName" validate:"required,min=3,max=50"`
+	StartingLocation string     `json:"startingLocation" validate:"omitempty,oneof=auto random center edge north_west north_east south_west south_east alliance"`
+	NearPlayerID     *uuid.UUID `json:"nearPlayerId,omitempty"`
 }
 
 // WorldJoinResponse representa la respuesta al unirse a un mundo
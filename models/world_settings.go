@@ -0,0 +1,155 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Condiciones de fin de partida de un mundo
+const (
+	WorldEndNone       = "none"       // el mundo no termina
+	WorldEndDeadline   = "deadline"   // termina en una fecha fija
+	WorldEndDomination = "domination" // termina cuando una alianza controla un porcentaje de las aldeas
+)
+
+// Límites de las reglas configurables
+const (
+	WorldSpeedMin      = 0.1
+	WorldSpeedMax      = 100.0
+	WorldMultiplierMax = 100.0
+)
+
+// WorldSettings son las reglas de juego de un mundo. Se fijan antes de que el mundo empiece y se
+// aplican a construcción, entrenamiento, marchas y producción.
+type WorldSettings struct {
+	WorldID              uuid.UUID  `json:"world_id" db:"world_id"`
+	GameSpeed            float64    `json:"game_speed" db:"game_speed"`                       // divide los tiempos de construcción y entrenamiento
+	UnitSpeed            float64    `json:"unit_speed" db:"unit_speed"`                       // multiplica la velocidad de las marchas
+	ProductionMultiplier float64    `json:"production_multiplier" db:"production_multiplier"` // multiplica la producción de recursos
+	StorageMultiplier    float64    `json:"storage_multiplier" db:"storage_multiplier"`       // multiplica la capacidad de almacenes y graneros
+	MaxVillages          int        `json:"max_villages" db:"max_villages"`                   // 0 = sin más límite que la cultura
+	MoraleEnabled        bool       `json:"morale_enabled" db:"morale_enabled"`               // penaliza atacar a jugadores más pequeños
+	ProtectionHours      int        `json:"protection_hours" db:"protection_hours"`           // duración del escudo de principiante
+	DemolitionRefund     float64    `json:"demolition_refund" db:"demolition_refund"`         // fracción del coste del nivel devuelta al demoler
+	EndCondition         string     `json:"end_condition" db:"end_condition"`
+	EndsAt               *time.Time `json:"ends_at,omitempty" db:"ends_at"`                       // para WorldEndDeadline
+	DominationPercent    float64    `json:"domination_percent,omitempty" db:"domination_percent"` // para WorldEndDomination, 0.6 = 60%
+	UpdatedAt            time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// DefaultWorldSettings devuelve las reglas clásicas a velocidad 1x. El escudo de principiante
+// parte de las reglas de protección del tipo de mundo.
+func DefaultWorldSettings(worldID uuid.UUID, worldType string) *WorldSettings {
+	return &WorldSettings{
+		WorldID:              worldID,
+		GameSpeed:            1,
+		UnitSpeed:            1,
+		ProductionMultiplier: 1,
+		StorageMultiplier:    1,
+		DemolitionRefund:     0.5,
+		ProtectionHours:      int(GetProtectionRules(worldType).BeginnerShield.Hours()),
+		EndCondition:         WorldEndNone,
+	}
+}
+
+// Validate comprueba que las reglas estén dentro de los límites permitidos
+func (s *WorldSettings) Validate() error {
+	if s.GameSpeed < WorldSpeedMin || s.GameSpeed > WorldSpeedMax {
+		return fmt.Errorf("la velocidad de juego debe estar entre %.1f y %.0f", WorldSpeedMin, WorldSpeedMax)
+	}
+	if s.UnitSpeed < WorldSpeedMin || s.UnitSpeed > WorldSpeedMax {
+		return fmt.Errorf("la velocidad de las unidades debe estar entre %.1f y %.0f", WorldSpeedMin, WorldSpeedMax)
+	}
+	if s.ProductionMultiplier <= 0 || s.ProductionMultiplier > WorldMultiplierMax {
+		return fmt.Errorf("el multiplicador de producción debe ser positivo y no mayor que %.0f", WorldMultiplierMax)
+	}
+	if s.StorageMultiplier <= 0 || s.StorageMultiplier > WorldMultiplierMax {
+		return fmt.Errorf("el multiplicador de almacenamiento debe ser positivo y no mayor que %.0f", WorldMultiplierMax)
+	}
+	if s.MaxVillages < 0 {
+		return fmt.Errorf("el máximo de aldeas no puede ser negativo")
+	}
+	if s.ProtectionHours < 0 {
+		return fmt.Errorf("las horas de protección no pueden ser negativas")
+	}
+	if s.DemolitionRefund < 0 || s.DemolitionRefund > 1 {
+		return fmt.Errorf("el reembolso por demolición debe estar entre 0 y 1")
+	}
+
+	switch s.EndCondition {
+	case WorldEndNone:
+	case WorldEndDeadline:
+		if s.EndsAt == nil {
+			return fmt.Errorf("la condición de fin por fecha requiere ends_at")
+		}
+	case WorldEndDomination:
+		if s.DominationPercent <= 0 || s.DominationPercent > 1 {
+			return fmt.Errorf("el porcentaje de dominación debe estar entre 0 y 1")
+		}
+	default:
+		return fmt.Errorf("condición de fin desconocida: %s", s.EndCondition)
+	}
+	return nil
+}
+
+// UpdateWorldSettingsRequest cambia las reglas de un mundo; los campos ausentes no se modifican
+type UpdateWorldSettingsRequest struct {
+	GameSpeed            *float64   `json:"game_speed"`
+	UnitSpeed            *float64   `json:"unit_speed"`
+	ProductionMultiplier *float64   `json:"production_multiplier"`
+	StorageMultiplier    *float64   `json:"storage_multiplier"`
+	MaxVillages          *int       `json:"max_villages"`
+	MoraleEnabled        *bool      `json:"morale_enabled"`
+	ProtectionHours      *int       `json:"protection_hours"`
+	DemolitionRefund     *float64   `json:"demolition_refund"`
+	EndCondition         *string    `json:"end_condition"`
+	EndsAt               *time.Time `json:"ends_at"`
+	DominationPercent    *float64   `json:"domination_percent"`
+}
+
+// Apply copia en las reglas los campos presentes en la solicitud
+func (r *UpdateWorldSettingsRequest) Apply(settings *WorldSettings) {
+	if r.GameSpeed != nil {
+		settings.GameSpeed = *r.GameSpeed
+	}
+	if r.UnitSpeed != nil {
+		settings.UnitSpeed = *r.UnitSpeed
+	}
+	if r.ProductionMultiplier != nil {
+		settings.ProductionMultiplier = *r.ProductionMultiplier
+	}
+	if r.StorageMultiplier != nil {
+		settings.StorageMultiplier = *r.StorageMultiplier
+	}
+	if r.MaxVillages != nil {
+		settings.MaxVillages = *r.MaxVillages
+	}
+	if r.MoraleEnabled != nil {
+		settings.MoraleEnabled = *r.MoraleEnabled
+	}
+	if r.ProtectionHours != nil {
+		settings.ProtectionHours = *r.ProtectionHours
+	}
+	if r.DemolitionRefund != nil {
+		settings.DemolitionRefund = *r.DemolitionRefund
+	}
+	if r.EndCondition != nil {
+		settings.EndCondition = *r.EndCondition
+	}
+	if r.EndsAt != nil {
+		settings.EndsAt = r.EndsAt
+	}
+	if r.DominationPercent != nil {
+		settings.DominationPercent = *r.DominationPercent
+	}
+}
+
+// WorldEndStatus indica si un mundo ha alcanzado su condición de fin
+type WorldEndStatus struct {
+	Ended             bool       `json:"ended"`
+	Reason            string     `json:"reason,omitempty"`
+	LeadingAllianceID *uuid.UUID `json:"leading_alliance_id,omitempty"`
+	LeadingShare      float64    `json:"leading_share,omitempty"` // fracción de aldeas de la alianza líder
+}
@@ -7,6 +7,7 @@ import (
 
 	"server-backend/models"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
@@ -446,3 +447,42 @@ func (r *AllianceRepository) GetAllianceRankings(limit int) ([]models.AllianceRa
 
 	return rankings, nil
 }
+
+// GetPlayerAllianceLevel obtiene el nombre y el nivel de la alianza activa de un jugador. Devuelve
+// nivel 0 si el jugador no pertenece a ninguna.
+func (r *AllianceRepository) GetPlayerAllianceLevel(playerID uuid.UUID) (string, int, error) {
+	var name string
+	var level int
+	err := r.db.QueryRow(`
+		SELECT a.name, COALESCE(a.level, 0)
+		FROM players p
+		JOIN alliances a ON a.id = p.alliance_id
+		WHERE p.id = $1 AND a.is_active = true
+	`, playerID).Scan(&name, &level)
+	if err == sql.ErrNoRows {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("error obteniendo alianza del jugador: %w", err)
+	}
+	return name, level, nil
+}
+
+// ArePlayersAllied verifica si dos jugadores pertenecen a la misma alianza
+func (r *AllianceRepository) ArePlayersAllied(playerA, playerB uuid.UUID) (bool, error) {
+	var allied bool
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM players a
+			JOIN players b ON a.alliance_id = b.alliance_id
+			WHERE a.id = $1 AND b.id = $2 AND a.alliance_id IS NOT NULL
+		)
+	`
+
+	err := r.db.QueryRow(query, playerA, playerB).Scan(&allied)
+	if err != nil {
+		return false, fmt.Errorf("error verificando alianza entre jugadores: %w", err)
+	}
+
+	return allied, nil
+}
@@ -8,6 +8,7 @@ import (
 	"server-backend/models"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
@@ -327,6 +328,37 @@ func (r *BattleRepository) TrainUnits(playerID, unitID uuid.UUID, quantity int)
 
 // CreateBattle crea una nueva batalla
 func (r *BattleRepository) CreateBattle(attackerID, defenderID uuid.UUID, battleType, mode string, config map[string]interface{}) (*models.Battle, error) {
+	battle, err := r.PrepareBattle(attackerID, defenderID, battleType, mode, config)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO battles (
+			id, attacker_id, defender_id, battle_type, mode, max_waves, max_duration,
+			status, current_wave, terrain, weather, attacker_formation, defender_formation,
+			attacker_tactics, defender_tactics, created_at, updated_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $16
+		)
+	`
+
+	_, err = r.db.Exec(query,
+		battle.ID, battle.AttackerID, battle.DefenderID, battle.BattleType, battle.Mode,
+		battle.MaxWaves, battle.MaxDuration, battle.Status, battle.CurrentWave,
+		battle.Terrain, battle.Weather, battle.AttackerFormation, battle.DefenderFormation,
+		battle.AttackerTactics, battle.DefenderTactics, battle.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("error creando batalla: %w", err)
+	}
+
+	return battle, nil
+}
+
+// PrepareBattle construye una batalla pendiente con la configuración del sistema sin guardarla
+func (r *BattleRepository) PrepareBattle(attackerID, defenderID uuid.UUID, battleType, mode string, config map[string]interface{}) (*models.Battle, error) {
 	// Obtener configuración del sistema
 	systemConfig, err := r.GetBattleSystemConfig()
 	if err != nil {
@@ -361,28 +393,73 @@ func (r *BattleRepository) CreateBattle(attackerID, defenderID uuid.UUID, battle
 		battle.DefenderTactics = r.getStringFromConfig(config, "defender_tactics", "")
 	}
 
-	query := `
+	return battle, nil
+}
+
+// SaveVillageBattle guarda una batalla ya resuelta contra una aldea y descuenta en la misma
+// transacción las bajas de su guarnición y de los contingentes de apoyo estacionados en ella. Las
+// bajas se restan de las cantidades actuales, así que las tropas que lleguen, se retiren o terminen
+// de entrenarse mientras se resolvía el combate se conservan.
+func (r *BattleRepository) SaveVillageBattle(battle *models.Battle, villageID uuid.UUID, garrisonLosses map[string]int, support []*models.SupportTroops, supportLosses []map[string]int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	battle.UpdatedAt = now
+	_, err = tx.Exec(`
 		INSERT INTO battles (
 			id, attacker_id, defender_id, battle_type, mode, max_waves, max_duration,
-			status, current_wave, terrain, weather, attacker_formation, defender_formation,
-			attacker_tactics, defender_tactics, created_at, updated_at
+			status, current_wave, start_time, end_time, duration, winner,
+			attacker_losses, defender_losses, terrain, weather, attacker_formation, defender_formation,
+			attacker_tactics, defender_tactics, seed, attacker_army, defender_army, loot, created_at, updated_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $16
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19,
+			$20, $21, $22, $23, $24, $25, $26, $27
 		)
-	`
-
-	_, err = r.db.Exec(query,
-		battle.ID, battle.AttackerID, battle.DefenderID, battle.BattleType, battle.Mode,
+	`, battle.ID, battle.AttackerID, battle.DefenderID, battle.BattleType, battle.Mode,
 		battle.MaxWaves, battle.MaxDuration, battle.Status, battle.CurrentWave,
-		battle.Terrain, battle.Weather, battle.AttackerFormation, battle.DefenderFormation,
-		battle.AttackerTactics, battle.DefenderTactics, battle.CreatedAt,
-	)
-
+		battle.StartTime, battle.EndTime, battle.Duration, battle.Winner,
+		battle.AttackerLosses, battle.DefenderLosses, battle.Terrain, battle.Weather,
+		battle.AttackerFormation, battle.DefenderFormation, battle.AttackerTactics,
+		battle.DefenderTactics, battle.Seed, battle.AttackerArmy, battle.DefenderArmy, battle.Loot,
+		battle.CreatedAt, battle.UpdatedAt)
 	if err != nil {
-		return nil, fmt.Errorf("error creando batalla: %w", err)
+		return fmt.Errorf("error guardando batalla: %w", err)
 	}
 
-	return battle, nil
+	for unitType, lost := range garrisonLosses {
+		if err := removeVillageUnits(tx, villageID, unitType, lost); err != nil {
+			return fmt.Errorf("error descontando bajas del defensor: %w", err)
+		}
+	}
+
+	for i, contingent := range support {
+		for unitType, lost := range supportLosses[i] {
+			if lost <= 0 {
+				continue
+			}
+			if err := removeStationedUnits(tx, contingent.OriginVillageID, contingent.HostVillageID, unitType, lost); err != nil {
+				return fmt.Errorf("error descontando bajas de tropas de apoyo: %w", err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// removeVillageUnits resta bajas de la guarnición de una aldea sin bajar de cero
+func removeVillageUnits(q *sql.Tx, villageID uuid.UUID, unitType string, lost int) error {
+	if lost <= 0 {
+		return nil
+	}
+	_, err := q.Exec(`
+		UPDATE units SET quantity = GREATEST(quantity - $1, 0), updated_at = $2
+		WHERE village_id = $3 AND type = $4
+	`, lost, time.Now(), villageID, unitType)
+	return err
 }
 
 // GetBattle obtiene una batalla específica
@@ -391,7 +468,8 @@ func (r *BattleRepository) GetBattle(battleID uuid.UUID) (*models.Battle, error)
 		SELECT id, attacker_id, defender_id, battle_type, mode, max_waves, max_duration,
 		       status, current_wave, start_time, end_time, duration, winner,
 		       attacker_losses, defender_losses, terrain, weather, attacker_formation,
-		       defender_formation, attacker_tactics, defender_tactics, created_at, updated_at
+		       defender_formation, attacker_tactics, defender_tactics, seed, attacker_army,
+		       defender_army, loot, created_at, updated_at
 		FROM battles
 		WHERE id = $1
 	`
@@ -403,7 +481,8 @@ func (r *BattleRepository) GetBattle(battleID uuid.UUID) (*models.Battle, error)
 		&battle.StartTime, &battle.EndTime, &battle.Duration, &battle.Winner,
 		&battle.AttackerLosses, &battle.DefenderLosses, &battle.Terrain, &battle.Weather,
 		&battle.AttackerFormation, &battle.DefenderFormation, &battle.AttackerTactics,
-		&battle.DefenderTactics, &battle.CreatedAt, &battle.UpdatedAt,
+		&battle.DefenderTactics, &battle.Seed, &battle.AttackerArmy, &battle.DefenderArmy, &battle.Loot,
+		&battle.CreatedAt, &battle.UpdatedAt,
 	)
 
 	if err != nil {
@@ -450,6 +529,61 @@ func (r *BattleRepository) GetBattleWaves(battleID uuid.UUID) ([]models.BattleWa
 	return waves, nil
 }
 
+// UpdateBattleLoot guarda el botín saqueado en el informe de batalla
+func (r *BattleRepository) UpdateBattleLoot(battleID uuid.UUID, loot string) error {
+	_, err := r.db.Exec(`
+		UPDATE battles SET loot = $1, updated_at = $2 WHERE id = $3
+	`, loot, time.Now(), battleID)
+	if err != nil {
+		return fmt.Errorf("error guardando botín de batalla: %w", err)
+	}
+	return nil
+}
+
+// SaveBattleWaves reemplaza las oleadas guardadas de una batalla dentro de una transacción
+func (r *BattleRepository) SaveBattleWaves(battleID uuid.UUID, waves []models.BattleWave) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM battle_waves WHERE battle_id = $1`, battleID); err != nil {
+		return fmt.Errorf("error eliminando oleadas previas: %w", err)
+	}
+
+	query := `
+		INSERT INTO battle_waves (
+			id, battle_id, wave_number, attacker_units, defender_units,
+			attacker_damage, defender_damage, attacker_losses, defender_losses,
+			combat_log, duration, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+
+	now := time.Now()
+	for i := range waves {
+		wave := &waves[i]
+		wave.ID = uuid.New()
+		wave.BattleID = battleID
+		wave.CreatedAt = now
+
+		_, err := tx.Exec(query,
+			wave.ID, wave.BattleID, wave.WaveNumber, wave.AttackerUnits, wave.DefenderUnits,
+			wave.AttackerDamage, wave.DefenderDamage, wave.AttackerLosses, wave.DefenderLosses,
+			wave.CombatLog, wave.Duration, wave.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("error guardando oleada %d: %w", wave.WaveNumber, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error confirmando oleadas: %w", err)
+	}
+
+	return nil
+}
+
 // GetBattleRankings obtiene los rankings de batalla
 func (r *BattleRepository) GetBattleRankings(limit int) ([]models.BattleRanking, error) {
 	query := `
@@ -531,15 +665,17 @@ func (r *BattleRepository) UpdateBattle(battle *models.Battle) error {
 		SET status = $1, current_wave = $2, start_time = $3, end_time = $4,
 		    duration = $5, winner = $6, attacker_losses = $7, defender_losses = $8,
 		    terrain = $9, weather = $10, attacker_formation = $11, defender_formation = $12,
-		    attacker_tactics = $13, defender_tactics = $14, updated_at = $15
-		WHERE id = $16
+		    attacker_tactics = $13, defender_tactics = $14, seed = $15, attacker_army = $16,
+		    defender_army = $17, loot = $18, updated_at = $19
+		WHERE id = $20
 	`
 
 	_, err := r.db.Exec(query,
 		battle.Status, battle.CurrentWave, battle.StartTime, battle.EndTime,
 		battle.Duration, battle.Winner, battle.AttackerLosses, battle.DefenderLosses,
 		battle.Terrain, battle.Weather, battle.AttackerFormation, battle.DefenderFormation,
-		battle.AttackerTactics, battle.DefenderTactics, time.Now(), battle.ID,
+		battle.AttackerTactics, battle.DefenderTactics, battle.Seed, battle.AttackerArmy,
+		battle.DefenderArmy, battle.Loot, time.Now(), battle.ID,
 	)
 
 	if err != nil {
@@ -555,7 +691,8 @@ func (r *BattleRepository) GetBattlesByPlayer(playerID uuid.UUID, limit int) ([]
 		SELECT id, attacker_id, defender_id, battle_type, mode, max_waves, max_duration,
 		       status, current_wave, start_time, end_time, duration, winner,
 		       attacker_losses, defender_losses, terrain, weather, attacker_formation,
-		       defender_formation, attacker_tactics, defender_tactics, created_at, updated_at
+		       defender_formation, attacker_tactics, defender_tactics, seed, attacker_army,
+		       defender_army, loot, created_at, updated_at
 		FROM battles
 		WHERE attacker_id = $1 OR defender_id = $1
 		ORDER BY created_at DESC
@@ -577,7 +714,8 @@ func (r *BattleRepository) GetBattlesByPlayer(playerID uuid.UUID, limit int) ([]
 			&battle.StartTime, &battle.EndTime, &battle.Duration, &battle.Winner,
 			&battle.AttackerLosses, &battle.DefenderLosses, &battle.Terrain, &battle.Weather,
 			&battle.AttackerFormation, &battle.DefenderFormation, &battle.AttackerTactics,
-			&battle.DefenderTactics, &battle.CreatedAt, &battle.UpdatedAt,
+			&battle.DefenderTactics, &battle.Seed, &battle.AttackerArmy, &battle.DefenderArmy, &battle.Loot,
+			&battle.CreatedAt, &battle.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error escaneando batalla: %w", err)
@@ -594,7 +732,8 @@ func (r *BattleRepository) GetBattlesByStatus(status string, limit int) ([]model
 		SELECT id, attacker_id, defender_id, battle_type, mode, max_waves, max_duration,
 		       status, current_wave, start_time, end_time, duration, winner,
 		       attacker_losses, defender_losses, terrain, weather, attacker_formation,
-		       defender_formation, attacker_tactics, defender_tactics, created_at, updated_at
+		       defender_formation, attacker_tactics, defender_tactics, seed, attacker_army,
+		       defender_army, loot, created_at, updated_at
 		FROM battles
 		WHERE status = $1
 		ORDER BY created_at DESC
@@ -616,7 +755,8 @@ func (r *BattleRepository) GetBattlesByStatus(status string, limit int) ([]model
 			&battle.StartTime, &battle.EndTime, &battle.Duration, &battle.Winner,
 			&battle.AttackerLosses, &battle.DefenderLosses, &battle.Terrain, &battle.Weather,
 			&battle.AttackerFormation, &battle.DefenderFormation, &battle.AttackerTactics,
-			&battle.DefenderTactics, &battle.CreatedAt, &battle.UpdatedAt,
+			&battle.DefenderTactics, &battle.Seed, &battle.AttackerArmy, &battle.DefenderArmy, &battle.Loot,
+			&battle.CreatedAt, &battle.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error escaneando batalla: %w", err)
@@ -633,7 +773,8 @@ func (r *BattleRepository) GetBattlesByType(battleType string, limit int) ([]mod
 		SELECT id, attacker_id, defender_id, battle_type, mode, max_waves, max_duration,
 		       status, current_wave, start_time, end_time, duration, winner,
 		       attacker_losses, defender_losses, terrain, weather, attacker_formation,
-		       defender_formation, attacker_tactics, defender_tactics, created_at, updated_at
+		       defender_formation, attacker_tactics, defender_tactics, seed, attacker_army,
+		       defender_army, loot, created_at, updated_at
 		FROM battles
 		WHERE battle_type = $1
 		ORDER BY created_at DESC
@@ -655,7 +796,8 @@ func (r *BattleRepository) GetBattlesByType(battleType string, limit int) ([]mod
 			&battle.StartTime, &battle.EndTime, &battle.Duration, &battle.Winner,
 			&battle.AttackerLosses, &battle.DefenderLosses, &battle.Terrain, &battle.Weather,
 			&battle.AttackerFormation, &battle.DefenderFormation, &battle.AttackerTactics,
-			&battle.DefenderTactics, &battle.CreatedAt, &battle.UpdatedAt,
+			&battle.DefenderTactics, &battle.Seed, &battle.AttackerArmy, &battle.DefenderArmy, &battle.Loot,
+			&battle.CreatedAt, &battle.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error escaneando batalla: %w", err)
@@ -672,7 +814,8 @@ func (r *BattleRepository) GetBattlesByDateRange(startDate, endDate time.Time, l
 		SELECT id, attacker_id, defender_id, battle_type, mode, max_waves, max_duration,
 		       status, current_wave, start_time, end_time, duration, winner,
 		       attacker_losses, defender_losses, terrain, weather, attacker_formation,
-		       defender_formation, attacker_tactics, defender_tactics, created_at, updated_at
+		       defender_formation, attacker_tactics, defender_tactics, seed, attacker_army,
+		       defender_army, loot, created_at, updated_at
 		FROM battles
 		WHERE created_at >= $1 AND created_at <= $2
 		ORDER BY created_at DESC
@@ -694,7 +837,8 @@ func (r *BattleRepository) GetBattlesByDateRange(startDate, endDate time.Time, l
 			&battle.StartTime, &battle.EndTime, &battle.Duration, &battle.Winner,
 			&battle.AttackerLosses, &battle.DefenderLosses, &battle.Terrain, &battle.Weather,
 			&battle.AttackerFormation, &battle.DefenderFormation, &battle.AttackerTactics,
-			&battle.DefenderTactics, &battle.CreatedAt, &battle.UpdatedAt,
+			&battle.DefenderTactics, &battle.Seed, &battle.AttackerArmy, &battle.DefenderArmy, &battle.Loot,
+			&battle.CreatedAt, &battle.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error escaneando batalla: %w", err)
@@ -834,7 +978,8 @@ func (r *BattleRepository) GetAllBattles() ([]models.Battle, error) {
 		SELECT id, attacker_id, defender_id, battle_type, mode, max_waves, max_duration,
 		       status, current_wave, start_time, end_time, duration, winner,
 		       attacker_losses, defender_losses, terrain, weather, attacker_formation,
-		       defender_formation, attacker_tactics, defender_tactics, created_at, updated_at
+		       defender_formation, attacker_tactics, defender_tactics, seed, attacker_army,
+		       defender_army, loot, created_at, updated_at
 		FROM battles
 		ORDER BY created_at DESC
 		LIMIT 100
@@ -855,7 +1000,8 @@ func (r *BattleRepository) GetAllBattles() ([]models.Battle, error) {
 			&battle.StartTime, &battle.EndTime, &battle.Duration, &battle.Winner,
 			&battle.AttackerLosses, &battle.DefenderLosses, &battle.Terrain, &battle.Weather,
 			&battle.AttackerFormation, &battle.DefenderFormation, &battle.AttackerTactics,
-			&battle.DefenderTactics, &battle.CreatedAt, &battle.UpdatedAt,
+			&battle.DefenderTactics, &battle.Seed, &battle.AttackerArmy, &battle.DefenderArmy, &battle.Loot,
+			&battle.CreatedAt, &battle.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error escaneando batalla: %w", err)
@@ -869,3 +1015,122 @@ func (r *BattleRepository) GetAllBattles() ([]models.Battle, error) {
 
 	return battles, nil
 }
+
+// GetBattleTerrain obtiene un terreno activo por su tipo (plain, forest, mountain...)
+func (r *BattleRepository) GetBattleTerrain(terrainType string) (*models.BattleTerrain, error) {
+	query := `
+		SELECT id, name, description, type, bonuses, penalties, icon, model, color,
+		       is_active, is_advanced, created_at
+		FROM battle_terrains
+		WHERE type = $1 AND is_active = true
+		LIMIT 1
+	`
+
+	var terrain models.BattleTerrain
+	err := r.db.QueryRow(query, terrainType).Scan(
+		&terrain.ID, &terrain.Name, &terrain.Description, &terrain.Type, &terrain.Bonuses,
+		&terrain.Penalties, &terrain.Icon, &terrain.Model, &terrain.Color,
+		&terrain.IsActive, &terrain.IsAdvanced, &terrain.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("terreno no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo terreno: %w", err)
+	}
+
+	return &terrain, nil
+}
+
+// GetBattleWeather obtiene un clima activo por su tipo (sunny, rainy, snowy...)
+func (r *BattleRepository) GetBattleWeather(weatherType string) (*models.BattleWeather, error) {
+	query := `
+		SELECT id, name, description, type, effects, unit_modifiers, icon, particle_effect,
+		       is_active, is_advanced, created_at
+		FROM battle_weathers
+		WHERE type = $1 AND is_active = true
+		LIMIT 1
+	`
+
+	var weather models.BattleWeather
+	err := r.db.QueryRow(query, weatherType).Scan(
+		&weather.ID, &weather.Name, &weather.Description, &weather.Type, &weather.Effects,
+		&weather.UnitModifiers, &weather.Icon, &weather.ParticleEffect,
+		&weather.IsActive, &weather.IsAdvanced, &weather.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("clima no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo clima: %w", err)
+	}
+
+	return &weather, nil
+}
+
+// GetBattleFormation obtiene una formación activa por ID o por nombre
+func (r *BattleRepository) GetBattleFormation(key string) (*models.BattleFormation, error) {
+	query := `
+		SELECT id, name, description, type, layout, bonuses, penalties, requirements,
+		       min_units, max_units, icon, preview, is_active, is_advanced, created_at
+		FROM battle_formations
+		WHERE (id::text = $1 OR name = $1) AND is_active = true
+		LIMIT 1
+	`
+
+	var formation models.BattleFormation
+	err := r.db.QueryRow(query, key).Scan(
+		&formation.ID, &formation.Name, &formation.Description, &formation.Type, &formation.Layout,
+		&formation.Bonuses, &formation.Penalties, &formation.Requirements,
+		&formation.MinUnits, &formation.MaxUnits, &formation.Icon, &formation.Preview,
+		&formation.IsActive, &formation.IsAdvanced, &formation.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("formación no encontrada")
+		}
+		return nil, fmt.Errorf("error obteniendo formación: %w", err)
+	}
+
+	return &formation, nil
+}
+
+// GetBattleTactics obtiene las tácticas activas cuyos IDs o nombres están en keys
+func (r *BattleRepository) GetBattleTactics(keys []string) ([]models.BattleTactic, error) {
+	if len(keys) == 0 {
+		return []models.BattleTactic{}, nil
+	}
+
+	query := `
+		SELECT id, name, description, type, effects, target, duration, cost,
+		       requirements, icon, animation, is_active, is_advanced, created_at
+		FROM battle_tactics
+		WHERE (id::text = ANY($1) OR name = ANY($1)) AND is_active = true
+		ORDER BY name ASC
+	`
+
+	rows, err := r.db.Query(query, pq.Array(keys))
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo tácticas: %w", err)
+	}
+	defer rows.Close()
+
+	var tactics []models.BattleTactic
+	for rows.Next() {
+		var tactic models.BattleTactic
+		err := rows.Scan(
+			&tactic.ID, &tactic.Name, &tactic.Description, &tactic.Type, &tactic.Effects,
+			&tactic.Target, &tactic.Duration, &tactic.Cost, &tactic.Requirements,
+			&tactic.Icon, &tactic.Animation, &tactic.IsActive, &tactic.IsAdvanced, &tactic.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando táctica: %w", err)
+		}
+		tactics = append(tactics, tactic)
+	}
+
+	return tactics, nil
+}
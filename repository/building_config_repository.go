@@ -25,7 +25,9 @@ func (r *BuildingConfigRepository) GetBuildingConfig(buildingType string, level
 	err := r.db.QueryRow(`
 		SELECT id, type, level, wood_cost, stone_cost, food_cost, gold_cost, 
 		       build_time_seconds, production_per_hour, storage_capacity, 
-		       training_speed_modifier, construction_speed_modifier
+		       training_speed_modifier, construction_speed_modifier,
+		       defense_bonus, tower_damage, durability, COALESCE(produced_resource, ''),
+		       merchants, merchant_capacity
 		FROM building_configs
 		WHERE type = $1 AND level = $2
 	`, buildingType, level).Scan(
@@ -41,6 +43,12 @@ func (r *BuildingConfigRepository) GetBuildingConfig(buildingType string, level
 		&config.StorageCapacity,
 		&config.TrainingSpeedModifier,
 		&config.ConstructionSpeedModifier,
+		&config.DefenseBonus,
+		&config.TowerDamage,
+		&config.Durability,
+		&config.ProducedResource,
+		&config.Merchants,
+		&config.MerchantCapacity,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -56,7 +64,9 @@ func (r *BuildingConfigRepository) GetBuildingConfigsByType(buildingType string)
 	rows, err := r.db.Query(`
 		SELECT id, type, level, wood_cost, stone_cost, food_cost, gold_cost, 
 		       build_time_seconds, production_per_hour, storage_capacity, 
-		       training_speed_modifier, construction_speed_modifier
+		       training_speed_modifier, construction_speed_modifier,
+		       defense_bonus, tower_damage, durability, COALESCE(produced_resource, ''),
+		       merchants, merchant_capacity
 		FROM building_configs
 		WHERE type = $1
 		ORDER BY level
@@ -82,6 +92,12 @@ func (r *BuildingConfigRepository) GetBuildingConfigsByType(buildingType string)
 			&config.StorageCapacity,
 			&config.TrainingSpeedModifier,
 			&config.ConstructionSpeedModifier,
+			&config.DefenseBonus,
+			&config.TowerDamage,
+			&config.Durability,
+			&config.ProducedResource,
+			&config.Merchants,
+			&config.MerchantCapacity,
 		)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,399 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"server-backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrConstructionSlotsFull indica que todos los huecos de construcción de la aldea están ocupados
+var ErrConstructionSlotsFull = errors.New("no quedan huecos de construcción libres")
+
+type ConstructionRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewConstructionRepository(db *sql.DB, logger *zap.Logger) *ConstructionRepository {
+	return &ConstructionRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const constructionColumns = `
+	id, village_id, building_type, action, target_level, position, status,
+	cost_wood, cost_stone, cost_food, cost_gold, start_time, end_time, created_at, updated_at
+`
+
+// CreateOrder añade una orden al final de la cola de construcción de la aldea
+func (r *ConstructionRepository) CreateOrder(order *models.ConstructionOrder) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockConstructionQueue(tx, order.VillageID); err != nil {
+		return err
+	}
+	if err := insertConstructionOrder(tx, order); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetOrder obtiene una orden de construcción por ID
+func (r *ConstructionRepository) GetOrder(orderID uuid.UUID) (*models.ConstructionOrder, error) {
+	row := r.db.QueryRow(`SELECT `+constructionColumns+` FROM construction_orders WHERE id = $1`, orderID)
+	order, err := scanConstructionOrder(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// GetVillageQueue obtiene las órdenes pendientes de una aldea: primero las que están en obras por
+// hora de inicio y después las que esperan, en orden de cola
+func (r *ConstructionRepository) GetVillageQueue(villageID uuid.UUID) ([]*models.ConstructionOrder, error) {
+	return r.queryOrders(`
+		SELECT `+constructionColumns+`
+		FROM construction_orders
+		WHERE village_id = $1 AND status IN ($2, $3)
+		ORDER BY status = $2, start_time, position
+	`, villageID, models.ConstructionStatusQueued, models.ConstructionStatusBuilding)
+}
+
+// GetQueuedOrders obtiene las órdenes en espera de una aldea en orden de cola
+func (r *ConstructionRepository) GetQueuedOrders(villageID uuid.UUID) ([]*models.ConstructionOrder, error) {
+	return r.queryOrders(`
+		SELECT `+constructionColumns+`
+		FROM construction_orders
+		WHERE village_id = $1 AND status = $2
+		ORDER BY position
+	`, villageID, models.ConstructionStatusQueued)
+}
+
+// GetActiveOrder obtiene la orden en obras de un edificio (nil si no hay ninguna)
+func (r *ConstructionRepository) GetActiveOrder(villageID uuid.UUID, buildingType string) (*models.ConstructionOrder, error) {
+	row := r.db.QueryRow(`
+		SELECT `+constructionColumns+`
+		FROM construction_orders
+		WHERE village_id = $1 AND building_type = $2 AND status = $3
+		LIMIT 1
+	`, villageID, buildingType, models.ConstructionStatusBuilding)
+	order, err := scanConstructionOrder(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// GetDueOrders obtiene las órdenes en obras que ya han terminado
+func (r *ConstructionRepository) GetDueOrders(now time.Time, limit int) ([]*models.ConstructionOrder, error) {
+	return r.queryOrders(`
+		SELECT `+constructionColumns+`
+		FROM construction_orders
+		WHERE status = $1 AND end_time <= $2
+		ORDER BY end_time
+		LIMIT $3
+	`, models.ConstructionStatusBuilding, now, limit)
+}
+
+// GetVillagesWithQueuedOrders obtiene las aldeas que tienen órdenes esperando hueco o recursos
+func (r *ConstructionRepository) GetVillagesWithQueuedOrders(limit int) ([]uuid.UUID, error) {
+	rows, err := r.db.Query(`
+		SELECT DISTINCT village_id
+		FROM construction_orders
+		WHERE status = $1
+		LIMIT $2
+	`, models.ConstructionStatusQueued, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var villageIDs []uuid.UUID
+	for rows.Next() {
+		var villageID uuid.UUID
+		if err := rows.Scan(&villageID); err != nil {
+			return nil, err
+		}
+		villageIDs = append(villageIDs, villageID)
+	}
+	return villageIDs, rows.Err()
+}
+
+// ClaimOrder pone en obras una orden con el coste cobrado y sus tiempos si alguno de los slots huecos
+// de la aldea está libre en el instante de inicio. Las órdenes nuevas se insertan ya en obras y las
+// de la cola pasan a obras si siguen en espera. La cola de la aldea queda bloqueada durante la
+// comprobación, así que dos pasadas simultáneas no pueden ocupar el mismo hueco. Devuelve
+// ErrConstructionSlotsFull si no hay hueco y sql.ErrNoRows si la orden ya no estaba en la cola.
+func (r *ConstructionRepository) ClaimOrder(order *models.ConstructionOrder, slots int, isNew bool) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockConstructionQueue(tx, order.VillageID); err != nil {
+		return err
+	}
+
+	var active int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM construction_orders
+		WHERE village_id = $1 AND status = $2 AND end_time > $3
+	`, order.VillageID, models.ConstructionStatusBuilding, order.StartTime).Scan(&active)
+	if err != nil {
+		return err
+	}
+	if active >= slots {
+		return ErrConstructionSlotsFull
+	}
+
+	if isNew {
+		if err := insertConstructionOrder(tx, order); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	order.UpdatedAt = time.Now()
+	result, err := tx.Exec(`
+		UPDATE construction_orders
+		SET status = $1, target_level = $2, cost_wood = $3, cost_stone = $4, cost_food = $5, cost_gold = $6,
+		    start_time = $7, end_time = $8, updated_at = $9
+		WHERE id = $10 AND status = $11
+	`, order.Status, order.TargetLevel, order.Cost.Wood, order.Cost.Stone, order.Cost.Food, order.Cost.Gold,
+		order.StartTime, order.EndTime, order.UpdatedAt, order.ID, models.ConstructionStatusQueued)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReleaseOrder devuelve a la cola una orden reclamada con StartOrder cuya obra no se pudo iniciar
+func (r *ConstructionRepository) ReleaseOrder(order *models.ConstructionOrder) error {
+	order.UpdatedAt = time.Now()
+	_, err := r.db.Exec(`
+		UPDATE construction_orders
+		SET status = $1, start_time = NULL, end_time = NULL, updated_at = $2
+		WHERE id = $3 AND status = $4
+	`, models.ConstructionStatusQueued, order.UpdatedAt, order.ID, models.ConstructionStatusBuilding)
+	if err != nil {
+		return err
+	}
+	order.Status = models.ConstructionStatusQueued
+	order.StartTime = nil
+	order.EndTime = nil
+	return nil
+}
+
+// DeleteOrder elimina una orden creada con CreateOrder cuya obra no se pudo iniciar
+func (r *ConstructionRepository) DeleteOrder(order *models.ConstructionOrder) error {
+	_, err := r.db.Exec(`DELETE FROM construction_orders WHERE id = $1 AND status = $2`,
+		order.ID, models.ConstructionStatusBuilding)
+	return err
+}
+
+// CompleteOrder marca como terminada una orden en obras y deja el edificio en su nivel objetivo en
+// la misma transacción. Devuelve sql.ErrNoRows si otro proceso ya la había completado o cancelado.
+func (r *ConstructionRepository) CompleteOrder(order *models.ConstructionOrder) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		UPDATE construction_orders
+		SET status = $1, updated_at = $2
+		WHERE id = $3 AND status = $4
+	`, models.ConstructionStatusCompleted, now, order.ID, models.ConstructionStatusBuilding)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE buildings
+		SET level = $1, is_upgrading = false, upgrade_completion_time = NULL
+		WHERE village_id = $2 AND type = $3
+	`, order.TargetLevel, order.VillageID, order.BuildingType)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	order.Status = models.ConstructionStatusCompleted
+	order.UpdatedAt = now
+	return nil
+}
+
+// CancelOrder cancela una orden pendiente. Devuelve sql.ErrNoRows si ya no estaba en la cola.
+func (r *ConstructionRepository) CancelOrder(order *models.ConstructionOrder) error {
+	now := time.Now()
+	result, err := r.db.Exec(`
+		UPDATE construction_orders
+		SET status = $1, end_time = $2, updated_at = $2
+		WHERE id = $3 AND status IN ($4, $5)
+	`, models.ConstructionStatusCancelled, now, order.ID, models.ConstructionStatusQueued, models.ConstructionStatusBuilding)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+	order.Status = models.ConstructionStatusCancelled
+	order.EndTime = &now
+	order.UpdatedAt = now
+	return nil
+}
+
+// UpdateQueuedOrders guarda la posición y el nivel objetivo de las órdenes en espera
+func (r *ConstructionRepository) UpdateQueuedOrders(orders []*models.ConstructionOrder) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, order := range orders {
+		_, err := tx.Exec(`
+			UPDATE construction_orders
+			SET position = $1, target_level = $2, updated_at = $3
+			WHERE id = $4 AND status = $5
+		`, order.Position, order.TargetLevel, now, order.ID, models.ConstructionStatusQueued)
+		if err != nil {
+			return err
+		}
+		order.UpdatedAt = now
+	}
+	return tx.Commit()
+}
+
+// GetPremiumUntil devuelve hasta cuándo tiene el jugador los huecos de construcción premium
+func (r *ConstructionRepository) GetPremiumUntil(playerID uuid.UUID) (*time.Time, error) {
+	var until *time.Time
+	err := r.db.QueryRow(`SELECT construction_premium_until FROM players WHERE id = $1`, playerID).Scan(&until)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return until, err
+}
+
+// SetPremiumUntil fija el fin de los huecos de construcción premium del jugador
+func (r *ConstructionRepository) SetPremiumUntil(playerID uuid.UUID, until time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE players SET construction_premium_until = $1, updated_at = NOW() WHERE id = $2
+	`, until, playerID)
+	return err
+}
+
+// lockConstructionQueue serializa las operaciones sobre la cola de construcción de una aldea hasta
+// el final de la transacción
+func lockConstructionQueue(tx *sql.Tx, villageID uuid.UUID) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, "construction:"+villageID.String())
+	return err
+}
+
+// insertConstructionOrder inserta una orden al final de la cola, ya bloqueada con lockConstructionQueue
+func insertConstructionOrder(tx *sql.Tx, order *models.ConstructionOrder) error {
+	err := tx.QueryRow(`
+		SELECT COALESCE(MAX(position), 0) + 1
+		FROM construction_orders
+		WHERE village_id = $1 AND status IN ($2, $3)
+	`, order.VillageID, models.ConstructionStatusQueued, models.ConstructionStatusBuilding).Scan(&order.Position)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO construction_orders (`+constructionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $14)
+	`, order.ID, order.VillageID, order.BuildingType, order.Action, order.TargetLevel, order.Position, order.Status,
+		order.Cost.Wood, order.Cost.Stone, order.Cost.Food, order.Cost.Gold,
+		order.StartTime, order.EndTime, order.CreatedAt)
+	if err != nil {
+		return err
+	}
+	order.UpdatedAt = order.CreatedAt
+	return nil
+}
+
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *ConstructionRepository) queryOrders(query string, args ...interface{}) ([]*models.ConstructionOrder, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []*models.ConstructionOrder
+	for rows.Next() {
+		order, err := scanConstructionOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func scanConstructionOrder(scanner rowScanner) (*models.ConstructionOrder, error) {
+	var order models.ConstructionOrder
+	err := scanner.Scan(
+		&order.ID,
+		&order.VillageID,
+		&order.BuildingType,
+		&order.Action,
+		&order.TargetLevel,
+		&order.Position,
+		&order.Status,
+		&order.Cost.Wood,
+		&order.Cost.Stone,
+		&order.Cost.Food,
+		&order.Cost.Gold,
+		&order.StartTime,
+		&order.EndTime,
+		&order.CreatedAt,
+		&order.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
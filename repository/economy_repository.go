@@ -31,7 +31,8 @@ func (r *EconomyRepository) GetEconomySystemConfig() (*models.EconomySystemConfi
 		       exchange_fee, min_exchange_amount, max_exchange_amount, market_tax,
 		       transaction_fee, max_price_fluctuation, price_update_interval,
 		       max_items_per_player, max_active_listings, min_listing_duration,
-		       max_listing_duration, advanced_config, created_at, updated_at
+		       max_listing_duration, amm_enabled, amm_fee, amm_initial_reserve,
+		       amm_initial_gold_reserve, amm_max_price_impact, advanced_config, created_at, updated_at
 		FROM economy_system_config
 		ORDER BY id DESC
 		LIMIT 1
@@ -44,7 +45,9 @@ func (r *EconomyRepository) GetEconomySystemConfig() (*models.EconomySystemConfi
 		&config.ExchangeFee, &config.MinExchangeAmount, &config.MaxExchangeAmount, &config.MarketTax,
 		&config.TransactionFee, &config.MaxPriceFluctuation, &config.PriceUpdateInterval,
 		&config.MaxItemsPerPlayer, &config.MaxActiveListings, &config.MinListingDuration,
-		&config.MaxListingDuration, &config.AdvancedConfig, &config.CreatedAt, &config.UpdatedAt,
+		&config.MaxListingDuration, &config.MarketMaker.Enabled, &config.MarketMaker.Fee,
+		&config.MarketMaker.InitialReserve, &config.MarketMaker.InitialGoldReserve,
+		&config.MarketMaker.MaxPriceImpact, &config.AdvancedConfig, &config.CreatedAt, &config.UpdatedAt,
 	)
 
 	if err != nil {
@@ -78,7 +81,8 @@ func (r *EconomyRepository) createDefaultEconomyConfig() (*models.EconomySystemC
 		            exchange_fee, min_exchange_amount, max_exchange_amount, market_tax,
 		            transaction_fee, max_price_fluctuation, price_update_interval,
 		            max_items_per_player, max_active_listings, min_listing_duration,
-		            max_listing_duration, advanced_config, created_at, updated_at
+		            max_listing_duration, amm_enabled, amm_fee, amm_initial_reserve,
+		            amm_initial_gold_reserve, amm_max_price_impact, advanced_config, created_at, updated_at
 	`
 
 	var config models.EconomySystemConfig
@@ -89,7 +93,9 @@ func (r *EconomyRepository) createDefaultEconomyConfig() (*models.EconomySystemC
 		&config.ExchangeFee, &config.MinExchangeAmount, &config.MaxExchangeAmount, &config.MarketTax,
 		&config.TransactionFee, &config.MaxPriceFluctuation, &config.PriceUpdateInterval,
 		&config.MaxItemsPerPlayer, &config.MaxActiveListings, &config.MinListingDuration,
-		&config.MaxListingDuration, &config.AdvancedConfig, &config.CreatedAt, &config.UpdatedAt,
+		&config.MaxListingDuration, &config.MarketMaker.Enabled, &config.MarketMaker.Fee,
+		&config.MarketMaker.InitialReserve, &config.MarketMaker.InitialGoldReserve,
+		&config.MarketMaker.MaxPriceImpact, &config.AdvancedConfig, &config.CreatedAt, &config.UpdatedAt,
 	)
 
 	if err != nil {
@@ -108,8 +114,10 @@ func (r *EconomyRepository) UpdateEconomySystemConfig(config *models.EconomySyst
 		    exchange_fee = $7, min_exchange_amount = $8, max_exchange_amount = $9, market_tax = $10,
 		    transaction_fee = $11, max_price_fluctuation = $12, price_update_interval = $13,
 		    max_items_per_player = $14, max_active_listings = $15, min_listing_duration = $16,
-		    max_listing_duration = $17, advanced_config = $18, updated_at = $19
-		WHERE id = $20
+		    max_listing_duration = $17, advanced_config = $18, updated_at = $19,
+		    amm_enabled = $20, amm_fee = $21, amm_initial_reserve = $22,
+		    amm_initial_gold_reserve = $23, amm_max_price_impact = $24
+		WHERE id = $25
 	`
 
 	_, err := r.db.Exec(query,
@@ -118,7 +126,9 @@ func (r *EconomyRepository) UpdateEconomySystemConfig(config *models.EconomySyst
 		config.ExchangeFee, config.MinExchangeAmount, config.MaxExchangeAmount, config.MarketTax,
 		config.TransactionFee, config.MaxPriceFluctuation, config.PriceUpdateInterval,
 		config.MaxItemsPerPlayer, config.MaxActiveListings, config.MinListingDuration,
-		config.MaxListingDuration, config.AdvancedConfig, time.Now(), config.ID,
+		config.MaxListingDuration, config.AdvancedConfig, time.Now(),
+		config.MarketMaker.Enabled, config.MarketMaker.Fee, config.MarketMaker.InitialReserve,
+		config.MarketMaker.InitialGoldReserve, config.MarketMaker.MaxPriceImpact, config.ID,
 	)
 
 	if err != nil {
@@ -128,6 +138,36 @@ func (r *EconomyRepository) UpdateEconomySystemConfig(config *models.EconomySyst
 	return nil
 }
 
+// GetMarketMakerConfig obtiene los parámetros del mercader NPC. Sin configuración guardada se
+// usan los valores por defecto de la tabla.
+func (r *EconomyRepository) GetMarketMakerConfig() (*models.MarketMakerConfig, error) {
+	query := `
+		SELECT amm_enabled, amm_fee, amm_initial_reserve, amm_initial_gold_reserve, amm_max_price_impact
+		FROM economy_system_config
+		ORDER BY id DESC
+		LIMIT 1
+	`
+
+	var config models.MarketMakerConfig
+	err := r.db.QueryRow(query).Scan(
+		&config.Enabled, &config.Fee, &config.InitialReserve, &config.InitialGoldReserve, &config.MaxPriceImpact,
+	)
+	if err == sql.ErrNoRows {
+		return &models.MarketMakerConfig{
+			Enabled:            true,
+			Fee:                0.02,
+			InitialReserve:     200000,
+			InitialGoldReserve: 50000,
+			MaxPriceImpact:     0.15,
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo configuración del mercader: %w", err)
+	}
+
+	return &config, nil
+}
+
 // GetPlayerEconomy obtiene la economía de un jugador
 func (r *EconomyRepository) GetPlayerEconomy(playerID uuid.UUID) (*models.PlayerEconomy, error) {
 	query := `
@@ -555,15 +595,104 @@ func (r *EconomyRepository) GetPlayerMarketActivity(playerID uuid.UUID) (*models
 	return &activity, nil
 }
 
-// GetMarketTrends obtiene las tendencias del mercado
+// marketTrendPeriods son los periodos admitidos para las tendencias del mercado
+var marketTrendPeriods = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// GetMarketTrends compara para cada recurso el precio medio ponderado y el volumen del periodo
+// (24h, 7d o 30d) con los del periodo anterior, a partir de las velas horarias de todos los mundos
 func (r *EconomyRepository) GetMarketTrends(period string) (*models.MarketTrends, error) {
-	// TODO: Implementar obtención de tendencias del mercado
-	// Por ahora retornamos tendencias básicas
-	return &models.MarketTrends{
+	duration, ok := marketTrendPeriods[period]
+	if !ok {
+		period = "24h"
+		duration = marketTrendPeriods[period]
+	}
+	start := time.Now().Add(-duration)
+
+	query := `
+		SELECT resource_type,
+		       COALESCE(SUM(turnover) FILTER (WHERE bucket_start >= $2), 0),
+		       COALESCE(SUM(volume) FILTER (WHERE bucket_start >= $2), 0),
+		       COALESCE(SUM(turnover) FILTER (WHERE bucket_start < $2), 0),
+		       COALESCE(SUM(volume) FILTER (WHERE bucket_start < $2), 0)
+		FROM price_candles
+		WHERE period = $1 AND bucket_start >= $3
+		GROUP BY resource_type
+		ORDER BY resource_type
+	`
+
+	rows, err := r.db.Query(query, models.CandleInterval1h, start, start.Add(-duration))
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo tendencias: %w", err)
+	}
+	defer rows.Close()
+
+	trends := &models.MarketTrends{
 		Period:        period,
 		PriceChanges:  []*models.PriceChange{},
 		VolumeChanges: []*models.VolumeChange{},
-	}, nil
+	}
+	for rows.Next() {
+		var resourceType string
+		var turnover, volume, previousTurnover, previousVolume int64
+		if err := rows.Scan(&resourceType, &turnover, &volume, &previousTurnover, &previousVolume); err != nil {
+			return nil, fmt.Errorf("error escaneando tendencia: %w", err)
+		}
+
+		// El precio solo se compara si hubo cruces en ambos periodos
+		priceChange := &models.PriceChange{ItemName: resourceType}
+		if volume > 0 && previousVolume > 0 {
+			price := float64(turnover) / float64(volume)
+			previousPrice := float64(previousTurnover) / float64(previousVolume)
+			priceChange.Change = price - previousPrice
+			priceChange.Percentage = priceChange.Change / previousPrice * 100
+		}
+		trends.PriceChanges = append(trends.PriceChanges, priceChange)
+
+		volumeChange := &models.VolumeChange{
+			ItemName: resourceType,
+			Change:   float64(volume - previousVolume),
+		}
+		if previousVolume > 0 {
+			volumeChange.Percentage = volumeChange.Change / float64(previousVolume) * 100
+		}
+		trends.VolumeChanges = append(trends.VolumeChanges, volumeChange)
+	}
+
+	return trends, rows.Err()
+}
+
+// GetResourcePriceHistory obtiene el precio medio ponderado de un recurso en todos los mundos por
+// cada vela del intervalo indicado entre from y to
+func (r *EconomyRepository) GetResourcePriceHistory(resourceType, period string, from, to time.Time) ([]*models.PriceHistory, error) {
+	query := `
+		SELECT bucket_start, SUM(turnover)::float / SUM(volume)
+		FROM price_candles
+		WHERE resource_type = $1 AND period = $2 AND bucket_start >= $3 AND bucket_start < $4
+		GROUP BY bucket_start
+		HAVING SUM(volume) > 0
+		ORDER BY bucket_start
+	`
+
+	rows, err := r.db.Query(query, resourceType, period, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo historial de precios: %w", err)
+	}
+	defer rows.Close()
+
+	history := []*models.PriceHistory{}
+	for rows.Next() {
+		var point models.PriceHistory
+		if err := rows.Scan(&point.Date, &point.Price); err != nil {
+			return nil, fmt.Errorf("error escaneando historial de precios: %w", err)
+		}
+		history = append(history, &point)
+	}
+
+	return history, rows.Err()
 }
 
 // UpdatePlayerReputation actualiza la reputación de un jugador
@@ -0,0 +1,575 @@
+package repository
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"server-backend/models"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMarketOrderNotFound  = errors.New("orden no encontrada")
+	ErrMarketOrderNotOpen   = errors.New("la orden ya no está abierta")
+	ErrMarketOrderInvalid   = errors.New("orden inválida")
+	ErrMarketOrderLimit     = errors.New("has alcanzado el máximo de órdenes abiertas")
+	ErrMarketOrderOwnership = errors.New("la orden no pertenece al jugador")
+)
+
+// exchangeMatchBatch es el número de órdenes contrarias que se bloquean de cada vez al casar
+const exchangeMatchBatch = 100
+
+type ExchangeRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewExchangeRepository(db *sql.DB, logger *zap.Logger) *ExchangeRepository {
+	return &ExchangeRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const marketOrderColumns = `
+	id, world_id, player_id, village_id, resource_type, side, price, quantity, filled, status, created_at, updated_at
+`
+
+// PlaceOrder deja en depósito lo que compromete la orden, la casa contra el libro por precio y
+// antigüedad y guarda lo que quede abierto. Todo ocurre en una transacción que bloquea el libro del
+// recurso en el mundo, así que dos órdenes simultáneas no pueden dejar el libro cruzado ni cruzar
+// dos veces la misma orden. Los recursos guardados de la aldea deben estar materializados antes de
+// llamar.
+func (r *ExchangeRepository) PlaceOrder(order *models.MarketOrder, maxOpenOrders int) ([]*models.TradeTransaction, error) {
+	if order.Quantity <= 0 || order.Price <= 0 {
+		return nil, ErrMarketOrderInvalid
+	}
+	escrow, err := orderEscrow(order, order.Quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockOrderBook(tx, order.WorldID, order.ResourceType); err != nil {
+		return nil, err
+	}
+
+	var open int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM market_orders WHERE player_id = $1 AND status = $2
+	`, order.PlayerID, models.MarketOrderOpen).Scan(&open)
+	if err != nil {
+		return nil, err
+	}
+	if open >= maxOpenOrders {
+		return nil, ErrMarketOrderLimit
+	}
+
+	// Variaciones de recursos por aldea; las filas de recursos se bloquean y actualizan al final,
+	// todas en orden de ID, para no cruzarse con otros libros que toquen las mismas aldeas
+	deltas := map[uuid.UUID]models.Resources{
+		order.VillageID: negateTradeGoods(escrow),
+	}
+
+	now := time.Now()
+	order.ID = uuid.New()
+	order.Filled = 0
+	order.Status = models.MarketOrderOpen
+	order.CreatedAt = now
+	order.UpdatedAt = now
+
+	// La orden entrante se guarda antes de casarla: los cruces la referencian
+	_, err = tx.Exec(`
+		INSERT INTO market_orders (`+marketOrderColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+	`, order.ID, order.WorldID, order.PlayerID, order.VillageID, order.ResourceType, order.Side,
+		order.Price, order.Quantity, order.Filled, order.Status, order.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var fills []*models.TradeTransaction
+	for order.Remaining() > 0 {
+		makers, err := r.lockMatchingOrders(tx, order)
+		if err != nil {
+			return nil, err
+		}
+		for _, maker := range makers {
+			if order.Remaining() == 0 {
+				break
+			}
+			fill := matchOrders(order, maker, now)
+			fills = append(fills, fill)
+			settleFill(deltas, order, maker, fill)
+
+			_, err := tx.Exec(`
+				UPDATE market_orders SET filled = $1, status = $2, updated_at = $3 WHERE id = $4
+			`, maker.Filled, maker.Status, now, maker.ID)
+			if err != nil {
+				return nil, err
+			}
+			if err := insertTradeTransaction(tx, fill); err != nil {
+				return nil, err
+			}
+		}
+		if len(makers) < exchangeMatchBatch {
+			break
+		}
+	}
+	if order.Remaining() == 0 {
+		order.Status = models.MarketOrderFilled
+	}
+	if order.Filled > 0 {
+		_, err = tx.Exec(`
+			UPDATE market_orders SET filled = $1, status = $2, updated_at = $3 WHERE id = $4
+		`, order.Filled, order.Status, now, order.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// El depósito de la orden entrante se comprueba con su fila ya bloqueada
+	owners, stocks, err := lockStockDeltas(tx, deltas)
+	if err != nil {
+		return nil, err
+	}
+	if owners[order.VillageID] != order.PlayerID {
+		return nil, ErrTradeVillageNotOwned
+	}
+	if !hasTradeStock(stocks[order.VillageID], escrow) {
+		return nil, ErrTradeInsufficientResources
+	}
+	if err := applyStockDeltas(tx, deltas); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return fills, nil
+}
+
+// CancelOrder cancela una orden abierta del jugador y devuelve a su aldea el depósito de lo que
+// quedaba sin cruzar
+func (r *ExchangeRepository) CancelOrder(orderID, playerID uuid.UUID) (*models.MarketOrder, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	order, err := scanMarketOrder(tx.QueryRow(`SELECT `+marketOrderColumns+` FROM market_orders WHERE id = $1`, orderID))
+	if err == sql.ErrNoRows {
+		return nil, ErrMarketOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if order.PlayerID != playerID {
+		return nil, ErrMarketOrderOwnership
+	}
+
+	// Se bloquea el libro antes que la orden, en el mismo orden que PlaceOrder
+	if err := lockOrderBook(tx, order.WorldID, order.ResourceType); err != nil {
+		return nil, err
+	}
+	order, err = scanMarketOrder(tx.QueryRow(`SELECT `+marketOrderColumns+` FROM market_orders WHERE id = $1 FOR UPDATE`, orderID))
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != models.MarketOrderOpen {
+		return nil, ErrMarketOrderNotOpen
+	}
+
+	refund, err := orderEscrow(order, order.Remaining())
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := lockTradeStock(tx, order.VillageID); err != nil {
+		return nil, err
+	}
+	if err := addTradeStock(tx, order.VillageID, refund); err != nil {
+		return nil, err
+	}
+
+	order.Status = models.MarketOrderCancelled
+	order.UpdatedAt = time.Now()
+	_, err = tx.Exec(`UPDATE market_orders SET status = $1, updated_at = $2 WHERE id = $3`, order.Status, order.UpdatedAt, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// GetPlayerOrders obtiene las órdenes abiertas de un jugador
+func (r *ExchangeRepository) GetPlayerOrders(playerID uuid.UUID) ([]*models.MarketOrder, error) {
+	rows, err := r.db.Query(`
+		SELECT `+marketOrderColumns+`
+		FROM market_orders
+		WHERE player_id = $1 AND status = $2
+		ORDER BY created_at DESC
+	`, playerID, models.MarketOrderOpen)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*models.MarketOrder{}
+	for rows.Next() {
+		order, err := scanMarketOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+// GetOrderBook obtiene la profundidad del libro de un recurso agrupada por precio
+func (r *ExchangeRepository) GetOrderBook(worldID uuid.UUID, resourceType string, depth int) (*models.OrderBook, error) {
+	book := &models.OrderBook{
+		WorldID:      worldID,
+		ResourceType: resourceType,
+		Bids:         []*models.OrderBookLevel{},
+		Asks:         []*models.OrderBookLevel{},
+	}
+
+	for _, side := range []struct {
+		side   string
+		order  string
+		levels *[]*models.OrderBookLevel
+	}{
+		{models.MarketOrderBuy, "DESC", &book.Bids},
+		{models.MarketOrderSell, "ASC", &book.Asks},
+	} {
+		rows, err := r.db.Query(`
+			SELECT price, SUM(quantity - filled), COUNT(*)
+			FROM market_orders
+			WHERE world_id = $1 AND resource_type = $2 AND side = $3 AND status = $4
+			GROUP BY price
+			ORDER BY price `+side.order+`
+			LIMIT $5
+		`, worldID, resourceType, side.side, models.MarketOrderOpen, depth)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var level models.OrderBookLevel
+			if err := rows.Scan(&level.Price, &level.Quantity, &level.Orders); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			*side.levels = append(*side.levels, &level)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return book, nil
+}
+
+// GetMarketStats calcula las estadísticas de cada recurso del mundo desde since: precio medio
+// ponderado por volumen, mínimo, máximo y volumen de los cruces, y órdenes y ofertas abiertas
+func (r *ExchangeRepository) GetMarketStats(worldID uuid.UUID, since time.Time) ([]models.MarketStats, error) {
+	rows, err := r.db.Query(`
+		WITH resources(resource_type) AS (VALUES ('wood'), ('stone'), ('food')),
+		trades AS (
+			SELECT t.resource_type,
+			       SUM(t.total_price)::float / NULLIF(SUM(t.amount), 0) AS average_price,
+			       MIN(t.price_per_unit) AS min_price,
+			       MAX(t.price_per_unit) AS max_price,
+			       SUM(t.amount) AS volume,
+			       MAX(t.created_at) AS last_trade
+			FROM trade_transactions t
+			JOIN villages v ON v.id = t.seller_village_id
+			WHERE v.world_id = $1 AND t.created_at > $2
+			GROUP BY t.resource_type
+		),
+		open_orders AS (
+			SELECT resource_type, COUNT(*) AS active
+			FROM market_orders
+			WHERE world_id = $1 AND status = 'open'
+			GROUP BY resource_type
+		),
+		open_offers AS (
+			SELECT o.resource_type, COUNT(*) AS active
+			FROM trade_offers o
+			JOIN villages v ON v.id = o.village_id
+			WHERE v.world_id = $1 AND o.status = 'active'
+			GROUP BY o.resource_type
+		)
+		SELECT r.resource_type,
+		       COALESCE(t.average_price, 0), COALESCE(t.min_price, 0), COALESCE(t.max_price, 0),
+		       COALESCE(t.volume, 0), COALESCE(oo.active, 0) + COALESCE(of.active, 0),
+		       COALESCE(t.last_trade, NOW())
+		FROM resources r
+		LEFT JOIN trades t ON t.resource_type = r.resource_type
+		LEFT JOIN open_orders oo ON oo.resource_type = r.resource_type
+		LEFT JOIN open_offers of ON of.resource_type = r.resource_type
+		ORDER BY r.resource_type
+	`, worldID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []models.MarketStats{}
+	for rows.Next() {
+		var stat models.MarketStats
+		err := rows.Scan(&stat.ResourceType, &stat.AveragePrice, &stat.MinPrice, &stat.MaxPrice,
+			&stat.TotalVolume, &stat.ActiveOffers, &stat.LastUpdated)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+// lockMatchingOrders bloquea la siguiente tanda de órdenes contrarias que cruzan con order, por
+// prioridad de precio y tiempo. Las órdenes del mismo jugador no se cruzan entre sí.
+func (r *ExchangeRepository) lockMatchingOrders(tx *sql.Tx, order *models.MarketOrder) ([]*models.MarketOrder, error) {
+	query := `
+		SELECT ` + marketOrderColumns + `
+		FROM market_orders
+		WHERE world_id = $1 AND resource_type = $2 AND side = $3 AND status = $4 AND player_id <> $5
+		  AND price <= $6
+		ORDER BY price ASC, created_at ASC, id
+		LIMIT $7
+		FOR UPDATE`
+	opposite := models.MarketOrderSell
+	if order.Side == models.MarketOrderSell {
+		query = `
+		SELECT ` + marketOrderColumns + `
+		FROM market_orders
+		WHERE world_id = $1 AND resource_type = $2 AND side = $3 AND status = $4 AND player_id <> $5
+		  AND price >= $6
+		ORDER BY price DESC, created_at ASC, id
+		LIMIT $7
+		FOR UPDATE`
+		opposite = models.MarketOrderBuy
+	}
+
+	rows, err := tx.Query(query, order.WorldID, order.ResourceType, opposite, models.MarketOrderOpen,
+		order.PlayerID, order.Price, exchangeMatchBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var makers []*models.MarketOrder
+	for rows.Next() {
+		maker, err := scanMarketOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		makers = append(makers, maker)
+	}
+	return makers, rows.Err()
+}
+
+// matchOrders cruza la orden entrante con una orden del libro al precio de esta última
+func matchOrders(taker, maker *models.MarketOrder, at time.Time) *models.TradeTransaction {
+	quantity := taker.Remaining()
+	if maker.Remaining() < quantity {
+		quantity = maker.Remaining()
+	}
+	taker.Filled += quantity
+	maker.Filled += quantity
+	if maker.Remaining() == 0 {
+		maker.Status = models.MarketOrderFilled
+	}
+
+	buy, sell := taker, maker
+	if taker.Side == models.MarketOrderSell {
+		buy, sell = maker, taker
+	}
+	return &models.TradeTransaction{
+		ID:              uuid.New(),
+		BuyOrderID:      &buy.ID,
+		SellOrderID:     &sell.ID,
+		BuyerID:         buy.PlayerID,
+		SellerID:        sell.PlayerID,
+		BuyerVillageID:  buy.VillageID,
+		SellerVillageID: sell.VillageID,
+		ResourceType:    taker.ResourceType,
+		Amount:          quantity,
+		PricePerUnit:    maker.Price,
+		TotalPrice:      maker.Price * quantity,
+		CreatedAt:       at,
+	}
+}
+
+// settleFill anota en deltas el resultado de un cruce: el comprador recibe el recurso, el vendedor
+// el oro y, si la orden entrante era de compra a un precio mayor que el del cruce, se le devuelve
+// la diferencia que tenía en depósito
+func settleFill(deltas map[uuid.UUID]models.Resources, taker, maker *models.MarketOrder, fill *models.TradeTransaction) {
+	goods, _ := tradeGoods(fill.ResourceType, fill.Amount)
+
+	buyer := deltas[fill.BuyerVillageID]
+	buyer.Wood += goods.Wood
+	buyer.Stone += goods.Stone
+	buyer.Food += goods.Food
+	if taker.Side == models.MarketOrderBuy {
+		buyer.Gold += (taker.Price - maker.Price) * fill.Amount
+	}
+	deltas[fill.BuyerVillageID] = buyer
+
+	seller := deltas[fill.SellerVillageID]
+	seller.Gold += fill.TotalPrice
+	deltas[fill.SellerVillageID] = seller
+}
+
+// cancelVillageOrders cancela las órdenes abiertas de una aldea que cambia de dueño dentro de la
+// transacción del traspaso y acumula en refunds, para refundVillageID, lo que quedaba en depósito.
+// Los libros afectados se bloquean antes que las órdenes, como en CancelOrder, y siempre en el mismo
+// orden.
+func cancelVillageOrders(tx *sql.Tx, villageID, refundVillageID uuid.UUID, now time.Time, refunds map[uuid.UUID]models.Resources) error {
+	rows, err := tx.Query(`
+		SELECT DISTINCT world_id, resource_type FROM market_orders
+		WHERE village_id = $1 AND status = $2
+		ORDER BY world_id, resource_type
+	`, villageID, models.MarketOrderOpen)
+	if err != nil {
+		return err
+	}
+	type book struct {
+		worldID      uuid.UUID
+		resourceType string
+	}
+	var books []book
+	for rows.Next() {
+		var b book
+		if err := rows.Scan(&b.worldID, &b.resourceType); err != nil {
+			rows.Close()
+			return err
+		}
+		books = append(books, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, b := range books {
+		if err := lockOrderBook(tx, b.worldID, b.resourceType); err != nil {
+			return err
+		}
+	}
+
+	rows, err = tx.Query(`
+		UPDATE market_orders SET status = $1, updated_at = $2
+		WHERE village_id = $3 AND status = $4
+		RETURNING `+marketOrderColumns, models.MarketOrderCancelled, now, villageID, models.MarketOrderOpen)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		order, err := scanMarketOrder(rows)
+		if err != nil {
+			return err
+		}
+		escrow, err := orderEscrow(order, order.Remaining())
+		if err != nil {
+			return err
+		}
+		refunds[refundVillageID] = addGoods(refunds[refundVillageID], escrow)
+	}
+	return rows.Err()
+}
+
+// orderEscrow devuelve lo que una orden deja en depósito por quantity unidades
+func orderEscrow(order *models.MarketOrder, quantity int) (models.Resources, error) {
+	switch order.Side {
+	case models.MarketOrderSell:
+		return tradeGoods(order.ResourceType, quantity)
+	case models.MarketOrderBuy:
+		if _, err := tradeGoods(order.ResourceType, quantity); err != nil {
+			return models.Resources{}, err
+		}
+		return models.Resources{Gold: order.Price * quantity}, nil
+	}
+	return models.Resources{}, ErrMarketOrderInvalid
+}
+
+// lockOrderBook serializa las operaciones sobre el libro de un recurso en un mundo hasta el final
+// de la transacción
+func lockOrderBook(tx *sql.Tx, worldID uuid.UUID, resourceType string) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, "market:"+worldID.String()+":"+resourceType)
+	return err
+}
+
+// lockStockDeltas bloquea las filas de recursos de las aldeas de deltas en orden de ID, para no
+// cruzarse con otras transacciones que toquen las mismas aldeas, y devuelve sus dueños y existencias
+func lockStockDeltas(tx *sql.Tx, deltas map[uuid.UUID]models.Resources) (map[uuid.UUID]uuid.UUID, map[uuid.UUID]models.Resources, error) {
+	owners := make(map[uuid.UUID]uuid.UUID, len(deltas))
+	stocks := make(map[uuid.UUID]models.Resources, len(deltas))
+	for _, villageID := range sortedVillageIDs(deltas) {
+		owner, stock, err := lockTradeStock(tx, villageID)
+		if err != nil {
+			return nil, nil, err
+		}
+		owners[villageID] = owner
+		stocks[villageID] = stock
+	}
+	return owners, stocks, nil
+}
+
+// applyStockDeltas aplica las variaciones de recursos de varias aldeas, ya bloqueadas con
+// lockStockDeltas
+func applyStockDeltas(tx *sql.Tx, deltas map[uuid.UUID]models.Resources) error {
+	for _, villageID := range sortedVillageIDs(deltas) {
+		if err := addTradeStock(tx, villageID, deltas[villageID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedVillageIDs(deltas map[uuid.UUID]models.Resources) []uuid.UUID {
+	villageIDs := make([]uuid.UUID, 0, len(deltas))
+	for villageID := range deltas {
+		villageIDs = append(villageIDs, villageID)
+	}
+	sort.Slice(villageIDs, func(i, j int) bool {
+		return bytes.Compare(villageIDs[i][:], villageIDs[j][:]) < 0
+	})
+	return villageIDs
+}
+
+func scanMarketOrder(scanner rowScanner) (*models.MarketOrder, error) {
+	var order models.MarketOrder
+	err := scanner.Scan(
+		&order.ID,
+		&order.WorldID,
+		&order.PlayerID,
+		&order.VillageID,
+		&order.ResourceType,
+		&order.Side,
+		&order.Price,
+		&order.Quantity,
+		&order.Filled,
+		&order.Status,
+		&order.CreatedAt,
+		&order.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
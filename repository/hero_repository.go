@@ -7,6 +7,7 @@ import (
 
 	"server-backend/models"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
@@ -439,6 +440,31 @@ func (r *HeroRepository) GetActiveHeroes(playerID int) ([]models.PlayerHero, err
 	return activeHeroes, nil
 }
 
+// GetActiveHeroEffects obtiene el nombre, el nivel y las habilidades de los héroes activos de un
+// jugador
+func (r *HeroRepository) GetActiveHeroEffects(playerID uuid.UUID) ([]models.ProductionEffectSource, error) {
+	rows, err := r.db.Query(`
+		SELECT h.name, ph.level, COALESCE(h.abilities::text, '')
+		FROM player_heroes ph
+		JOIN heroes h ON h.id = ph.hero_id
+		WHERE ph.player_id = $1 AND ph.is_active = true
+	`, playerID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo efectos de héroes activos: %w", err)
+	}
+	defer rows.Close()
+
+	var heroes []models.ProductionEffectSource
+	for rows.Next() {
+		var hero models.ProductionEffectSource
+		if err := rows.Scan(&hero.Name, &hero.Level, &hero.Effects); err != nil {
+			return nil, fmt.Errorf("error escaneando efectos de héroe: %w", err)
+		}
+		heroes = append(heroes, hero)
+	}
+	return heroes, rows.Err()
+}
+
 // GetHeroSkills obtiene las habilidades de un héroe
 func (r *HeroRepository) GetHeroSkills(heroID int) ([]models.HeroSkill, error) {
 	query := `
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"server-backend/models"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type IntelRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewIntelRepository(db *sql.DB, logger *zap.Logger) *IntelRepository {
+	return &IntelRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const intelColumns = `
+	id, player_id, march_id, source_village_id, target_village_id, target_player_id, success,
+	scouts_sent, scouts_lost, defender_scouts_lost, accuracy, resources, buildings, garrison, created_at
+`
+
+// CreateIntelReport guarda un informe de exploración
+func (r *IntelRepository) CreateIntelReport(report *models.IntelReport) error {
+	resourcesJSON, err := json.Marshal(report.Resources)
+	if err != nil {
+		return err
+	}
+	buildingsJSON, err := json.Marshal(report.Buildings)
+	if err != nil {
+		return err
+	}
+	garrisonJSON, err := json.Marshal(report.Garrison)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(`
+		INSERT INTO intel_reports (`+intelColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	`, report.ID, report.PlayerID, report.MarchID, report.SourceVillageID, report.TargetVillageID,
+		report.TargetPlayerID, report.Success, report.ScoutsSent, report.ScoutsLost, report.DefenderScoutsLost,
+		report.Accuracy, string(resourcesJSON), string(buildingsJSON), string(garrisonJSON), report.CreatedAt)
+	return err
+}
+
+// GetIntelReport obtiene un informe de exploración por ID
+func (r *IntelRepository) GetIntelReport(reportID uuid.UUID) (*models.IntelReport, error) {
+	row := r.db.QueryRow(`SELECT `+intelColumns+` FROM intel_reports WHERE id = $1`, reportID)
+	report, err := scanIntelReport(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// GetIntelReportsByPlayer obtiene los informes de exploración más recientes de un jugador
+func (r *IntelRepository) GetIntelReportsByPlayer(playerID uuid.UUID, limit int) ([]*models.IntelReport, error) {
+	rows, err := r.db.Query(`
+		SELECT `+intelColumns+`
+		FROM intel_reports
+		WHERE player_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, playerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []*models.IntelReport
+	for rows.Next() {
+		report, err := scanIntelReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+func scanIntelReport(scanner rowScanner) (*models.IntelReport, error) {
+	var report models.IntelReport
+	var resourcesJSON, buildingsJSON, garrisonJSON string
+	err := scanner.Scan(
+		&report.ID,
+		&report.PlayerID,
+		&report.MarchID,
+		&report.SourceVillageID,
+		&report.TargetVillageID,
+		&report.TargetPlayerID,
+		&report.Success,
+		&report.ScoutsSent,
+		&report.ScoutsLost,
+		&report.DefenderScoutsLost,
+		&report.Accuracy,
+		&resourcesJSON,
+		&buildingsJSON,
+		&garrisonJSON,
+		&report.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decodeIntelSection(resourcesJSON, &report.Resources); err != nil {
+		return nil, err
+	}
+	if err := decodeIntelSection(buildingsJSON, &report.Buildings); err != nil {
+		return nil, err
+	}
+	if err := decodeIntelSection(garrisonJSON, &report.Garrison); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// decodeIntelSection decodifica una sección del informe; las secciones no reveladas quedan a nil
+func decodeIntelSection(raw string, target *map[string]int) error {
+	if raw == "" || raw == "null" {
+		return nil
+	}
+	return json.Unmarshal([]byte(raw), target)
+}
@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"server-backend/models"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type MapRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewMapRepository(db *sql.DB, logger *zap.Logger) *MapRepository {
+	return &MapRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const mapSiteColumns = `id, world_id, x, y, type, resource, bonus, level, garrison, owner_village_id, created_at`
+
+// GetWorldMap obtiene los metadatos del mapa de un mundo (nil si aún no se ha generado)
+func (r *MapRepository) GetWorldMap(worldID uuid.UUID) (*models.WorldMap, error) {
+	var worldMap models.WorldMap
+	err := r.db.QueryRow(`
+		SELECT world_id, seed, width, height, chunk_size, generated_at
+		FROM world_maps
+		WHERE world_id = $1
+	`, worldID).Scan(
+		&worldMap.WorldID,
+		&worldMap.Seed,
+		&worldMap.Width,
+		&worldMap.Height,
+		&worldMap.ChunkSize,
+		&worldMap.GeneratedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &worldMap, nil
+}
+
+// SaveWorldMap persiste un mapa completo (metadatos, chunks y emplazamientos) en una transacción.
+// Si otro proceso lo generó antes, no se sobrescribe.
+func (r *MapRepository) SaveWorldMap(worldMap *models.WorldMap, chunks []*models.MapChunk, sites []*models.MapSite) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO world_maps (world_id, seed, width, height, chunk_size, generated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (world_id) DO NOTHING
+	`, worldMap.WorldID, worldMap.Seed, worldMap.Width, worldMap.Height, worldMap.ChunkSize, worldMap.GeneratedAt)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return nil
+	}
+
+	chunkStmt, err := tx.Prepare(`
+		INSERT INTO world_map_chunks (world_id, chunk_x, chunk_y, terrain)
+		VALUES ($1, $2, $3, $4)
+	`)
+	if err != nil {
+		return err
+	}
+	defer chunkStmt.Close()
+	for _, chunk := range chunks {
+		if _, err := chunkStmt.Exec(chunk.WorldID, chunk.ChunkX, chunk.ChunkY, chunk.Terrain); err != nil {
+			return err
+		}
+	}
+
+	siteStmt, err := tx.Prepare(`
+		INSERT INTO map_sites (` + mapSiteColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`)
+	if err != nil {
+		return err
+	}
+	defer siteStmt.Close()
+	for _, site := range sites {
+		garrisonJSON, err := json.Marshal(site.Garrison)
+		if err != nil {
+			return err
+		}
+		_, err = siteStmt.Exec(site.ID, site.WorldID, site.X, site.Y, site.Type, site.Resource, site.Bonus,
+			site.Level, string(garrisonJSON), site.OwnerID, site.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetChunks obtiene los chunks de un rango (ambos extremos incluidos)
+func (r *MapRepository) GetChunks(worldID uuid.UUID, minChunkX, minChunkY, maxChunkX, maxChunkY int) ([]*models.MapChunk, error) {
+	rows, err := r.db.Query(`
+		SELECT world_id, chunk_x, chunk_y, terrain
+		FROM world_map_chunks
+		WHERE world_id = $1 AND chunk_x BETWEEN $2 AND $3 AND chunk_y BETWEEN $4 AND $5
+	`, worldID, minChunkX, maxChunkX, minChunkY, maxChunkY)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []*models.MapChunk
+	for rows.Next() {
+		var chunk models.MapChunk
+		if err := rows.Scan(&chunk.WorldID, &chunk.ChunkX, &chunk.ChunkY, &chunk.Terrain); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, &chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// GetSitesInBounds obtiene los emplazamientos especiales dentro de un rectángulo
+func (r *MapRepository) GetSitesInBounds(worldID uuid.UUID, minX, minY, maxX, maxY int) ([]*models.MapSite, error) {
+	rows, err := r.db.Query(`
+		SELECT `+mapSiteColumns+`
+		FROM map_sites
+		WHERE world_id = $1 AND x BETWEEN $2 AND $3 AND y BETWEEN $4 AND $5
+		ORDER BY y, x
+	`, worldID, minX, maxX, minY, maxY)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sites []*models.MapSite
+	for rows.Next() {
+		site, err := scanMapSite(rows)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sites, nil
+}
+
+// GetSiteAt obtiene el emplazamiento de una casilla (nil si no hay ninguno)
+func (r *MapRepository) GetSiteAt(worldID uuid.UUID, x, y int) (*models.MapSite, error) {
+	row := r.db.QueryRow(`
+		SELECT `+mapSiteColumns+`
+		FROM map_sites
+		WHERE world_id = $1 AND x = $2 AND y = $3
+	`, worldID, x, y)
+	site, err := scanMapSite(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+// GetSitesByOwner obtiene los oasis controlados por una aldea
+func (r *MapRepository) GetSitesByOwner(villageID uuid.UUID) ([]*models.MapSite, error) {
+	rows, err := r.db.Query(`
+		SELECT `+mapSiteColumns+`
+		FROM map_sites
+		WHERE owner_village_id = $1
+		ORDER BY y, x
+	`, villageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sites []*models.MapSite
+	for rows.Next() {
+		site, err := scanMapSite(rows)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sites, nil
+}
+
+// GetVillagesInBounds obtiene las aldeas de un rectángulo con su dueño y alianza
+func (r *MapRepository) GetVillagesInBounds(worldID uuid.UUID, minX, minY, maxX, maxY int) ([]*models.MapVillage, error) {
+	rows, err := r.db.Query(`
+		SELECT v.id, v.name, v.x_coordinate, v.y_coordinate, p.id, p.username, a.id, a.tag, v.created_at
+		FROM villages v
+		JOIN players p ON p.id = v.player_id
+		LEFT JOIN alliances a ON a.id = p.alliance_id
+		WHERE v.world_id = $1 AND v.x_coordinate BETWEEN $2 AND $3 AND v.y_coordinate BETWEEN $4 AND $5
+		ORDER BY v.y_coordinate, v.x_coordinate
+	`, worldID, minX, maxX, minY, maxY)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var villages []*models.MapVillage
+	for rows.Next() {
+		var village models.MapVillage
+		err := rows.Scan(
+			&village.ID,
+			&village.Name,
+			&village.X,
+			&village.Y,
+			&village.PlayerID,
+			&village.PlayerName,
+			&village.AllianceID,
+			&village.AllianceTag,
+			&village.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		villages = append(villages, &village)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return villages, nil
+}
+
+func scanMapSite(scanner rowScanner) (*models.MapSite, error) {
+	var site models.MapSite
+	var garrisonJSON sql.NullString
+	err := scanner.Scan(
+		&site.ID,
+		&site.WorldID,
+		&site.X,
+		&site.Y,
+		&site.Type,
+		&site.Resource,
+		&site.Bonus,
+		&site.Level,
+		&garrisonJSON,
+		&site.OwnerID,
+		&site.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if garrisonJSON.Valid && garrisonJSON.String != "" && garrisonJSON.String != "null" {
+		if err := json.Unmarshal([]byte(garrisonJSON.String), &site.Garrison); err != nil {
+			return nil, err
+		}
+	}
+	return &site, nil
+}
@@ -0,0 +1,473 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"server-backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type MarchRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewMarchRepository(db *sql.DB, logger *zap.Logger) *MarchRepository {
+	return &MarchRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const marchColumns = `
+	id, player_id, source_village_id, target_village_id, target_x, target_y, type, status, units, loot, distance,
+	departure_time, arrival_time, return_time, battle_id, created_at, updated_at
+`
+
+// CreateMarch registra una marcha y retira las tropas de la aldea de origen en la misma transacción
+func (r *MarchRepository) CreateMarch(march *models.March) error {
+	unitsJSON, err := json.Marshal(march.Units)
+	if err != nil {
+		return err
+	}
+	lootJSON, err := json.Marshal(march.Loot)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for unitType, quantity := range march.Units {
+		result, err := tx.Exec(`
+			UPDATE units
+			SET quantity = quantity - $1, updated_at = $2
+			WHERE village_id = $3 AND type = $4 AND quantity >= $1
+		`, quantity, time.Now(), march.SourceVillageID, unitType)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return fmt.Errorf("unidades insuficientes de tipo %s", unitType)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO marches (
+			id, player_id, source_village_id, target_village_id, target_x, target_y, type, status, units, loot, distance,
+			departure_time, arrival_time, return_time, battle_id, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $16)
+	`, march.ID, march.PlayerID, march.SourceVillageID, nullableVillageID(march.TargetVillageID), march.TargetX, march.TargetY,
+		march.Type, march.Status, string(unitsJSON), string(lootJSON), march.Distance, march.DepartureTime, march.ArrivalTime,
+		march.ReturnTime, march.BattleID, march.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetMarch obtiene una marcha por ID
+func (r *MarchRepository) GetMarch(marchID uuid.UUID) (*models.March, error) {
+	row := r.db.QueryRow(`SELECT `+marchColumns+` FROM marches WHERE id = $1`, marchID)
+	march, err := scanMarch(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return march, nil
+}
+
+// GetActiveMarchesByPlayer obtiene las marchas en curso de un jugador
+func (r *MarchRepository) GetActiveMarchesByPlayer(playerID uuid.UUID) ([]*models.March, error) {
+	return r.queryMarches(`
+		SELECT `+marchColumns+`
+		FROM marches
+		WHERE player_id = $1 AND status IN ('in_transit', 'returning', 'resolving')
+		ORDER BY arrival_time ASC
+	`, playerID)
+}
+
+// GetActiveMarchesBySource obtiene las marchas en curso que salieron de una aldea
+func (r *MarchRepository) GetActiveMarchesBySource(villageID uuid.UUID) ([]*models.March, error) {
+	return r.queryMarches(`
+		SELECT `+marchColumns+`
+		FROM marches
+		WHERE source_village_id = $1 AND status IN ('in_transit', 'returning', 'resolving')
+		ORDER BY arrival_time ASC
+	`, villageID)
+}
+
+// GetIncomingMarches obtiene las marchas que se dirigen a una aldea
+func (r *MarchRepository) GetIncomingMarches(villageID uuid.UUID) ([]*models.March, error) {
+	return r.queryMarches(`
+		SELECT `+marchColumns+`
+		FROM marches
+		WHERE target_village_id = $1 AND status = 'in_transit'
+		ORDER BY arrival_time ASC
+	`, villageID)
+}
+
+// CountIncomingAttacks cuenta los ataques en tránsito contra las aldeas de un jugador
+func (r *MarchRepository) CountIncomingAttacks(playerID uuid.UUID) (int, error) {
+	var count int
+	err := r.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM marches m
+		JOIN villages v ON v.id = m.target_village_id
+		WHERE v.player_id = $1 AND m.type = 'attack' AND m.status = 'in_transit'
+	`, playerID).Scan(&count)
+	return count, err
+}
+
+// CountPendingSettlements cuenta las marchas de colonización de un jugador que aún no han llegado
+func (r *MarchRepository) CountPendingSettlements(playerID uuid.UUID) (int, error) {
+	var count int
+	err := r.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM marches
+		WHERE player_id = $1 AND type = 'settle' AND status IN ('in_transit', 'resolving')
+	`, playerID).Scan(&count)
+	return count, err
+}
+
+// GetDueArrivals obtiene las marchas que ya han llegado a su destino
+func (r *MarchRepository) GetDueArrivals(now time.Time, limit int) ([]*models.March, error) {
+	return r.queryMarches(`
+		SELECT `+marchColumns+`
+		FROM marches
+		WHERE status = 'in_transit' AND arrival_time <= $1
+		ORDER BY arrival_time ASC
+		LIMIT $2
+	`, now, limit)
+}
+
+// GetDueReturns obtiene las marchas que ya han vuelto a su aldea de origen
+func (r *MarchRepository) GetDueReturns(now time.Time, limit int) ([]*models.March, error) {
+	return r.queryMarches(`
+		SELECT `+marchColumns+`
+		FROM marches
+		WHERE status = 'returning' AND return_time <= $1
+		ORDER BY return_time ASC
+		LIMIT $2
+	`, now, limit)
+}
+
+// ClaimArrival reserva una marcha llegada para resolverla, pasándola de en tránsito a 'resolving'.
+// Devuelve sql.ErrNoRows si la marcha ya no estaba en tránsito porque otra pasada del planificador
+// la reclamó antes o el jugador la canceló.
+func (r *MarchRepository) ClaimArrival(march *models.March) error {
+	now := time.Now()
+	result, err := r.db.Exec(`
+		UPDATE marches SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4
+	`, models.MarchStatusResolving, now, march.ID, models.MarchStatusInTransit)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+	march.Status = models.MarchStatusResolving
+	march.UpdatedAt = now
+	return nil
+}
+
+// UpdateMarch actualiza estado, tropas y tiempos de una marcha si sigue en el estado from.
+// Devuelve sql.ErrNoRows si la marcha ya había cambiado de estado.
+func (r *MarchRepository) UpdateMarch(march *models.March, from string) error {
+	unitsJSON, err := json.Marshal(march.Units)
+	if err != nil {
+		return err
+	}
+	lootJSON, err := json.Marshal(march.Loot)
+	if err != nil {
+		return err
+	}
+
+	march.UpdatedAt = time.Now()
+	result, err := r.db.Exec(`
+		UPDATE marches
+		SET status = $1, units = $2, loot = $3, arrival_time = $4, return_time = $5, battle_id = $6, updated_at = $7
+		WHERE id = $8 AND status = $9
+	`, march.Status, string(unitsJSON), string(lootJSON), march.ArrivalTime, march.ReturnTime, march.BattleID, march.UpdatedAt, march.ID, from)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// SettleMarch marca como completada la marcha que sigue en el estado from y deposita sus tropas y su
+// botín en la aldea indicada. Devuelve sql.ErrNoRows sin depositar nada si la marcha ya había
+// cambiado de estado. Si la aldea ha cambiado de dueño mientras la marcha estaba fuera, las tropas
+// y el botín se pierden.
+func (r *MarchRepository) SettleMarch(march *models.March, villageID uuid.UUID, from string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := completeMarch(tx, march.ID, from, now); err != nil {
+		return err
+	}
+
+	var owner uuid.UUID
+	err = tx.QueryRow(`SELECT player_id FROM villages WHERE id = $1 FOR SHARE`, villageID).Scan(&owner)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows || owner != march.PlayerID {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		march.Status = models.MarchStatusCompleted
+		march.UpdatedAt = now
+		return nil
+	}
+
+	if err := addVillageUnits(tx, villageID, march.Units); err != nil {
+		return err
+	}
+
+	if len(march.Loot) > 0 {
+		_, err = tx.Exec(`
+			UPDATE resources
+			SET wood = wood + $1, stone = stone + $2, food = food + $3, gold = gold + $4
+			WHERE village_id = $5
+		`, march.Loot["wood"], march.Loot["stone"], march.Loot["food"], march.Loot["gold"], villageID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	march.Status = models.MarchStatusCompleted
+	march.UpdatedAt = now
+	return nil
+}
+
+// StationMarch marca como completada la marcha que sigue en el estado from y deja sus tropas
+// estacionadas en la aldea de destino. Devuelve sql.ErrNoRows sin estacionar nada si la marcha ya
+// había cambiado de estado.
+func (r *MarchRepository) StationMarch(march *models.March, from string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := completeMarch(tx, march.ID, from, now); err != nil {
+		return err
+	}
+
+	for unitType, quantity := range march.Units {
+		if quantity <= 0 {
+			continue
+		}
+		_, err := tx.Exec(`
+			INSERT INTO stationed_troops (id, owner_player_id, origin_village_id, host_village_id, unit_type, quantity, arrived_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+			ON CONFLICT (origin_village_id, host_village_id, unit_type)
+			DO UPDATE SET quantity = stationed_troops.quantity + EXCLUDED.quantity, updated_at = EXCLUDED.updated_at
+		`, uuid.New(), march.PlayerID, march.SourceVillageID, march.TargetVillageID, unitType, quantity, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	march.Status = models.MarchStatusCompleted
+	march.UpdatedAt = now
+	return nil
+}
+
+// completeMarch pasa una marcha del estado from a completada dentro de una transacción. Al
+// bloquear la fila, una segunda transacción que intente completarla espera y no encuentra nada.
+func completeMarch(tx *sql.Tx, marchID uuid.UUID, from string, now time.Time) error {
+	result, err := tx.Exec(`
+		UPDATE marches SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4
+	`, models.MarchStatusCompleted, now, marchID, from)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// CreateRecallMarch retira tropas estacionadas y registra la marcha de regreso a su aldea de origen
+// en la misma transacción
+func (r *MarchRepository) CreateRecallMarch(march *models.March) error {
+	unitsJSON, err := json.Marshal(march.Units)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for unitType, quantity := range march.Units {
+		var remaining int
+		err := tx.QueryRow(`
+			UPDATE stationed_troops
+			SET quantity = quantity - $1, updated_at = $2
+			WHERE origin_village_id = $3 AND host_village_id = $4 AND unit_type = $5 AND quantity >= $1
+			RETURNING quantity
+		`, quantity, march.CreatedAt, march.SourceVillageID, march.TargetVillageID, unitType).Scan(&remaining)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("tropas estacionadas insuficientes de tipo %s", unitType)
+		}
+		if err != nil {
+			return err
+		}
+		if remaining == 0 {
+			_, err = tx.Exec(`
+				DELETE FROM stationed_troops
+				WHERE origin_village_id = $1 AND host_village_id = $2 AND unit_type = $3
+			`, march.SourceVillageID, march.TargetVillageID, unitType)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO marches (
+			id, player_id, source_village_id, target_village_id, type, status, units, loot, distance,
+			departure_time, arrival_time, return_time, battle_id, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, '{}', $8, $9, $10, $11, NULL, $12, $12)
+	`, march.ID, march.PlayerID, march.SourceVillageID, march.TargetVillageID, march.Type, march.Status,
+		string(unitsJSON), march.Distance, march.DepartureTime, march.ArrivalTime, march.ReturnTime, march.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// addVillageUnits suma tropas a una aldea creando la fila de unidad si no existe
+func addVillageUnits(tx *sql.Tx, villageID uuid.UUID, units map[string]int) error {
+	now := time.Now()
+	for unitType, quantity := range units {
+		if quantity <= 0 {
+			continue
+		}
+		result, err := tx.Exec(`
+			UPDATE units SET quantity = quantity + $1, updated_at = $2
+			WHERE village_id = $3 AND type = $4
+		`, quantity, now, villageID, unitType)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected > 0 {
+			continue
+		}
+		_, err = tx.Exec(`
+			INSERT INTO units (id, village_id, type, quantity, in_training, training_completion_time, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, 0, NULL, $5, $5)
+		`, uuid.New(), villageID, unitType, quantity, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MarchRepository) queryMarches(query string, args ...interface{}) ([]*models.March, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var marches []*models.March
+	for rows.Next() {
+		march, err := scanMarch(rows)
+		if err != nil {
+			return nil, err
+		}
+		marches = append(marches, march)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return marches, nil
+}
+
+// nullableVillageID guarda NULL en lugar de uuid.Nil para las marchas sin aldea de destino
+func nullableVillageID(id uuid.UUID) interface{} {
+	if id == uuid.Nil {
+		return nil
+	}
+	return id
+}
+
+// rowScanner abstrae *sql.Row y *sql.Rows para reutilizar las funciones de escaneo
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMarch(scanner rowScanner) (*models.March, error) {
+	var march models.March
+	var unitsJSON, lootJSON string
+	err := scanner.Scan(
+		&march.ID,
+		&march.PlayerID,
+		&march.SourceVillageID,
+		&march.TargetVillageID,
+		&march.TargetX,
+		&march.TargetY,
+		&march.Type,
+		&march.Status,
+		&unitsJSON,
+		&lootJSON,
+		&march.Distance,
+		&march.DepartureTime,
+		&march.ArrivalTime,
+		&march.ReturnTime,
+		&march.BattleID,
+		&march.CreatedAt,
+		&march.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	march.Units = make(map[string]int)
+	if unitsJSON != "" {
+		if err := json.Unmarshal([]byte(unitsJSON), &march.Units); err != nil {
+			return nil, err
+		}
+	}
+	march.Loot = make(map[string]int)
+	if lootJSON != "" {
+		if err := json.Unmarshal([]byte(lootJSON), &march.Loot); err != nil {
+			return nil, err
+		}
+	}
+	return &march, nil
+}
@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"server-backend/models"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrPoolInvalidSwap = errors.New("cambio inválido: se necesitan dos recursos distintos (madera, piedra, comida u oro) y una cantidad suficiente")
+	ErrPoolSlippage    = errors.New("el precio ha empeorado por debajo de la cantidad mínima aceptada")
+	ErrPoolPriceImpact = errors.New("el cambio es demasiado grande para las reservas del mercader")
+)
+
+type MarketMakerRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewMarketMakerRepository(db *sql.DB, logger *zap.Logger) *MarketMakerRepository {
+	return &MarketMakerRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// execer es lo que comparten *sql.DB y *sql.Tx para ejecutar sentencias
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+const marketPoolColumns = `world_id, wood, stone, food, gold, created_at, updated_at`
+
+// GetPool obtiene las reservas del mercader de un mundo, creándolas con las reservas iniciales de
+// config si el mundo aún no tiene mercader
+func (r *MarketMakerRepository) GetPool(worldID uuid.UUID, config *models.MarketMakerConfig) (*models.MarketPool, error) {
+	if err := ensureMarketPool(r.db, worldID, config); err != nil {
+		return nil, err
+	}
+	return scanMarketPool(r.db.QueryRow(`SELECT `+marketPoolColumns+` FROM market_pools WHERE world_id = $1`, worldID))
+}
+
+// Swap cambia recursos de una aldea con el mercader de su mundo. La cotización se recalcula con las
+// reservas bloqueadas, así que minAmountOut protege al jugador de los cambios que se ejecuten entre
+// su cotización y el suyo. Los recursos guardados de la aldea deben estar materializados antes de
+// llamar.
+func (r *MarketMakerRepository) Swap(worldID, playerID, villageID uuid.UUID, from, to string, amount, minAmountOut int, config *models.MarketMakerConfig) (*models.PoolSwap, *models.MarketPool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	if err := ensureMarketPool(tx, worldID, config); err != nil {
+		return nil, nil, err
+	}
+	pool, err := scanMarketPool(tx.QueryRow(`SELECT `+marketPoolColumns+` FROM market_pools WHERE world_id = $1 FOR UPDATE`, worldID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	quote := pool.Quote(from, to, amount, config.Fee)
+	if quote == nil || quote.AmountOut <= 0 {
+		return nil, nil, ErrPoolInvalidSwap
+	}
+	if quote.PriceImpact > config.MaxPriceImpact {
+		return nil, nil, ErrPoolPriceImpact
+	}
+	if quote.AmountOut < minAmountOut {
+		return nil, nil, ErrPoolSlippage
+	}
+
+	given, err := resourceGoods(from, quote.AmountIn)
+	if err != nil {
+		return nil, nil, err
+	}
+	received, err := resourceGoods(to, quote.AmountOut)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owner, stock, err := lockTradeStock(tx, villageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if owner != playerID {
+		return nil, nil, ErrTradeVillageNotOwned
+	}
+	if !hasTradeStock(stock, given) {
+		return nil, nil, ErrTradeInsufficientResources
+	}
+
+	delta := negateTradeGoods(given)
+	delta.Wood += received.Wood
+	delta.Stone += received.Stone
+	delta.Food += received.Food
+	delta.Gold += received.Gold
+	if err := addTradeStock(tx, villageID, delta); err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	pool.Apply(quote)
+	pool.UpdatedAt = now
+	_, err = tx.Exec(`
+		UPDATE market_pools SET wood = $1, stone = $2, food = $3, gold = $4, updated_at = $5 WHERE world_id = $6
+	`, pool.Wood, pool.Stone, pool.Food, pool.Gold, pool.UpdatedAt, worldID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	swap := &models.PoolSwap{
+		ID:           uuid.New(),
+		WorldID:      worldID,
+		PlayerID:     playerID,
+		VillageID:    villageID,
+		FromResource: from,
+		ToResource:   to,
+		AmountIn:     quote.AmountIn,
+		AmountOut:    quote.AmountOut,
+		Fee:          quote.Fee,
+		CreatedAt:    now,
+	}
+	_, err = tx.Exec(`
+		INSERT INTO market_pool_swaps (id, world_id, player_id, village_id, from_resource, to_resource, amount_in, amount_out, fee, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, swap.ID, swap.WorldID, swap.PlayerID, swap.VillageID, swap.FromResource, swap.ToResource,
+		swap.AmountIn, swap.AmountOut, swap.Fee, swap.CreatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return swap, pool, nil
+}
+
+// GetPlayerSwaps obtiene los últimos cambios de un jugador con el mercader
+func (r *MarketMakerRepository) GetPlayerSwaps(playerID uuid.UUID, limit int) ([]*models.PoolSwap, error) {
+	rows, err := r.db.Query(`
+		SELECT id, world_id, player_id, village_id, from_resource, to_resource, amount_in, amount_out, fee, created_at
+		FROM market_pool_swaps
+		WHERE player_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, playerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	swaps := []*models.PoolSwap{}
+	for rows.Next() {
+		var swap models.PoolSwap
+		err := rows.Scan(&swap.ID, &swap.WorldID, &swap.PlayerID, &swap.VillageID, &swap.FromResource,
+			&swap.ToResource, &swap.AmountIn, &swap.AmountOut, &swap.Fee, &swap.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		swaps = append(swaps, &swap)
+	}
+	return swaps, rows.Err()
+}
+
+// ensureMarketPool crea el mercader de un mundo con las reservas iniciales si aún no existe
+func ensureMarketPool(q execer, worldID uuid.UUID, config *models.MarketMakerConfig) error {
+	_, err := q.Exec(`
+		INSERT INTO market_pools (world_id, wood, stone, food, gold)
+		VALUES ($1, $2, $2, $2, $3)
+		ON CONFLICT (world_id) DO NOTHING
+	`, worldID, config.InitialReserve, config.InitialGoldReserve)
+	return err
+}
+
+func scanMarketPool(scanner rowScanner) (*models.MarketPool, error) {
+	var pool models.MarketPool
+	err := scanner.Scan(&pool.WorldID, &pool.Wood, &pool.Stone, &pool.Food, &pool.Gold, &pool.CreatedAt, &pool.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &pool, nil
+}
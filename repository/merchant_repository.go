@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"database/sql"
+	"server-backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type MerchantRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewMerchantRepository(db *sql.DB, logger *zap.Logger) *MerchantRepository {
+	return &MerchantRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const shipmentColumns = `
+	id, player_id, source_village_id, target_village_id, receiver_id, transaction_id, direct_trade_id,
+	wood, stone, food, gold, merchants, distance, status, departure_time, arrival_time, return_time,
+	created_at, updated_at
+`
+
+// queryer es lo común a *sql.DB y *sql.Tx para las consultas que se hacen dentro y fuera de transacciones
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// GetBusyMerchants cuenta los mercaderes de una aldea que están de viaje
+func (r *MerchantRepository) GetBusyMerchants(villageID uuid.UUID) (int, error) {
+	return busyMerchants(r.db, villageID)
+}
+
+// GetOutgoingShipments obtiene los envíos de una aldea cuyos mercaderes aún no han vuelto
+func (r *MerchantRepository) GetOutgoingShipments(villageID uuid.UUID) ([]*models.MerchantShipment, error) {
+	return r.queryShipments(`
+		SELECT `+shipmentColumns+`
+		FROM merchant_shipments
+		WHERE source_village_id = $1 AND status IN ($2, $3)
+		ORDER BY departure_time
+	`, villageID, models.ShipmentInTransit, models.ShipmentReturning)
+}
+
+// GetIncomingShipments obtiene los envíos que viajan hacia una aldea
+func (r *MerchantRepository) GetIncomingShipments(villageID uuid.UUID) ([]*models.MerchantShipment, error) {
+	return r.queryShipments(`
+		SELECT `+shipmentColumns+`
+		FROM merchant_shipments
+		WHERE target_village_id = $1 AND status = $2
+		ORDER BY arrival_time
+	`, villageID, models.ShipmentInTransit)
+}
+
+// GetDueArrivals obtiene los envíos que ya han llegado a su destino
+func (r *MerchantRepository) GetDueArrivals(now time.Time, limit int) ([]*models.MerchantShipment, error) {
+	return r.queryShipments(`
+		SELECT `+shipmentColumns+`
+		FROM merchant_shipments
+		WHERE status = $1 AND arrival_time <= $2
+		ORDER BY arrival_time
+		LIMIT $3
+	`, models.ShipmentInTransit, now, limit)
+}
+
+// GetDueReturns obtiene los envíos cuyos mercaderes ya han vuelto a su aldea
+func (r *MerchantRepository) GetDueReturns(now time.Time, limit int) ([]*models.MerchantShipment, error) {
+	return r.queryShipments(`
+		SELECT `+shipmentColumns+`
+		FROM merchant_shipments
+		WHERE status = $1 AND return_time <= $2
+		ORDER BY return_time
+		LIMIT $3
+	`, models.ShipmentReturning, now, limit)
+}
+
+// DeliverShipment entrega la mercancía en la aldea de destino y pone a los mercaderes de vuelta.
+// Devuelve sql.ErrNoRows si otro proceso ya la había entregado. Los recursos guardados de la aldea
+// de destino deben estar materializados antes de llamar.
+func (r *MerchantRepository) DeliverShipment(shipment *models.MerchantShipment) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		UPDATE merchant_shipments SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4
+	`, models.ShipmentReturning, now, shipment.ID, models.ShipmentInTransit)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+
+	goods := models.Resources{
+		Wood:  shipment.Goods.Wood,
+		Stone: shipment.Goods.Stone,
+		Food:  shipment.Goods.Food,
+		Gold:  shipment.Goods.Gold,
+	}
+	if err := addTradeStock(tx, shipment.TargetVillageID, goods); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	shipment.Status = models.ShipmentReturning
+	shipment.UpdatedAt = now
+	return nil
+}
+
+// CompleteShipment libera a los mercaderes que han vuelto. Devuelve sql.ErrNoRows si ya estaba
+// completado.
+func (r *MerchantRepository) CompleteShipment(shipment *models.MerchantShipment) error {
+	now := time.Now()
+	result, err := r.db.Exec(`
+		UPDATE merchant_shipments SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4
+	`, models.ShipmentCompleted, now, shipment.ID, models.ShipmentReturning)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+	shipment.Status = models.ShipmentCompleted
+	shipment.UpdatedAt = now
+	return nil
+}
+
+func (r *MerchantRepository) queryShipments(query string, args ...interface{}) ([]*models.MerchantShipment, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shipments := []*models.MerchantShipment{}
+	for rows.Next() {
+		shipment, err := scanMerchantShipment(rows)
+		if err != nil {
+			return nil, err
+		}
+		shipments = append(shipments, shipment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return shipments, nil
+}
+
+// busyMerchants cuenta los mercaderes de viaje de una aldea. Dentro de una transacción que tiene
+// bloqueada la fila de recursos de la aldea, el recuento no puede cambiar hasta el commit.
+func busyMerchants(q queryer, villageID uuid.UUID) (int, error) {
+	var busy int
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(merchants), 0)
+		FROM merchant_shipments
+		WHERE source_village_id = $1 AND status IN ($2, $3)
+	`, villageID, models.ShipmentInTransit, models.ShipmentReturning).Scan(&busy)
+	return busy, err
+}
+
+// dispatchMerchants envía mercaderes de dispatch.VillageID con la mercancía del envío. La fila de
+// recursos de la aldea de origen debe estar bloqueada en tx para que dos envíos simultáneos no
+// usen los mismos mercaderes.
+func dispatchMerchants(tx *sql.Tx, dispatch *models.MerchantDispatch, shipment *models.MerchantShipment) error {
+	load := shipment.Goods.Wood + shipment.Goods.Stone + shipment.Goods.Food + shipment.Goods.Gold
+	needed := dispatch.MerchantsNeeded(load)
+	if needed == 0 {
+		return ErrTradeNoMerchants
+	}
+	busy, err := busyMerchants(tx, dispatch.VillageID)
+	if err != nil {
+		return err
+	}
+	if dispatch.Merchants-busy < needed {
+		return ErrTradeNoMerchants
+	}
+
+	now := time.Now()
+	shipment.ID = uuid.New()
+	shipment.SourceVillageID = dispatch.VillageID
+	shipment.Merchants = needed
+	shipment.Distance = dispatch.Distance
+	shipment.Status = models.ShipmentInTransit
+	shipment.DepartureTime = now
+	shipment.ArrivalTime = now.Add(dispatch.TravelTime)
+	shipment.ReturnTime = shipment.ArrivalTime.Add(dispatch.TravelTime)
+	shipment.CreatedAt = now
+	shipment.UpdatedAt = now
+
+	_, err = tx.Exec(`
+		INSERT INTO merchant_shipments (`+shipmentColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $18)
+	`, shipment.ID, shipment.PlayerID, shipment.SourceVillageID, shipment.TargetVillageID, shipment.ReceiverID,
+		shipment.TransactionID, shipment.DirectTradeID,
+		shipment.Goods.Wood, shipment.Goods.Stone, shipment.Goods.Food, shipment.Goods.Gold,
+		shipment.Merchants, shipment.Distance, shipment.Status,
+		shipment.DepartureTime, shipment.ArrivalTime, shipment.ReturnTime, shipment.CreatedAt)
+	return err
+}
+
+func scanMerchantShipment(scanner rowScanner) (*models.MerchantShipment, error) {
+	var shipment models.MerchantShipment
+	err := scanner.Scan(
+		&shipment.ID,
+		&shipment.PlayerID,
+		&shipment.SourceVillageID,
+		&shipment.TargetVillageID,
+		&shipment.ReceiverID,
+		&shipment.TransactionID,
+		&shipment.DirectTradeID,
+		&shipment.Goods.Wood,
+		&shipment.Goods.Stone,
+		&shipment.Goods.Food,
+		&shipment.Goods.Gold,
+		&shipment.Merchants,
+		&shipment.Distance,
+		&shipment.Status,
+		&shipment.DepartureTime,
+		&shipment.ArrivalTime,
+		&shipment.ReturnTime,
+		&shipment.CreatedAt,
+		&shipment.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &shipment, nil
+}
@@ -337,3 +337,20 @@ func (r *PlayerRepository) GetPlayerTitles(playerID uuid.UUID) ([]models.Title,
 	// TODO: Implementar cuando se tenga la tabla player_titles configurada
 	return []models.Title{}, nil
 }
+
+// AccrueCulturePoints suma la cultura generada desde la última actualización al ritmo indicado
+// (puntos por hora) y devuelve el total acumulado
+func (r *PlayerRepository) AccrueCulturePoints(playerID uuid.UUID, perHour float64) (float64, error) {
+	var culture float64
+	err := r.db.QueryRow(`
+		UPDATE players
+		SET culture_points = culture_points + GREATEST(EXTRACT(EPOCH FROM (NOW() - culture_updated_at)), 0) / 3600 * $2,
+			culture_updated_at = NOW()
+		WHERE id = $1
+		RETURNING culture_points
+	`, playerID, perHour).Scan(&culture)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("jugador no encontrado")
+	}
+	return culture, err
+}
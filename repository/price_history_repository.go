@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"server-backend/models"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// candleLevel es un intervalo de velas y de dónde se construye: las velas de un minuto salen de
+// los cruces y las de cada nivel superior de las velas del nivel anterior
+type candleLevel struct {
+	Period string
+	Unit   string // unidad de date_trunc
+	Source string // intervalo de origen; vacío para construir desde trade_transactions
+}
+
+var candleLevels = []candleLevel{
+	{Period: models.CandleInterval1m, Unit: "minute"},
+	{Period: models.CandleInterval1h, Unit: "hour", Source: models.CandleInterval1m},
+	{Period: models.CandleInterval1d, Unit: "day", Source: models.CandleInterval1h},
+}
+
+type PriceHistoryRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewPriceHistoryRepository(db *sql.DB, logger *zap.Logger) *PriceHistoryRepository {
+	return &PriceHistoryRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// AggregateCandles actualiza las velas de todos los intervalos. Cada nivel se recalcula desde el
+// comienzo del intervalo que contiene su última vela menos lag, para recoger los cruces que se
+// confirmaron después de su marca de tiempo. Recalcular una vela la reemplaza entera, así que el
+// proceso se puede repetir sin duplicar volumen.
+func (r *PriceHistoryRepository) AggregateCandles(lag time.Duration) error {
+	for _, level := range candleLevels {
+		var last time.Time
+		err := r.db.QueryRow(`
+			SELECT COALESCE(MAX(bucket_start), 'epoch') FROM price_candles WHERE period = $1
+		`, level.Period).Scan(&last)
+		if err != nil {
+			return err
+		}
+		from := last.Add(-lag)
+
+		if level.Source == "" {
+			_, err = r.db.Exec(`
+				INSERT INTO price_candles (world_id, resource_type, period, bucket_start, open, high, low, close, volume, turnover, trades)
+				SELECT v.world_id, t.resource_type, $1, date_trunc($2, t.created_at) AS bucket,
+				       (ARRAY_AGG(t.price_per_unit ORDER BY t.created_at, t.id))[1],
+				       MAX(t.price_per_unit),
+				       MIN(t.price_per_unit),
+				       (ARRAY_AGG(t.price_per_unit ORDER BY t.created_at DESC, t.id DESC))[1],
+				       SUM(t.amount), SUM(t.total_price), COUNT(*)
+				FROM trade_transactions t
+				JOIN villages v ON v.id = t.seller_village_id
+				WHERE t.created_at >= date_trunc($2, $3::timestamptz)
+				GROUP BY v.world_id, t.resource_type, bucket
+				`+candleUpsert, level.Period, level.Unit, from)
+		} else {
+			_, err = r.db.Exec(`
+				INSERT INTO price_candles (world_id, resource_type, period, bucket_start, open, high, low, close, volume, turnover, trades)
+				SELECT world_id, resource_type, $1, date_trunc($2, bucket_start) AS bucket,
+				       (ARRAY_AGG(open ORDER BY bucket_start))[1],
+				       MAX(high),
+				       MIN(low),
+				       (ARRAY_AGG(close ORDER BY bucket_start DESC))[1],
+				       SUM(volume), SUM(turnover), SUM(trades)
+				FROM price_candles
+				WHERE period = $4 AND bucket_start >= date_trunc($2, $3::timestamptz)
+				GROUP BY world_id, resource_type, bucket
+				`+candleUpsert, level.Period, level.Unit, from, level.Source)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const candleUpsert = `
+	ON CONFLICT (world_id, resource_type, period, bucket_start) DO UPDATE SET
+		open = EXCLUDED.open,
+		high = EXCLUDED.high,
+		low = EXCLUDED.low,
+		close = EXCLUDED.close,
+		volume = EXCLUDED.volume,
+		turnover = EXCLUDED.turnover,
+		trades = EXCLUDED.trades
+`
+
+// PruneCandles elimina las velas de un intervalo anteriores a before
+func (r *PriceHistoryRepository) PruneCandles(period string, before time.Time) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM price_candles WHERE period = $1 AND bucket_start < $2`, period, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetCandles obtiene las velas de un recurso del mundo entre from y to, como mucho las limit más
+// recientes, ordenadas de la más antigua a la más reciente
+func (r *PriceHistoryRepository) GetCandles(worldID uuid.UUID, resourceType, period string, from, to time.Time, limit int) ([]*models.PriceCandle, error) {
+	rows, err := r.db.Query(`
+		SELECT bucket_start, open, high, low, close, volume, turnover, trades
+		FROM (
+			SELECT bucket_start, open, high, low, close, volume, turnover, trades
+			FROM price_candles
+			WHERE world_id = $1 AND resource_type = $2 AND period = $3 AND bucket_start >= $4 AND bucket_start < $5
+			ORDER BY bucket_start DESC
+			LIMIT $6
+		) c
+		ORDER BY bucket_start
+	`, worldID, resourceType, period, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candles := []*models.PriceCandle{}
+	for rows.Next() {
+		var candle models.PriceCandle
+		err := rows.Scan(&candle.BucketStart, &candle.Open, &candle.High, &candle.Low, &candle.Close,
+			&candle.Volume, &candle.Turnover, &candle.Trades)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, &candle)
+	}
+	return candles, rows.Err()
+}
+
+// GetResourcePrices obtiene de las velas de un minuto el último precio de cada recurso del mundo,
+// su variación respecto al último precio anterior a since y el volumen desde since
+func (r *PriceHistoryRepository) GetResourcePrices(worldID uuid.UUID, since time.Time) ([]models.ResourcePrice, error) {
+	rows, err := r.db.Query(`
+		SELECT resource_type,
+		       (ARRAY_AGG(close ORDER BY bucket_start DESC))[1],
+		       (ARRAY_AGG(close ORDER BY bucket_start DESC) FILTER (WHERE bucket_start < $3))[1],
+		       COALESCE(SUM(volume) FILTER (WHERE bucket_start >= $3), 0),
+		       MAX(bucket_start)
+		FROM price_candles
+		WHERE world_id = $1 AND period = $2
+		GROUP BY resource_type
+		ORDER BY resource_type
+	`, worldID, models.CandleInterval1m, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := []models.ResourcePrice{}
+	for rows.Next() {
+		var price models.ResourcePrice
+		var previous sql.NullInt64
+		if err := rows.Scan(&price.ResourceType, &price.CurrentPrice, &previous, &price.Volume24h, &price.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if previous.Valid && previous.Int64 > 0 {
+			price.Change24h = float64(int64(price.CurrentPrice)-previous.Int64) / float64(previous.Int64) * 100
+		}
+		prices = append(prices, price)
+	}
+	return prices, rows.Err()
+}
@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"server-backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+type ProtectionRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewProtectionRepository(db *sql.DB, logger *zap.Logger) *ProtectionRepository {
+	return &ProtectionRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const protectionColumns = `player_id, beginner_forfeited_at, vacation_started_at, vacation_until, vacation_cooldown_until, peace_until, updated_at`
+
+// GetProtection obtiene el estado de protección de un jugador (nil si nunca ha cambiado)
+func (r *ProtectionRepository) GetProtection(playerID uuid.UUID) (*models.PlayerProtection, error) {
+	row := r.db.QueryRow(`
+		SELECT `+protectionColumns+`
+		FROM player_protections
+		WHERE player_id = $1
+	`, playerID)
+	protection, err := scanProtection(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return protection, nil
+}
+
+// GetProtections obtiene el estado de protección de varios jugadores indexado por jugador
+func (r *ProtectionRepository) GetProtections(playerIDs []uuid.UUID) (map[uuid.UUID]*models.PlayerProtection, error) {
+	protections := make(map[uuid.UUID]*models.PlayerProtection)
+	if len(playerIDs) == 0 {
+		return protections, nil
+	}
+
+	ids := make([]string, len(playerIDs))
+	for i, id := range playerIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := r.db.Query(`
+		SELECT `+protectionColumns+`
+		FROM player_protections
+		WHERE player_id = ANY($1::uuid[])
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		protection, err := scanProtection(rows)
+		if err != nil {
+			return nil, err
+		}
+		protections[protection.PlayerID] = protection
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return protections, nil
+}
+
+// ForfeitShields retira al jugador el escudo de principiante y el escudo de paz por haber atacado
+func (r *ProtectionRepository) ForfeitShields(playerID uuid.UUID, at time.Time) error {
+	_, err := r.db.Exec(`
+		INSERT INTO player_protections (player_id, beginner_forfeited_at, updated_at)
+		VALUES ($1, $2, $2)
+		ON CONFLICT (player_id) DO UPDATE SET
+			beginner_forfeited_at = $2,
+			peace_until = CASE WHEN player_protections.peace_until > $2 THEN $2 ELSE player_protections.peace_until END,
+			updated_at = $2
+	`, playerID, at)
+	return err
+}
+
+// SetVacation guarda el periodo de vacaciones y el enfriamiento posterior. El periodo también se
+// registra en el historial de vacaciones; terminarlo antes de tiempo actualiza su fin.
+func (r *ProtectionRepository) SetVacation(playerID uuid.UUID, startedAt, until, cooldownUntil time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO player_protections (player_id, vacation_started_at, vacation_until, vacation_cooldown_until, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (player_id) DO UPDATE SET
+			vacation_started_at = $2,
+			vacation_until = $3,
+			vacation_cooldown_until = $4,
+			updated_at = NOW()
+	`, playerID, startedAt, until, cooldownUntil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO player_vacations (player_id, started_at, until)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (player_id, started_at) DO UPDATE SET until = $3
+	`, playerID, startedAt, until)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetVacationHours suma las horas del intervalo [from, to] que el jugador pasó en modo vacaciones,
+// contando todos sus periodos
+func (r *ProtectionRepository) GetVacationHours(playerID uuid.UUID, from, to time.Time) (float64, error) {
+	var hours float64
+	err := r.db.QueryRow(`
+		SELECT COALESCE(SUM(EXTRACT(EPOCH FROM LEAST(until, $3) - GREATEST(started_at, $2))), 0) / 3600
+		FROM player_vacations
+		WHERE player_id = $1 AND started_at < $3 AND until > $2
+	`, playerID, from, to).Scan(&hours)
+	return hours, err
+}
+
+// BuyPeaceShield cobra el escudo de paz en moneda global y lo activa en la misma transacción. Si ya
+// hay uno vigente se prolonga desde su fin. Devuelve el nuevo fin del escudo.
+func (r *ProtectionRepository) BuyPeaceShield(playerID uuid.UUID, duration time.Duration, cost int64, description string) (time.Time, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var balance int64
+	err = tx.QueryRow(`
+		SELECT COALESCE(amount, 0) FROM player_global_currency WHERE player_id = $1 FOR UPDATE
+	`, playerID).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return time.Time{}, fmt.Errorf("el jugador no tiene moneda global")
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if balance < cost {
+		return time.Time{}, fmt.Errorf("fondos insuficientes: tiene %d, necesita %d", balance, cost)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE player_global_currency SET amount = amount - $1, updated_at = NOW() WHERE player_id = $2
+	`, cost, playerID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO currency_transactions (player_id, currency_type, amount, type, description, balance)
+		VALUES ($1, 'global', $2, 'spend', $3, $4)
+	`, playerID, cost, description, balance-cost)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var until time.Time
+	err = tx.QueryRow(`
+		INSERT INTO player_protections (player_id, peace_until, updated_at)
+		VALUES ($1, NOW() + $2 * INTERVAL '1 second', NOW())
+		ON CONFLICT (player_id) DO UPDATE SET
+			peace_until = GREATEST(COALESCE(player_protections.peace_until, NOW()), NOW()) + $2 * INTERVAL '1 second',
+			updated_at = NOW()
+		RETURNING peace_until
+	`, playerID, duration.Seconds()).Scan(&until)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, err
+	}
+	return until, nil
+}
+
+func scanProtection(scanner rowScanner) (*models.PlayerProtection, error) {
+	var protection models.PlayerProtection
+	err := scanner.Scan(
+		&protection.PlayerID,
+		&protection.BeginnerForfeitedAt,
+		&protection.VacationStartedAt,
+		&protection.VacationUntil,
+		&protection.VacationCooldownUntil,
+		&protection.PeaceUntil,
+		&protection.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &protection, nil
+}
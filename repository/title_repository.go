@@ -270,6 +270,32 @@ func (r *TitleRepository) GetPlayerEquippedTitles(playerID uuid.UUID) ([]models.
 	return titles, nil
 }
 
+// GetEquippedTitleBonuses obtiene el nombre, el nivel y las bonificaciones de los títulos equipados
+// de un jugador que siguen vigentes
+func (r *TitleRepository) GetEquippedTitleBonuses(playerID uuid.UUID) ([]models.ProductionEffectSource, error) {
+	rows, err := r.db.Query(`
+		SELECT t.name, pt.level, COALESCE(t.bonuses, '')
+		FROM player_titles pt
+		JOIN titles t ON t.id = pt.title_id
+		WHERE pt.player_id = $1 AND pt.is_equipped = true AND t.is_active = true
+		  AND (pt.expiry_date IS NULL OR pt.expiry_date > NOW())
+	`, playerID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo bonificaciones de títulos: %w", err)
+	}
+	defer rows.Close()
+
+	var titles []models.ProductionEffectSource
+	for rows.Next() {
+		var title models.ProductionEffectSource
+		if err := rows.Scan(&title.Name, &title.Level, &title.Effects); err != nil {
+			return nil, fmt.Errorf("error escaneando bonificaciones de título: %w", err)
+		}
+		titles = append(titles, title)
+	}
+	return titles, rows.Err()
+}
+
 // GetPlayerRecentUnlocks obtiene los títulos recientemente desbloqueados de un jugador
 func (r *TitleRepository) GetPlayerRecentUnlocks(playerID uuid.UUID, limit int) ([]models.PlayerTitle, error) {
 	query := `
@@ -1,7 +1,9 @@
 package repository
 
 import (
+	"bytes"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,6 +12,20 @@ import (
 	"github.com/google/uuid"
 )
 
+var (
+	ErrTradeOfferNotFound         = errors.New("oferta no encontrada")
+	ErrTradeOfferNotActive        = errors.New("la oferta ya no está activa")
+	ErrTradeOfferInsufficient     = errors.New("la oferta no tiene tanta cantidad disponible")
+	ErrTradeOwnOffer              = errors.New("no puedes comprar tu propia oferta")
+	ErrTradeInvalidResource       = errors.New("solo se puede vender madera, piedra o comida")
+	ErrTradeInvalidAmount         = errors.New("la cantidad debe ser positiva")
+	ErrTradeVillageNotOwned       = errors.New("la aldea no pertenece al jugador")
+	ErrTradeInsufficientResources = errors.New("recursos insuficientes")
+	ErrTradeNoMerchants           = errors.New("no hay mercaderes disponibles para el envío")
+	ErrDirectTradeNotFound        = errors.New("intercambio no encontrado")
+	ErrDirectTradeNotPending      = errors.New("el intercambio ya no está pendiente")
+)
+
 type TradeRepository struct {
 	db *sql.DB
 }
@@ -18,21 +34,47 @@ func NewTradeRepository(db *sql.DB) *TradeRepository {
 	return &TradeRepository{db: db}
 }
 
-// CreateTradeOffer crea una nueva oferta de comercio
+// CreateTradeOffer publica una oferta y retira en la misma transacción los recursos ofrecidos de
+// la aldea del vendedor, que quedan en depósito. Los recursos guardados de la aldea deben estar
+// materializados antes de llamar.
 func (r *TradeRepository) CreateTradeOffer(offer *models.TradeOffer) (*models.TradeOffer, error) {
-	query := `
-		INSERT INTO trade_offers (
-			id, seller_id, village_id, resource_type, amount, price_per_unit,
-			status, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	`
+	escrow, err := tradeGoods(offer.ResourceType, offer.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error creating trade offer: %v", err)
+	}
+	defer tx.Rollback()
+
+	owner, stock, err := lockTradeStock(tx, offer.VillageID)
+	if err != nil {
+		return nil, err
+	}
+	if owner != offer.SellerID {
+		return nil, ErrTradeVillageNotOwned
+	}
+	if !hasTradeStock(stock, escrow) {
+		return nil, ErrTradeInsufficientResources
+	}
+	if err := addTradeStock(tx, offer.VillageID, negateTradeGoods(escrow)); err != nil {
+		return nil, fmt.Errorf("error escrowing trade offer: %v", err)
+	}
 
 	now := time.Now()
 	offer.ID = uuid.New()
+	offer.Status = models.TradeOfferActive
 	offer.CreatedAt = now
 	offer.UpdatedAt = now
 
-	_, err := r.db.Exec(query,
+	_, err = tx.Exec(`
+		INSERT INTO trade_offers (
+			id, seller_id, village_id, resource_type, amount, price_per_unit,
+			status, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`,
 		offer.ID, offer.SellerID, offer.VillageID, offer.ResourceType, offer.Amount,
 		offer.PricePerUnit, offer.Status, offer.CreatedAt, offer.UpdatedAt,
 	)
@@ -40,6 +82,9 @@ func (r *TradeRepository) CreateTradeOffer(offer *models.TradeOffer) (*models.Tr
 		return nil, fmt.Errorf("error creating trade offer: %v", err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error creating trade offer: %v", err)
+	}
 	return offer, nil
 }
 
@@ -53,6 +98,9 @@ func (r *TradeRepository) GetTradeOffer(offerID uuid.UUID) (*models.TradeOffer,
 		&offer.Amount, &offer.PricePerUnit, &offer.Status,
 		&offer.CreatedAt, &offer.UpdatedAt,
 	)
+	if err == sql.ErrNoRows {
+		return nil, ErrTradeOfferNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting trade offer: %v", err)
 	}
@@ -137,26 +185,67 @@ func (r *TradeRepository) GetPlayerTradeOffers(playerID uuid.UUID) ([]models.Tra
 	return offers, nil
 }
 
-// ProcessTrade procesa una transacción de comercio
-func (r *TradeRepository) ProcessTrade(offerID, buyerID, buyerVillageID uuid.UUID, amount int) (*models.TradeTransaction, error) {
-	// Obtener la oferta
-	offer, err := r.GetTradeOffer(offerID)
-	if err != nil {
-		return nil, fmt.Errorf("error getting trade offer: %v", err)
+// ProcessTrade compra parte o toda una oferta. En una sola transacción bloquea la oferta y los
+// recursos de las dos aldeas, cobra el oro al comprador, se lo paga al vendedor y envía la
+// mercancía del depósito con los mercaderes de la aldea del vendedor, que la entregan al llegar.
+// Dos compradores a la vez no pueden vender más de lo ofertado ni ocupar los mismos mercaderes.
+// Los recursos guardados de ambas aldeas deben estar materializados antes de llamar.
+func (r *TradeRepository) ProcessTrade(offerID, buyerID, buyerVillageID uuid.UUID, amount int, dispatch *models.MerchantDispatch) (*models.TradeTransaction, *models.MerchantShipment, error) {
+	if amount <= 0 {
+		return nil, nil, ErrTradeInvalidAmount
 	}
 
-	if offer.Status != "active" {
-		return nil, fmt.Errorf("offer is not active")
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error processing trade: %v", err)
 	}
+	defer tx.Rollback()
 
+	offer, err := lockTradeOffer(tx, offerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offer.Status != models.TradeOfferActive {
+		return nil, nil, ErrTradeOfferNotActive
+	}
+	if offer.SellerID == buyerID || offer.VillageID == buyerVillageID {
+		return nil, nil, ErrTradeOwnOffer
+	}
 	if offer.Amount < amount {
-		return nil, fmt.Errorf("insufficient amount in offer")
+		return nil, nil, ErrTradeOfferInsufficient
+	}
+	if dispatch.VillageID != offer.VillageID {
+		return nil, nil, ErrTradeNoMerchants
+	}
+
+	goods, err := tradeGoods(offer.ResourceType, amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	totalPrice := offer.PricePerUnit * amount
+
+	owners, stocks, err := lockTradeStocks(tx, offer.VillageID, buyerVillageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if owners[buyerVillageID] != buyerID {
+		return nil, nil, ErrTradeVillageNotOwned
+	}
+	if stocks[buyerVillageID].Gold < totalPrice {
+		return nil, nil, ErrTradeInsufficientResources
+	}
+
+	// El oro cambia de manos al comprar; la mercancía sale del depósito con los mercaderes
+	if err := addTradeStock(tx, buyerVillageID, models.Resources{Gold: -totalPrice}); err != nil {
+		return nil, nil, fmt.Errorf("error charging trade buyer: %v", err)
+	}
+	if err := addTradeStock(tx, offer.VillageID, models.Resources{Gold: totalPrice}); err != nil {
+		return nil, nil, fmt.Errorf("error paying trade seller: %v", err)
 	}
 
-	// Crear la transacción
 	transaction := &models.TradeTransaction{
 		ID:              uuid.New(),
-		OfferID:         offerID,
+		OfferID:         &offerID,
 		SellerID:        offer.SellerID,
 		BuyerID:         buyerID,
 		SellerVillageID: offer.VillageID,
@@ -164,50 +253,89 @@ func (r *TradeRepository) ProcessTrade(offerID, buyerID, buyerVillageID uuid.UUI
 		ResourceType:    offer.ResourceType,
 		Amount:          amount,
 		PricePerUnit:    offer.PricePerUnit,
-		TotalPrice:      offer.PricePerUnit * amount,
+		TotalPrice:      totalPrice,
 		CreatedAt:       time.Now(),
 	}
 
-	query := `
-		INSERT INTO trade_transactions (
-			id, offer_id, seller_id, buyer_id, seller_village_id, buyer_village_id,
-			resource_type, amount, price_per_unit, total_price, created_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-	`
-
-	_, err = r.db.Exec(query,
-		transaction.ID, transaction.OfferID, transaction.SellerID, transaction.BuyerID,
-		transaction.SellerVillageID, transaction.BuyerVillageID,
-		transaction.ResourceType, transaction.Amount, transaction.PricePerUnit,
-		transaction.TotalPrice, transaction.CreatedAt,
-	)
+	err = insertTradeTransaction(tx, transaction)
 	if err != nil {
-		return nil, fmt.Errorf("error creating trade transaction: %v", err)
+		return nil, nil, fmt.Errorf("error creating trade transaction: %v", err)
+	}
+
+	shipment := &models.MerchantShipment{
+		PlayerID:        offer.SellerID,
+		TargetVillageID: buyerVillageID,
+		ReceiverID:      buyerID,
+		TransactionID:   &transaction.ID,
+		Goods:           goodsCost(goods),
+	}
+	if err := dispatchMerchants(tx, dispatch, shipment); err != nil {
+		if err == ErrTradeNoMerchants {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("error dispatching merchants: %v", err)
 	}
 
-	// Actualizar la oferta
+	// La oferta conserva lo que queda por vender; al agotarse se da por completada
+	status := models.TradeOfferActive
 	if offer.Amount == amount {
-		// Oferta completamente vendida
-		_, err = r.db.Exec("UPDATE trade_offers SET status = 'completed', updated_at = $1 WHERE id = $2", time.Now(), offerID)
-	} else {
-		// Oferta parcialmente vendida
-		_, err = r.db.Exec("UPDATE trade_offers SET amount = amount - $1, updated_at = $2 WHERE id = $3", amount, time.Now(), offerID)
+		status = models.TradeOfferCompleted
 	}
+	_, err = tx.Exec(`
+		UPDATE trade_offers SET amount = amount - $1, status = $2, updated_at = $3 WHERE id = $4
+	`, amount, status, transaction.CreatedAt, offerID)
 	if err != nil {
-		return nil, fmt.Errorf("error updating trade offer: %v", err)
+		return nil, nil, fmt.Errorf("error updating trade offer: %v", err)
 	}
 
-	return transaction, nil
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("error processing trade: %v", err)
+	}
+	return transaction, shipment, nil
 }
 
-// CancelTradeOffer cancela una oferta de comercio
-func (r *TradeRepository) CancelTradeOffer(offerID uuid.UUID) error {
-	query := `UPDATE trade_offers SET status = 'cancelled', updated_at = $1 WHERE id = $2`
-	_, err := r.db.Exec(query, time.Now(), offerID)
+// CancelTradeOffer cancela una oferta activa y devuelve a la aldea del vendedor lo que quedaba en
+// depósito. Devuelve la oferta con la cantidad devuelta.
+func (r *TradeRepository) CancelTradeOffer(offerID uuid.UUID) (*models.TradeOffer, error) {
+	tx, err := r.db.Begin()
 	if err != nil {
-		return fmt.Errorf("error cancelling trade offer: %v", err)
+		return nil, fmt.Errorf("error cancelling trade offer: %v", err)
 	}
-	return nil
+	defer tx.Rollback()
+
+	offer, err := lockTradeOffer(tx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	if offer.Status != models.TradeOfferActive {
+		return nil, ErrTradeOfferNotActive
+	}
+
+	if offer.Amount > 0 {
+		escrow, err := tradeGoods(offer.ResourceType, offer.Amount)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := lockTradeStock(tx, offer.VillageID); err != nil {
+			return nil, err
+		}
+		if err := addTradeStock(tx, offer.VillageID, escrow); err != nil {
+			return nil, fmt.Errorf("error returning trade escrow: %v", err)
+		}
+	}
+
+	offer.Status = models.TradeOfferCancelled
+	offer.UpdatedAt = time.Now()
+	_, err = tx.Exec(`UPDATE trade_offers SET status = $1, updated_at = $2 WHERE id = $3`,
+		offer.Status, offer.UpdatedAt, offerID)
+	if err != nil {
+		return nil, fmt.Errorf("error cancelling trade offer: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error cancelling trade offer: %v", err)
+	}
+	return offer, nil
 }
 
 // DeleteTradeOffer elimina una oferta de comercio
@@ -223,7 +351,7 @@ func (r *TradeRepository) DeleteTradeOffer(offerID uuid.UUID) error {
 // GetTradeHistory obtiene el historial de transacciones de un jugador
 func (r *TradeRepository) GetTradeHistory(playerID uuid.UUID, limit int) ([]models.TradeTransaction, error) {
 	query := `
-		SELECT id, offer_id, seller_id, buyer_id, seller_village_id, buyer_village_id,
+		SELECT id, offer_id, buy_order_id, sell_order_id, seller_id, buyer_id, seller_village_id, buyer_village_id,
 		       resource_type, amount, price_per_unit, total_price, created_at
 		FROM trade_transactions
 		WHERE seller_id = $1 OR buyer_id = $1
@@ -241,7 +369,8 @@ func (r *TradeRepository) GetTradeHistory(playerID uuid.UUID, limit int) ([]mode
 	for rows.Next() {
 		var transaction models.TradeTransaction
 		err := rows.Scan(
-			&transaction.ID, &transaction.OfferID, &transaction.SellerID, &transaction.BuyerID,
+			&transaction.ID, &transaction.OfferID, &transaction.BuyOrderID, &transaction.SellOrderID,
+			&transaction.SellerID, &transaction.BuyerID,
 			&transaction.SellerVillageID, &transaction.BuyerVillageID,
 			&transaction.ResourceType, &transaction.Amount, &transaction.PricePerUnit,
 			&transaction.TotalPrice, &transaction.CreatedAt,
@@ -382,3 +511,500 @@ func (r *TradeRepository) GetTradeOffersBySeller(sellerID uuid.UUID) ([]models.T
 
 	return offers, nil
 }
+
+const directTradeColumns = `
+	id, initiator_id, initiator_village_id, target_id, target_village_id, offered_resource, offered_amount,
+	requested_resource, requested_amount, status, message, created_at, expires_at
+`
+
+// CreateDirectTrade propone un intercambio directo y retira en la misma transacción lo ofrecido de
+// la aldea del iniciador, que queda en depósito. Los recursos guardados de la aldea deben estar
+// materializados antes de llamar.
+func (r *TradeRepository) CreateDirectTrade(trade *models.DirectTrade) error {
+	escrow, err := resourceGoods(trade.OfferedResource, trade.OfferedAmount)
+	if err != nil {
+		return err
+	}
+	if _, err := resourceGoods(trade.RequestedResource, trade.RequestedAmount); err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error creating direct trade: %v", err)
+	}
+	defer tx.Rollback()
+
+	owner, stock, err := lockTradeStock(tx, trade.InitiatorVillageID)
+	if err != nil {
+		return err
+	}
+	if owner != trade.InitiatorID {
+		return ErrTradeVillageNotOwned
+	}
+	if !hasTradeStock(stock, escrow) {
+		return ErrTradeInsufficientResources
+	}
+	if err := addTradeStock(tx, trade.InitiatorVillageID, negateTradeGoods(escrow)); err != nil {
+		return fmt.Errorf("error escrowing direct trade: %v", err)
+	}
+
+	// El destinatario es el dueño actual de la aldea de destino
+	err = tx.QueryRow(`SELECT player_id FROM villages WHERE id = $1`, trade.TargetVillageID).Scan(&trade.TargetID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("aldea de destino no encontrada")
+	}
+	if err != nil {
+		return fmt.Errorf("error creating direct trade: %v", err)
+	}
+	if trade.TargetID == trade.InitiatorID {
+		return ErrTradeOwnOffer
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO direct_trades (`+directTradeColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`, trade.ID, trade.InitiatorID, trade.InitiatorVillageID, trade.TargetID, trade.TargetVillageID,
+		trade.OfferedResource, trade.OfferedAmount, trade.RequestedResource, trade.RequestedAmount,
+		trade.Status, trade.Message, trade.CreatedAt, trade.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("error creating direct trade: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error creating direct trade: %v", err)
+	}
+	return nil
+}
+
+// GetDirectTrade obtiene un intercambio directo (nil si no existe)
+func (r *TradeRepository) GetDirectTrade(tradeID uuid.UUID) (*models.DirectTrade, error) {
+	trade, err := scanDirectTrade(r.db.QueryRow(`SELECT `+directTradeColumns+` FROM direct_trades WHERE id = $1`, tradeID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting direct trade: %v", err)
+	}
+	return trade, nil
+}
+
+// GetPlayerDirectTrades obtiene los intercambios directos enviados o recibidos por un jugador
+func (r *TradeRepository) GetPlayerDirectTrades(playerID uuid.UUID, limit int) ([]models.DirectTrade, error) {
+	rows, err := r.db.Query(`
+		SELECT `+directTradeColumns+`
+		FROM direct_trades
+		WHERE initiator_id = $1 OR target_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, playerID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error getting direct trades: %v", err)
+	}
+	defer rows.Close()
+
+	trades := []models.DirectTrade{}
+	for rows.Next() {
+		trade, err := scanDirectTrade(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning direct trade: %v", err)
+		}
+		trades = append(trades, *trade)
+	}
+	return trades, rows.Err()
+}
+
+// GetExpiredDirectTrades obtiene los intercambios pendientes que ya han caducado
+func (r *TradeRepository) GetExpiredDirectTrades(now time.Time, limit int) ([]models.DirectTrade, error) {
+	rows, err := r.db.Query(`
+		SELECT `+directTradeColumns+`
+		FROM direct_trades
+		WHERE status = $1 AND expires_at <= $2
+		ORDER BY expires_at
+		LIMIT $3
+	`, models.DirectTradePending, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error getting expired direct trades: %v", err)
+	}
+	defer rows.Close()
+
+	trades := []models.DirectTrade{}
+	for rows.Next() {
+		trade, err := scanDirectTrade(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning direct trade: %v", err)
+		}
+		trades = append(trades, *trade)
+	}
+	return trades, rows.Err()
+}
+
+// AcceptDirectTrade acepta un intercambio pendiente. En una sola transacción cobra lo pedido de la
+// aldea del destinatario y envía las dos cargas con los mercaderes de cada aldea: lo ofrecido sale
+// del depósito hacia el destinatario y lo pedido viaja hacia el iniciador. Los recursos guardados
+// de ambas aldeas deben estar materializados antes de llamar.
+func (r *TradeRepository) AcceptDirectTrade(tradeID, playerID uuid.UUID, initiatorDispatch, targetDispatch *models.MerchantDispatch) (*models.DirectTrade, []*models.MerchantShipment, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error accepting direct trade: %v", err)
+	}
+	defer tx.Rollback()
+
+	trade, err := lockDirectTrade(tx, tradeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if trade.TargetID != playerID {
+		return nil, nil, ErrTradeVillageNotOwned
+	}
+	if trade.Status != models.DirectTradePending || !time.Now().Before(trade.ExpiresAt) {
+		return nil, nil, ErrDirectTradeNotPending
+	}
+	if initiatorDispatch.VillageID != trade.InitiatorVillageID || targetDispatch.VillageID != trade.TargetVillageID {
+		return nil, nil, ErrTradeNoMerchants
+	}
+
+	offered, err := resourceGoods(trade.OfferedResource, trade.OfferedAmount)
+	if err != nil {
+		return nil, nil, err
+	}
+	requested, err := resourceGoods(trade.RequestedResource, trade.RequestedAmount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owners, stocks, err := lockTradeStocks(tx, trade.InitiatorVillageID, trade.TargetVillageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if owners[trade.TargetVillageID] != playerID {
+		return nil, nil, ErrTradeVillageNotOwned
+	}
+	if !hasTradeStock(stocks[trade.TargetVillageID], requested) {
+		return nil, nil, ErrTradeInsufficientResources
+	}
+	if err := addTradeStock(tx, trade.TargetVillageID, negateTradeGoods(requested)); err != nil {
+		return nil, nil, fmt.Errorf("error charging direct trade: %v", err)
+	}
+
+	outbound := &models.MerchantShipment{
+		PlayerID:        trade.InitiatorID,
+		TargetVillageID: trade.TargetVillageID,
+		ReceiverID:      trade.TargetID,
+		DirectTradeID:   &trade.ID,
+		Goods:           goodsCost(offered),
+	}
+	inbound := &models.MerchantShipment{
+		PlayerID:        trade.TargetID,
+		TargetVillageID: trade.InitiatorVillageID,
+		ReceiverID:      trade.InitiatorID,
+		DirectTradeID:   &trade.ID,
+		Goods:           goodsCost(requested),
+	}
+	for _, leg := range []struct {
+		dispatch *models.MerchantDispatch
+		shipment *models.MerchantShipment
+	}{{initiatorDispatch, outbound}, {targetDispatch, inbound}} {
+		if err := dispatchMerchants(tx, leg.dispatch, leg.shipment); err != nil {
+			if err == ErrTradeNoMerchants {
+				return nil, nil, err
+			}
+			return nil, nil, fmt.Errorf("error dispatching merchants: %v", err)
+		}
+	}
+
+	trade.Status = models.DirectTradeAccepted
+	if _, err := tx.Exec(`UPDATE direct_trades SET status = $1 WHERE id = $2`, trade.Status, trade.ID); err != nil {
+		return nil, nil, fmt.Errorf("error accepting direct trade: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("error accepting direct trade: %v", err)
+	}
+	return trade, []*models.MerchantShipment{outbound, inbound}, nil
+}
+
+// CloseDirectTrade rechaza o da por caducado un intercambio pendiente y devuelve lo que estaba en
+// depósito a la aldea del iniciador
+func (r *TradeRepository) CloseDirectTrade(tradeID uuid.UUID, status string) (*models.DirectTrade, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error closing direct trade: %v", err)
+	}
+	defer tx.Rollback()
+
+	trade, err := lockDirectTrade(tx, tradeID)
+	if err != nil {
+		return nil, err
+	}
+	if trade.Status != models.DirectTradePending {
+		return nil, ErrDirectTradeNotPending
+	}
+
+	escrow, err := resourceGoods(trade.OfferedResource, trade.OfferedAmount)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := lockTradeStock(tx, trade.InitiatorVillageID); err != nil {
+		return nil, err
+	}
+	if err := addTradeStock(tx, trade.InitiatorVillageID, escrow); err != nil {
+		return nil, fmt.Errorf("error returning direct trade escrow: %v", err)
+	}
+
+	trade.Status = status
+	if _, err := tx.Exec(`UPDATE direct_trades SET status = $1 WHERE id = $2`, trade.Status, trade.ID); err != nil {
+		return nil, fmt.Errorf("error closing direct trade: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error closing direct trade: %v", err)
+	}
+	return trade, nil
+}
+
+// cancelVillageTrades cierra el comercio abierto de una aldea que cambia de dueño dentro de la
+// transacción del traspaso y acumula en refunds lo que hay que devolver a cada aldea. Las ofertas y
+// los intercambios que salieron de ella devuelven su depósito al antiguo dueño en refundVillageID,
+// igual que la mercancía que viajaba hacia él; los intercambios que otros le habían propuesto
+// devuelven el depósito a su iniciador. Los envíos que salieron de la aldea siguen su camino porque
+// su mercancía ya está pagada.
+func cancelVillageTrades(tx *sql.Tx, villageID, ownerID, refundVillageID uuid.UUID, now time.Time, refunds map[uuid.UUID]models.Resources) error {
+
+	rows, err := tx.Query(`
+		UPDATE trade_offers SET status = $1, updated_at = $2
+		WHERE village_id = $3 AND status = $4
+		RETURNING resource_type, amount
+	`, models.TradeOfferCancelled, now, villageID, models.TradeOfferActive)
+	if err != nil {
+		return fmt.Errorf("error cancelling trade offers: %v", err)
+	}
+	for rows.Next() {
+		var resourceType string
+		var amount int
+		if err := rows.Scan(&resourceType, &amount); err != nil {
+			rows.Close()
+			return err
+		}
+		if amount > 0 {
+			escrow, err := tradeGoods(resourceType, amount)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			refunds[refundVillageID] = addGoods(refunds[refundVillageID], escrow)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.Query(`
+		UPDATE direct_trades SET status = $1
+		WHERE (initiator_village_id = $2 OR target_village_id = $2) AND status = $3
+		RETURNING initiator_village_id, offered_resource, offered_amount
+	`, models.DirectTradeCancelled, villageID, models.DirectTradePending)
+	if err != nil {
+		return fmt.Errorf("error cancelling direct trades: %v", err)
+	}
+	for rows.Next() {
+		var initiatorVillageID uuid.UUID
+		var resourceType string
+		var amount int
+		if err := rows.Scan(&initiatorVillageID, &resourceType, &amount); err != nil {
+			rows.Close()
+			return err
+		}
+		escrow, err := resourceGoods(resourceType, amount)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if initiatorVillageID == villageID {
+			initiatorVillageID = refundVillageID
+		}
+		refunds[initiatorVillageID] = addGoods(refunds[initiatorVillageID], escrow)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// La mercancía que viajaba hacia el antiguo dueño se le entrega en su otra aldea y los mercaderes
+	// emprenden el regreso como si hubieran llegado
+	rows, err = tx.Query(`
+		UPDATE merchant_shipments SET status = $1, updated_at = $2
+		WHERE target_village_id = $3 AND receiver_id = $4 AND status = $5
+		RETURNING wood, stone, food, gold
+	`, models.ShipmentReturning, now, villageID, ownerID, models.ShipmentInTransit)
+	if err != nil {
+		return fmt.Errorf("error redirecting merchant shipments: %v", err)
+	}
+	for rows.Next() {
+		var goods models.Resources
+		if err := rows.Scan(&goods.Wood, &goods.Stone, &goods.Food, &goods.Gold); err != nil {
+			rows.Close()
+			return err
+		}
+		refunds[refundVillageID] = addGoods(refunds[refundVillageID], goods)
+	}
+	rows.Close()
+	return rows.Err()
+}
+
+// tradeGoods devuelve como recursos la cantidad de mercancía de una oferta. El oro no se vende
+// porque es la moneda con la que se paga.
+func tradeGoods(resourceType string, amount int) (models.Resources, error) {
+	if resourceType == "gold" {
+		return models.Resources{}, ErrTradeInvalidResource
+	}
+	return resourceGoods(resourceType, amount)
+}
+
+// resourceGoods devuelve como recursos una cantidad de un tipo de recurso
+func resourceGoods(resourceType string, amount int) (models.Resources, error) {
+	if amount <= 0 {
+		return models.Resources{}, ErrTradeInvalidAmount
+	}
+	switch resourceType {
+	case "wood":
+		return models.Resources{Wood: amount}, nil
+	case "stone":
+		return models.Resources{Stone: amount}, nil
+	case "food":
+		return models.Resources{Food: amount}, nil
+	case "gold":
+		return models.Resources{Gold: amount}, nil
+	}
+	return models.Resources{}, ErrTradeInvalidResource
+}
+
+func goodsCost(goods models.Resources) models.ResourceCostsLegacy {
+	return models.ResourceCostsLegacy{Wood: goods.Wood, Stone: goods.Stone, Food: goods.Food, Gold: goods.Gold}
+}
+
+func addGoods(a, b models.Resources) models.Resources {
+	return models.Resources{Wood: a.Wood + b.Wood, Stone: a.Stone + b.Stone, Food: a.Food + b.Food, Gold: a.Gold + b.Gold}
+}
+
+func negateTradeGoods(goods models.Resources) models.Resources {
+	return models.Resources{Wood: -goods.Wood, Stone: -goods.Stone, Food: -goods.Food, Gold: -goods.Gold}
+}
+
+func hasTradeStock(stock, goods models.Resources) bool {
+	return stock.Wood >= goods.Wood && stock.Stone >= goods.Stone && stock.Food >= goods.Food && stock.Gold >= goods.Gold
+}
+
+// insertTradeTransaction registra una compra del mercado o un cruce de la bolsa
+func insertTradeTransaction(tx *sql.Tx, transaction *models.TradeTransaction) error {
+	_, err := tx.Exec(`
+		INSERT INTO trade_transactions (
+			id, offer_id, buy_order_id, sell_order_id, seller_id, buyer_id, seller_village_id, buyer_village_id,
+			resource_type, amount, price_per_unit, total_price, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`,
+		transaction.ID, transaction.OfferID, transaction.BuyOrderID, transaction.SellOrderID,
+		transaction.SellerID, transaction.BuyerID, transaction.SellerVillageID, transaction.BuyerVillageID,
+		transaction.ResourceType, transaction.Amount, transaction.PricePerUnit,
+		transaction.TotalPrice, transaction.CreatedAt,
+	)
+	return err
+}
+
+// lockTradeOffer lee una oferta bloqueándola hasta el final de la transacción
+func lockTradeOffer(tx *sql.Tx, offerID uuid.UUID) (*models.TradeOffer, error) {
+	var offer models.TradeOffer
+	err := tx.QueryRow(`
+		SELECT id, seller_id, village_id, resource_type, amount, price_per_unit, status, created_at, updated_at
+		FROM trade_offers
+		WHERE id = $1
+		FOR UPDATE
+	`, offerID).Scan(
+		&offer.ID, &offer.SellerID, &offer.VillageID, &offer.ResourceType,
+		&offer.Amount, &offer.PricePerUnit, &offer.Status,
+		&offer.CreatedAt, &offer.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrTradeOfferNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error locking trade offer: %v", err)
+	}
+	return &offer, nil
+}
+
+// lockDirectTrade lee un intercambio directo bloqueándolo hasta el final de la transacción
+func lockDirectTrade(tx *sql.Tx, tradeID uuid.UUID) (*models.DirectTrade, error) {
+	trade, err := scanDirectTrade(tx.QueryRow(`SELECT `+directTradeColumns+` FROM direct_trades WHERE id = $1 FOR UPDATE`, tradeID))
+	if err == sql.ErrNoRows {
+		return nil, ErrDirectTradeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error locking direct trade: %v", err)
+	}
+	return trade, nil
+}
+
+func scanDirectTrade(scanner rowScanner) (*models.DirectTrade, error) {
+	var trade models.DirectTrade
+	err := scanner.Scan(
+		&trade.ID, &trade.InitiatorID, &trade.InitiatorVillageID, &trade.TargetID, &trade.TargetVillageID,
+		&trade.OfferedResource, &trade.OfferedAmount, &trade.RequestedResource, &trade.RequestedAmount,
+		&trade.Status, &trade.Message, &trade.CreatedAt, &trade.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &trade, nil
+}
+
+// lockTradeStock bloquea la fila de recursos de una aldea y devuelve su dueño y sus existencias
+func lockTradeStock(tx *sql.Tx, villageID uuid.UUID) (uuid.UUID, models.Resources, error) {
+	var owner uuid.UUID
+	var stock models.Resources
+	err := tx.QueryRow(`
+		SELECT v.player_id, r.id, r.village_id, r.wood, r.stone, r.food, r.gold, r.last_updated
+		FROM resources r
+		JOIN villages v ON v.id = r.village_id
+		WHERE r.village_id = $1
+		FOR UPDATE OF r
+	`, villageID).Scan(&owner, &stock.ID, &stock.VillageID, &stock.Wood, &stock.Stone, &stock.Food, &stock.Gold, &stock.LastUpdated)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, models.Resources{}, fmt.Errorf("recursos de la aldea no encontrados")
+	}
+	if err != nil {
+		return uuid.Nil, models.Resources{}, fmt.Errorf("error locking village resources: %v", err)
+	}
+	return owner, stock, nil
+}
+
+// lockTradeStocks bloquea las filas de recursos de dos aldeas, siempre en orden de ID para que dos
+// operaciones cruzadas entre las mismas aldeas no se bloqueen mutuamente
+func lockTradeStocks(tx *sql.Tx, a, b uuid.UUID) (map[uuid.UUID]uuid.UUID, map[uuid.UUID]models.Resources, error) {
+	if bytes.Compare(b[:], a[:]) < 0 {
+		a, b = b, a
+	}
+	owners := make(map[uuid.UUID]uuid.UUID, 2)
+	stocks := make(map[uuid.UUID]models.Resources, 2)
+	for _, villageID := range []uuid.UUID{a, b} {
+		owner, stock, err := lockTradeStock(tx, villageID)
+		if err != nil {
+			return nil, nil, err
+		}
+		owners[villageID] = owner
+		stocks[villageID] = stock
+	}
+	return owners, stocks, nil
+}
+
+// addTradeStock suma (o resta) recursos a una aldea sin mover last_updated, de modo que la
+// producción pendiente desde el último checkpoint se sigue acumulando
+func addTradeStock(tx *sql.Tx, villageID uuid.UUID, delta models.Resources) error {
+	_, err := tx.Exec(`
+		UPDATE resources
+		SET wood = wood + $1, stone = stone + $2, food = food + $3, gold = gold + $4
+		WHERE village_id = $5
+	`, delta.Wood, delta.Stone, delta.Food, delta.Gold, villageID)
+	return err
+}
@@ -0,0 +1,343 @@
+package repository
+
+import (
+	"database/sql"
+	"server-backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type TrainingRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewTrainingRepository(db *sql.DB, logger *zap.Logger) *TrainingRepository {
+	return &TrainingRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const trainingColumns = `
+	id, village_id, building, unit_type, quantity, completed, unit_time,
+	cost_wood, cost_stone, cost_food, cost_gold, position, status,
+	start_time, next_completion_time, end_time, created_at, updated_at
+`
+
+// CreateBatch añade un lote al final de la cola del edificio y lo refleja en las unidades en entrenamiento
+func (r *TrainingRepository) CreateBatch(batch *models.TrainingBatch) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockTrainingQueue(tx, batch.VillageID, batch.Building); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`
+		SELECT COALESCE(MAX(position), 0) + 1
+		FROM training_batches
+		WHERE village_id = $1 AND building = $2 AND status IN ($3, $4)
+	`, batch.VillageID, batch.Building, models.TrainingStatusQueued, models.TrainingStatusTraining).Scan(&batch.Position)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO training_batches (`+trainingColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $17)
+	`, batch.ID, batch.VillageID, batch.Building, batch.UnitType, batch.Quantity, batch.Completed, batch.UnitTime,
+		batch.UnitCost.Wood, batch.UnitCost.Stone, batch.UnitCost.Food, batch.UnitCost.Gold, batch.Position, batch.Status,
+		batch.StartTime, batch.NextCompletionTime, batch.EndTime, batch.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := addUnitsInTraining(tx, batch.VillageID, batch.UnitType, batch.Quantity); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetBatch obtiene un lote de entrenamiento por ID
+func (r *TrainingRepository) GetBatch(batchID uuid.UUID) (*models.TrainingBatch, error) {
+	row := r.db.QueryRow(`SELECT `+trainingColumns+` FROM training_batches WHERE id = $1`, batchID)
+	batch, err := scanTrainingBatch(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// GetVillageQueue obtiene los lotes pendientes de una aldea ordenados por edificio y posición
+func (r *TrainingRepository) GetVillageQueue(villageID uuid.UUID) ([]*models.TrainingBatch, error) {
+	return r.queryBatches(`
+		SELECT `+trainingColumns+`
+		FROM training_batches
+		WHERE village_id = $1 AND status IN ($2, $3)
+		ORDER BY building, position
+	`, villageID, models.TrainingStatusQueued, models.TrainingStatusTraining)
+}
+
+// GetDueBatches obtiene los lotes con alguna unidad lista para salir
+func (r *TrainingRepository) GetDueBatches(now time.Time, limit int) ([]*models.TrainingBatch, error) {
+	return r.queryBatches(`
+		SELECT `+trainingColumns+`
+		FROM training_batches
+		WHERE status = $1 AND next_completion_time <= $2
+		ORDER BY next_completion_time
+		LIMIT $3
+	`, models.TrainingStatusTraining, now, limit)
+}
+
+// StartQueuedBatches ocupa los huecos libres de un edificio con slots plazas con los siguientes lotes
+// de la cola, que arrancan en startAt. La cola del edificio queda bloqueada durante la transacción,
+// así que dos pasadas simultáneas no pueden arrancar más lotes que plazas.
+func (r *TrainingRepository) StartQueuedBatches(villageID uuid.UUID, building string, slots int, startAt time.Time) ([]*models.TrainingBatch, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockTrainingQueue(tx, villageID, building); err != nil {
+		return nil, err
+	}
+
+	var active int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM training_batches
+		WHERE village_id = $1 AND building = $2 AND status = $3
+	`, villageID, building, models.TrainingStatusTraining).Scan(&active)
+	if err != nil {
+		return nil, err
+	}
+	free := slots - active
+	if free <= 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT `+trainingColumns+`
+		FROM training_batches
+		WHERE village_id = $1 AND building = $2 AND status = $3
+		ORDER BY position
+		LIMIT $4
+	`, villageID, building, models.TrainingStatusQueued, free)
+	if err != nil {
+		return nil, err
+	}
+	var queued []*models.TrainingBatch
+	for rows.Next() {
+		batch, err := scanTrainingBatch(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		queued = append(queued, batch)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, batch := range queued {
+		batch.Start(startAt)
+		batch.UpdatedAt = now
+		result, err := tx.Exec(`
+			UPDATE training_batches
+			SET status = $1, start_time = $2, next_completion_time = $3, end_time = $4, updated_at = $5
+			WHERE id = $6 AND status = $7
+		`, batch.Status, batch.StartTime, batch.NextCompletionTime, batch.EndTime, batch.UpdatedAt,
+			batch.ID, models.TrainingStatusQueued)
+		if err != nil {
+			return nil, err
+		}
+		if err := requireAffected(result); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return queued, nil
+}
+
+// CompleteUnits pasa count unidades terminadas del lote a la guarnición de la aldea. El lote solo se
+// actualiza si sigue entrenando con las unidades completadas que había antes de estas; si otra pasada
+// ya las entregó o el lote se canceló, devuelve sql.ErrNoRows sin sumar nada.
+func (r *TrainingRepository) CompleteUnits(batch *models.TrainingBatch, count int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		UPDATE training_batches
+		SET completed = $1, status = $2, next_completion_time = $3, updated_at = $4
+		WHERE id = $5 AND completed = $6 AND status = $7
+	`, batch.Completed, batch.Status, batch.NextCompletionTime, now, batch.ID,
+		batch.Completed-count, models.TrainingStatusTraining)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE units
+		SET quantity = quantity + $1, in_training = GREATEST(in_training - $1, 0), updated_at = $2
+		WHERE village_id = $3 AND type = $4
+	`, count, now, batch.VillageID, batch.UnitType)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	batch.UpdatedAt = now
+	return nil
+}
+
+// CancelBatch cancela un lote, descuenta las unidades no entrenadas y devuelve el reembolso a la aldea.
+// El reembolso se calcula con el estado leído, así que el lote solo se cancela si sigue en ese estado
+// y con las mismas unidades entregadas; si no, devuelve sql.ErrNoRows sin reembolsar nada.
+func (r *TrainingRepository) CancelBatch(batch *models.TrainingBatch, refund models.ResourceCostsLegacy) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		UPDATE training_batches
+		SET status = $1, next_completion_time = NULL, end_time = $2, updated_at = $2
+		WHERE id = $3 AND status = $4 AND completed = $5
+	`, models.TrainingStatusCancelled, now, batch.ID, batch.Status, batch.Completed)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE units
+		SET in_training = GREATEST(in_training - $1, 0), updated_at = $2
+		WHERE village_id = $3 AND type = $4
+	`, batch.Remaining(), now, batch.VillageID, batch.UnitType)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE resources
+		SET wood = wood + $1, stone = stone + $2, food = food + $3, gold = gold + $4
+		WHERE village_id = $5
+	`, refund.Wood, refund.Stone, refund.Food, refund.Gold, batch.VillageID)
+	if err != nil {
+		return err
+	}
+
+	batch.Status = models.TrainingStatusCancelled
+	batch.NextCompletionTime = nil
+	batch.EndTime = &now
+	batch.UpdatedAt = now
+	return tx.Commit()
+}
+
+// lockTrainingQueue serializa las operaciones sobre la cola de un edificio de una aldea hasta el
+// final de la transacción
+func lockTrainingQueue(tx *sql.Tx, villageID uuid.UUID, building string) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, "training:"+villageID.String()+":"+building)
+	return err
+}
+
+// addUnitsInTraining suma unidades en entrenamiento creando la fila de unidad si no existe
+func addUnitsInTraining(tx *sql.Tx, villageID uuid.UUID, unitType string, quantity int) error {
+	now := time.Now()
+	result, err := tx.Exec(`
+		UPDATE units SET in_training = in_training + $1, updated_at = $2
+		WHERE village_id = $3 AND type = $4
+	`, quantity, now, villageID, unitType)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+	_, err = tx.Exec(`
+		INSERT INTO units (id, village_id, type, quantity, in_training, training_completion_time, created_at, updated_at)
+		VALUES ($1, $2, $3, 0, $4, NULL, $5, $5)
+	`, uuid.New(), villageID, unitType, quantity, now)
+	return err
+}
+
+func (r *TrainingRepository) queryBatches(query string, args ...interface{}) ([]*models.TrainingBatch, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []*models.TrainingBatch
+	for rows.Next() {
+		batch, err := scanTrainingBatch(rows)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+func scanTrainingBatch(scanner rowScanner) (*models.TrainingBatch, error) {
+	var batch models.TrainingBatch
+	err := scanner.Scan(
+		&batch.ID,
+		&batch.VillageID,
+		&batch.Building,
+		&batch.UnitType,
+		&batch.Quantity,
+		&batch.Completed,
+		&batch.UnitTime,
+		&batch.UnitCost.Wood,
+		&batch.UnitCost.Stone,
+		&batch.UnitCost.Food,
+		&batch.UnitCost.Gold,
+		&batch.Position,
+		&batch.Status,
+		&batch.StartTime,
+		&batch.NextCompletionTime,
+		&batch.EndTime,
+		&batch.CreatedAt,
+		&batch.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
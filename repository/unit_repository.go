@@ -114,6 +114,15 @@ func (r *UnitRepository) UpdateUnit(unit *models.Unit) error {
 	return err
 }
 
+// RemoveUnits resta bajas de la guarnición de una aldea sobre la cantidad actual, sin bajar de cero
+func (r *UnitRepository) RemoveUnits(villageID uuid.UUID, unitType string, quantity int) error {
+	_, err := r.db.Exec(`
+		UPDATE units SET quantity = GREATEST(quantity - $1, 0), updated_at = $2
+		WHERE village_id = $3 AND type = $4
+	`, quantity, time.Now(), villageID, unitType)
+	return err
+}
+
 func (r *UnitRepository) StartTraining(villageID uuid.UUID, unitType string, quantity int) error {
 	// Obtener o crear la unidad
 	unit, err := r.GetUnitByVillageAndType(villageID, unitType)
@@ -228,3 +237,137 @@ func (r *UnitRepository) GetTotalUnitsByType(villageID uuid.UUID) (map[string]in
 	}
 	return totals, nil
 }
+
+// ===== TROPAS DE APOYO ESTACIONADAS =====
+
+const stationedTroopsQuery = `
+	SELECT st.owner_player_id, st.origin_village_id, st.host_village_id, v.player_id, st.unit_type, st.quantity, st.arrived_at
+	FROM stationed_troops st
+	JOIN villages v ON v.id = st.host_village_id
+`
+
+// GetStationedTroopsByHost obtiene las tropas de apoyo estacionadas en una aldea
+func (r *UnitRepository) GetStationedTroopsByHost(hostVillageID uuid.UUID) ([]*models.SupportTroops, error) {
+	return r.queryStationedTroops(stationedTroopsQuery+`
+		WHERE st.host_village_id = $1 AND st.quantity > 0
+		ORDER BY st.arrived_at
+	`, hostVillageID)
+}
+
+// GetStationedTroopsByOwner obtiene las tropas de apoyo que un jugador tiene en aldeas ajenas
+func (r *UnitRepository) GetStationedTroopsByOwner(ownerPlayerID uuid.UUID) ([]*models.SupportTroops, error) {
+	return r.queryStationedTroops(stationedTroopsQuery+`
+		WHERE st.owner_player_id = $1 AND st.quantity > 0
+		ORDER BY st.arrived_at
+	`, ownerPlayerID)
+}
+
+// GetStationedTroopsByOrigin obtiene los contingentes que una aldea mantiene en aldeas ajenas
+func (r *UnitRepository) GetStationedTroopsByOrigin(originVillageID uuid.UUID) ([]*models.SupportTroops, error) {
+	return r.queryStationedTroops(stationedTroopsQuery+`
+		WHERE st.origin_village_id = $1 AND st.quantity > 0
+		ORDER BY st.arrived_at
+	`, originVillageID)
+}
+
+// GetStationedTroopsByHostPlayer obtiene las tropas de apoyo estacionadas en cualquier aldea de un jugador
+func (r *UnitRepository) GetStationedTroopsByHostPlayer(hostPlayerID uuid.UUID) ([]*models.SupportTroops, error) {
+	return r.queryStationedTroops(stationedTroopsQuery+`
+		WHERE v.player_id = $1 AND st.quantity > 0
+		ORDER BY st.arrived_at
+	`, hostPlayerID)
+}
+
+// GetStationedTroops obtiene el contingente que una aldea mantiene en otra
+func (r *UnitRepository) GetStationedTroops(originVillageID, hostVillageID uuid.UUID) (*models.SupportTroops, error) {
+	troops, err := r.queryStationedTroops(stationedTroopsQuery+`
+		WHERE st.origin_village_id = $1 AND st.host_village_id = $2 AND st.quantity > 0
+	`, originVillageID, hostVillageID)
+	if err != nil {
+		return nil, err
+	}
+	if len(troops) == 0 {
+		return nil, nil
+	}
+	return troops[0], nil
+}
+
+// RemoveStationedUnits resta bajas de un contingente estacionado sobre la cantidad actual; las filas
+// que se quedan sin unidades se eliminan
+func (r *UnitRepository) RemoveStationedUnits(originVillageID, hostVillageID uuid.UUID, unitType string, quantity int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := removeStationedUnits(tx, originVillageID, hostVillageID, unitType, quantity); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeStationedUnits resta bajas de un contingente dentro de una transacción
+func removeStationedUnits(tx *sql.Tx, originVillageID, hostVillageID uuid.UUID, unitType string, quantity int) error {
+	if quantity <= 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+		DELETE FROM stationed_troops
+		WHERE origin_village_id = $1 AND host_village_id = $2 AND unit_type = $3 AND quantity <= $4
+	`, originVillageID, hostVillageID, unitType, quantity)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE stationed_troops SET quantity = quantity - $1, updated_at = $2
+		WHERE origin_village_id = $3 AND host_village_id = $4 AND unit_type = $5
+	`, quantity, time.Now(), originVillageID, hostVillageID, unitType)
+	return err
+}
+
+// queryStationedTroops agrupa las filas por contingente (aldea de origen y aldea anfitriona)
+func (r *UnitRepository) queryStationedTroops(query string, args ...interface{}) ([]*models.SupportTroops, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var troops []*models.SupportTroops
+	index := make(map[[2]uuid.UUID]*models.SupportTroops)
+	for rows.Next() {
+		var row models.SupportTroops
+		var unitType string
+		var quantity int
+		err := rows.Scan(
+			&row.OwnerPlayerID,
+			&row.OriginVillageID,
+			&row.HostVillageID,
+			&row.HostPlayerID,
+			&unitType,
+			&quantity,
+			&row.ArrivedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		key := [2]uuid.UUID{row.OriginVillageID, row.HostVillageID}
+		contingent, exists := index[key]
+		if !exists {
+			contingent = &row
+			contingent.Units = make(map[string]int)
+			index[key] = contingent
+			troops = append(troops, contingent)
+		}
+		if row.ArrivedAt.Before(contingent.ArrivedAt) {
+			contingent.ArrivedAt = row.ArrivedAt
+		}
+		contingent.Units[unitType] += quantity
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return troops, nil
+}
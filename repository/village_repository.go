@@ -3,6 +3,7 @@ package repository
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"server-backend/models"
@@ -18,6 +19,9 @@ type VillageRepository struct {
 	logger *zap.Logger
 }
 
+// ErrVillageCoordinatesTaken indica que otra aldea ha ocupado la casilla antes de insertar la nueva
+var ErrVillageCoordinatesTaken = errors.New("la casilla ya está ocupada por una aldea")
+
 func NewVillageRepository(db *sql.DB, logger *zap.Logger) *VillageRepository {
 	return &VillageRepository{
 		db:     db,
@@ -33,14 +37,70 @@ func (r *VillageRepository) CreateVillage(playerID, worldID uuid.UUID, name stri
 	}
 	defer tx.Rollback()
 
+	villageID, err := r.insertVillage(tx, playerID, worldID, name, x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	// Confirmar transacción
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// Obtener la aldea con sus detalles
+	return r.GetVillageByID(villageID)
+}
+
+// CreateSettledVillage funda la aldea de una marcha de colonización reclamada y la da por completada
+// en la misma transacción, consumiendo a los colonos. Devuelve sql.ErrNoRows sin fundar nada si la
+// marcha ya no estaba en 'resolving', así que repetir la llegada no crea una segunda aldea.
+func (r *VillageRepository) CreateSettledVillage(march *models.March, worldID uuid.UUID, name string, x, y int) (*models.VillageWithDetails, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		UPDATE marches SET status = $1, units = '{}', updated_at = $2 WHERE id = $3 AND status = $4
+	`, models.MarchStatusCompleted, now, march.ID, models.MarchStatusResolving)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireAffected(result); err != nil {
+		return nil, err
+	}
+
+	villageID, err := r.insertVillage(tx, march.PlayerID, worldID, name, x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	march.Status = models.MarchStatusCompleted
+	march.Units = map[string]int{}
+	march.UpdatedAt = now
+
+	return r.GetVillageByID(villageID)
+}
+
+// insertVillage crea una aldea con sus recursos iniciales y sus edificios básicos dentro de una
+// transacción
+func (r *VillageRepository) insertVillage(tx *sql.Tx, playerID, worldID uuid.UUID, name string, x, y int) (uuid.UUID, error) {
 	// Crear aldea
 	villageID := uuid.New()
-	_, err = tx.Exec(`
+	_, err := tx.Exec(`
 		INSERT INTO villages (id, player_id, world_id, name, x_coordinate, y_coordinate, created_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`, villageID, playerID, worldID, name, x, y, time.Now())
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return uuid.Nil, ErrVillageCoordinatesTaken
+	}
 	if err != nil {
-		return nil, err
+		return uuid.Nil, err
 	}
 
 	// Calcular recursos iniciales basados en la capacidad del almacén nivel 1
@@ -53,7 +113,7 @@ func (r *VillageRepository) CreateVillage(playerID, worldID uuid.UUID, name stri
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`, resourcesID, villageID, initialWood, initialStone, initialFood, initialGold, time.Now())
 	if err != nil {
-		return nil, err
+		return uuid.Nil, err
 	}
 
 	// Inicializar edificios básicos
@@ -65,17 +125,23 @@ func (r *VillageRepository) CreateVillage(playerID, worldID uuid.UUID, name stri
 			VALUES ($1, $2, $3, 1, false, NULL)
 		`, buildingID, villageID, buildingType)
 		if err != nil {
-			return nil, err
+			return uuid.Nil, err
 		}
 	}
 
-	// Confirmar transacción
-	if err := tx.Commit(); err != nil {
-		return nil, err
+	// Edificios defensivos: existen desde el inicio pero sin construir
+	defensiveBuildings := []string{"wall", "tower"}
+	for _, buildingType := range defensiveBuildings {
+		_, err = tx.Exec(`
+			INSERT INTO buildings (id, village_id, type, level, is_upgrading, upgrade_completion_time)
+			VALUES ($1, $2, $3, 0, false, NULL)
+		`, uuid.New(), villageID, buildingType)
+		if err != nil {
+			return uuid.Nil, err
+		}
 	}
 
-	// Obtener la aldea con sus detalles
-	return r.GetVillageByID(villageID)
+	return villageID, nil
 }
 
 func (r *VillageRepository) GetVillageByID(id uuid.UUID) (*models.VillageWithDetails, error) {
@@ -115,7 +181,7 @@ func (r *VillageRepository) GetVillageByID(id uuid.UUID) (*models.VillageWithDet
 
 	// Obtener edificios
 	rows, err := r.db.Query(`
-		SELECT id, village_id, type, level, is_upgrading, upgrade_completion_time
+		SELECT id, village_id, type, level, is_upgrading, upgrade_completion_time, damage
 		FROM buildings
 		WHERE village_id = $1
 	`, id)
@@ -134,6 +200,7 @@ func (r *VillageRepository) GetVillageByID(id uuid.UUID) (*models.VillageWithDet
 			&building.Level,
 			&building.IsUpgrading,
 			&building.UpgradeCompletionTime,
+			&building.Damage,
 		)
 		if err != nil {
 			return nil, err
@@ -187,7 +254,7 @@ func (r *VillageRepository) GetVillagesByPlayerID(playerID uuid.UUID) ([]*models
 
 		// Obtener edificios
 		buildingRows, err := r.db.Query(`
-			SELECT id, village_id, type, level, is_upgrading, upgrade_completion_time
+			SELECT id, village_id, type, level, is_upgrading, upgrade_completion_time, damage
 			FROM buildings
 			WHERE village_id = $1
 		`, village.Village.ID)
@@ -206,6 +273,7 @@ func (r *VillageRepository) GetVillagesByPlayerID(playerID uuid.UUID) ([]*models
 				&building.Level,
 				&building.IsUpgrading,
 				&building.UpgradeCompletionTime,
+				&building.Damage,
 			)
 			if err != nil {
 				return nil, err
@@ -238,6 +306,63 @@ func (r *VillageRepository) UpdateResources(villageID uuid.UUID, wood, stone, fo
 	return err
 }
 
+// CheckpointResources materializa los recursos de una aldea de forma atómica. Bloquea la fila de
+// recursos, entrega a compute las existencias guardadas y su last_updated, y guarda el resultado con
+// la marca de tiempo que devuelve compute. Si compute falla no se modifica nada.
+func (r *VillageRepository) CheckpointResources(villageID uuid.UUID, compute func(stock models.Resources) (models.Resources, time.Time, error)) (models.Resources, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.Resources{}, err
+	}
+	defer tx.Rollback()
+
+	var stock models.Resources
+	err = tx.QueryRow(`
+		SELECT id, village_id, wood, stone, food, gold, last_updated
+		FROM resources
+		WHERE village_id = $1
+		FOR UPDATE
+	`, villageID).Scan(&stock.ID, &stock.VillageID, &stock.Wood, &stock.Stone, &stock.Food, &stock.Gold, &stock.LastUpdated)
+	if err == sql.ErrNoRows {
+		return models.Resources{}, fmt.Errorf("recursos de la aldea no encontrados")
+	}
+	if err != nil {
+		return models.Resources{}, err
+	}
+
+	updated, at, err := compute(stock)
+	if err != nil {
+		return models.Resources{}, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE resources
+		SET wood = $1, stone = $2, food = $3, gold = $4, last_updated = $5
+		WHERE village_id = $6
+	`, updated.Wood, updated.Stone, updated.Food, updated.Gold, at, villageID)
+	if err != nil {
+		return models.Resources{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Resources{}, err
+	}
+
+	updated.ID = stock.ID
+	updated.VillageID = stock.VillageID
+	updated.LastUpdated = at
+	return updated, nil
+}
+
+// AddResources suma (o resta, con valores negativos) recursos de forma atómica
+func (r *VillageRepository) AddResources(villageID uuid.UUID, wood, stone, food, gold int) error {
+	_, err := r.db.Exec(`
+		UPDATE resources
+		SET wood = wood + $1, stone = stone + $2, food = food + $3, gold = gold + $4
+		WHERE village_id = $5
+	`, wood, stone, food, gold, villageID)
+	return err
+}
+
 func (r *VillageRepository) UpdateBuilding(villageID uuid.UUID, buildingType string, level int, isUpgrading bool, upgradeCompletionTime *time.Time) error {
 	_, err := r.db.Exec(`
 		UPDATE buildings
@@ -247,6 +372,40 @@ func (r *VillageRepository) UpdateBuilding(villageID uuid.UUID, buildingType str
 	return err
 }
 
+// UpdateBuildingDamage actualiza los puntos de estructura perdidos de un edificio
+func (r *VillageRepository) UpdateBuildingDamage(villageID uuid.UUID, buildingType string, damage int) error {
+	_, err := r.db.Exec(`
+		UPDATE buildings
+		SET damage = $1
+		WHERE village_id = $2 AND type = $3
+	`, damage, villageID, buildingType)
+	return err
+}
+
+// ClearBuildingDamage repara un edificio que sigue con los daños leídos. Devuelve sql.ErrNoRows si
+// otra reparación o un asedio los han cambiado entretanto.
+func (r *VillageRepository) ClearBuildingDamage(villageID uuid.UUID, buildingType string, damage int) error {
+	result, err := r.db.Exec(`
+		UPDATE buildings
+		SET damage = 0
+		WHERE village_id = $1 AND type = $2 AND damage = $3
+	`, villageID, buildingType, damage)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// RestoreBuildingDamage devuelve a un edificio los daños de una reparación que no se pudo pagar
+func (r *VillageRepository) RestoreBuildingDamage(villageID uuid.UUID, buildingType string, damage int) error {
+	_, err := r.db.Exec(`
+		UPDATE buildings
+		SET damage = damage + $1
+		WHERE village_id = $2 AND type = $3
+	`, damage, villageID, buildingType)
+	return err
+}
+
 // CheckBuildingRequirementsAdvanced verifica los requisitos para construir usando la función avanzada de la BD
 func (r *VillageRepository) CheckBuildingRequirementsAdvanced(villageID uuid.UUID, buildingType string, targetLevel int) (*sql.Rows, error) {
 	return r.db.Query(`
@@ -373,7 +532,7 @@ func (r *VillageRepository) GetAllVillages() ([]*models.VillageWithDetails, erro
 
 		// Obtener edificios
 		buildingRows, err := r.db.Query(`
-			SELECT id, village_id, type, level, is_upgrading, upgrade_completion_time
+			SELECT id, village_id, type, level, is_upgrading, upgrade_completion_time, damage
 			FROM buildings
 			WHERE village_id = $1
 		`, village.Village.ID)
@@ -392,6 +551,7 @@ func (r *VillageRepository) GetAllVillages() ([]*models.VillageWithDetails, erro
 				&building.Level,
 				&building.IsUpgrading,
 				&building.UpgradeCompletionTime,
+				&building.Damage,
 			)
 			if err != nil {
 				return nil, err
@@ -448,7 +608,7 @@ func (r *VillageRepository) GetVillageByCoordinates(worldID uuid.UUID, x, y int)
 
 	// Obtener edificios
 	rows, err := r.db.Query(`
-		SELECT id, village_id, type, level, is_upgrading, upgrade_completion_time
+		SELECT id, village_id, type, level, is_upgrading, upgrade_completion_time, damage
 		FROM buildings
 		WHERE village_id = $1
 	`, village.Village.ID)
@@ -467,6 +627,7 @@ func (r *VillageRepository) GetVillageByCoordinates(worldID uuid.UUID, x, y int)
 			&building.Level,
 			&building.IsUpgrading,
 			&building.UpgradeCompletionTime,
+			&building.Damage,
 		)
 		if err != nil {
 			return nil, err
@@ -522,7 +683,7 @@ func (r *VillageRepository) GetVillagesInRange(worldID uuid.UUID, centerX, cente
 
 		// Obtener edificios
 		buildingRows, err := r.db.Query(`
-			SELECT id, village_id, type, level, is_upgrading, upgrade_completion_time
+			SELECT id, village_id, type, level, is_upgrading, upgrade_completion_time, damage
 			FROM buildings
 			WHERE village_id = $1
 		`, village.Village.ID)
@@ -541,6 +702,7 @@ func (r *VillageRepository) GetVillagesInRange(worldID uuid.UUID, centerX, cente
 				&building.Level,
 				&building.IsUpgrading,
 				&building.UpgradeCompletionTime,
+				&building.Damage,
 			)
 			if err != nil {
 				return nil, err
@@ -751,3 +913,397 @@ func (r *VillageRepository) CleanupCompletedUpgrades(villageID uuid.UUID) error
 	_, err := r.db.Exec(query, villageID)
 	return err
 }
+
+// PlunderResources retira de una aldea el botín de un ataque. Cada recurso conserva la
+// cantidad protegida y el total saqueado no supera la capacidad de carga indicada.
+// El cálculo se hace bloqueando la fila de recursos para evitar carreras con la producción.
+func (r *VillageRepository) PlunderResources(villageID uuid.UUID, protection models.Resources, capacity int) (map[string]int, error) {
+	loot := map[string]int{"wood": 0, "stone": 0, "food": 0, "gold": 0}
+	if capacity <= 0 {
+		return loot, nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var stock models.Resources
+	err = tx.QueryRow(`
+		SELECT wood, stone, food, gold FROM resources WHERE village_id = $1 FOR UPDATE
+	`, villageID).Scan(&stock.Wood, &stock.Stone, &stock.Food, &stock.Gold)
+	if err == sql.ErrNoRows {
+		return loot, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	available := map[string]int{
+		"wood":  stock.Wood - protection.Wood,
+		"stone": stock.Stone - protection.Stone,
+		"food":  stock.Food - protection.Food,
+		"gold":  stock.Gold - protection.Gold,
+	}
+	order := []string{"wood", "stone", "food", "gold"}
+
+	// Repartir la capacidad a partes iguales; lo que sobra de un recurso agotado pasa al resto
+	remaining := capacity
+	for remaining > 0 {
+		open := 0
+		for _, resource := range order {
+			if available[resource]-loot[resource] > 0 {
+				open++
+			}
+		}
+		if open == 0 {
+			break
+		}
+		share := remaining / open
+		if share == 0 {
+			share = 1
+		}
+		for _, resource := range order {
+			left := available[resource] - loot[resource]
+			if left <= 0 || remaining == 0 {
+				continue
+			}
+			take := share
+			if take > left {
+				take = left
+			}
+			if take > remaining {
+				take = remaining
+			}
+			loot[resource] += take
+			remaining -= take
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE resources
+		SET wood = wood - $1, stone = stone - $2, food = food - $3, gold = gold - $4
+		WHERE village_id = $5
+	`, loot["wood"], loot["stone"], loot["food"], loot["gold"], villageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return loot, nil
+}
+
+// SearchVillages busca aldeas dentro de un rectángulo aplicando los filtros de la búsqueda.
+// El rectángulo aprovecha el índice (world_id, x_coordinate, y_coordinate); si la búsqueda tiene
+// radio se descartan además las aldeas fuera del círculo. La puntuación se suma solo para las aldeas
+// del rectángulo. El resultado se ordena por distancia.
+func (r *VillageRepository) SearchVillages(search *models.VillageSearch, minX, minY, maxX, maxY int) ([]*models.NearbyVillage, error) {
+	query := `
+		SELECT v.id, v.name, v.x_coordinate, v.y_coordinate, p.id, p.username, a.id, a.tag, v.created_at,
+			   p.last_active, s.score,
+			   SQRT(POWER(v.x_coordinate - $2, 2) + POWER(v.y_coordinate - $3, 2)) AS distance
+		FROM villages v
+		JOIN players p ON p.id = v.player_id
+		LEFT JOIN alliances a ON a.id = p.alliance_id
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(SUM(b.level), 0) AS score FROM buildings b WHERE b.village_id = v.id
+		) s
+		WHERE v.world_id = $1
+		AND v.x_coordinate BETWEEN $4 AND $5
+		AND v.y_coordinate BETWEEN $6 AND $7`
+	args := []interface{}{search.WorldID, search.CenterX, search.CenterY, minX, maxX, minY, maxY}
+	argCount := len(args) + 1
+
+	if search.Radius > 0 {
+		query += fmt.Sprintf(" AND POWER(v.x_coordinate - $2, 2) + POWER(v.y_coordinate - $3, 2) <= $%d", argCount)
+		args = append(args, search.Radius*search.Radius)
+		argCount++
+	}
+	if search.PlayerID != nil {
+		query += fmt.Sprintf(" AND v.player_id = $%d", argCount)
+		args = append(args, *search.PlayerID)
+		argCount++
+	}
+	if search.ExcludePlayerID != nil {
+		query += fmt.Sprintf(" AND v.player_id <> $%d", argCount)
+		args = append(args, *search.ExcludePlayerID)
+		argCount++
+	}
+	if search.AllianceID != nil {
+		query += fmt.Sprintf(" AND p.alliance_id = $%d", argCount)
+		args = append(args, *search.AllianceID)
+		argCount++
+	}
+	if search.ExcludeAllianceID != nil {
+		query += fmt.Sprintf(" AND (p.alliance_id IS NULL OR p.alliance_id <> $%d)", argCount)
+		args = append(args, *search.ExcludeAllianceID)
+		argCount++
+	}
+	if search.InactiveDays > 0 {
+		query += fmt.Sprintf(" AND (p.last_active IS NULL OR p.last_active < NOW() - make_interval(days => $%d))", argCount)
+		args = append(args, search.InactiveDays)
+		argCount++
+	}
+	if search.MinScore > 0 {
+		query += fmt.Sprintf(" AND s.score >= $%d", argCount)
+		args = append(args, search.MinScore)
+		argCount++
+	}
+	if search.MaxScore > 0 {
+		query += fmt.Sprintf(" AND s.score <= $%d", argCount)
+		args = append(args, search.MaxScore)
+		argCount++
+	}
+
+	query += " ORDER BY distance, v.id"
+	if search.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argCount)
+		args = append(args, search.Limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var villages []*models.NearbyVillage
+	for rows.Next() {
+		var village models.NearbyVillage
+		err := rows.Scan(
+			&village.ID,
+			&village.Name,
+			&village.X,
+			&village.Y,
+			&village.PlayerID,
+			&village.PlayerName,
+			&village.AllianceID,
+			&village.AllianceTag,
+			&village.CreatedAt,
+			&village.LastActive,
+			&village.Score,
+			&village.Distance,
+		)
+		if err != nil {
+			return nil, err
+		}
+		villages = append(villages, &village)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return villages, nil
+}
+
+// CountVillagesInWorld cuenta las aldeas de un mundo
+func (r *VillageRepository) CountVillagesInWorld(worldID uuid.UUID) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM villages WHERE world_id = $1`, worldID).Scan(&count)
+	return count, err
+}
+
+// GetTopPlayerVillages obtiene las aldeas de los jugadores más fuertes de un mundo, medidos por la
+// suma de los niveles de los edificios de todas sus aldeas
+func (r *VillageRepository) GetTopPlayerVillages(worldID uuid.UUID, topPlayers int) ([]*models.MapVillage, error) {
+	rows, err := r.db.Query(`
+		WITH top_players AS (
+			SELECT v.player_id
+			FROM villages v
+			JOIN buildings b ON b.village_id = v.id
+			WHERE v.world_id = $1
+			GROUP BY v.player_id
+			ORDER BY SUM(b.level) DESC
+			LIMIT $2
+		)
+		SELECT v.id, v.name, v.x_coordinate, v.y_coordinate, p.id, p.username
+		FROM villages v
+		JOIN top_players t ON t.player_id = v.player_id
+		JOIN players p ON p.id = v.player_id
+		WHERE v.world_id = $1
+	`, worldID, topPlayers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var villages []*models.MapVillage
+	for rows.Next() {
+		var village models.MapVillage
+		err := rows.Scan(
+			&village.ID,
+			&village.Name,
+			&village.X,
+			&village.Y,
+			&village.PlayerID,
+			&village.PlayerName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		villages = append(villages, &village)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return villages, nil
+}
+
+// currentLoyaltySQL calcula la lealtad actual de una aldea sumando la regeneración desde la
+// última actualización ($1 es la regeneración por hora)
+const currentLoyaltySQL = `LEAST(v.loyalty + FLOOR(GREATEST(EXTRACT(EPOCH FROM (NOW() - v.loyalty_updated_at)), 0) / 3600 * $1)::int, $2)`
+
+// GetPlayerLoyalty obtiene la lealtad actual de las aldeas de un jugador
+func (r *VillageRepository) GetPlayerLoyalty(playerID uuid.UUID) ([]*models.VillageLoyalty, error) {
+	rows, err := r.db.Query(`
+		SELECT v.id, v.name, `+currentLoyaltySQL+`
+		FROM villages v
+		WHERE v.player_id = $3
+		ORDER BY v.created_at
+	`, models.LoyaltyRegenPerHour, models.LoyaltyMax, playerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var loyalty []*models.VillageLoyalty
+	for rows.Next() {
+		var village models.VillageLoyalty
+		if err := rows.Scan(&village.VillageID, &village.Name, &village.Loyalty); err != nil {
+			return nil, err
+		}
+		loyalty = append(loyalty, &village)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return loyalty, nil
+}
+
+// ReduceLoyalty aplica la regeneración pendiente, resta la lealtad indicada y devuelve la lealtad
+// antes y después del golpe
+func (r *VillageRepository) ReduceLoyalty(villageID uuid.UUID, amount int) (int, int, error) {
+	var before, after int
+	err := r.db.QueryRow(`
+		WITH current AS (
+			SELECT v.id, `+currentLoyaltySQL+` AS loyalty
+			FROM villages v
+			WHERE v.id = $3
+			FOR UPDATE
+		)
+		UPDATE villages
+		SET loyalty = GREATEST(current.loyalty - $4, 0), loyalty_updated_at = NOW()
+		FROM current
+		WHERE villages.id = current.id
+		RETURNING current.loyalty, villages.loyalty
+	`, models.LoyaltyRegenPerHour, models.LoyaltyMax, villageID, amount).Scan(&before, &after)
+	if err == sql.ErrNoRows {
+		return 0, 0, fmt.Errorf("aldea no encontrada")
+	}
+	return before, after, err
+}
+
+// TransferVillage entrega una aldea conquistada a su nuevo dueño en una sola transacción.
+// Edificios y recursos cuelgan de la aldea y cambian de manos con ella; las obras en curso vuelven
+// al nivel anterior, la cola de entrenamiento se cancela y la guarnición del antiguo dueño se
+// pierde. Los apoyos estacionados (los de terceros en la aldea y los enviados desde ella) y las
+// marchas en curso no se tocan: siguen su camino normal. El comercio abierto de la aldea y sus
+// órdenes de la bolsa se cancelan y su depósito vuelve al antiguo dueño en la aldea más antigua que
+// le queda.
+func (r *VillageRepository) TransferVillage(villageID, fromPlayerID, toPlayerID uuid.UUID, loyalty int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var owner uuid.UUID
+	err = tx.QueryRow(`SELECT player_id FROM villages WHERE id = $1 FOR UPDATE`, villageID).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("aldea no encontrada")
+	}
+	if err != nil {
+		return err
+	}
+	if owner != fromPlayerID {
+		return fmt.Errorf("la aldea ya ha cambiado de dueño")
+	}
+
+	var refundVillageID uuid.UUID
+	err = tx.QueryRow(`
+		SELECT id FROM villages WHERE player_id = $1 AND id <> $2 ORDER BY created_at LIMIT 1
+	`, fromPlayerID, villageID).Scan(&refundVillageID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("el jugador no tiene otra aldea")
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if _, err := tx.Exec(`
+		UPDATE villages SET player_id = $1, loyalty = $2, loyalty_updated_at = $3 WHERE id = $4
+	`, toPlayerID, loyalty, now, villageID); err != nil {
+		return err
+	}
+
+	// Los depósitos se devuelven al final, bloqueando todas las aldeas afectadas de una vez
+	refunds := make(map[uuid.UUID]models.Resources)
+	if err := cancelVillageOrders(tx, villageID, refundVillageID, now, refunds); err != nil {
+		return err
+	}
+	if err := cancelVillageTrades(tx, villageID, fromPlayerID, refundVillageID, now, refunds); err != nil {
+		return err
+	}
+	if _, _, err := lockStockDeltas(tx, refunds); err != nil {
+		return err
+	}
+	if err := applyStockDeltas(tx, refunds); err != nil {
+		return err
+	}
+
+	// Las mejoras en curso se pierden: el edificio vuelve al nivel anterior a la orden que se estaba
+	// construyendo, y los niveles ya terminados se conservan
+	if _, err := tx.Exec(`
+		UPDATE buildings b
+		SET level = o.target_level - 1, is_upgrading = false, upgrade_completion_time = NULL
+		FROM construction_orders o
+		WHERE b.village_id = $1 AND b.is_upgrading = true
+		  AND o.village_id = b.village_id AND o.building_type = b.type AND o.status = 'building'
+	`, villageID); err != nil {
+		return err
+	}
+	// Las mejoras iniciadas fuera de la cola ya tenían el nivel siguiente
+	if _, err := tx.Exec(`
+		UPDATE buildings SET level = GREATEST(level - 1, 0), is_upgrading = false, upgrade_completion_time = NULL
+		WHERE village_id = $1 AND is_upgrading = true
+	`, villageID); err != nil {
+		return err
+	}
+
+	// La cola de construcción también se pierde: el nuevo dueño no hereda las obras pagadas por el
+	// anterior ni las que este dejó esperando
+	if _, err := tx.Exec(`
+		UPDATE construction_orders SET status = 'cancelled', end_time = $1, updated_at = $1
+		WHERE village_id = $2 AND status IN ('queued', 'building')
+	`, now, villageID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE training_batches SET status = 'cancelled', updated_at = $1
+		WHERE village_id = $2 AND status IN ('queued', 'training')
+	`, now, villageID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM units WHERE village_id = $1`, villageID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"database/sql"
+	"server-backend/models"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type WorldSettingsRepository struct {
+	db     *sql.DB
+	logger *zap.Logger
+}
+
+func NewWorldSettingsRepository(db *sql.DB, logger *zap.Logger) *WorldSettingsRepository {
+	return &WorldSettingsRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// GetSettings obtiene las reglas de un mundo (nil si nunca se han configurado)
+func (r *WorldSettingsRepository) GetSettings(worldID uuid.UUID) (*models.WorldSettings, error) {
+	var settings models.WorldSettings
+	err := r.db.QueryRow(`
+		SELECT world_id, game_speed, unit_speed, production_multiplier, storage_multiplier,
+		       max_villages, morale_enabled, protection_hours, demolition_refund, end_condition, ends_at,
+		       domination_percent, updated_at
+		FROM world_settings
+		WHERE world_id = $1
+	`, worldID).Scan(
+		&settings.WorldID,
+		&settings.GameSpeed,
+		&settings.UnitSpeed,
+		&settings.ProductionMultiplier,
+		&settings.StorageMultiplier,
+		&settings.MaxVillages,
+		&settings.MoraleEnabled,
+		&settings.ProtectionHours,
+		&settings.DemolitionRefund,
+		&settings.EndCondition,
+		&settings.EndsAt,
+		&settings.DominationPercent,
+		&settings.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SaveSettings crea o reemplaza las reglas de un mundo
+func (r *WorldSettingsRepository) SaveSettings(settings *models.WorldSettings) error {
+	return r.db.QueryRow(`
+		INSERT INTO world_settings (world_id, game_speed, unit_speed, production_multiplier, storage_multiplier,
+		                            max_villages, morale_enabled, protection_hours, demolition_refund, end_condition, ends_at,
+		                            domination_percent, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW())
+		ON CONFLICT (world_id) DO UPDATE SET
+			game_speed = EXCLUDED.game_speed,
+			unit_speed = EXCLUDED.unit_speed,
+			production_multiplier = EXCLUDED.production_multiplier,
+			storage_multiplier = EXCLUDED.storage_multiplier,
+			max_villages = EXCLUDED.max_villages,
+			morale_enabled = EXCLUDED.morale_enabled,
+			protection_hours = EXCLUDED.protection_hours,
+			demolition_refund = EXCLUDED.demolition_refund,
+			end_condition = EXCLUDED.end_condition,
+			ends_at = EXCLUDED.ends_at,
+			domination_percent = EXCLUDED.domination_percent,
+			updated_at = NOW()
+		RETURNING updated_at
+	`,
+		settings.WorldID,
+		settings.GameSpeed,
+		settings.UnitSpeed,
+		settings.ProductionMultiplier,
+		settings.StorageMultiplier,
+		settings.MaxVillages,
+		settings.MoraleEnabled,
+		settings.ProtectionHours,
+		settings.DemolitionRefund,
+		settings.EndCondition,
+		settings.EndsAt,
+		settings.DominationPercent,
+	).Scan(&settings.UpdatedAt)
+}
+
+// GetLeadingAllianceShare devuelve la alianza con más aldeas del mundo y la fracción de las
+// aldeas del mundo que controla (nil si ninguna alianza tiene aldeas)
+func (r *WorldSettingsRepository) GetLeadingAllianceShare(worldID uuid.UUID) (*uuid.UUID, float64, error) {
+	var allianceID uuid.UUID
+	var share float64
+	err := r.db.QueryRow(`
+		SELECT p.alliance_id,
+		       COUNT(*)::float / (SELECT COUNT(*) FROM villages WHERE world_id = $1)
+		FROM villages v
+		JOIN players p ON p.id = v.player_id
+		WHERE v.world_id = $1 AND p.alliance_id IS NOT NULL
+		GROUP BY p.alliance_id
+		ORDER BY COUNT(*) DESC
+		LIMIT 1
+	`, worldID).Scan(&allianceID, &share)
+	if err == sql.ErrNoRows {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return &allianceID, share, nil
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"server-backend/handlers"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// SetupBattleRoutes configura las rutas de consulta de batallas
+func SetupBattleRoutes(r *gin.RouterGroup, battleHandler *handlers.BattleHandler, logger *zap.Logger) {
+	// Grupo de rutas de batallas (ya protegido por el grupo padre)
+	battleGroup := r.Group("/api/battles")
+
+	// Repetición oleada a oleada de una batalla desde su semilla
+	battleGroup.GET("/:id/replay", battleHandler.ReplayBattle)
+
+	// Simulación de un combate sin comprometer unidades
+	battleGroup.POST("/simulate", battleHandler.SimulateBattle)
+
+	// Guarnición y defensas de una aldea propia (?village_id=)
+	battleGroup.GET("/defenses", battleHandler.GetVillageDefenses)
+
+	logger.Info("✅ Rutas de batallas configuradas exitosamente")
+}
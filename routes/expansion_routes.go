@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"server-backend/handlers"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// SetupExpansionRoutes configura las rutas de cultura, colonización y lealtad. Los colonos se
+// envían como marchas de tipo "settle" y los nobles viajan en ataques normales.
+func SetupExpansionRoutes(r *gin.RouterGroup, expansionHandler *handlers.ExpansionHandler, logger *zap.Logger) {
+	// Grupo de rutas de expansión (ya protegido por el grupo padre)
+	expansionGroup := r.Group("/api/expansion")
+
+	expansionGroup.GET("/", expansionHandler.GetExpansionStatus)
+
+	logger.Info("✅ Rutas de expansión configuradas exitosamente")
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"server-backend/handlers"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// SetupMapRoutes configura las rutas del mapa del mundo
+func SetupMapRoutes(r *gin.RouterGroup, mapHandler *handlers.MapHandler, logger *zap.Logger) {
+	// Grupo de rutas del mapa (ya protegido por el grupo padre)
+	mapGroup := r.Group("/api/worlds/:world_id/map")
+
+	mapGroup.GET("/", mapHandler.GetWorldMap)
+	mapGroup.GET("/viewport", mapHandler.GetViewport)
+
+	// Búsquedas espaciales de aldeas
+	mapGroup.GET("/villages", mapHandler.SearchVillages)
+	mapGroup.GET("/targets", mapHandler.FindTargets)
+
+	logger.Info("✅ Rutas del mapa configuradas exitosamente")
+}
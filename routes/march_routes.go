@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"server-backend/handlers"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// SetupMarchRoutes configura todas las rutas relacionadas con marchas de tropas
+func SetupMarchRoutes(r *gin.RouterGroup, marchHandler *handlers.MarchHandler, logger *zap.Logger) {
+	// Grupo de rutas de marchas (ya protegido por el grupo padre)
+	marchGroup := r.Group("/api/marches")
+
+	marchGroup.GET("/", marchHandler.GetMarches)
+	marchGroup.GET("/incoming", marchHandler.GetIncomingMarches)
+	marchGroup.POST("/", marchHandler.SendMarch)
+	marchGroup.POST("/:id/cancel", marchHandler.CancelMarch)
+
+	// Informes de exploración
+	intelGroup := r.Group("/api/intel")
+
+	intelGroup.GET("/", marchHandler.GetIntelReports)
+	intelGroup.GET("/:id", marchHandler.GetIntelReport)
+
+	// Tropas de apoyo estacionadas en aldeas aliadas
+	supportGroup := r.Group("/api/support")
+
+	supportGroup.GET("/", marchHandler.GetSupportTroops)
+	supportGroup.POST("/recall", marchHandler.RecallSupport)
+
+	logger.Info("✅ Rutas de marchas configuradas exitosamente")
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"server-backend/handlers"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// SetupProtectionRoutes configura las rutas de escudos y modo vacaciones
+func SetupProtectionRoutes(r *gin.RouterGroup, protectionHandler *handlers.ProtectionHandler, logger *zap.Logger) {
+	// Grupo de rutas de protección (ya protegido por el grupo padre)
+	protectionGroup := r.Group("/api/protection")
+
+	protectionGroup.GET("/", protectionHandler.GetProtection)
+	protectionGroup.POST("/vacation", protectionHandler.ActivateVacation)
+	protectionGroup.DELETE("/vacation", protectionHandler.EndVacation)
+	protectionGroup.POST("/peace-shield", protectionHandler.BuyPeaceShield)
+
+	logger.Info("✅ Rutas de protección configuradas exitosamente")
+}
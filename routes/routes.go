@@ -29,6 +29,13 @@ func SetupAllRoutes(r *gin.Engine, handlers *Handlers, repos *Repositories, serv
 	SetupPlayerRoutes(protected, repos.Player, repos.Village, logger)
 	SetupAllianceRoutes(protected, handlers.Alliance, logger)
 	SetupUnitRoutes(protected, handlers.Unit, logger)
+	SetupMarchRoutes(protected, handlers.March, logger)
+	SetupBattleRoutes(protected, handlers.Battle, logger)
+	SetupMapRoutes(protected, handlers.Map, logger)
+	SetupProtectionRoutes(protected, handlers.Protection, logger)
+	SetupExpansionRoutes(protected, handlers.Expansion, logger)
+	SetupWorldSettingsRoutes(protected, handlers.WorldSettings, authMiddleware, logger)
+	SetupTradeRoutes(protected, handlers.Trade, logger)
 	SetupBuildingRoutes(protected, repos.Village, logger)
 
 	// Configurar rutas protegidas de autenticación
@@ -68,11 +75,18 @@ func setupGlobalMiddleware(r *gin.Engine, logger *zap.Logger) {
 
 // Handlers contiene todos los handlers
 type Handlers struct {
-	Auth     *handlers.AuthHandler
-	Village  *handlers.VillageHandler
-	Chat     *handlers.ChatHandler
-	Alliance *handlers.AllianceHandler
-	Unit     *handlers.UnitHandler
+	Auth          *handlers.AuthHandler
+	Village       *handlers.VillageHandler
+	Chat          *handlers.ChatHandler
+	Alliance      *handlers.AllianceHandler
+	Unit          *handlers.UnitHandler
+	March         *handlers.MarchHandler
+	Battle        *handlers.BattleHandler
+	Map           *handlers.MapHandler
+	Protection    *handlers.ProtectionHandler
+	Expansion     *handlers.ExpansionHandler
+	WorldSettings *handlers.WorldSettingsHandler
+	Trade         *handlers.TradeHandler
 }
 
 // Repositories contiene todos los repositorios
@@ -81,12 +95,23 @@ type Repositories struct {
 	Village  *repository.VillageRepository
 	Alliance *repository.AllianceRepository
 	Unit     *repository.UnitRepository
+	Battle   *repository.BattleRepository
 }
 
 // Services contiene todos los servicios
 type Services struct {
-	Resource *services.ResourceService
-	JWT      *auth.JWTManager
-	Redis    *services.RedisService
-	Chat     *services.ChatService
+	Resource      *services.ResourceService
+	JWT           *auth.JWTManager
+	Redis         *services.RedisService
+	Chat          *services.ChatService
+	March         *services.MarchService
+	Battle        *services.BattleService
+	Training      *services.TrainingService
+	Map           *services.MapService
+	Protection    *services.ProtectionService
+	Expansion     *services.ExpansionService
+	WorldSettings *services.WorldSettingsService
+	Trade         *services.TradeService
+	Exchange      *services.ExchangeService
+	MarketMaker   *services.MarketMakerService
 }
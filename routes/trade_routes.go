@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"server-backend/handlers"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// SetupTradeRoutes configura las rutas del mercado, la bolsa, el mercader NPC, los intercambios directos y los mercaderes
+func SetupTradeRoutes(r *gin.RouterGroup, tradeHandler *handlers.TradeHandler, logger *zap.Logger) {
+	// Grupo de rutas de comercio (ya protegido por el grupo padre)
+	tradeGroup := r.Group("/api/trade")
+
+	// Ofertas del mercado
+	tradeGroup.GET("/offers", tradeHandler.GetTradeOffers)
+	tradeGroup.GET("/offers/mine", tradeHandler.GetPlayerTradeOffers)
+	tradeGroup.GET("/offers/:id", tradeHandler.GetTradeOffer)
+	tradeGroup.POST("/offers", tradeHandler.CreateTradeOffer)
+	tradeGroup.POST("/offers/:id/buy", tradeHandler.BuyTradeOffer)
+	tradeGroup.DELETE("/offers/:id", tradeHandler.CancelTradeOffer)
+	tradeGroup.GET("/history", tradeHandler.GetTradeHistory)
+	tradeGroup.GET("/stats", tradeHandler.GetMarketStats)
+	tradeGroup.GET("/prices", tradeHandler.GetResourcePrices)
+
+	// Bolsa de recursos: órdenes limitadas por mundo
+	tradeGroup.GET("/orders/mine", tradeHandler.GetPlayerMarketOrders)
+	tradeGroup.POST("/orders", tradeHandler.PlaceMarketOrder)
+	tradeGroup.DELETE("/orders/:id", tradeHandler.CancelMarketOrder)
+	tradeGroup.GET("/book", tradeHandler.GetOrderBook)
+	tradeGroup.GET("/candles", tradeHandler.GetPriceCandles)
+
+	// Mercader NPC: cambio instantáneo entre recursos
+	tradeGroup.GET("/pool", tradeHandler.GetMarketPool)
+	tradeGroup.GET("/pool/quote", tradeHandler.QuotePoolSwap)
+	tradeGroup.GET("/pool/swaps", tradeHandler.GetPoolSwaps)
+	tradeGroup.POST("/pool/swap", tradeHandler.SwapWithPool)
+
+	// Intercambios directos entre jugadores
+	tradeGroup.GET("/direct", tradeHandler.GetDirectTrades)
+	tradeGroup.POST("/direct", tradeHandler.CreateDirectTrade)
+	tradeGroup.POST("/direct/:id/accept", tradeHandler.AcceptDirectTrade)
+	tradeGroup.POST("/direct/:id/decline", tradeHandler.DeclineDirectTrade)
+
+	// Mercaderes y envíos de una aldea
+	tradeGroup.GET("/villages/:villageID/shipments", tradeHandler.GetVillageShipments)
+
+	logger.Info("✅ Rutas de comercio configuradas exitosamente")
+}
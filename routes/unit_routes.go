@@ -14,6 +14,8 @@ func SetupUnitRoutes(r *gin.RouterGroup, unitHandler *handlers.UnitHandler, logg
 
 	unitGroup.GET("/", unitHandler.GetUnits)
 	unitGroup.POST("/train", unitHandler.TrainUnits)
+	unitGroup.GET("/queue", unitHandler.GetTrainingQueue)
+	unitGroup.POST("/queue/:id/cancel", unitHandler.CancelTraining)
 
 	logger.Info("✅ Rutas de unidades configuradas exitosamente")
 }
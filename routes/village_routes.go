@@ -26,12 +26,18 @@ func SetupVillageRoutes(r *gin.RouterGroup, villageHandler *handlers.VillageHand
 	villageGroup.POST("/:villageID/buildings/:buildingType/complete", villageHandler.CompleteBuildingUpgrade)
 	villageGroup.DELETE("/:villageID/buildings/:buildingType/upgrade", villageHandler.CancelBuildingUpgrade)
 	villageGroup.GET("/:villageID/buildings/:buildingType/time-remaining", villageHandler.GetBuildingUpgradeTimeRemaining)
+	villageGroup.POST("/:villageID/buildings/:buildingType/repair", villageHandler.RepairBuilding)
+	villageGroup.POST("/:villageID/buildings/:buildingType/demolish", villageHandler.DemolishBuilding)
 
 	// Rutas de cola de construcción
 	villageGroup.GET("/:villageID/buildings/:buildingType/requirements", villageHandler.CheckBuildingRequirements)
 	villageGroup.POST("/:villageID/construction-queue/process", villageHandler.ProcessConstructionQueue)
 	villageGroup.GET("/:villageID/construction-queue", villageHandler.GetConstructionQueue)
 	villageGroup.GET("/:villageID/construction-queue/status", villageHandler.GetConstructionQueueStatus)
+	villageGroup.POST("/:villageID/construction-queue", villageHandler.EnqueueBuildingUpgrade)
+	villageGroup.PUT("/:villageID/construction-queue/:orderID", villageHandler.MoveConstructionOrder)
+	villageGroup.DELETE("/:villageID/construction-queue/:orderID", villageHandler.CancelConstructionOrder)
+	villageGroup.POST("/:villageID/construction-premium", villageHandler.BuyConstructionPremium)
 
 	// Rutas de recursos
 	resourceGroup := r.Group("/resources")
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"server-backend/handlers"
+	"server-backend/middleware"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// SetupWorldSettingsRoutes configura las rutas de reglas de mundo. Cualquier jugador puede
+// consultarlas; solo los administradores pueden cambiarlas, y solo antes de que el mundo empiece.
+func SetupWorldSettingsRoutes(r *gin.RouterGroup, worldSettingsHandler *handlers.WorldSettingsHandler, authMiddleware *middleware.AuthMiddleware, logger *zap.Logger) {
+	// Grupo de rutas de reglas de mundo (ya protegido por el grupo padre)
+	settingsGroup := r.Group("/api/worlds/:world_id/settings")
+
+	settingsGroup.GET("/", worldSettingsHandler.GetWorldSettings)
+	settingsGroup.PUT("/", authMiddleware.RequireAdminGin(), worldSettingsHandler.UpdateWorldSettings)
+
+	logger.Info("✅ Rutas de reglas de mundo configuradas exitosamente")
+}
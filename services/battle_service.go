@@ -5,7 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"math/rand"
+	"math"
+	"sort"
 	"time"
 
 	"server-backend/models"
@@ -17,12 +18,16 @@ import (
 )
 
 type BattleService struct {
-	battleRepo   *repository.BattleRepository
-	villageRepo  *repository.VillageRepository
-	unitRepo     *repository.UnitRepository
-	logger       *zap.Logger
-	wsManager    *websocket.Manager
-	redisService *RedisService
+	battleRepo         *repository.BattleRepository
+	villageRepo        *repository.VillageRepository
+	unitRepo           *repository.UnitRepository
+	buildingConfigRepo *repository.BuildingConfigRepository
+	logger             *zap.Logger
+	wsManager          *websocket.Manager
+	redisService       *RedisService
+	combatEngine       *CombatEngine
+	protectionService  *ProtectionService
+	worldSettings      *WorldSettingsService
 }
 
 type BattleData struct {
@@ -46,14 +51,16 @@ type MatchmakingRequest struct {
 	RequestedAt time.Time `json:"requested_at"`
 }
 
-func NewBattleService(battleRepo *repository.BattleRepository, villageRepo *repository.VillageRepository, unitRepo *repository.UnitRepository, logger *zap.Logger, redisService *RedisService) *BattleService {
+func NewBattleService(battleRepo *repository.BattleRepository, villageRepo *repository.VillageRepository, unitRepo *repository.UnitRepository, buildingConfigRepo *repository.BuildingConfigRepository, logger *zap.Logger, redisService *RedisService) *BattleService {
 	return &BattleService{
-		battleRepo:   battleRepo,
-		villageRepo:  villageRepo,
-		unitRepo:     unitRepo,
-		logger:       logger,
-		wsManager:    nil, // Se establecerá después con SetWebSocketManager
-		redisService: redisService,
+		battleRepo:         battleRepo,
+		villageRepo:        villageRepo,
+		unitRepo:           unitRepo,
+		buildingConfigRepo: buildingConfigRepo,
+		logger:             logger,
+		wsManager:          nil, // Se establecerá después con SetWebSocketManager
+		redisService:       redisService,
+		combatEngine:       NewCombatEngine(),
 	}
 }
 
@@ -77,16 +84,22 @@ func (s *BattleService) CreateBattle(request *models.BattleRequest) (*models.Bat
 	}
 
 	// Guardar la batalla usando el repositorio
+	tacticsJSON := ""
+	if len(request.Tactics) > 0 {
+		tacticsJSON = marshalCombatJSON(request.Tactics)
+	}
 	config := map[string]interface{}{
-		"units":           request.Units,
-		"mode":            request.Mode,
-		"formation":       request.Formation,
-		"tactics":         request.Tactics,
-		"terrain":         request.Terrain,
-		"weather":         request.Weather,
-		"max_waves":       request.MaxWaves,
-		"max_duration":    request.MaxDuration,
-		"advanced_config": request.AdvancedConfig,
+		"units":              request.Units,
+		"mode":               request.Mode,
+		"formation":          request.Formation,
+		"tactics":            request.Tactics,
+		"attacker_formation": request.Formation,
+		"attacker_tactics":   tacticsJSON,
+		"terrain":            request.Terrain,
+		"weather":            request.Weather,
+		"max_waves":          request.MaxWaves,
+		"max_duration":       request.MaxDuration,
+		"advanced_config":    request.AdvancedConfig,
 	}
 
 	createdBattle, err := s.battleRepo.CreateBattle(request.AttackerID, request.DefenderVillageID, request.BattleType, request.Mode, config)
@@ -94,6 +107,11 @@ func (s *BattleService) CreateBattle(request *models.BattleRequest) (*models.Bat
 		return nil, fmt.Errorf("error creando batalla: %w", err)
 	}
 
+	// Atacar a otro jugador retira los escudos propios
+	if request.BattleType != "pve" && s.protectionService != nil {
+		s.protectionService.OnAttackLaunched(request.AttackerID)
+	}
+
 	// Incrementar contador de rate limiting
 	s.redisService.IncrementCounter(rateLimitKey)
 	s.redisService.SetCounter(rateLimitKey, attackCount+1, time.Hour) // Expira en 1 hora
@@ -153,6 +171,20 @@ func (s *BattleService) validateBattleRequest(request *models.BattleRequest) err
 		return fmt.Errorf("modo de batalla no válido")
 	}
 
+	// Los ataques contra jugadores respetan los escudos y el modo vacaciones
+	if request.BattleType != "pve" && s.protectionService != nil {
+		defender, err := s.villageRepo.GetVillageByID(request.DefenderVillageID)
+		if err != nil {
+			return fmt.Errorf("error obteniendo aldea defensora: %w", err)
+		}
+		if defender == nil {
+			return fmt.Errorf("aldea defensora no encontrada")
+		}
+		if err := s.protectionService.CheckAttack(request.AttackerID, &defender.Village); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -240,6 +272,335 @@ func (s *BattleService) GetBattleWithDetails(battleID uuid.UUID) (*models.Battle
 	return details, nil
 }
 
+// BattleReplayFrame es una oleada re-simulada lista para animar en el cliente
+type BattleReplayFrame struct {
+	WaveNumber     int              `json:"wave_number"`
+	AttackerUnits  map[string]int   `json:"attacker_units"`
+	DefenderUnits  map[string]int   `json:"defender_units"`
+	AttackerDamage int              `json:"attacker_damage"`
+	DefenderDamage int              `json:"defender_damage"`
+	AttackerLosses map[string]int   `json:"attacker_losses"`
+	DefenderLosses map[string]int   `json:"defender_losses"`
+	CombatLog      []CombatLogEntry `json:"combat_log"`
+	Duration       int              `json:"duration"`
+	MatchesStored  bool             `json:"matches_stored"` // coincide con la oleada guardada
+}
+
+// BattleReplay es la repetición completa de una batalla a partir de su semilla
+type BattleReplay struct {
+	BattleID     uuid.UUID           `json:"battle_id"`
+	Seed         int64               `json:"seed"`
+	Winner       string              `json:"winner"`
+	StoredWinner string              `json:"stored_winner"`
+	Diverged     bool                `json:"diverged"` // true si la re-simulación difiere de lo guardado
+	Frames       []BattleReplayFrame `json:"frames"`
+}
+
+// ReplayBattle re-simula una batalla con su semilla y ejércitos guardados y la compara
+// con las oleadas almacenadas. El terreno y el clima se recargan del catálogo actual,
+// por lo que un cambio de balance en ellos aparece como divergencia.
+func (s *BattleService) ReplayBattle(battleID uuid.UUID) (*BattleReplay, error) {
+	battle, err := s.battleRepo.GetBattle(battleID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo batalla: %w", err)
+	}
+
+	if battle.Status != "completed" {
+		return nil, fmt.Errorf("solo se pueden repetir batallas completadas")
+	}
+	if battle.AttackerArmy == "" || battle.DefenderArmy == "" {
+		return nil, fmt.Errorf("la batalla no tiene datos de repetición")
+	}
+
+	input := &CombatInput{
+		Seed:     battle.Seed,
+		MaxWaves: battle.MaxWaves,
+		Attacker: &CombatArmy{},
+		Defender: &CombatArmy{},
+	}
+	if err := json.Unmarshal([]byte(battle.AttackerArmy), input.Attacker); err != nil {
+		return nil, fmt.Errorf("ejército atacante guardado inválido: %w", err)
+	}
+	if err := json.Unmarshal([]byte(battle.DefenderArmy), input.Defender); err != nil {
+		return nil, fmt.Errorf("ejército defensor guardado inválido: %w", err)
+	}
+
+	// Formaciones y tácticas ya vienen en los ejércitos guardados; solo se recarga el entorno
+	s.loadBattleEnvironment(battle, input)
+
+	storedWaves, err := s.battleRepo.GetBattleWaves(battleID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo oleadas: %w", err)
+	}
+	storedByNumber := make(map[int]models.BattleWave, len(storedWaves))
+	for _, wave := range storedWaves {
+		storedByNumber[wave.WaveNumber] = wave
+	}
+
+	outcome := s.combatEngine.Resolve(input)
+
+	replay := &BattleReplay{
+		BattleID:     battle.ID,
+		Seed:         battle.Seed,
+		Winner:       outcome.Winner,
+		StoredWinner: battle.Winner,
+		Diverged:     outcome.Winner != battle.Winner || len(outcome.Waves) != len(storedWaves),
+		Frames:       make([]BattleReplayFrame, 0, len(outcome.Waves)),
+	}
+
+	for _, wave := range outcome.Waves {
+		frame := BattleReplayFrame{
+			WaveNumber:     wave.WaveNumber,
+			AttackerUnits:  decodeUnitCounts(wave.AttackerUnits),
+			DefenderUnits:  decodeUnitCounts(wave.DefenderUnits),
+			AttackerDamage: wave.AttackerDamage,
+			DefenderDamage: wave.DefenderDamage,
+			AttackerLosses: decodeUnitCounts(wave.AttackerLosses),
+			DefenderLosses: decodeUnitCounts(wave.DefenderLosses),
+			Duration:       wave.Duration,
+		}
+		json.Unmarshal([]byte(wave.CombatLog), &frame.CombatLog)
+
+		if stored, ok := storedByNumber[wave.WaveNumber]; ok {
+			frame.MatchesStored = stored.AttackerDamage == wave.AttackerDamage &&
+				stored.DefenderDamage == wave.DefenderDamage &&
+				sameUnitCounts(decodeUnitCounts(stored.AttackerLosses), frame.AttackerLosses) &&
+				sameUnitCounts(decodeUnitCounts(stored.DefenderLosses), frame.DefenderLosses)
+		}
+		if !frame.MatchesStored {
+			replay.Diverged = true
+		}
+
+		replay.Frames = append(replay.Frames, frame)
+	}
+
+	return replay, nil
+}
+
+// decodeUnitCounts interpreta un JSON {"unidad": cantidad}; devuelve un mapa vacío si es inválido
+func decodeUnitCounts(raw string) map[string]int {
+	counts := make(map[string]int)
+	if raw == "" {
+		return counts
+	}
+	if err := json.Unmarshal([]byte(raw), &counts); err != nil {
+		return make(map[string]int)
+	}
+	return counts
+}
+
+// sameUnitCounts compara dos mapas de cantidades ignorando las entradas a cero
+func sameUnitCounts(a, b map[string]int) bool {
+	for key, value := range a {
+		if value != 0 && b[key] != value {
+			return false
+		}
+	}
+	for key, value := range b {
+		if value != 0 && a[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	// defaultSimulationIterations es el número de iteraciones Monte-Carlo por defecto
+	defaultSimulationIterations = 100
+	// maxSimulationIterations limita el coste de una simulación
+	maxSimulationIterations = 1000
+	// heroBonusPerPoint es la bonificación al ejército por cada punto de ataque/defensa del héroe
+	heroBonusPerPoint = 0.01
+	// moraleExponent suaviza la penalización de moral según la diferencia de tamaño
+	moraleExponent = 0.3
+	// moraleMin es la moral mínima de un atacante mucho mayor que su objetivo
+	moraleMin = 0.3
+)
+
+// SimulateBattle ejecuta N iteraciones del motor de combate sin efectos secundarios:
+// no crea batallas, no consume unidades y no escribe en base de datos.
+func (s *BattleService) SimulateBattle(request *models.BattleSimulationRequest) (*models.BattleSimulationReport, error) {
+	if len(request.AttackerUnits) == 0 || len(request.DefenderUnits) == 0 {
+		return nil, fmt.Errorf("se requieren unidades en ambos bandos")
+	}
+
+	iterations := request.Iterations
+	if iterations <= 0 {
+		iterations = defaultSimulationIterations
+	}
+	if iterations > maxSimulationIterations {
+		iterations = maxSimulationIterations
+	}
+
+	seed := request.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	attackerGroups, err := s.resolveSimulationGroups(request.AttackerUnits)
+	if err != nil {
+		return nil, fmt.Errorf("ejército atacante inválido: %w", err)
+	}
+	defenderGroups, err := s.resolveSimulationGroups(request.DefenderUnits)
+	if err != nil {
+		return nil, fmt.Errorf("ejército defensor inválido: %w", err)
+	}
+
+	// El entorno se carga una sola vez y se reutiliza en todas las iteraciones
+	environment := &models.Battle{
+		Mode:              "advanced",
+		Terrain:           request.Terrain,
+		Weather:           request.Weather,
+		AttackerFormation: request.AttackerFormation,
+		DefenderFormation: request.DefenderFormation,
+	}
+	template := &CombatInput{
+		MaxWaves: request.MaxWaves,
+		Attacker: &CombatArmy{Groups: attackerGroups},
+		Defender: &CombatArmy{Groups: defenderGroups},
+	}
+	s.loadBattleEnvironment(environment, template)
+	s.loadBattleFormations(environment, template)
+	applySimulationHero(template.Attacker, request.AttackerHero)
+	applySimulationHero(template.Defender, request.DefenderHero)
+
+	attackerWins, defenderWins, draws, totalWaves := 0, 0, 0, 0
+	attackerSamples := make(map[string][]int)
+	defenderSamples := make(map[string][]int)
+
+	for i := 0; i < iterations; i++ {
+		input := *template
+		input.Seed = seed + int64(i)
+		outcome := s.combatEngine.Resolve(&input)
+
+		switch outcome.Winner {
+		case "attacker":
+			attackerWins++
+		case "defender":
+			defenderWins++
+		default:
+			draws++
+		}
+		totalWaves += len(outcome.Waves)
+
+		collectLossSamples(attackerSamples, attackerGroups, outcome.AttackerLosses)
+		collectLossSamples(defenderSamples, defenderGroups, outcome.DefenderLosses)
+	}
+
+	total := float64(iterations)
+	return &models.BattleSimulationReport{
+		Iterations:               iterations,
+		Seed:                     seed,
+		AttackerWinProbability:   float64(attackerWins) / total,
+		DefenderWinProbability:   float64(defenderWins) / total,
+		DrawProbability:          float64(draws) / total,
+		AverageWaves:             float64(totalWaves) / total,
+		ExpectedAttackerLosses:   expectedLosses(attackerSamples),
+		ExpectedDefenderLosses:   expectedLosses(defenderSamples),
+		AttackerLossDistribution: lossDistributions(attackerSamples),
+		DefenderLossDistribution: lossDistributions(defenderSamples),
+	}, nil
+}
+
+// resolveSimulationGroups convierte una composición en grupos de combate. Las claves
+// pueden ser IDs del catálogo de unidades militares o tipos de models.UnitTypes.
+func (s *BattleService) resolveSimulationGroups(units map[string]int) ([]*CombatUnitGroup, error) {
+	groups := make([]*CombatUnitGroup, 0, len(units))
+	for key, quantity := range units {
+		if quantity <= 0 {
+			continue
+		}
+
+		if unitID, err := uuid.Parse(key); err == nil {
+			militaryUnit, err := s.battleRepo.GetMilitaryUnit(unitID)
+			if err != nil {
+				return nil, fmt.Errorf("unidad %s: %w", key, err)
+			}
+			groups = append(groups, &CombatUnitGroup{
+				UnitID:   key,
+				Category: militaryUnit.Type,
+				Quantity: quantity,
+				Attack:   float64(militaryUnit.PhysicalAttack),
+				Defense:  float64(militaryUnit.PhysicalDefense),
+				Health:   float64(militaryUnit.Health),
+			})
+			continue
+		}
+
+		unitType, exists := models.UnitTypes[key]
+		if !exists {
+			return nil, fmt.Errorf("tipo de unidad desconocido: %s", key)
+		}
+		groups = append(groups, newUnitTypeCombatGroup(unitType, quantity))
+	}
+
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("el ejército no tiene unidades")
+	}
+	return groups, nil
+}
+
+// newUnitTypeCombatGroup crea un grupo de combate a partir de un tipo de unidad de aldea
+func newUnitTypeCombatGroup(unitType models.UnitType, quantity int) *CombatUnitGroup {
+	return &CombatUnitGroup{
+		UnitID:   unitType.Type,
+		Category: unitType.Category,
+		Quantity: quantity,
+		Attack:   float64(unitType.Attack),
+		Defense:  float64(unitType.Defense),
+		Health:   float64(unitType.Health),
+	}
+}
+
+// applySimulationHero traduce las estadísticas del héroe en bonificaciones del ejército
+func applySimulationHero(army *CombatArmy, hero *models.BattleSimulationHero) {
+	if hero == nil {
+		return
+	}
+	army.AttackBonus += float64(hero.Attack) * heroBonusPerPoint
+	army.DefenseBonus += float64(hero.Defense) * heroBonusPerPoint
+}
+
+// collectLossSamples guarda las bajas de una iteración (incluidos los ceros) por unidad
+func collectLossSamples(samples map[string][]int, groups []*CombatUnitGroup, losses map[string]int) {
+	for _, group := range groups {
+		samples[group.UnitID] = append(samples[group.UnitID], losses[group.UnitID])
+	}
+}
+
+// expectedLosses calcula la media de bajas por unidad
+func expectedLosses(samples map[string][]int) map[string]float64 {
+	expected := make(map[string]float64, len(samples))
+	for unitID, values := range samples {
+		total := 0
+		for _, value := range values {
+			total += value
+		}
+		expected[unitID] = float64(total) / float64(len(values))
+	}
+	return expected
+}
+
+// lossDistributions calcula mínimo, percentiles y máximo de bajas por unidad
+func lossDistributions(samples map[string][]int) map[string]models.BattleLossDistribution {
+	distributions := make(map[string]models.BattleLossDistribution, len(samples))
+	for unitID, values := range samples {
+		sorted := append([]int(nil), values...)
+		sort.Ints(sorted)
+		percentile := func(p float64) int {
+			return sorted[int(p*float64(len(sorted)-1))]
+		}
+		distributions[unitID] = models.BattleLossDistribution{
+			Min: sorted[0],
+			P10: percentile(0.1),
+			P50: percentile(0.5),
+			P90: percentile(0.9),
+			Max: sorted[len(sorted)-1],
+		}
+	}
+	return distributions
+}
+
 // ProcessBattle procesa una batalla con Redis
 func (s *BattleService) ProcessBattle(battleID uuid.UUID) error {
 	// Obtener batalla del cache o base de datos
@@ -267,13 +628,17 @@ func (s *BattleService) ProcessBattle(battleID uuid.UUID) error {
 	// Actualizar estado de la batalla
 	battle.Status = "completed"
 	now := time.Now()
+	if battle.StartTime == nil {
+		battle.StartTime = &now
+	}
 	battle.EndTime = &now
-	battle.Winner = result.Winner
+	s.applyBattleResult(&battle, result)
 
 	// Guardar en base de datos
 	if err := s.battleRepo.UpdateBattle(&battle); err != nil {
 		return fmt.Errorf("error actualizando batalla: %w", err)
 	}
+	s.saveBattleWaves(&battle, result)
 
 	// Actualizar cache
 	s.redisService.SetCache(battleKey, battle, time.Hour)
@@ -294,7 +659,7 @@ func (s *BattleService) ProcessBattle(battleID uuid.UUID) error {
 	return nil
 }
 
-// simulateBattle simula una batalla y retorna el resultado
+// simulateBattle simula una batalla oleada a oleada con el motor de combate
 func (s *BattleService) simulateBattle(battle *models.Battle) (*BattleResult, error) {
 	// Obtener unidades de ambos bandos
 	attackerUnits, err := s.battleRepo.GetPlayerUnits(battle.AttackerID)
@@ -307,63 +672,516 @@ func (s *BattleService) simulateBattle(battle *models.Battle) (*BattleResult, er
 		return nil, fmt.Errorf("error obteniendo unidades del defensor: %w", err)
 	}
 
-	// Calcular poder total de cada bando
-	attackerPower := s.calculateTotalPower(attackerUnits)
-	defenderPower := s.calculateTotalPower(defenderUnits)
+	input := &CombatInput{
+		Seed:     time.Now().UnixNano(),
+		MaxWaves: battle.MaxWaves,
+		Attacker: &CombatArmy{Groups: s.buildCombatGroups(attackerUnits)},
+		Defender: &CombatArmy{Groups: s.buildCombatGroups(defenderUnits)},
+	}
+	s.loadBattleEnvironment(battle, input)
+	s.loadBattleFormations(battle, input)
 
-	// Simular resultado basado en poder y aleatoriedad
-	result := &BattleResult{
-		AttackerLosses: "{}",
-		DefenderLosses: "{}",
+	return newBattleResult(s.combatEngine.Resolve(input), input), nil
+}
+
+// newBattleResult empaqueta el resultado del motor de combate para persistirlo
+func newBattleResult(outcome *CombatOutcome, input *CombatInput) *BattleResult {
+	return &BattleResult{
+		Winner:         outcome.Winner,
+		AttackerLosses: marshalCombatJSON(outcome.AttackerLosses),
+		DefenderLosses: marshalCombatJSON(outcome.DefenderLosses),
+		Seed:           outcome.Seed,
+		Duration:       outcome.Duration,
+		Waves:          outcome.Waves,
+		Input:          input,
+		Outcome:        outcome,
 	}
+}
 
-	// Fórmula simple: poder + aleatoriedad
-	attackerRoll := rand.Float64() * 0.2 // ±10% aleatoriedad
-	defenderRoll := rand.Float64() * 0.2
+// ResolveVillageBattle resuelve el ataque de una marcha que llega a una aldea. Las tropas
+// atacantes son las que viajan en la marcha y las defensoras las estacionadas en la aldea.
+// Las bajas del defensor se descuentan de la aldea; las del atacante las gestiona la marcha.
+func (s *BattleService) ResolveVillageBattle(attackerID uuid.UUID, target *models.VillageWithDetails, units map[string]int) (*models.Battle, *BattleResult, error) {
+	attackerGroups := make([]*CombatUnitGroup, 0, len(units))
+	for unitType, quantity := range units {
+		info, exists := models.UnitTypes[unitType]
+		if !exists || quantity <= 0 {
+			continue
+		}
+		attackerGroups = append(attackerGroups, newUnitTypeCombatGroup(info, quantity))
+	}
+	if len(attackerGroups) == 0 {
+		return nil, nil, fmt.Errorf("la marcha no tiene unidades de combate")
+	}
+
+	defenderUnits, err := s.unitRepo.GetUnitsByVillageID(target.Village.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error obteniendo unidades del defensor: %w", err)
+	}
+	support, err := s.unitRepo.GetStationedTroopsByHost(target.Village.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error obteniendo tropas de apoyo: %w", err)
+	}
+
+	// La guarnición propia y los contingentes aliados combaten juntos, agrupados por tipo
+	garrison := make(map[string]int)
+	defenderTotals := make(map[string]int)
+	for _, unit := range defenderUnits {
+		if unit.Quantity > 0 {
+			garrison[unit.Type] += unit.Quantity
+			defenderTotals[unit.Type] += unit.Quantity
+		}
+	}
+	for _, contingent := range support {
+		for unitType, quantity := range contingent.Units {
+			defenderTotals[unitType] += quantity
+		}
+	}
+	defenderGroups := make([]*CombatUnitGroup, 0, len(defenderTotals))
+	for unitType, quantity := range defenderTotals {
+		info, exists := models.UnitTypes[unitType]
+		if !exists || quantity <= 0 {
+			continue
+		}
+		defenderGroups = append(defenderGroups, newUnitTypeCombatGroup(info, quantity))
+	}
 
-	attackerFinalPower := attackerPower * (1 + attackerRoll)
-	defenderFinalPower := defenderPower * (1 + defenderRoll)
+	// La batalla se guarda ya resuelta junto con las bajas del defensor
+	battle, err := s.battleRepo.PrepareBattle(attackerID, target.Village.PlayerID, "pvp", "basic", map[string]interface{}{
+		"units": units,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creando batalla: %w", err)
+	}
 
-	if attackerFinalPower > defenderFinalPower {
-		result.Winner = "attacker"
-		// Calcular pérdidas proporcionales
-		ratio := defenderFinalPower / attackerFinalPower
-		result.AttackerLosses = s.calculateLosses(attackerUnits, 1-ratio)
-		result.DefenderLosses = s.calculateLosses(defenderUnits, 0.8) // 80% de pérdidas para el perdedor
-	} else if defenderFinalPower > attackerFinalPower {
-		result.Winner = "defender"
-		ratio := attackerFinalPower / defenderFinalPower
-		result.AttackerLosses = s.calculateLosses(attackerUnits, 0.8)
-		result.DefenderLosses = s.calculateLosses(defenderUnits, 1-ratio)
-	} else {
-		result.Winner = "draw"
-		result.AttackerLosses = s.calculateLosses(attackerUnits, 0.5)
-		result.DefenderLosses = s.calculateLosses(defenderUnits, 0.5)
+	input := &CombatInput{
+		Seed:     time.Now().UnixNano(),
+		MaxWaves: battle.MaxWaves,
+		Attacker: &CombatArmy{Groups: attackerGroups},
+		Defender: &CombatArmy{Groups: defenderGroups},
 	}
+	s.loadBattleEnvironment(battle, input)
+	s.applyVillageDefenses(target, input.Defender)
+	s.applyMorale(attackerID, target, input.Attacker)
+	result := newBattleResult(s.combatEngine.Resolve(input), input)
+	s.applySiegeDamage(target, result.Outcome.AttackerSurvivors)
 
-	return result, nil
+	now := time.Now()
+	battle.Status = "completed"
+	battle.StartTime = &now
+	battle.EndTime = &now
+	s.applyBattleResult(battle, result)
+
+	// Repartir las bajas entre la guarnición y los contingentes aliados en proporción a sus efectivos
+	garrisonLosses, supportLosses := splitDefenderLosses(result.Outcome.DefenderLosses, garrison, support)
+	if err := s.battleRepo.SaveVillageBattle(battle, target.Village.ID, garrisonLosses, support, supportLosses); err != nil {
+		return nil, nil, err
+	}
+	s.saveBattleWaves(battle, result)
+	s.notifySupportLosses(battle, support, supportLosses)
+
+	s.updatePlayerBattleStatistics(battle, result)
+	s.notifyBattleCompleted(battle, result)
+	s.updateBattleRankingsCache()
+
+	return battle, result, nil
 }
 
-// calculateTotalPower calcula el poder total de un conjunto de unidades
-func (s *BattleService) calculateTotalPower(units []models.PlayerUnit) float64 {
-	totalPower := 0.0
-	for _, unit := range units {
-		// Poder = (ataque + defensa) * cantidad * nivel
-		unitPower := float64(unit.CurrentAttack+unit.CurrentDefense) * float64(unit.Quantity) * float64(unit.Level)
-		totalPower += unitPower
+// applyBattleResult copia el resultado de la simulación y los datos de repetición sobre la batalla
+func (s *BattleService) applyBattleResult(battle *models.Battle, result *BattleResult) {
+	battle.Winner = result.Winner
+	battle.AttackerLosses = result.AttackerLosses
+	battle.DefenderLosses = result.DefenderLosses
+	battle.Duration = result.Duration
+	battle.CurrentWave = len(result.Waves)
+	battle.Seed = result.Seed
+	if result.Input != nil {
+		battle.AttackerArmy = marshalCombatJSON(result.Input.Attacker)
+		battle.DefenderArmy = marshalCombatJSON(result.Input.Defender)
+	}
+}
+
+// saveBattleWaves persiste las oleadas de la simulación e invalida el cache de detalles
+func (s *BattleService) saveBattleWaves(battle *models.Battle, result *BattleResult) {
+	if err := s.battleRepo.SaveBattleWaves(battle.ID, result.Waves); err != nil {
+		s.logger.Error("Error guardando oleadas de batalla", zap.String("battle_id", battle.ID.String()), zap.Error(err))
+	}
+	s.redisService.DeleteCache(fmt.Sprintf("battle_details:%s", battle.ID.String()))
+}
+
+// applyMorale reduce el ataque de quien ataca a un jugador más pequeño, si el mundo tiene la moral
+// activada. El tamaño de cada jugador es la suma de niveles de edificio de todas sus aldeas.
+func (s *BattleService) applyMorale(attackerID uuid.UUID, target *models.VillageWithDetails, attacker *CombatArmy) {
+	if s.worldSettings == nil || !s.worldSettings.SettingsFor(target.Village.WorldID).MoraleEnabled {
+		return
+	}
+
+	attackerPoints, err := s.playerBuildingPoints(attackerID)
+	if err != nil {
+		s.logger.Warn("Error calculando la moral del atacante", zap.Error(err))
+		return
+	}
+	defenderPoints, err := s.playerBuildingPoints(target.Village.PlayerID)
+	if err != nil {
+		s.logger.Warn("Error calculando la moral del defensor", zap.Error(err))
+		return
+	}
+	if attackerPoints <= 0 || defenderPoints >= attackerPoints {
+		return
+	}
+
+	morale := math.Max(moraleMin, math.Pow(float64(defenderPoints)/float64(attackerPoints), moraleExponent))
+	attacker.AttackBonus += morale - 1
+}
+
+// playerBuildingPoints suma los niveles de edificio de todas las aldeas de un jugador
+func (s *BattleService) playerBuildingPoints(playerID uuid.UUID) (int, error) {
+	villages, err := s.villageRepo.GetVillagesByPlayerID(playerID)
+	if err != nil {
+		return 0, err
+	}
+	points := 0
+	for _, village := range villages {
+		for _, building := range village.Buildings {
+			points += building.Level
+		}
+	}
+	return points, nil
+}
+
+// applyVillageDefenses traslada la muralla y las torres de la aldea al ejército defensor.
+// La bonificación de la muralla se reduce en proporción a los daños de asedio sin reparar.
+func (s *BattleService) applyVillageDefenses(village *models.VillageWithDetails, army *CombatArmy) {
+	if wall, exists := village.Buildings["wall"]; exists && wall.Level > 0 {
+		config, err := s.buildingConfigRepo.GetBuildingConfig("wall", wall.Level)
+		if err != nil {
+			s.logger.Error("Error obteniendo configuración de la muralla", zap.Error(err))
+		} else if config != nil {
+			army.DefenseBonus += config.DefenseBonus * wallIntegrity(wall, config)
+		}
+	}
+
+	if tower, exists := village.Buildings["tower"]; exists && tower.Level > 0 {
+		config, err := s.buildingConfigRepo.GetBuildingConfig("tower", tower.Level)
+		if err != nil {
+			s.logger.Error("Error obteniendo configuración de la torre", zap.Error(err))
+		} else if config != nil {
+			army.TowerDamage += float64(config.TowerDamage)
+		}
+	}
+}
+
+// applySiegeDamage daña la muralla con las unidades de asedio que sobreviven al combate
+func (s *BattleService) applySiegeDamage(village *models.VillageWithDetails, survivors map[string]int) {
+	wall, exists := village.Buildings["wall"]
+	if !exists || wall.Level == 0 {
+		return
+	}
+
+	siegeDamage := 0
+	for unitType, quantity := range survivors {
+		if info, ok := models.UnitTypes[unitType]; ok && info.Category == "siege" {
+			siegeDamage += info.SiegeDamage * quantity
+		}
+	}
+	if siegeDamage == 0 {
+		return
+	}
+
+	config, err := s.buildingConfigRepo.GetBuildingConfig("wall", wall.Level)
+	if err != nil || config == nil || config.Durability <= 0 {
+		return
+	}
+
+	damage := wall.Damage + siegeDamage
+	if damage > config.Durability {
+		damage = config.Durability
+	}
+	if err := s.villageRepo.UpdateBuildingDamage(village.Village.ID, "wall", damage); err != nil {
+		s.logger.Error("Error actualizando daños de la muralla", zap.Error(err))
+		return
+	}
+	wall.Damage = damage
+
+	s.logger.Info("Muralla dañada por asedio",
+		zap.String("village_id", village.Village.ID.String()),
+		zap.Int("siege_damage", siegeDamage),
+		zap.Int("total_damage", damage),
+		zap.Int("durability", config.Durability),
+	)
+}
+
+// VillageDefenses resume las defensas efectivas de una aldea
+type VillageDefenses struct {
+	VillageID      uuid.UUID      `json:"village_id"`
+	Units          []*models.Unit `json:"units"`
+	WallLevel      int            `json:"wall_level"`
+	WallDamage     int            `json:"wall_damage"`
+	WallDurability int            `json:"wall_durability"`
+	DefenseBonus   float64        `json:"defense_bonus"`
+	TowerLevel     int            `json:"tower_level"`
+	TowerDamage    float64        `json:"tower_damage"`
+}
+
+// GetVillageDefenses obtiene la guarnición y las bonificaciones defensivas de una aldea
+func (s *BattleService) GetVillageDefenses(village *models.VillageWithDetails) (*VillageDefenses, error) {
+	units, err := s.unitRepo.GetUnitsByVillageID(village.Village.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo guarnición: %w", err)
+	}
+
+	army := &CombatArmy{}
+	s.applyVillageDefenses(village, army)
+
+	defenses := &VillageDefenses{
+		VillageID:    village.Village.ID,
+		Units:        units,
+		DefenseBonus: army.DefenseBonus,
+		TowerDamage:  army.TowerDamage,
+	}
+	if wall, exists := village.Buildings["wall"]; exists {
+		defenses.WallLevel = wall.Level
+		defenses.WallDamage = wall.Damage
+		if config, err := s.buildingConfigRepo.GetBuildingConfig("wall", wall.Level); err == nil && config != nil {
+			defenses.WallDurability = config.Durability
+		}
+	}
+	if tower, exists := village.Buildings["tower"]; exists {
+		defenses.TowerLevel = tower.Level
+	}
+	return defenses, nil
+}
+
+// wallIntegrity devuelve la fracción (0-1) de la muralla que sigue en pie
+func wallIntegrity(wall *models.Building, config *models.BuildingConfig) float64 {
+	if config.Durability <= 0 || wall.Damage <= 0 {
+		return 1
+	}
+	if wall.Damage >= config.Durability {
+		return 0
+	}
+	return 1 - float64(wall.Damage)/float64(config.Durability)
+}
+
+// ResolveScouting enfrenta a los exploradores de una marcha con los exploradores de la aldea
+// objetivo. No genera informe de batalla; las bajas defensoras se descuentan de la aldea.
+func (s *BattleService) ResolveScouting(target *models.VillageWithDetails, scouts int) (*CombatOutcome, error) {
+	scoutType := models.UnitTypes["scout"]
+	outcome := &CombatOutcome{
+		Winner:            "attacker",
+		AttackerLosses:    map[string]int{},
+		DefenderLosses:    map[string]int{},
+		AttackerSurvivors: map[string]int{scoutType.Type: scouts},
+		DefenderSurvivors: map[string]int{},
+	}
+
+	defenderScouts, err := s.unitRepo.GetUnitByVillageAndType(target.Village.ID, scoutType.Type)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo exploradores del defensor: %w", err)
+	}
+	if defenderScouts == nil || defenderScouts.Quantity <= 0 {
+		return outcome, nil
+	}
+
+	outcome = s.combatEngine.Resolve(&CombatInput{
+		Seed:     time.Now().UnixNano(),
+		Attacker: &CombatArmy{Groups: []*CombatUnitGroup{newUnitTypeCombatGroup(scoutType, scouts)}},
+		Defender: &CombatArmy{Groups: []*CombatUnitGroup{newUnitTypeCombatGroup(scoutType, defenderScouts.Quantity)}},
+	})
+
+	if lost := outcome.DefenderLosses[scoutType.Type]; lost > 0 {
+		if err := s.unitRepo.RemoveUnits(target.Village.ID, scoutType.Type, lost); err != nil {
+			s.logger.Error("Error descontando exploradores del defensor", zap.Error(err))
+		}
+	}
+
+	return outcome, nil
+}
+
+// splitDefenderLosses reparte las bajas de cada tipo de unidad entre la guarnición propia y los
+// contingentes de apoyo, proporcionalmente a lo que aportó cada uno. El resto del redondeo recae
+// en la guarnición.
+func splitDefenderLosses(losses map[string]int, garrison map[string]int, support []*models.SupportTroops) (map[string]int, []map[string]int) {
+	garrisonLosses := make(map[string]int, len(losses))
+	supportLosses := make([]map[string]int, len(support))
+	for i := range support {
+		supportLosses[i] = make(map[string]int)
+	}
+
+	for unitType, lost := range losses {
+		total := garrison[unitType]
+		for _, contingent := range support {
+			total += contingent.Units[unitType]
+		}
+		if lost <= 0 || total <= 0 {
+			continue
+		}
+
+		assigned := 0
+		for i, contingent := range support {
+			share := lost * contingent.Units[unitType] / total
+			supportLosses[i][unitType] = share
+			assigned += share
+		}
+		garrisonLosses[unitType] = lost - assigned
+
+		// Si la guarnición no tiene suficientes unidades, el exceso pasa a los contingentes
+		if excess := garrisonLosses[unitType] - garrison[unitType]; excess > 0 {
+			garrisonLosses[unitType] = garrison[unitType]
+			for i, contingent := range support {
+				if excess == 0 {
+					break
+				}
+				room := contingent.Units[unitType] - supportLosses[i][unitType]
+				if room > excess {
+					room = excess
+				}
+				supportLosses[i][unitType] += room
+				excess -= room
+			}
+		}
+	}
+
+	return garrisonLosses, supportLosses
+}
+
+// notifySupportLosses avisa a los dueños de los contingentes aliados que han sufrido bajas
+func (s *BattleService) notifySupportLosses(battle *models.Battle, support []*models.SupportTroops, losses []map[string]int) {
+	if s.wsManager == nil {
+		return
+	}
+	for i, contingent := range support {
+		lostAny := false
+		for _, lost := range losses[i] {
+			if lost > 0 {
+				lostAny = true
+				break
+			}
+		}
+		if !lostAny {
+			continue
+		}
+		s.wsManager.SendToUser(contingent.OwnerPlayerID.String(), "support_battle", map[string]interface{}{
+			"battle_id":         battle.ID.String(),
+			"origin_village_id": contingent.OriginVillageID.String(),
+			"host_village_id":   contingent.HostVillageID.String(),
+			"losses":            losses[i],
+			"timestamp":         time.Now().Unix(),
+		})
+	}
+}
+
+// RecordBattleLoot registra en el informe de batalla los recursos saqueados por el atacante
+func (s *BattleService) RecordBattleLoot(battle *models.Battle, loot map[string]int) error {
+	battle.Loot = marshalCombatJSON(loot)
+	if err := s.battleRepo.UpdateBattleLoot(battle.ID, battle.Loot); err != nil {
+		return err
 	}
-	return totalPower
+	s.redisService.DeleteCache(fmt.Sprintf("battle:%s", battle.ID.String()))
+	s.redisService.DeleteCache(fmt.Sprintf("battle_details:%s", battle.ID.String()))
+	return nil
 }
 
-// calculateLosses calcula las pérdidas de unidades
-func (s *BattleService) calculateLosses(units []models.PlayerUnit, lossRatio float64) string {
-	losses := make(map[string]int)
+// buildCombatGroups convierte las unidades de un jugador en grupos de combate
+func (s *BattleService) buildCombatGroups(units []models.PlayerUnit) []*CombatUnitGroup {
+	groups := make([]*CombatUnitGroup, 0, len(units))
 	for _, unit := range units {
-		losses[unit.UnitID.String()] = int(float64(unit.Quantity) * lossRatio)
+		if unit.Quantity <= 0 {
+			continue
+		}
+
+		// La categoría viene del catálogo de unidades militares
+		category := "infantry"
+		if militaryUnit, err := s.battleRepo.GetMilitaryUnit(unit.UnitID); err == nil && militaryUnit.Type != "" {
+			category = militaryUnit.Type
+		}
+
+		// Cada nivel por encima del primero aporta un 10% de ataque y defensa
+		levelFactor := 1 + 0.1*float64(unit.Level-1)
+		if levelFactor < 1 {
+			levelFactor = 1
+		}
+
+		groups = append(groups, &CombatUnitGroup{
+			UnitID:   unit.UnitID.String(),
+			Category: category,
+			Quantity: unit.Quantity,
+			Attack:   float64(unit.CurrentAttack) * levelFactor,
+			Defense:  float64(unit.CurrentDefense) * levelFactor,
+			Health:   float64(unit.CurrentHealth),
+		})
 	}
+	return groups
+}
 
-	lossesJSON, _ := json.Marshal(losses)
-	return string(lossesJSON)
+// loadBattleEnvironment carga el terreno y el clima de una batalla avanzada
+func (s *BattleService) loadBattleEnvironment(battle *models.Battle, input *CombatInput) {
+	if battle.Mode != "advanced" {
+		return
+	}
+
+	if battle.Terrain != "" {
+		terrain, err := s.battleRepo.GetBattleTerrain(battle.Terrain)
+		if err != nil {
+			s.logger.Warn("Terreno de batalla no disponible", zap.String("terrain", battle.Terrain), zap.Error(err))
+		} else {
+			input.Terrain = terrain
+		}
+	}
+
+	if battle.Weather != "" {
+		weather, err := s.battleRepo.GetBattleWeather(battle.Weather)
+		if err != nil {
+			s.logger.Warn("Clima de batalla no disponible", zap.String("weather", battle.Weather), zap.Error(err))
+		} else {
+			input.Weather = weather
+		}
+	}
+}
+
+// loadBattleFormations carga las formaciones y tácticas de ambos bandos en una batalla avanzada
+func (s *BattleService) loadBattleFormations(battle *models.Battle, input *CombatInput) {
+	if battle.Mode != "advanced" {
+		return
+	}
+
+	input.Attacker.Formation = s.loadFormation(battle.AttackerFormation)
+	input.Defender.Formation = s.loadFormation(battle.DefenderFormation)
+	input.Attacker.Tactics = s.loadTactics(battle.AttackerTactics)
+	input.Defender.Tactics = s.loadTactics(battle.DefenderTactics)
+}
+
+// loadFormation obtiene una formación por ID o nombre, nil si no existe
+func (s *BattleService) loadFormation(key string) *models.BattleFormation {
+	if key == "" {
+		return nil
+	}
+	formation, err := s.battleRepo.GetBattleFormation(key)
+	if err != nil {
+		s.logger.Warn("Formación de batalla no disponible", zap.String("formation", key), zap.Error(err))
+		return nil
+	}
+	return formation
+}
+
+// loadTactics obtiene las tácticas a partir del JSON guardado en la batalla
+func (s *BattleService) loadTactics(raw string) []models.BattleTactic {
+	if raw == "" {
+		return nil
+	}
+
+	var keys []string
+	if err := json.Unmarshal([]byte(raw), &keys); err != nil {
+		s.logger.Warn("Tácticas de batalla inválidas", zap.String("tactics", raw), zap.Error(err))
+		return nil
+	}
+
+	tactics, err := s.battleRepo.GetBattleTactics(keys)
+	if err != nil {
+		s.logger.Warn("Error obteniendo tácticas de batalla", zap.Error(err))
+		return nil
+	}
+	return tactics
 }
 
 // updatePlayerBattleStatistics actualiza las estadísticas de batalla de los jugadores
@@ -682,11 +1500,26 @@ func (s *BattleService) SetWebSocketManager(wsManager *websocket.Manager) {
 	s.wsManager = wsManager
 }
 
+// SetProtectionService establece el servicio de protección que valida los ataques
+func (s *BattleService) SetProtectionService(protectionService *ProtectionService) {
+	s.protectionService = protectionService
+}
+
+// SetWorldSettingsService establece el servicio de reglas de mundo, que activa la moral
+func (s *BattleService) SetWorldSettingsService(worldSettings *WorldSettingsService) {
+	s.worldSettings = worldSettings
+}
+
 // BattleResult representa el resultado de una batalla
 type BattleResult struct {
-	Winner         string `json:"winner"`
-	AttackerLosses string `json:"attacker_losses"`
-	DefenderLosses string `json:"defender_losses"`
+	Winner         string              `json:"winner"`
+	AttackerLosses string              `json:"attacker_losses"`
+	DefenderLosses string              `json:"defender_losses"`
+	Seed           int64               `json:"seed"`
+	Duration       int                 `json:"duration"`
+	Waves          []models.BattleWave `json:"waves,omitempty"`
+	Input          *CombatInput        `json:"-"`
+	Outcome        *CombatOutcome      `json:"-"`
 }
 
 // RequestBattle solicita una batalla PvP (matchmaking)
@@ -965,22 +1798,22 @@ func (s *BattleService) finishBattle(ctx context.Context, battleID int64) error
 	}
 
 	// Simular resultado
-	result := &BattleResult{
-		Winner:         "attacker", // Simplificado
-		AttackerLosses: "10%",
-		DefenderLosses: "15%",
+	result, err := s.simulateBattle(battle)
+	if err != nil {
+		return fmt.Errorf("error simulando batalla: %w", err)
 	}
 
 	// Actualizar batalla
 	battle.Status = "completed"
 	now := time.Now()
 	battle.EndTime = &now
-	battle.Winner = result.Winner
+	s.applyBattleResult(battle, result)
 
 	err = s.battleRepo.UpdateBattle(battle)
 	if err != nil {
 		return fmt.Errorf("error actualizando batalla: %v", err)
 	}
+	s.saveBattleWaves(battle, result)
 
 	// Actualizar estadísticas
 	s.updatePlayerBattleStatistics(battle, result)
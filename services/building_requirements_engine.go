@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"server-backend/models"
 	"server-backend/repository"
+	"sort"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -15,6 +16,7 @@ type BuildingRequirementsEngine struct {
 	buildingConfigRepo *repository.BuildingConfigRepository
 	researchRepo       *repository.ResearchRepository
 	allianceRepo       *repository.AllianceRepository
+	resourceService    *ResourceService
 	logger             *zap.Logger
 }
 
@@ -82,6 +84,12 @@ func NewBuildingRequirementsEngine(
 	}
 }
 
+// SetResourceService establece el servicio de recursos, con el que se comprueban las existencias
+// actuales en lugar de las últimas guardadas
+func (e *BuildingRequirementsEngine) SetResourceService(resourceService *ResourceService) {
+	e.resourceService = resourceService
+}
+
 // CheckBuildingRequirements verifica los requisitos para construir un edificio usando lógica Go
 func (e *BuildingRequirementsEngine) CheckBuildingRequirements(villageID uuid.UUID, buildingType string, targetLevel int) (*BuildingRequirementsResultGo, error) {
 	e.logger.Info("Verificando requisitos de construcción",
@@ -98,6 +106,9 @@ func (e *BuildingRequirementsEngine) CheckBuildingRequirements(villageID uuid.UU
 	if village == nil {
 		return nil, fmt.Errorf("aldea no encontrada")
 	}
+	if e.resourceService != nil {
+		e.resourceService.ProjectResources(village)
+	}
 
 	// 2. Obtener configuración del edificio
 	config, err := e.buildingConfigRepo.GetBuildingConfig(buildingType, targetLevel)
@@ -135,7 +146,7 @@ func (e *BuildingRequirementsEngine) CheckBuildingRequirements(villageID uuid.UU
 
 	// 6. Verificar recursos disponibles
 	if !e.hasEnoughResources(village.Resources, finalCosts) {
-		missingRequirements = append(missingRequirements, "recursos insuficientes")
+		missingRequirements = append(missingRequirements, ErrInsufficientResources.Error())
 	}
 
 	result := &BuildingRequirementsResultGo{
@@ -160,6 +171,58 @@ func (e *BuildingRequirementsEngine) CheckBuildingRequirements(villageID uuid.UU
 	return result, nil
 }
 
+// CheckDemolitionRequirements comprueba que bajar un edificio a newLevel no deje sin requisitos a
+// los demás edificios de la aldea. plannedLevels son los niveles que alcanzarán las mejoras en
+// cola, que también deben seguir siendo posibles. Solo se devuelven los requisitos que rompería
+// la demolición, no los que ya faltaban antes.
+func (e *BuildingRequirementsEngine) CheckDemolitionRequirements(village *models.VillageWithDetails, buildingType string, newLevel int, plannedLevels map[string]int) []string {
+	after := *village
+	after.Buildings = make(map[string]*models.Building, len(village.Buildings))
+	for otherType, building := range village.Buildings {
+		copied := *building
+		if otherType == buildingType {
+			copied.Level = newLevel
+		}
+		after.Buildings[otherType] = &copied
+	}
+
+	broken := []string{}
+	for otherType, building := range village.Buildings {
+		if otherType == buildingType {
+			continue
+		}
+		level := building.Level
+		if planned, ok := plannedLevels[otherType]; ok && planned > level {
+			level = planned
+		}
+		if level <= 0 {
+			continue
+		}
+
+		before := make(map[string]bool)
+		for _, requirement := range e.structuralRequirements(village, otherType, level) {
+			before[requirement] = true
+		}
+		for _, requirement := range e.structuralRequirements(&after, otherType, level) {
+			if !before[requirement] {
+				broken = append(broken, fmt.Sprintf("%s nivel %d: %s", otherType, level, requirement))
+			}
+		}
+	}
+	sort.Strings(broken)
+	return broken
+}
+
+// structuralRequirements devuelve los requisitos de ayuntamiento y de otros edificios que le
+// faltan a un edificio para estar en un nivel
+func (e *BuildingRequirementsEngine) structuralRequirements(village *models.VillageWithDetails, buildingType string, level int) []string {
+	missing := e.checkBuildingDependencies(village, buildingType, level)
+	if err := e.checkTownHallRequirements(village, buildingType, level); err != nil {
+		missing = append(missing, err.Error())
+	}
+	return missing
+}
+
 // checkTownHallRequirements verifica los requisitos del ayuntamiento
 func (e *BuildingRequirementsEngine) checkTownHallRequirements(village *models.VillageWithDetails, buildingType string, targetLevel int) error {
 	townHall, exists := village.Buildings["town_hall"]
@@ -178,6 +241,8 @@ func (e *BuildingRequirementsEngine) checkTownHallRequirements(village *models.V
 		requiredLevel = 5
 	case "wood_cutter", "stone_quarry", "farm", "gold_mine":
 		requiredLevel = 2
+	case "wall", "tower":
+		requiredLevel = 3
 	default:
 		requiredLevel = 1
 	}
@@ -237,6 +302,11 @@ func (e *BuildingRequirementsEngine) getBuildingDependencies(buildingType string
 				RequiredLevel: 2,
 			})
 		}
+	case "tower":
+		dependencies = append(dependencies, BuildingDependency{
+			BuildingType:  "wall",
+			RequiredLevel: 1,
+		})
 	}
 
 	return dependencies
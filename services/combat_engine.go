@@ -0,0 +1,457 @@
+package services
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+
+	"server-backend/models"
+)
+
+const (
+	// defaultCombatWaves es el número de oleadas si la batalla no define MaxWaves
+	defaultCombatWaves = 10
+	// combatWaveDuration es la duración simulada de cada oleada en segundos
+	combatWaveDuration = 30
+	// combatDamageScale ajusta la letalidad global del combate (valor de balance)
+	combatDamageScale = 2.5
+	// combatArmorBase es la constante de mitigación: daño * base / (base + defensa)
+	combatArmorBase = 100.0
+	// combatMinMultiplier evita que los modificadores anulen por completo a una unidad
+	combatMinMultiplier = 0.1
+)
+
+// combatCounters define las ventajas por categoría: atacante -> objetivo -> multiplicador
+var combatCounters = map[string]map[string]float64{
+	"infantry": {"cavalry": 1.2},
+	"cavalry":  {"archer": 1.3, "siege": 1.5},
+	"archer":   {"infantry": 1.25},
+	"magic":    {"infantry": 1.1, "cavalry": 1.1},
+}
+
+// CombatUnitGroup representa un grupo homogéneo de unidades dentro de un ejército
+type CombatUnitGroup struct {
+	UnitID   string  `json:"unit_id"`
+	Category string  `json:"category"` // infantry, cavalry, archer, siege, magic, scout
+	Quantity int     `json:"quantity"`
+	Attack   float64 `json:"attack"`
+	Defense  float64 `json:"defense"`
+	Health   float64 `json:"health"`
+}
+
+// CombatArmy representa uno de los bandos de la batalla
+type CombatArmy struct {
+	Groups    []*CombatUnitGroup      `json:"groups"`
+	Formation *models.BattleFormation `json:"formation,omitempty"`
+	Tactics   []models.BattleTactic   `json:"tactics,omitempty"`
+
+	// Bonificaciones externas (héroe, edificios...) como fracción: 0.1 = +10%
+	AttackBonus  float64 `json:"attack_bonus,omitempty"`
+	DefenseBonus float64 `json:"defense_bonus,omitempty"`
+
+	// Daño de torres que este bando inflige antes de la primera oleada
+	TowerDamage float64 `json:"tower_damage,omitempty"`
+}
+
+// CombatInput agrupa todo lo necesario para resolver una batalla de forma determinista
+type CombatInput struct {
+	Seed     int64                 `json:"seed"`
+	MaxWaves int                   `json:"max_waves"`
+	Attacker *CombatArmy           `json:"attacker"`
+	Defender *CombatArmy           `json:"defender"`
+	Terrain  *models.BattleTerrain `json:"terrain,omitempty"`
+	Weather  *models.BattleWeather `json:"weather,omitempty"`
+}
+
+// CombatOutcome es el resultado completo de una simulación
+type CombatOutcome struct {
+	Seed              int64               `json:"seed"`
+	Winner            string              `json:"winner"` // attacker, defender, draw
+	Waves             []models.BattleWave `json:"waves"`
+	AttackerLosses    map[string]int      `json:"attacker_losses"`
+	DefenderLosses    map[string]int      `json:"defender_losses"`
+	AttackerSurvivors map[string]int      `json:"attacker_survivors"`
+	DefenderSurvivors map[string]int      `json:"defender_survivors"`
+	AttackerDamage    int                 `json:"attacker_damage"`
+	DefenderDamage    int                 `json:"defender_damage"`
+	Duration          int                 `json:"duration"`
+}
+
+// CombatLogEntry es una línea del log de combate de una oleada
+type CombatLogEntry struct {
+	Side   string `json:"side"` // attacker, defender
+	Unit   string `json:"unit"`
+	Target string `json:"target"`
+	Damage int    `json:"damage"`
+}
+
+// combatModifiers contiene los multiplicadores de ataque y defensa por grupo
+type combatModifiers struct {
+	attack  []float64
+	defense []float64
+}
+
+// CombatEngine resuelve batallas oleada a oleada con un RNG sembrado
+type CombatEngine struct{}
+
+// NewCombatEngine crea un nuevo motor de combate
+func NewCombatEngine() *CombatEngine {
+	return &CombatEngine{}
+}
+
+// Resolve simula la batalla completa. Con el mismo input (incluida la semilla)
+// el resultado es siempre idéntico.
+func (e *CombatEngine) Resolve(input *CombatInput) *CombatOutcome {
+	rng := rand.New(rand.NewSource(input.Seed))
+
+	maxWaves := input.MaxWaves
+	if maxWaves <= 0 {
+		maxWaves = defaultCombatWaves
+	}
+
+	attacker := cloneCombatGroups(input.Attacker)
+	defender := cloneCombatGroups(input.Defender)
+
+	outcome := &CombatOutcome{
+		Seed:           input.Seed,
+		AttackerLosses: make(map[string]int),
+		DefenderLosses: make(map[string]int),
+	}
+
+	// Andanada previa de las torres, registrada como oleada 0
+	if input.Defender != nil && input.Defender.TowerDamage > 0 && combatArmySize(attacker) > 0 {
+		attackerUnits := combatSnapshot(attacker)
+		damage := e.towerVolley(input.Defender.TowerDamage, attacker)
+		losses := e.applyDamage(rng, attacker, damage)
+		for unitID, killed := range losses {
+			outcome.AttackerLosses[unitID] += killed
+		}
+		towerDamage := sumCombatDamage(damage)
+		outcome.DefenderDamage += towerDamage
+
+		outcome.Waves = append(outcome.Waves, models.BattleWave{
+			WaveNumber:     0,
+			AttackerUnits:  marshalCombatJSON(attackerUnits),
+			DefenderUnits:  marshalCombatJSON(combatSnapshot(defender)),
+			DefenderDamage: towerDamage,
+			AttackerLosses: marshalCombatJSON(losses),
+			DefenderLosses: marshalCombatJSON(map[string]int{}),
+			CombatLog:      marshalCombatJSON([]CombatLogEntry{{Side: "defender", Unit: "tower", Target: "army", Damage: towerDamage}}),
+		})
+	}
+
+	for wave := 1; wave <= maxWaves; wave++ {
+		if combatArmySize(attacker) == 0 || combatArmySize(defender) == 0 {
+			break
+		}
+
+		attackerMods := e.buildModifiers(attacker, input.Attacker, input.Defender, input, wave)
+		defenderMods := e.buildModifiers(defender, input.Defender, input.Attacker, input, wave)
+
+		attackerUnits := combatSnapshot(attacker)
+		defenderUnits := combatSnapshot(defender)
+
+		// Ambos bandos golpean a la vez sobre las cantidades al inicio de la oleada
+		var combatLog []CombatLogEntry
+		damageToDefender, attackerLog := e.dealDamage(rng, "attacker", attacker, attackerMods, defender, defenderMods)
+		damageToAttacker, defenderLog := e.dealDamage(rng, "defender", defender, defenderMods, attacker, attackerMods)
+		combatLog = append(combatLog, attackerLog...)
+		combatLog = append(combatLog, defenderLog...)
+
+		defenderWaveLosses := e.applyDamage(rng, defender, damageToDefender)
+		attackerWaveLosses := e.applyDamage(rng, attacker, damageToAttacker)
+
+		for unitID, killed := range attackerWaveLosses {
+			outcome.AttackerLosses[unitID] += killed
+		}
+		for unitID, killed := range defenderWaveLosses {
+			outcome.DefenderLosses[unitID] += killed
+		}
+
+		attackerDamage := sumCombatDamage(damageToDefender)
+		defenderDamage := sumCombatDamage(damageToAttacker)
+		outcome.AttackerDamage += attackerDamage
+		outcome.DefenderDamage += defenderDamage
+		outcome.Duration += combatWaveDuration
+
+		outcome.Waves = append(outcome.Waves, models.BattleWave{
+			WaveNumber:     wave,
+			AttackerUnits:  marshalCombatJSON(attackerUnits),
+			DefenderUnits:  marshalCombatJSON(defenderUnits),
+			AttackerDamage: attackerDamage,
+			DefenderDamage: defenderDamage,
+			AttackerLosses: marshalCombatJSON(attackerWaveLosses),
+			DefenderLosses: marshalCombatJSON(defenderWaveLosses),
+			CombatLog:      marshalCombatJSON(combatLog),
+			Duration:       combatWaveDuration,
+		})
+	}
+
+	outcome.AttackerSurvivors = combatSnapshot(attacker)
+	outcome.DefenderSurvivors = combatSnapshot(defender)
+
+	attackerAlive := combatArmySize(attacker) > 0
+	defenderAlive := combatArmySize(defender) > 0
+	switch {
+	case attackerAlive && !defenderAlive:
+		outcome.Winner = "attacker"
+	case defenderAlive && !attackerAlive:
+		outcome.Winner = "defender"
+	default:
+		outcome.Winner = "draw"
+	}
+
+	return outcome
+}
+
+// dealDamage calcula el daño que un bando inflige a cada grupo enemigo
+func (e *CombatEngine) dealDamage(rng *rand.Rand, side string, source []*CombatUnitGroup, sourceMods combatModifiers, target []*CombatUnitGroup, targetMods combatModifiers) ([]float64, []CombatLogEntry) {
+	damage := make([]float64, len(target))
+	var combatLog []CombatLogEntry
+
+	// El daño se reparte según la presencia (cantidad * vida) de cada grupo enemigo
+	totalPresence := 0.0
+	for _, group := range target {
+		totalPresence += float64(group.Quantity) * group.Health
+	}
+	if totalPresence == 0 {
+		return damage, combatLog
+	}
+
+	for i, group := range source {
+		if group.Quantity == 0 {
+			continue
+		}
+
+		roll := 0.9 + rng.Float64()*0.2
+		raw := float64(group.Quantity) * group.Attack * sourceMods.attack[i] * roll * combatDamageScale
+
+		for j, enemy := range target {
+			if enemy.Quantity == 0 {
+				continue
+			}
+			share := float64(enemy.Quantity) * enemy.Health / totalPresence
+			portion := raw * share * combatCounter(group.Category, enemy.Category)
+			mitigated := portion * combatArmorBase / (combatArmorBase + enemy.Defense*targetMods.defense[j])
+			damage[j] += mitigated
+
+			combatLog = append(combatLog, CombatLogEntry{
+				Side:   side,
+				Unit:   group.UnitID,
+				Target: enemy.UnitID,
+				Damage: int(mitigated),
+			})
+		}
+	}
+
+	return damage, combatLog
+}
+
+// towerVolley reparte el daño de las torres según la presencia de cada grupo, mitigado por su defensa
+func (e *CombatEngine) towerVolley(towerDamage float64, target []*CombatUnitGroup) []float64 {
+	damage := make([]float64, len(target))
+
+	totalPresence := 0.0
+	for _, group := range target {
+		totalPresence += float64(group.Quantity) * group.Health
+	}
+	if totalPresence == 0 {
+		return damage
+	}
+
+	for i, group := range target {
+		if group.Quantity == 0 {
+			continue
+		}
+		share := float64(group.Quantity) * group.Health / totalPresence
+		damage[i] = towerDamage * share * combatArmorBase / (combatArmorBase + group.Defense)
+	}
+	return damage
+}
+
+// applyDamage convierte daño en bajas. La fracción sobrante se resuelve con el RNG
+// para que daños pequeños sigan teniendo una probabilidad justa de matar.
+func (e *CombatEngine) applyDamage(rng *rand.Rand, groups []*CombatUnitGroup, damage []float64) map[string]int {
+	losses := make(map[string]int)
+	for i, group := range groups {
+		if group.Quantity == 0 || damage[i] <= 0 {
+			continue
+		}
+
+		exact := damage[i] / group.Health
+		killed := int(math.Floor(exact))
+		if rng.Float64() < exact-float64(killed) {
+			killed++
+		}
+		if killed > group.Quantity {
+			killed = group.Quantity
+		}
+
+		group.Quantity -= killed
+		if killed > 0 {
+			losses[group.UnitID] += killed
+		}
+	}
+	return losses
+}
+
+// buildModifiers calcula los multiplicadores de cada grupo para la oleada actual
+func (e *CombatEngine) buildModifiers(groups []*CombatUnitGroup, own, enemy *CombatArmy, input *CombatInput, wave int) combatModifiers {
+	attackBonus, defenseBonus := 0.0, 0.0
+
+	if own != nil {
+		attackBonus += own.AttackBonus
+		defenseBonus += own.DefenseBonus
+	}
+
+	if own != nil && own.Formation != nil {
+		bonuses := parseCombatModifiers(own.Formation.Bonuses)
+		penalties := parseCombatModifiers(own.Formation.Penalties)
+		attackBonus += bonuses["attack"] - penalties["attack"]
+		defenseBonus += bonuses["defense"] - penalties["defense"]
+	}
+
+	// Tácticas propias dirigidas a uno mismo y tácticas enemigas dirigidas a nosotros
+	if own != nil {
+		for _, tactic := range own.Tactics {
+			if tacticActive(tactic, wave) && (tactic.Target == "self" || tactic.Target == "ally" || tactic.Target == "all") {
+				effects := parseCombatModifiers(tactic.Effects)
+				attackBonus += effects["attack"]
+				defenseBonus += effects["defense"]
+			}
+		}
+	}
+	if enemy != nil {
+		for _, tactic := range enemy.Tactics {
+			if tacticActive(tactic, wave) && (tactic.Target == "enemy" || tactic.Target == "all") {
+				effects := parseCombatModifiers(tactic.Effects)
+				attackBonus += effects["attack"]
+				defenseBonus += effects["defense"]
+			}
+		}
+	}
+
+	if input.Weather != nil {
+		effects := parseCombatModifiers(input.Weather.Effects)
+		attackBonus += effects["attack"]
+		defenseBonus += effects["defense"]
+	}
+
+	var terrainBonuses, terrainPenalties, weatherUnits, formationBonuses, formationPenalties map[string]float64
+	if input.Terrain != nil {
+		terrainBonuses = parseCombatModifiers(input.Terrain.Bonuses)
+		terrainPenalties = parseCombatModifiers(input.Terrain.Penalties)
+	}
+	if input.Weather != nil {
+		weatherUnits = parseCombatModifiers(input.Weather.UnitModifiers)
+	}
+	if own != nil && own.Formation != nil {
+		formationBonuses = parseCombatModifiers(own.Formation.Bonuses)
+		formationPenalties = parseCombatModifiers(own.Formation.Penalties)
+	}
+
+	mods := combatModifiers{
+		attack:  make([]float64, len(groups)),
+		defense: make([]float64, len(groups)),
+	}
+	for i, group := range groups {
+		// Los modificadores por categoría (terreno, clima, formación) afectan ataque y defensa
+		categoryBonus := terrainBonuses[group.Category] - terrainPenalties[group.Category] +
+			weatherUnits[group.Category] +
+			formationBonuses[group.Category] - formationPenalties[group.Category]
+
+		mods.attack[i] = math.Max(combatMinMultiplier, 1+attackBonus+categoryBonus)
+		mods.defense[i] = math.Max(combatMinMultiplier, 1+defenseBonus+categoryBonus)
+	}
+	return mods
+}
+
+// tacticActive indica si una táctica sigue activa en la oleada dada (Duration 0 = toda la batalla)
+func tacticActive(tactic models.BattleTactic, wave int) bool {
+	return tactic.Duration <= 0 || wave <= tactic.Duration
+}
+
+// combatCounter devuelve el multiplicador de ventaja entre categorías
+func combatCounter(attackerCategory, targetCategory string) float64 {
+	if counters, ok := combatCounters[attackerCategory]; ok {
+		if multiplier, ok := counters[targetCategory]; ok {
+			return multiplier
+		}
+	}
+	return 1
+}
+
+// parseCombatModifiers interpreta un JSON {"clave": valor} de bonificaciones.
+// Un JSON vacío o inválido se trata como ausencia de modificadores.
+func parseCombatModifiers(raw string) map[string]float64 {
+	modifiers := make(map[string]float64)
+	if raw == "" {
+		return modifiers
+	}
+	if err := json.Unmarshal([]byte(raw), &modifiers); err != nil {
+		return make(map[string]float64)
+	}
+	return modifiers
+}
+
+// cloneCombatGroups copia los grupos de un ejército ordenados por UnitID para
+// que el orden de iteración (y por tanto el consumo del RNG) sea estable
+func cloneCombatGroups(army *CombatArmy) []*CombatUnitGroup {
+	if army == nil {
+		return nil
+	}
+
+	groups := make([]*CombatUnitGroup, 0, len(army.Groups))
+	for _, group := range army.Groups {
+		if group == nil || group.Quantity <= 0 {
+			continue
+		}
+		clone := *group
+		if clone.Health <= 0 {
+			clone.Health = 1
+		}
+		groups = append(groups, &clone)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].UnitID < groups[j].UnitID
+	})
+	return groups
+}
+
+// combatArmySize devuelve el número total de unidades vivas
+func combatArmySize(groups []*CombatUnitGroup) int {
+	total := 0
+	for _, group := range groups {
+		total += group.Quantity
+	}
+	return total
+}
+
+// combatSnapshot devuelve las cantidades actuales por unidad
+func combatSnapshot(groups []*CombatUnitGroup) map[string]int {
+	snapshot := make(map[string]int)
+	for _, group := range groups {
+		snapshot[group.UnitID] += group.Quantity
+	}
+	return snapshot
+}
+
+// sumCombatDamage suma el daño total infligido en una oleada
+func sumCombatDamage(damage []float64) int {
+	total := 0.0
+	for _, value := range damage {
+		total += value
+	}
+	return int(total)
+}
+
+// marshalCombatJSON serializa estructuras del combate para los campos JSON de BattleWave
+func marshalCombatJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
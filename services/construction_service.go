@@ -1,6 +1,8 @@
 package services
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,35 +24,54 @@ var (
 	ErrBuildingConfigNotFound = errors.New("configuración de edificio no encontrada")
 	ErrRequirementsNotMet     = errors.New("no se cumplen los requisitos para construir")
 	ErrConstructionQueueFull  = errors.New("la cola de construcción está llena")
+	ErrBuildingNotDamaged     = errors.New("el edificio no tiene daños")
+	ErrConstructionNotFound   = errors.New("orden de construcción no encontrada")
+	ErrBuildingMinLevel       = errors.New("el edificio ya está en su nivel mínimo")
+	ErrDemolitionBlocked      = errors.New("otros edificios dependen de este nivel")
 )
 
 // Constantes para límites de construcción
 const (
 	MaxConstructionSlots    = 4 // Máximo de slots de construcción por aldea
-	ActiveConstructionSlots = 2 // Slots activos (los otros 2 se habilitan con micropagos)
+	ActiveConstructionSlots = 2 // Slots que se ganan con el ayuntamiento (los otros 2 se habilitan con micropagos)
 )
 
+const (
+	// constructionSchedulerInterval es la frecuencia con la que se completan las mejoras terminadas
+	constructionSchedulerInterval = 5 * time.Second
+	// constructionBatchSize limita las órdenes y aldeas procesadas por ciclo
+	constructionBatchSize = 200
+	// constructionLevelsPerSlot es cada cuántos niveles del ayuntamiento se gana un hueco de obras
+	constructionLevelsPerSlot = 10
+	// constructionQueueAhead es el máximo de órdenes esperando turno por aldea
+	constructionQueueAhead = 5
+	// demolitionTimeRatio es la fracción del tiempo de construcción del nivel que tarda en demolerse
+	demolitionTimeRatio = 0.5
+)
+
+// Huecos de construcción premium: habilitan los slots restantes hasta MaxConstructionSlots
+const (
+	ConstructionPremiumCost     int64 = 100
+	ConstructionPremiumDuration       = 7 * 24 * time.Hour
+)
+
+// RepairCostRatio es la fracción del coste del nivel actual que cuesta reparar un edificio destruido por completo
+const RepairCostRatio = 0.5
+
 type ConstructionService struct {
 	villageRepo        *repository.VillageRepository
 	buildingConfigRepo *repository.BuildingConfigRepository
 	researchRepo       *repository.ResearchRepository
 	allianceRepo       *repository.AllianceRepository
+	constructionRepo   *repository.ConstructionRepository
+	currencyRepo       *repository.CurrencyRepository
 	redisService       *RedisService
 	logger             *zap.Logger
 	timeZone           string
 	requirementsEngine *BuildingRequirementsEngine
 	wsManager          *websocket.Manager
-}
-
-type ConstructionQueueItem struct {
-	ID         int64     `json:"id"`
-	PlayerID   int64     `json:"player_id"`
-	VillageID  int64     `json:"village_id"`
-	BuildingID int64     `json:"building_id"`
-	Level      int       `json:"level"`
-	StartTime  time.Time `json:"start_time"`
-	EndTime    time.Time `json:"end_time"`
-	Status     string    `json:"status"` // "queued", "in_progress", "completed", "cancelled"
+	resourceService    *ResourceService
+	worldSettings      *WorldSettingsService
 }
 
 // BuildingRequirementsResultLegacy representa el resultado de verificar requisitos (formato legacy)
@@ -77,6 +98,8 @@ func NewConstructionService(
 	buildingConfigRepo *repository.BuildingConfigRepository,
 	researchRepo *repository.ResearchRepository,
 	allianceRepo *repository.AllianceRepository,
+	constructionRepo *repository.ConstructionRepository,
+	currencyRepo *repository.CurrencyRepository,
 	redisService *RedisService,
 	logger *zap.Logger,
 	timeZone string,
@@ -95,6 +118,8 @@ func NewConstructionService(
 		buildingConfigRepo: buildingConfigRepo,
 		researchRepo:       researchRepo,
 		allianceRepo:       allianceRepo,
+		constructionRepo:   constructionRepo,
+		currencyRepo:       currencyRepo,
 		redisService:       redisService,
 		logger:             logger,
 		timeZone:           timeZone,
@@ -109,6 +134,18 @@ func (s *ConstructionService) SetWebSocketManager(wsManager *websocket.Manager)
 	s.logger.Info("WebSocket manager configurado en ConstructionService")
 }
 
+// SetResourceService establece el servicio de recursos, que materializa la producción pendiente
+// antes de cada gasto y de cada cambio de nivel
+func (s *ConstructionService) SetResourceService(resourceService *ResourceService) {
+	s.resourceService = resourceService
+	s.requirementsEngine.SetResourceService(resourceService)
+}
+
+// SetWorldSettingsService establece el servicio de reglas de mundo, que aplica la velocidad de juego
+func (s *ConstructionService) SetWorldSettingsService(worldSettings *WorldSettingsService) {
+	s.worldSettings = worldSettings
+}
+
 // CheckBuildingRequirements verifica los requisitos para construir usando la nueva lógica Go
 func (s *ConstructionService) CheckBuildingRequirements(villageID uuid.UUID, buildingType string, targetLevel int) (*BuildingRequirementsResultLegacy, error) {
 	// Usar el nuevo motor de requisitos en Go
@@ -138,6 +175,9 @@ func (s *ConstructionService) UpgradeBuilding(villageID uuid.UUID, buildingType
 	if village == nil {
 		return nil, errors.New("aldea no encontrada")
 	}
+	if s.resourceService != nil {
+		s.resourceService.ProjectResources(village)
+	}
 
 	// Verificar que el edificio existe
 	building, exists := village.Buildings[buildingType]
@@ -151,7 +191,7 @@ func (s *ConstructionService) UpgradeBuilding(villageID uuid.UUID, buildingType
 	}
 
 	// Verificar límite de cola de construcción
-	canStart, err := s.canStartConstruction(villageID)
+	canStart, err := s.canStartConstruction(village)
 	if err != nil {
 		return nil, err
 	}
@@ -159,108 +199,33 @@ func (s *ConstructionService) UpgradeBuilding(villageID uuid.UUID, buildingType
 		return nil, ErrConstructionQueueFull
 	}
 
-	// Obtener el nivel máximo disponible
-	maxLevel, err := s.buildingConfigRepo.GetMaxLevel(buildingType)
-	if err != nil {
-		return nil, err
-	}
-
-	// Verificar que no esté en el nivel máximo
-	if building.Level >= maxLevel {
-		return nil, ErrBuildingMaxLevel
-	}
-
-	// Verificar requisitos usando la función avanzada
-	nextLevel := building.Level + 1
-	requirements, err := s.CheckBuildingRequirements(villageID, buildingType, nextLevel)
-	if err != nil {
-		return nil, err
-	}
-
-	if !requirements.CanBuild {
-		return nil, fmt.Errorf("%w: %v", ErrRequirementsNotMet, requirements.MissingRequirements)
-	}
-
-	// Verificar que hay suficientes recursos
-	if !s.hasEnoughResources(village.Resources, models.ResourceCostsLegacy{
-		Wood:  requirements.CostWood,
-		Stone: requirements.CostStone,
-		Food:  requirements.CostFood,
-		Gold:  requirements.CostGold,
-	}) {
-		return nil, ErrInsufficientResources
-	}
-
-	// Calcular tiempo de construcción con modificadores del ayuntamiento
-	baseTime := s.calculateConstructionTime(buildingType, nextLevel)
-	townHallLevel := s.getTownHallLevel(village)
-	constructionSpeedModifier := s.getConstructionSpeedModifier(townHallLevel)
-	upgradeTime := time.Duration(float64(baseTime) * constructionSpeedModifier)
-
 	// Usar la zona horaria configurada
 	loc, err := time.LoadLocation(s.timeZone)
 	if err != nil {
 		loc = time.UTC
 	}
 	now := time.Now().In(loc)
-	completionTime := now.Add(upgradeTime)
 
-	// Iniciar transacción para actualizar edificio y consumir recursos
-	err = s.villageRepo.UpdateBuilding(
-		villageID,
-		buildingType,
-		nextLevel,
-		true, // isUpgrading
-		&completionTime,
-	)
-	if err != nil {
-		return nil, err
+	// La mejora inmediata es una orden que entra directamente en obras
+	order := &models.ConstructionOrder{
+		ID:           uuid.New(),
+		VillageID:    villageID,
+		BuildingType: buildingType,
+		Action:       models.ConstructionActionUpgrade,
+		TargetLevel:  building.Level + 1,
+		CreatedAt:    now,
 	}
-
-	// Consumir recursos
-	newWood := village.Resources.Wood - requirements.CostWood
-	newStone := village.Resources.Stone - requirements.CostStone
-	newFood := village.Resources.Food - requirements.CostFood
-	newGold := village.Resources.Gold - requirements.CostGold
-
-	err = s.villageRepo.UpdateResources(villageID, newWood, newStone, newFood, newGold)
-	if err != nil {
+	if err := s.startOrder(village, order, now); err != nil {
 		return nil, err
 	}
 
-	s.logger.Info("Edificio mejorado iniciado",
-		zap.String("village_id", villageID.String()),
-		zap.String("building_type", buildingType),
-		zap.Int("new_level", nextLevel),
-		zap.Duration("upgrade_time", upgradeTime),
-	)
-
-	// Enviar notificación de inicio de mejora
-	costs := models.ResourceCostsLegacy{
-		Wood:  requirements.CostWood,
-		Stone: requirements.CostStone,
-		Food:  requirements.CostFood,
-		Gold:  requirements.CostGold,
-	}
-	s.sendBuildingUpgradeStarted(villageID, buildingType, nextLevel, upgradeTime, completionTime, costs)
-
 	return &models.BuildingUpgradeResultLegacy{
 		BuildingType:   buildingType,
-		NewLevel:       nextLevel,
-		UpgradeTime:    upgradeTime,
-		CompletionTime: completionTime,
-		Costs: models.ResourceCostsLegacy{
-			Wood:  requirements.CostWood,
-			Stone: requirements.CostStone,
-			Food:  requirements.CostFood,
-			Gold:  requirements.CostGold,
-		},
-		ResourcesSpent: models.ResourceCostsLegacy{
-			Wood:  requirements.CostWood,
-			Stone: requirements.CostStone,
-			Food:  requirements.CostFood,
-			Gold:  requirements.CostGold,
-		},
+		NewLevel:       order.TargetLevel,
+		UpgradeTime:    order.EndTime.Sub(*order.StartTime),
+		CompletionTime: *order.EndTime,
+		Costs:          order.Cost,
+		ResourcesSpent: order.Cost,
 	}, nil
 }
 
@@ -324,6 +289,31 @@ func (s *ConstructionService) CompleteUpgrade(villageID uuid.UUID, buildingType
 		return errors.New("la mejora aún no ha terminado")
 	}
 
+	// Las mejoras de la cola se completan con su orden y dejan paso a la siguiente
+	order, err := s.constructionRepo.GetActiveOrder(villageID, buildingType)
+	if err != nil {
+		return err
+	}
+	if order != nil {
+		if err := s.completeOrder(order); err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return err
+		}
+		if err := s.startQueuedOrders(villageID, *order.EndTime); err != nil {
+			s.logger.Error("Error iniciando órdenes en cola", zap.Error(err))
+		}
+		return nil
+	}
+
+	// Cerrar el tramo de producción del nivel anterior en el instante en que terminó la obra
+	if s.resourceService != nil {
+		if err := s.resourceService.CheckpointAt(villageID, *building.UpgradeCompletionTime); err != nil {
+			return err
+		}
+	}
+
 	// Completar la mejora
 	err = s.villageRepo.UpdateBuilding(
 		villageID,
@@ -348,6 +338,82 @@ func (s *ConstructionService) CompleteUpgrade(villageID uuid.UUID, buildingType
 	return nil
 }
 
+// RepairBuilding repara los daños de asedio de un edificio. El coste es proporcional al daño
+// respecto a la durabilidad del nivel actual.
+func (s *ConstructionService) RepairBuilding(villageID uuid.UUID, buildingType string) (*models.BuildingRepairResult, error) {
+	village, err := s.villageRepo.GetVillageByID(villageID)
+	if err != nil {
+		return nil, err
+	}
+	if village == nil {
+		return nil, errors.New("aldea no encontrada")
+	}
+
+	building, exists := village.Buildings[buildingType]
+	if !exists {
+		return nil, ErrInvalidBuildingType
+	}
+	if building.Damage <= 0 {
+		return nil, ErrBuildingNotDamaged
+	}
+	if s.resourceService != nil {
+		s.resourceService.ProjectResources(village)
+	}
+
+	config, err := s.buildingConfigRepo.GetBuildingConfig(buildingType, building.Level)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || config.Durability <= 0 {
+		return nil, ErrBuildingConfigNotFound
+	}
+
+	ratio := float64(building.Damage) / float64(config.Durability) * RepairCostRatio
+	cost := models.ResourceCostsLegacy{
+		Wood:  int(float64(config.WoodCost) * ratio),
+		Stone: int(float64(config.StoneCost) * ratio),
+		Food:  int(float64(config.FoodCost) * ratio),
+		Gold:  int(float64(config.GoldCost) * ratio),
+	}
+	// Reclamar la reparación antes de cobrar: si otra petición ya la ha hecho no se cobra dos veces
+	if err := s.villageRepo.ClearBuildingDamage(villageID, buildingType, building.Damage); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("los daños del edificio han cambiado, vuelve a intentarlo")
+		}
+		return nil, err
+	}
+	if err := s.spendResources(village, cost); err != nil {
+		if restoreErr := s.villageRepo.RestoreBuildingDamage(villageID, buildingType, building.Damage); restoreErr != nil {
+			s.logger.Error("Error restaurando daños de una reparación fallida", zap.Error(restoreErr))
+		}
+		return nil, err
+	}
+
+	s.logger.Info("Edificio reparado",
+		zap.String("village_id", villageID.String()),
+		zap.String("building_type", buildingType),
+		zap.Int("repaired_damage", building.Damage),
+	)
+
+	if s.wsManager != nil {
+		s.wsManager.SendToUser(village.Village.PlayerID.String(), "building_repaired", map[string]interface{}{
+			"village_id":      villageID.String(),
+			"building_type":   buildingType,
+			"repaired_damage": building.Damage,
+			"cost":            cost,
+			"timestamp":       time.Now().Unix(),
+		})
+	}
+
+	return &models.BuildingRepairResult{
+		BuildingType:   buildingType,
+		Level:          building.Level,
+		RepairedDamage: building.Damage,
+		Cost:           cost,
+		RepairedAt:     time.Now(),
+	}, nil
+}
+
 // GetUpgradeInfo obtiene información sobre la mejora de un edificio
 func (s *ConstructionService) GetUpgradeInfo(villageID uuid.UUID, buildingType string) (*models.BuildingUpgradeInfo, error) {
 	village, err := s.villageRepo.GetVillageByID(villageID)
@@ -374,7 +440,7 @@ func (s *ConstructionService) GetUpgradeInfo(villageID uuid.UUID, buildingType s
 	baseTime := s.calculateConstructionTime(buildingType, nextLevel)
 	townHallLevel := s.getTownHallLevel(village)
 	constructionSpeedModifier := s.getConstructionSpeedModifier(townHallLevel)
-	upgradeTime := time.Duration(float64(baseTime) * constructionSpeedModifier)
+	upgradeTime := time.Duration(float64(baseTime) * constructionSpeedModifier / s.gameSpeed(village.Village.WorldID))
 
 	return &models.BuildingUpgradeInfo{
 		BuildingType: buildingType,
@@ -391,6 +457,14 @@ func (s *ConstructionService) GetUpgradeInfo(villageID uuid.UUID, buildingType s
 	}, nil
 }
 
+// gameSpeed devuelve la velocidad de juego del mundo (1 si no hay reglas configuradas)
+func (s *ConstructionService) gameSpeed(worldID uuid.UUID) float64 {
+	if s.worldSettings == nil {
+		return 1
+	}
+	return s.worldSettings.SettingsFor(worldID).GameSpeed
+}
+
 // calculateConstructionTime calcula el tiempo base de construcción
 func (s *ConstructionService) calculateConstructionTime(buildingType string, level int) time.Duration {
 	// Obtener tiempo base del tipo de edificio
@@ -454,11 +528,17 @@ func (s *ConstructionService) validateTownHallRequirement(village *models.Villag
 	return nil
 }
 
-// GetConstructionQueue obtiene la cola de construcción de una aldea
-func (s *ConstructionService) GetConstructionQueue(villageID uuid.UUID) ([]ConstructionQueueItem, error) {
-	// Esta función podría implementarse para obtener la cola desde Redis o la BD
-	// Por ahora retornamos una lista vacía
-	return []ConstructionQueueItem{}, nil
+// GetConstructionQueue obtiene la cola de construcción de una aldea: las mejoras en obras y las
+// órdenes que esperan turno
+func (s *ConstructionService) GetConstructionQueue(villageID uuid.UUID) ([]*models.ConstructionOrder, error) {
+	queue, err := s.constructionRepo.GetVillageQueue(villageID)
+	if err != nil {
+		return nil, err
+	}
+	if queue == nil {
+		queue = []*models.ConstructionOrder{}
+	}
+	return queue, nil
 }
 
 // CancelUpgradeWithRefund cancela la mejora de un edificio y devuelve el 50% de los recursos
@@ -487,6 +567,15 @@ func (s *ConstructionService) CancelUpgradeWithRefund(villageID uuid.UUID, build
 		return nil, errors.New("el edificio no está siendo mejorado")
 	}
 
+	// Las mejoras de la cola se reembolsan en proporción al tiempo que les quedaba
+	order, err := s.constructionRepo.GetActiveOrder(villageID, buildingType)
+	if err != nil {
+		return nil, err
+	}
+	if order != nil {
+		return s.cancelOrder(village, order)
+	}
+
 	// 3. Calcular recursos gastados (nivel actual + 1)
 	targetLevel := building.Level + 1
 	config, err := s.buildingConfigRepo.GetBuildingConfig(buildingType, targetLevel)
@@ -522,13 +611,9 @@ func (s *ConstructionService) CancelUpgradeWithRefund(villageID uuid.UUID, build
 		}
 	}
 
-	// 6. Actualizar recursos (agregar reembolso)
-	newWood := village.Resources.Wood + refundAmount.Wood
-	newStone := village.Resources.Stone + refundAmount.Stone
-	newFood := village.Resources.Food + refundAmount.Food
-	newGold := village.Resources.Gold + refundAmount.Gold
-
-	err = s.villageRepo.UpdateResources(villageID, newWood, newStone, newFood, newGold)
+	// 6. Actualizar recursos (agregar reembolso). Materializa la producción antes de que la
+	// cancelación cambie el nivel vigente del edificio.
+	err = s.refundResources(village, refundAmount)
 	if err != nil {
 		return nil, fmt.Errorf("error actualizando recursos: %w", err)
 	}
@@ -572,6 +657,46 @@ func (s *ConstructionService) CancelUpgradeWithRefund(villageID uuid.UUID, build
 	return result, nil
 }
 
+// spendResources descuenta un coste de la aldea. Con el servicio de recursos el gasto se hace
+// sobre las existencias actuales y de forma atómica.
+func (s *ConstructionService) spendResources(village *models.VillageWithDetails, cost models.ResourceCostsLegacy) error {
+	return s.spendResourcesAt(village, cost, time.Now())
+}
+
+// spendResourcesAt descuenta un coste con las existencias que la aldea tenía en el instante at
+func (s *ConstructionService) spendResourcesAt(village *models.VillageWithDetails, cost models.ResourceCostsLegacy, at time.Time) error {
+	if s.resourceService != nil {
+		return s.resourceService.ConsumeResourcesAt(village.Village.ID, at, cost.Wood, cost.Stone, cost.Food, cost.Gold)
+	}
+	if !s.hasEnoughResources(village.Resources, cost) {
+		return ErrInsufficientResources
+	}
+	return s.villageRepo.UpdateResources(village.Village.ID,
+		village.Resources.Wood-cost.Wood,
+		village.Resources.Stone-cost.Stone,
+		village.Resources.Food-cost.Food,
+		village.Resources.Gold-cost.Gold,
+	)
+}
+
+// refundResources devuelve recursos a la aldea
+func (s *ConstructionService) refundResources(village *models.VillageWithDetails, amount models.ResourceCostsLegacy) error {
+	if s.resourceService != nil {
+		return s.resourceService.AddResources(village.Village.ID, models.Resources{
+			Wood:  amount.Wood,
+			Stone: amount.Stone,
+			Food:  amount.Food,
+			Gold:  amount.Gold,
+		})
+	}
+	return s.villageRepo.UpdateResources(village.Village.ID,
+		village.Resources.Wood+amount.Wood,
+		village.Resources.Stone+amount.Stone,
+		village.Resources.Food+amount.Food,
+		village.Resources.Gold+amount.Gold,
+	)
+}
+
 // ===== MÉTODOS DE NOTIFICACIÓN WEBSOCKET =====
 
 // sendBuildingUpgradeStarted envía notificación de inicio de mejora
@@ -824,6 +949,760 @@ func (s *ConstructionService) GetVillagesWithActiveUpgrades() ([]*models.Village
 	return activeVillages, nil
 }
 
+// ===== COLA DE CONSTRUCCIÓN =====
+
+// EnqueueUpgrade añade una mejora al final de la cola de la aldea. Si hay un hueco libre empieza
+// en el acto; si no, espera su turno sin cobrar nada hasta que empiece.
+func (s *ConstructionService) EnqueueUpgrade(playerID, villageID uuid.UUID, buildingType string) (*models.ConstructionOrder, error) {
+	village, err := s.ownedVillage(playerID, villageID)
+	if err != nil {
+		return nil, err
+	}
+
+	building, exists := village.Buildings[buildingType]
+	if !exists {
+		return nil, ErrInvalidBuildingType
+	}
+
+	queued, err := s.constructionRepo.GetQueuedOrders(villageID)
+	if err != nil {
+		return nil, err
+	}
+	if len(queued) >= constructionQueueAhead {
+		return nil, ErrConstructionQueueFull
+	}
+
+	// Cada orden en espera del mismo edificio sube un nivel más
+	targetLevel := building.Level + 1
+	for _, order := range queued {
+		if order.BuildingType == buildingType {
+			targetLevel++
+		}
+	}
+	maxLevel, err := s.buildingConfigRepo.GetMaxLevel(buildingType)
+	if err != nil {
+		return nil, err
+	}
+	if targetLevel > maxLevel {
+		return nil, ErrBuildingMaxLevel
+	}
+
+	order := &models.ConstructionOrder{
+		ID:           uuid.New(),
+		VillageID:    villageID,
+		BuildingType: buildingType,
+		Action:       models.ConstructionActionUpgrade,
+		TargetLevel:  targetLevel,
+		Status:       models.ConstructionStatusQueued,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.constructionRepo.CreateOrder(order); err != nil {
+		return nil, fmt.Errorf("error encolando mejora: %w", err)
+	}
+
+	s.logger.Info("Mejora encolada",
+		zap.String("village_id", villageID.String()),
+		zap.String("building_type", buildingType),
+		zap.Int("target_level", targetLevel),
+		zap.Int("position", order.Position),
+	)
+
+	if err := s.startQueuedOrders(villageID, order.CreatedAt); err != nil {
+		s.logger.Error("Error iniciando órdenes en cola", zap.Error(err))
+	}
+	return s.constructionRepo.GetOrder(order.ID)
+}
+
+// MoveOrder cambia la posición de una orden en espera y devuelve la cola resultante. Los niveles
+// objetivo se recalculan porque dos órdenes del mismo edificio pueden haber cambiado de orden.
+func (s *ConstructionService) MoveOrder(playerID, villageID, orderID uuid.UUID, position int) ([]*models.ConstructionOrder, error) {
+	village, err := s.ownedVillage(playerID, villageID)
+	if err != nil {
+		return nil, err
+	}
+
+	queued, err := s.constructionRepo.GetQueuedOrders(villageID)
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	for i, order := range queued {
+		if order.ID == orderID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("%w: solo se pueden mover las órdenes en espera", ErrConstructionNotFound)
+	}
+
+	if position < 1 {
+		position = 1
+	}
+	if position > len(queued) {
+		position = len(queued)
+	}
+	moved := queued[index]
+	queued = append(queued[:index], queued[index+1:]...)
+	queued = append(queued[:position-1], append([]*models.ConstructionOrder{moved}, queued[position-1:]...)...)
+
+	if err := s.saveQueueOrder(village, queued); err != nil {
+		return nil, fmt.Errorf("error reordenando la cola: %w", err)
+	}
+
+	// La nueva cabeza de la cola puede empezar si la anterior esperaba recursos
+	if err := s.startQueuedOrders(villageID, time.Now()); err != nil {
+		s.logger.Error("Error iniciando órdenes en cola", zap.Error(err))
+	}
+	return s.GetConstructionQueue(villageID)
+}
+
+// CancelOrder cancela una orden de la cola. Las que esperaban no habían pagado nada; las que están
+// en obras devuelven su coste en proporción al tiempo que les quedaba.
+func (s *ConstructionService) CancelOrder(playerID, villageID, orderID uuid.UUID) (*models.CancelUpgradeResult, error) {
+	village, err := s.ownedVillage(playerID, villageID)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := s.constructionRepo.GetOrder(orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil || order.VillageID != villageID || !order.Pending() {
+		return nil, ErrConstructionNotFound
+	}
+	return s.cancelOrder(village, order)
+}
+
+// cancelOrder cancela una orden pendiente, reembolsa la parte proporcional y reorganiza la cola
+func (s *ConstructionService) cancelOrder(village *models.VillageWithDetails, order *models.ConstructionOrder) (*models.CancelUpgradeResult, error) {
+	now := time.Now()
+	villageID := village.Village.ID
+	wasBuilding := order.Status == models.ConstructionStatusBuilding
+
+	result := &models.CancelUpgradeResult{
+		BuildingType: order.BuildingType,
+		OriginalCost: order.Cost,
+		CancelledAt:  now,
+		RefundReason: "Cancelación voluntaria por el jugador",
+	}
+	if wasBuilding && order.EndTime != nil && !now.Before(*order.EndTime) {
+		return nil, errors.New("la obra ya ha terminado")
+	}
+	// Una demolición cancelada no cobra ni devuelve nada: el edificio conserva su nivel
+	if wasBuilding && !order.IsDemolition() && order.StartTime != nil && order.EndTime != nil {
+		ratio := 1.0
+		if total := order.EndTime.Sub(*order.StartTime); total > 0 {
+			ratio = float64(order.EndTime.Sub(now)) / float64(total)
+		}
+		result.TimeRemaining = order.EndTime.Sub(now)
+		result.RefundPercentage = ratio * 100
+		result.RefundAmount = models.ResourceCostsLegacy{
+			Wood:  int(float64(order.Cost.Wood) * ratio),
+			Stone: int(float64(order.Cost.Stone) * ratio),
+			Food:  int(float64(order.Cost.Food) * ratio),
+			Gold:  int(float64(order.Cost.Gold) * ratio),
+		}
+	}
+
+	if err := s.constructionRepo.CancelOrder(order); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrConstructionNotFound
+		}
+		return nil, fmt.Errorf("error cancelando orden: %w", err)
+	}
+
+	if wasBuilding {
+		// El reembolso se materializa mientras el edificio aún cuenta con el nivel anterior
+		if err := s.refundResources(village, result.RefundAmount); err != nil {
+			return nil, fmt.Errorf("error actualizando recursos: %w", err)
+		}
+		restoredLevel := order.TargetLevel - 1
+		if order.IsDemolition() {
+			restoredLevel = order.TargetLevel + 1
+		}
+		if err := s.villageRepo.UpdateBuilding(villageID, order.BuildingType, restoredLevel, false, nil); err != nil {
+			return nil, fmt.Errorf("error cancelando mejora: %w", err)
+		}
+		s.sendBuildingUpgradeCancelled(villageID, order.BuildingType, result.RefundAmount, result.RefundPercentage)
+	}
+
+	s.logger.Info("Orden de construcción cancelada",
+		zap.String("village_id", villageID.String()),
+		zap.String("order_id", order.ID.String()),
+		zap.String("building_type", order.BuildingType),
+		zap.Bool("was_building", wasBuilding),
+		zap.Float64("refund_percentage", result.RefundPercentage),
+	)
+
+	if err := s.renumberQueue(villageID); err != nil {
+		s.logger.Error("Error reorganizando la cola de construcción", zap.Error(err))
+	}
+	if wasBuilding {
+		if err := s.startQueuedOrders(villageID, now); err != nil {
+			s.logger.Error("Error iniciando órdenes en cola", zap.Error(err))
+		}
+	}
+	return result, nil
+}
+
+// BuyPremiumSlots compra con moneda global los huecos de construcción premium del jugador para
+// todas sus aldeas. Si ya los tiene, se prolongan.
+func (s *ConstructionService) BuyPremiumSlots(playerID uuid.UUID) (*time.Time, error) {
+	now := time.Now()
+	start := now
+	if until := s.premiumUntil(playerID); until != nil {
+		start = *until
+	}
+
+	if err := s.currencyRepo.SpendGlobalCurrency(playerID, ConstructionPremiumCost, "Huecos de construcción premium"); err != nil {
+		return nil, err
+	}
+	until := start.Add(ConstructionPremiumDuration)
+	if err := s.constructionRepo.SetPremiumUntil(playerID, until); err != nil {
+		return nil, fmt.Errorf("error activando huecos premium: %w", err)
+	}
+
+	s.logger.Info("Huecos de construcción premium comprados",
+		zap.String("player_id", playerID.String()),
+		zap.Time("until", until),
+	)
+
+	// Los huecos nuevos los ocupan las órdenes que esperaban en las aldeas del jugador
+	villages, err := s.villageRepo.GetVillagesByPlayerID(playerID)
+	if err != nil {
+		s.logger.Error("Error obteniendo aldeas del jugador", zap.Error(err))
+		return &until, nil
+	}
+	for _, village := range villages {
+		if err := s.startQueuedOrders(village.Village.ID, now); err != nil {
+			s.logger.Error("Error iniciando órdenes en cola", zap.Error(err))
+		}
+	}
+	return &until, nil
+}
+
+// StartConstructionScheduler inicia el proceso periódico de la cola de construcción hasta que se cancela el contexto
+func (s *ConstructionService) StartConstructionScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(constructionSchedulerInterval)
+		defer ticker.Stop()
+
+		s.logger.Info("Planificador de construcción iniciado",
+			zap.Duration("interval", constructionSchedulerInterval),
+		)
+
+		for {
+			select {
+			case <-ctx.Done():
+				s.logger.Info("Planificador de construcción detenido")
+				return
+			case <-ticker.C:
+				s.ProcessDueConstruction()
+			}
+		}
+	}()
+}
+
+// ProcessDueConstruction completa las mejoras terminadas, arranca la siguiente orden de cada
+// aldea en el instante en que terminó la anterior y reintenta las que esperaban recursos
+func (s *ConstructionService) ProcessDueConstruction() {
+	now := time.Now()
+
+	orders, err := s.constructionRepo.GetDueOrders(now, constructionBatchSize)
+	if err != nil {
+		s.logger.Error("Error obteniendo órdenes de construcción", zap.Error(err))
+		return
+	}
+	for _, order := range orders {
+		if err := s.completeOrder(order); err != nil {
+			if err != sql.ErrNoRows {
+				s.logger.Error("Error completando mejora", zap.String("order_id", order.ID.String()), zap.Error(err))
+			}
+			continue
+		}
+		if err := s.startQueuedOrders(order.VillageID, *order.EndTime); err != nil {
+			s.logger.Error("Error iniciando órdenes en cola", zap.Error(err))
+		}
+	}
+
+	villageIDs, err := s.constructionRepo.GetVillagesWithQueuedOrders(constructionBatchSize)
+	if err != nil {
+		s.logger.Error("Error obteniendo aldeas con órdenes en cola", zap.Error(err))
+		return
+	}
+	for _, villageID := range villageIDs {
+		if err := s.startQueuedOrders(villageID, now); err != nil {
+			s.logger.Error("Error iniciando órdenes en cola", zap.String("village_id", villageID.String()), zap.Error(err))
+		}
+	}
+}
+
+// startOrder cobra el coste del nivel objetivo en startAt y pone el edificio en obras. Las órdenes
+// nuevas se registran ya en obras; las que esperaban en la cola pasan a obras. La orden se reclama
+// antes de cobrar, así que devuelve sql.ErrNoRows sin cobrar nada si otra pasada ya la arrancó.
+func (s *ConstructionService) startOrder(village *models.VillageWithDetails, order *models.ConstructionOrder, startAt time.Time) error {
+	villageID := village.Village.ID
+
+	maxLevel, err := s.buildingConfigRepo.GetMaxLevel(order.BuildingType)
+	if err != nil {
+		return err
+	}
+	if order.TargetLevel > maxLevel {
+		return ErrBuildingMaxLevel
+	}
+
+	requirements, err := s.CheckBuildingRequirements(villageID, order.BuildingType, order.TargetLevel)
+	if err != nil {
+		return err
+	}
+	if !requirements.CanBuild {
+		// La falta de recursos se comprueba al cobrar, con las existencias de startAt
+		var missing []string
+		for _, requirement := range requirements.MissingRequirements {
+			if requirement != ErrInsufficientResources.Error() {
+				missing = append(missing, requirement)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("%w: %v", ErrRequirementsNotMet, missing)
+		}
+	}
+	cost := models.ResourceCostsLegacy{
+		Wood:  requirements.CostWood,
+		Stone: requirements.CostStone,
+		Food:  requirements.CostFood,
+		Gold:  requirements.CostGold,
+	}
+
+	// Calcular tiempo de construcción con modificadores del ayuntamiento
+	baseTime := s.calculateConstructionTime(order.BuildingType, order.TargetLevel)
+	constructionSpeedModifier := s.getConstructionSpeedModifier(s.getTownHallLevel(village))
+	upgradeTime := time.Duration(float64(baseTime) * constructionSpeedModifier / s.gameSpeed(village.Village.WorldID))
+	completionTime := startAt.Add(upgradeTime)
+
+	// Reclamar la orden antes de cobrar: si otra pasada ya la ha arrancado no se cobra dos veces
+	isNew := order.Status == ""
+	order.Status = models.ConstructionStatusBuilding
+	order.Cost = cost
+	order.StartTime = &startAt
+	order.EndTime = &completionTime
+	err = s.constructionRepo.ClaimOrder(order, s.constructionSlots(village), isNew)
+	if err != nil {
+		if !isNew {
+			order.Status = models.ConstructionStatusQueued
+		}
+		if errors.Is(err, repository.ErrConstructionSlotsFull) {
+			return ErrConstructionQueueFull
+		}
+		return err
+	}
+
+	// Consumir recursos; si no alcanzan o la mejora no se puede registrar, la orden se libera
+	if err := s.spendResourcesAt(village, cost, startAt); err != nil {
+		s.releaseOrder(order, isNew)
+		if errors.Is(err, ErrInsufficientResources) {
+			return ErrInsufficientResources
+		}
+		return err
+	}
+	if err := s.villageRepo.UpdateBuilding(villageID, order.BuildingType, order.TargetLevel, true, &completionTime); err != nil {
+		if refundErr := s.refundResources(village, cost); refundErr != nil {
+			s.logger.Error("Error devolviendo recursos de una mejora fallida", zap.Error(refundErr))
+		}
+		s.releaseOrder(order, isNew)
+		return err
+	}
+
+	// Mantener la aldea en memoria al día para las siguientes órdenes del mismo ciclo
+	if building, exists := village.Buildings[order.BuildingType]; exists {
+		building.Level = order.TargetLevel
+		building.IsUpgrading = true
+		building.UpgradeCompletionTime = &completionTime
+	}
+
+	s.logger.Info("Edificio mejorado iniciado",
+		zap.String("village_id", villageID.String()),
+		zap.String("building_type", order.BuildingType),
+		zap.Int("new_level", order.TargetLevel),
+		zap.Duration("upgrade_time", upgradeTime),
+		zap.Time("start_time", startAt),
+	)
+
+	// Enviar notificación de inicio de mejora
+	s.sendBuildingUpgradeStarted(villageID, order.BuildingType, order.TargetLevel, upgradeTime, completionTime, cost)
+	return nil
+}
+
+// releaseOrder deshace la reclamación de una orden cuya obra no se pudo iniciar: las órdenes de la
+// cola vuelven a esperar y las nuevas se eliminan
+func (s *ConstructionService) releaseOrder(order *models.ConstructionOrder, isNew bool) {
+	var err error
+	if isNew {
+		err = s.constructionRepo.DeleteOrder(order)
+	} else {
+		err = s.constructionRepo.ReleaseOrder(order)
+	}
+	if err != nil {
+		s.logger.Error("Error liberando orden de construcción", zap.String("order_id", order.ID.String()), zap.Error(err))
+	}
+}
+
+// completeOrder termina una obra. Devuelve sql.ErrNoRows si ya se había completado.
+func (s *ConstructionService) completeOrder(order *models.ConstructionOrder) error {
+	// Cerrar el tramo de producción del nivel anterior en el instante en que terminó la obra
+	if s.resourceService != nil {
+		if err := s.resourceService.CheckpointAt(order.VillageID, *order.EndTime); err != nil {
+			return err
+		}
+	}
+	if err := s.constructionRepo.CompleteOrder(order); err != nil {
+		return err
+	}
+	if order.IsDemolition() {
+		return s.finishDemolition(order)
+	}
+
+	s.logger.Info("Mejora de edificio completada",
+		zap.String("village_id", order.VillageID.String()),
+		zap.String("building_type", order.BuildingType),
+		zap.Int("new_level", order.TargetLevel),
+	)
+	s.sendBuildingUpgradeCompleted(order.VillageID, order.BuildingType, order.TargetLevel)
+	return nil
+}
+
+// startQueuedOrders ocupa los huecos libres de la aldea con las órdenes en espera, en orden de
+// cola. Una orden sin recursos bloquea a las siguientes para respetar la prioridad del jugador;
+// las de un edificio que sigue en obras esperan sin bloquear.
+func (s *ConstructionService) startQueuedOrders(villageID uuid.UUID, startAt time.Time) error {
+	queued, err := s.constructionRepo.GetQueuedOrders(villageID)
+	if err != nil || len(queued) == 0 {
+		return err
+	}
+	village, err := s.villageRepo.GetVillageByID(villageID)
+	if err != nil || village == nil {
+		return err
+	}
+
+	free := s.constructionSlots(village) - s.activeConstructions(village, startAt)
+	dropped := false
+	for _, order := range queued {
+		if free <= 0 {
+			break
+		}
+		building, exists := village.Buildings[order.BuildingType]
+		if !exists {
+			s.dropOrder(village, order, ErrInvalidBuildingType.Error())
+			dropped = true
+			continue
+		}
+		if building.IsUpgrading {
+			continue
+		}
+
+		start := startAt
+		if order.CreatedAt.After(start) {
+			start = order.CreatedAt
+		}
+		order.TargetLevel = building.Level + 1
+
+		err := s.startOrder(village, order, start)
+		switch {
+		case err == nil:
+			free--
+		case err == sql.ErrNoRows:
+			// Otra pasada ya ha arrancado la orden y ocupa el hueco
+			free--
+		case errors.Is(err, ErrInsufficientResources), errors.Is(err, ErrConstructionQueueFull):
+			free = 0
+		case errors.Is(err, ErrRequirementsNotMet), errors.Is(err, ErrBuildingMaxLevel):
+			s.dropOrder(village, order, err.Error())
+			dropped = true
+		default:
+			return err
+		}
+	}
+
+	if dropped {
+		return s.renumberQueue(villageID)
+	}
+	return nil
+}
+
+// dropOrder cancela una orden en espera que ya no se puede construir. No había pagado nada.
+func (s *ConstructionService) dropOrder(village *models.VillageWithDetails, order *models.ConstructionOrder, reason string) {
+	if err := s.constructionRepo.CancelOrder(order); err != nil {
+		s.logger.Error("Error descartando orden de construcción", zap.String("order_id", order.ID.String()), zap.Error(err))
+		return
+	}
+
+	s.logger.Warn("Orden de construcción descartada",
+		zap.String("village_id", village.Village.ID.String()),
+		zap.String("building_type", order.BuildingType),
+		zap.Int("target_level", order.TargetLevel),
+		zap.String("reason", reason),
+	)
+
+	if s.wsManager != nil {
+		s.wsManager.SendToUser(village.Village.PlayerID.String(), "construction_order_dropped", map[string]interface{}{
+			"village_id":    village.Village.ID.String(),
+			"order_id":      order.ID.String(),
+			"building_type": order.BuildingType,
+			"target_level":  order.TargetLevel,
+			"reason":        reason,
+			"timestamp":     time.Now().Unix(),
+		})
+	}
+}
+
+// renumberQueue recalcula posiciones y niveles objetivo de las órdenes en espera
+func (s *ConstructionService) renumberQueue(villageID uuid.UUID) error {
+	queued, err := s.constructionRepo.GetQueuedOrders(villageID)
+	if err != nil || len(queued) == 0 {
+		return err
+	}
+	village, err := s.villageRepo.GetVillageByID(villageID)
+	if err != nil || village == nil {
+		return err
+	}
+	return s.saveQueueOrder(village, queued)
+}
+
+// saveQueueOrder guarda las órdenes en espera en el orden dado. El nivel objetivo de cada una es
+// el siguiente al del edificio contando las órdenes del mismo edificio que van delante.
+func (s *ConstructionService) saveQueueOrder(village *models.VillageWithDetails, queued []*models.ConstructionOrder) error {
+	levels := make(map[string]int)
+	for i, order := range queued {
+		level, ok := levels[order.BuildingType]
+		if !ok {
+			if building, exists := village.Buildings[order.BuildingType]; exists {
+				level = building.Level
+			}
+		}
+		level++
+		levels[order.BuildingType] = level
+
+		order.Position = i + 1
+		order.TargetLevel = level
+	}
+	return s.constructionRepo.UpdateQueuedOrders(queued)
+}
+
+// constructionSlots devuelve cuántas mejoras puede tener la aldea en obras a la vez: un hueco más
+// cada constructionLevelsPerSlot niveles de ayuntamiento, más los huecos premium del jugador
+func (s *ConstructionService) constructionSlots(village *models.VillageWithDetails) int {
+	slots := 1 + s.getTownHallLevel(village)/constructionLevelsPerSlot
+	if slots > ActiveConstructionSlots {
+		slots = ActiveConstructionSlots
+	}
+	if s.premiumUntil(village.Village.PlayerID) != nil {
+		slots += MaxConstructionSlots - ActiveConstructionSlots
+	}
+	return slots
+}
+
+// premiumUntil devuelve el fin de los huecos premium del jugador (nil si no los tiene activos)
+func (s *ConstructionService) premiumUntil(playerID uuid.UUID) *time.Time {
+	until, err := s.constructionRepo.GetPremiumUntil(playerID)
+	if err != nil {
+		s.logger.Warn("Error obteniendo huecos premium del jugador", zap.Error(err))
+		return nil
+	}
+	if until == nil || !until.After(time.Now()) {
+		return nil
+	}
+	return until
+}
+
+// activeConstructions cuenta las mejoras que siguen en obras en el instante at
+func (s *ConstructionService) activeConstructions(village *models.VillageWithDetails, at time.Time) int {
+	count := 0
+	for _, building := range village.Buildings {
+		if building.IsUpgrading && building.UpgradeCompletionTime != nil && building.UpgradeCompletionTime.After(at) {
+			count++
+		}
+	}
+	return count
+}
+
+// ownedVillage obtiene una aldea comprobando que pertenece al jugador
+func (s *ConstructionService) ownedVillage(playerID, villageID uuid.UUID) (*models.VillageWithDetails, error) {
+	village, err := s.villageRepo.GetVillageByID(villageID)
+	if err != nil {
+		return nil, err
+	}
+	if village == nil || village.Village.PlayerID != playerID {
+		return nil, fmt.Errorf("la aldea no pertenece al jugador")
+	}
+	return village, nil
+}
+
+// ===== DEMOLICIÓN =====
+
+// DemolishBuilding baja un edificio un nivel. La obra ocupa un hueco de construcción y, al
+// terminar, devuelve la fracción del coste del nivel que fijan las reglas del mundo. Se rechaza
+// si otro edificio, o una mejora en cola, depende del nivel que se pierde.
+func (s *ConstructionService) DemolishBuilding(playerID, villageID uuid.UUID, buildingType string) (*models.ConstructionOrder, error) {
+	village, err := s.ownedVillage(playerID, villageID)
+	if err != nil {
+		return nil, err
+	}
+
+	building, exists := village.Buildings[buildingType]
+	if !exists {
+		return nil, ErrInvalidBuildingType
+	}
+	minLevel := 0
+	if buildingType == "town_hall" {
+		minLevel = 1
+	}
+	if building.Level <= minLevel {
+		return nil, ErrBuildingMinLevel
+	}
+	if building.IsUpgrading {
+		return nil, ErrBuildingUpgrading
+	}
+
+	canStart, err := s.canStartConstruction(village)
+	if err != nil {
+		return nil, err
+	}
+	if !canStart {
+		return nil, ErrConstructionQueueFull
+	}
+
+	// Las mejoras en cola también cuentan: tras la demolición deben poder seguir construyéndose
+	queued, err := s.constructionRepo.GetQueuedOrders(villageID)
+	if err != nil {
+		return nil, err
+	}
+	plannedLevels := make(map[string]int)
+	for _, order := range queued {
+		if order.BuildingType == buildingType {
+			return nil, fmt.Errorf("%w: cancela primero las mejoras en cola de %s", ErrDemolitionBlocked, buildingType)
+		}
+		if order.TargetLevel > plannedLevels[order.BuildingType] {
+			plannedLevels[order.BuildingType] = order.TargetLevel
+		}
+	}
+	newLevel := building.Level - 1
+	if broken := s.requirementsEngine.CheckDemolitionRequirements(village, buildingType, newLevel, plannedLevels); len(broken) > 0 {
+		return nil, fmt.Errorf("%w: %v", ErrDemolitionBlocked, broken)
+	}
+
+	config, err := s.buildingConfigRepo.GetBuildingConfig(buildingType, building.Level)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, ErrBuildingConfigNotFound
+	}
+	ratio := s.demolitionRefund(village.Village.WorldID)
+	refund := models.ResourceCostsLegacy{
+		Wood:  int(float64(config.WoodCost) * ratio),
+		Stone: int(float64(config.StoneCost) * ratio),
+		Food:  int(float64(config.FoodCost) * ratio),
+		Gold:  int(float64(config.GoldCost) * ratio),
+	}
+
+	baseTime := s.calculateConstructionTime(buildingType, building.Level)
+	constructionSpeedModifier := s.getConstructionSpeedModifier(s.getTownHallLevel(village))
+	demolitionTime := time.Duration(float64(baseTime) * demolitionTimeRatio * constructionSpeedModifier / s.gameSpeed(village.Village.WorldID))
+	now := time.Now()
+	completionTime := now.Add(demolitionTime)
+
+	// El edificio deja de rendir su último nivel en cuanto empieza la demolición: se cierra antes
+	// el tramo de producción con el nivel completo
+	if s.resourceService != nil {
+		if err := s.resourceService.CheckpointAt(villageID, now); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.villageRepo.UpdateBuilding(villageID, buildingType, building.Level, true, &completionTime); err != nil {
+		return nil, err
+	}
+
+	order := &models.ConstructionOrder{
+		ID:           uuid.New(),
+		VillageID:    villageID,
+		BuildingType: buildingType,
+		Action:       models.ConstructionActionDemolish,
+		TargetLevel:  newLevel,
+		Status:       models.ConstructionStatusBuilding,
+		Cost:         refund,
+		StartTime:    &now,
+		EndTime:      &completionTime,
+		CreatedAt:    now,
+	}
+	if err := s.constructionRepo.CreateOrder(order); err != nil {
+		// Sin orden no habría quien terminase la demolición: el edificio vuelve a estar disponible
+		if restoreErr := s.villageRepo.UpdateBuilding(villageID, buildingType, building.Level, false, nil); restoreErr != nil {
+			s.logger.Error("Error restaurando edificio tras una demolición fallida", zap.Error(restoreErr))
+		}
+		return nil, fmt.Errorf("error registrando demolición: %w", err)
+	}
+
+	s.logger.Info("Demolición iniciada",
+		zap.String("village_id", villageID.String()),
+		zap.String("building_type", buildingType),
+		zap.Int("new_level", newLevel),
+		zap.Duration("demolition_time", demolitionTime),
+	)
+
+	if s.wsManager != nil {
+		s.wsManager.SendToUser(playerID.String(), "building_demolition_started", map[string]interface{}{
+			"village_id":      villageID.String(),
+			"building_type":   buildingType,
+			"target_level":    newLevel,
+			"completion_time": completionTime.Unix(),
+			"refund":          refund,
+			"timestamp":       now.Unix(),
+		})
+	}
+	return order, nil
+}
+
+// finishDemolition abona el reembolso de una demolición terminada y avisa al jugador
+func (s *ConstructionService) finishDemolition(order *models.ConstructionOrder) error {
+	village, err := s.villageRepo.GetVillageByID(order.VillageID)
+	if err != nil {
+		return err
+	}
+	if village == nil {
+		return errors.New("aldea no encontrada")
+	}
+	if err := s.refundResources(village, order.Cost); err != nil {
+		return fmt.Errorf("error abonando reembolso de demolición: %w", err)
+	}
+
+	s.logger.Info("Demolición completada",
+		zap.String("village_id", order.VillageID.String()),
+		zap.String("building_type", order.BuildingType),
+		zap.Int("new_level", order.TargetLevel),
+	)
+
+	if s.wsManager != nil {
+		s.wsManager.SendToUser(village.Village.PlayerID.String(), "building_demolished", map[string]interface{}{
+			"village_id":    order.VillageID.String(),
+			"building_type": order.BuildingType,
+			"new_level":     order.TargetLevel,
+			"refund":        order.Cost,
+			"timestamp":     time.Now().Unix(),
+		})
+	}
+	return nil
+}
+
+// demolitionRefund devuelve la fracción del coste que se reembolsa al demoler en un mundo
+func (s *ConstructionService) demolitionRefund(worldID uuid.UUID) float64 {
+	if s.worldSettings == nil {
+		return models.DefaultWorldSettings(worldID, "normal").DemolitionRefund
+	}
+	return s.worldSettings.SettingsFor(worldID).DemolitionRefund
+}
+
 // ===== MÉTODOS DE GESTIÓN DE COLA DE CONSTRUCCIÓN =====
 
 // getActiveConstructionCount cuenta cuántos edificios están siendo mejorados en una aldea
@@ -910,21 +1789,20 @@ func (s *ConstructionService) getActiveConstructionCount(villageID uuid.UUID) (i
 		zap.Int("total_upgrading", totalUpgrading),
 		zap.Int("completed_upgrades", completedUpgrades),
 		zap.Int("invalid_upgrades", invalidUpgrades),
-		zap.Int("available_slots", ActiveConstructionSlots-count),
 	)
 
 	return count, nil
 }
 
 // canStartConstruction verifica si se puede iniciar una nueva construcción
-func (s *ConstructionService) canStartConstruction(villageID uuid.UUID) (bool, error) {
-	activeCount, err := s.getActiveConstructionCount(villageID)
+func (s *ConstructionService) canStartConstruction(village *models.VillageWithDetails) (bool, error) {
+	activeCount, err := s.getActiveConstructionCount(village.Village.ID)
 	if err != nil {
 		return false, err
 	}
 
 	// Verificar si hay slots disponibles
-	return activeCount < ActiveConstructionSlots, nil
+	return activeCount < s.constructionSlots(village), nil
 }
 
 // GetConstructionQueueStatus obtiene el estado actual de la cola de construcción
@@ -965,12 +1843,21 @@ func (s *ConstructionService) GetConstructionQueueStatus(villageID uuid.UUID) (*
 		}
 	}
 
+	queued, err := s.constructionRepo.GetQueuedOrders(villageID)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := s.constructionSlots(village)
 	return &ConstructionQueueStatus{
-		ActiveSlots:             ActiveConstructionSlots,
+		ActiveSlots:             slots,
 		MaxSlots:                MaxConstructionSlots,
-		AvailableSlots:          ActiveConstructionSlots - activeCount,
+		AvailableSlots:          slots - activeCount,
 		BuildingsInConstruction: buildingsInConstruction,
-		CanStartConstruction:    activeCount < ActiveConstructionSlots,
+		CanStartConstruction:    activeCount < slots,
+		QueuedOrders:            len(queued),
+		QueueCapacity:           constructionQueueAhead,
+		PremiumUntil:            s.premiumUntil(village.Village.PlayerID),
 	}, nil
 }
 
@@ -981,6 +1868,9 @@ type ConstructionQueueStatus struct {
 	AvailableSlots          int                        `json:"available_slots"`
 	BuildingsInConstruction []BuildingConstructionInfo `json:"buildings_in_construction"`
 	CanStartConstruction    bool                       `json:"can_start_construction"`
+	QueuedOrders            int                        `json:"queued_orders"`
+	QueueCapacity           int                        `json:"queue_capacity"`
+	PremiumUntil            *time.Time                 `json:"premium_until,omitempty"`
 }
 
 // BuildingConstructionInfo representa información de un edificio en construcción
@@ -352,6 +352,15 @@ func (s *EconomyService) validateEconomyConfig(config *models.EconomySystemConfi
 	if config.MaxExchangeAmount <= config.MinExchangeAmount {
 		return fmt.Errorf("cantidad máxima debe ser mayor a la mínima")
 	}
+	if config.MarketMaker.Fee < 0 || config.MarketMaker.Fee >= 1 {
+		return fmt.Errorf("comisión del mercader debe estar entre 0 y 1")
+	}
+	if config.MarketMaker.InitialReserve <= 0 || config.MarketMaker.InitialGoldReserve <= 0 {
+		return fmt.Errorf("reservas iniciales del mercader deben ser mayores a 0")
+	}
+	if config.MarketMaker.MaxPriceImpact <= 0 || config.MarketMaker.MaxPriceImpact > 1 {
+		return fmt.Errorf("impacto máximo del mercader debe estar entre 0 y 1")
+	}
 	return nil
 }
 
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"server-backend/models"
+	"server-backend/repository"
+	"server-backend/websocket"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var ErrInvalidCandleInterval = errors.New("intervalo de velas inválido")
+
+const (
+	// maxOpenMarketOrders limita las órdenes abiertas de un jugador en la bolsa
+	maxOpenMarketOrders = 50
+	// orderBookDepth es el número de niveles de precio por lado que se muestran del libro
+	orderBookDepth = 20
+	// marketStatsWindow es la ventana de tiempo de los precios y estadísticas del mercado
+	marketStatsWindow = 24 * time.Hour
+	// candleAggregatorInterval es la frecuencia con la que se actualizan las velas de precio
+	candleAggregatorInterval = 15 * time.Second
+	// candleSettleLag es el margen con el que se recalculan las últimas velas para recoger cruces
+	// confirmados después de su marca de tiempo
+	candleSettleLag = time.Minute
+	// candlePruneInterval es la frecuencia con la que se eliminan las velas caducadas
+	candlePruneInterval = time.Hour
+	// maxCandles limita las velas devueltas por consulta
+	maxCandles = 1000
+)
+
+// candleRetention es el tiempo que se conservan las velas de cada intervalo; las diarias se
+// conservan siempre
+var candleRetention = map[string]time.Duration{
+	models.CandleInterval1m: 7 * 24 * time.Hour,
+	models.CandleInterval1h: 90 * 24 * time.Hour,
+	models.CandleInterval1d: 0,
+}
+
+// ExchangeService gestiona la bolsa de recursos de cada mundo: un libro de órdenes limitadas de
+// compra y venta por recurso, cotizado en oro. Las órdenes se casan al colocarse y los cruces se
+// liquidan al momento en las aldeas de ambas partes, porque el depósito ya está en la bolsa y no
+// hace falta que viajen mercaderes. Los cruces se agregan en segundo plano en velas de precio que
+// alimentan los precios y los gráficos del mercado.
+type ExchangeService struct {
+	exchangeRepo     *repository.ExchangeRepository
+	priceHistoryRepo *repository.PriceHistoryRepository
+	villageRepo      *repository.VillageRepository
+	resourceService  *ResourceService
+	wsManager        *websocket.Manager
+	logger           *zap.Logger
+}
+
+func NewExchangeService(exchangeRepo *repository.ExchangeRepository, priceHistoryRepo *repository.PriceHistoryRepository, villageRepo *repository.VillageRepository, resourceService *ResourceService, logger *zap.Logger) *ExchangeService {
+	return &ExchangeService{
+		exchangeRepo:     exchangeRepo,
+		priceHistoryRepo: priceHistoryRepo,
+		villageRepo:      villageRepo,
+		resourceService:  resourceService,
+		logger:           logger,
+	}
+}
+
+// SetWebSocketManager configura el WebSocket manager
+func (s *ExchangeService) SetWebSocketManager(wsManager *websocket.Manager) {
+	s.wsManager = wsManager
+}
+
+// PlaceOrder coloca una orden limitada en el libro del mundo de la aldea y la casa contra las
+// órdenes contrarias. Lo que no se cruza queda abierto en el libro.
+func (s *ExchangeService) PlaceOrder(playerID uuid.UUID, req *models.PlaceMarketOrderRequest) (*models.MarketOrderResult, error) {
+	if req.Side != models.MarketOrderBuy && req.Side != models.MarketOrderSell {
+		return nil, repository.ErrMarketOrderInvalid
+	}
+
+	village, err := s.villageRepo.GetVillageByID(req.VillageID)
+	if err != nil {
+		return nil, err
+	}
+	if village == nil || village.Village.PlayerID != playerID {
+		return nil, repository.ErrTradeVillageNotOwned
+	}
+
+	// Las aldeas de las órdenes del libro se materializan al cobrar su propio depósito; sus
+	// ganancias se suman a los recursos guardados sin tocar la producción pendiente
+	if err := s.resourceService.UpdateResources(req.VillageID); err != nil {
+		return nil, err
+	}
+
+	order := &models.MarketOrder{
+		WorldID:      village.Village.WorldID,
+		PlayerID:     playerID,
+		VillageID:    req.VillageID,
+		ResourceType: req.ResourceType,
+		Side:         req.Side,
+		Price:        req.Price,
+		Quantity:     req.Quantity,
+	}
+	fills, err := s.exchangeRepo.PlaceOrder(order, maxOpenMarketOrders)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Orden colocada en la bolsa",
+		zap.String("order_id", order.ID.String()),
+		zap.String("player_id", playerID.String()),
+		zap.String("resource_type", order.ResourceType),
+		zap.String("side", order.Side),
+		zap.Int("price", order.Price),
+		zap.Int("quantity", order.Quantity),
+		zap.Int("filled", order.Filled),
+	)
+	for _, fill := range fills {
+		s.notifyFill(fill)
+	}
+
+	if fills == nil {
+		fills = []*models.TradeTransaction{}
+	}
+	return &models.MarketOrderResult{Order: order, Fills: fills}, nil
+}
+
+// CancelOrder cancela una orden abierta del jugador y le devuelve lo que quedaba en depósito
+func (s *ExchangeService) CancelOrder(playerID, orderID uuid.UUID) (*models.MarketOrder, error) {
+	return s.exchangeRepo.CancelOrder(orderID, playerID)
+}
+
+// GetPlayerOrders obtiene las órdenes abiertas del jugador
+func (s *ExchangeService) GetPlayerOrders(playerID uuid.UUID) ([]*models.MarketOrder, error) {
+	return s.exchangeRepo.GetPlayerOrders(playerID)
+}
+
+// GetOrderBook obtiene la profundidad del libro de un recurso en un mundo
+func (s *ExchangeService) GetOrderBook(worldID uuid.UUID, resourceType string) (*models.OrderBook, error) {
+	if !isTradeResource(resourceType) {
+		return nil, repository.ErrTradeInvalidResource
+	}
+	return s.exchangeRepo.GetOrderBook(worldID, resourceType, orderBookDepth)
+}
+
+// GetResourcePrices obtiene de las velas el último precio de cada recurso del mundo con su
+// variación y volumen en las últimas 24 horas
+func (s *ExchangeService) GetResourcePrices(worldID uuid.UUID) ([]models.ResourcePrice, error) {
+	return s.priceHistoryRepo.GetResourcePrices(worldID, time.Now().Add(-marketStatsWindow))
+}
+
+// GetMarketStats obtiene las estadísticas de las últimas 24 horas de cada recurso del mundo
+func (s *ExchangeService) GetMarketStats(worldID uuid.UUID) ([]models.MarketStats, error) {
+	return s.exchangeRepo.GetMarketStats(worldID, time.Now().Add(-marketStatsWindow))
+}
+
+// GetCandles obtiene las velas de un recurso del mundo en el intervalo indicado (1m, 1h o 1d)
+func (s *ExchangeService) GetCandles(worldID uuid.UUID, resourceType, interval string, from, to time.Time) ([]*models.PriceCandle, error) {
+	if !isTradeResource(resourceType) {
+		return nil, repository.ErrTradeInvalidResource
+	}
+	if _, ok := candleRetention[interval]; !ok {
+		return nil, ErrInvalidCandleInterval
+	}
+	return s.priceHistoryRepo.GetCandles(worldID, resourceType, interval, from, to, maxCandles)
+}
+
+// StartCandleAggregator inicia la agregación periódica de los cruces en velas de precio hasta que se cancela el contexto
+func (s *ExchangeService) StartCandleAggregator(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(candleAggregatorInterval)
+		defer ticker.Stop()
+
+		s.logger.Info("Agregador de velas de precio iniciado",
+			zap.Duration("interval", candleAggregatorInterval),
+		)
+
+		var lastPrune time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				s.logger.Info("Agregador de velas de precio detenido")
+				return
+			case <-ticker.C:
+				s.AggregateCandles()
+				if time.Since(lastPrune) >= candlePruneInterval {
+					s.PruneCandles()
+					lastPrune = time.Now()
+				}
+			}
+		}
+	}()
+}
+
+// AggregateCandles actualiza las velas de todos los intervalos con los cruces recientes
+func (s *ExchangeService) AggregateCandles() {
+	if err := s.priceHistoryRepo.AggregateCandles(candleSettleLag); err != nil {
+		s.logger.Error("Error agregando velas de precio", zap.Error(err))
+	}
+}
+
+// PruneCandles elimina las velas que han superado el tiempo de conservación de su intervalo
+func (s *ExchangeService) PruneCandles() {
+	for interval, retention := range candleRetention {
+		if retention == 0 {
+			continue
+		}
+		deleted, err := s.priceHistoryRepo.PruneCandles(interval, time.Now().Add(-retention))
+		if err != nil {
+			s.logger.Error("Error eliminando velas caducadas", zap.String("interval", interval), zap.Error(err))
+			continue
+		}
+		if deleted > 0 {
+			s.logger.Info("Velas caducadas eliminadas", zap.String("interval", interval), zap.Int64("deleted", deleted))
+		}
+	}
+}
+
+// isTradeResource indica si el recurso se puede comerciar en el mercado
+func isTradeResource(resourceType string) bool {
+	switch resourceType {
+	case "wood", "stone", "food":
+		return true
+	}
+	return false
+}
+
+// notifyFill avisa por WebSocket a comprador y vendedor de un cruce en la bolsa
+func (s *ExchangeService) notifyFill(fill *models.TradeTransaction) {
+	if s.wsManager == nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"transaction_id": fill.ID.String(),
+		"resource_type":  fill.ResourceType,
+		"amount":         fill.Amount,
+		"price_per_unit": fill.PricePerUnit,
+		"total_price":    fill.TotalPrice,
+		"buy_order_id":   fill.BuyOrderID.String(),
+		"sell_order_id":  fill.SellOrderID.String(),
+	}
+	for _, playerID := range []uuid.UUID{fill.BuyerID, fill.SellerID} {
+		if err := s.wsManager.SendToUser(playerID.String(), "market_fill", data); err != nil {
+			s.logger.Warn("Error enviando cruce de la bolsa", zap.Error(err))
+		}
+	}
+}
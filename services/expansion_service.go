@@ -0,0 +1,266 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+
+	"server-backend/models"
+	"server-backend/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ExpansionService gestiona el crecimiento del imperio de un jugador: la cultura que limita el
+// número de aldeas, la fundación de aldeas con colonos y la conquista con nobles
+type ExpansionService struct {
+	villageRepo         *repository.VillageRepository
+	playerRepo          *repository.PlayerRepository
+	marchRepo           *repository.MarchRepository
+	mapRepo             *repository.MapRepository
+	mapService          *MapService
+	notificationService *NotificationService
+	worldSettings       *WorldSettingsService
+	logger              *zap.Logger
+}
+
+func NewExpansionService(villageRepo *repository.VillageRepository, playerRepo *repository.PlayerRepository, marchRepo *repository.MarchRepository, mapRepo *repository.MapRepository, mapService *MapService, logger *zap.Logger) *ExpansionService {
+	return &ExpansionService{
+		villageRepo: villageRepo,
+		playerRepo:  playerRepo,
+		marchRepo:   marchRepo,
+		mapRepo:     mapRepo,
+		mapService:  mapService,
+		logger:      logger,
+	}
+}
+
+// SetNotificationService establece el servicio de notificaciones persistentes
+func (s *ExpansionService) SetNotificationService(notificationService *NotificationService) {
+	s.notificationService = notificationService
+}
+
+// SetWorldSettingsService establece el servicio de reglas de mundo, que limita el número de aldeas
+func (s *ExpansionService) SetWorldSettingsService(worldSettings *WorldSettingsService) {
+	s.worldSettings = worldSettings
+}
+
+// GetExpansionStatus actualiza la cultura del jugador y devuelve cuántas aldeas puede tener
+func (s *ExpansionService) GetExpansionStatus(playerID uuid.UUID) (*models.ExpansionStatus, error) {
+	villages, err := s.villageRepo.GetVillagesByPlayerID(playerID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo aldeas: %w", err)
+	}
+
+	// La cultura se genera con los niveles de edificio de todas las aldeas
+	perHour := 0.0
+	for _, village := range villages {
+		for _, building := range village.Buildings {
+			perHour += float64(building.Level) * models.CulturePerBuildingLevel
+		}
+	}
+
+	culture, err := s.playerRepo.AccrueCulturePoints(playerID, perHour)
+	if err != nil {
+		return nil, fmt.Errorf("error actualizando cultura: %w", err)
+	}
+	pending, err := s.marchRepo.CountPendingSettlements(playerID)
+	if err != nil {
+		return nil, fmt.Errorf("error contando colonos en camino: %w", err)
+	}
+	loyalty, err := s.villageRepo.GetPlayerLoyalty(playerID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo lealtad: %w", err)
+	}
+
+	maxVillages := models.MaxVillagesForCulture(culture)
+	if s.worldSettings != nil && len(villages) > 0 {
+		if limit := s.worldSettings.SettingsFor(villages[0].Village.WorldID).MaxVillages; limit > 0 && maxVillages > limit {
+			maxVillages = limit
+		}
+	}
+	return &models.ExpansionStatus{
+		CulturePoints:      culture,
+		CulturePerHour:     perHour,
+		Villages:           len(villages),
+		PendingSettlements: pending,
+		MaxVillages:        maxVillages,
+		NextVillageCulture: models.CultureForVillages(len(villages) + pending + 1),
+		CanExpand:          len(villages)+pending < maxVillages,
+		Loyalty:            loyalty,
+	}, nil
+}
+
+// CheckSettlement valida el envío de colonos: el jugador necesita cultura para una aldea más
+// (contando los colonos que ya están en camino) y la casilla debe poder ocuparse
+func (s *ExpansionService) CheckSettlement(playerID, worldID uuid.UUID, x, y int) error {
+	status, err := s.GetExpansionStatus(playerID)
+	if err != nil {
+		return err
+	}
+	if !status.CanExpand {
+		return fmt.Errorf("cultura insuficiente: necesitas %.0f puntos para otra aldea", status.NextVillageCulture)
+	}
+	return s.checkSettleSite(worldID, x, y)
+}
+
+// FoundVillage funda la aldea de una marcha de colonización reclamada y la completa en el mismo paso.
+// La cultura se comprueba de nuevo porque el jugador puede haber conquistado aldeas durante el viaje.
+func (s *ExpansionService) FoundVillage(march *models.March) (*models.VillageWithDetails, error) {
+	if march.TargetX == nil || march.TargetY == nil {
+		return nil, fmt.Errorf("la marcha de colonización no tiene destino")
+	}
+	x, y := *march.TargetX, *march.TargetY
+
+	source, err := s.villageRepo.GetVillageByID(march.SourceVillageID)
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		return nil, fmt.Errorf("aldea de origen no encontrada")
+	}
+
+	status, err := s.GetExpansionStatus(march.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+	if status.Villages >= status.MaxVillages {
+		return nil, fmt.Errorf("cultura insuficiente para fundar otra aldea")
+	}
+	if err := s.checkSettleSite(source.Village.WorldID, x, y); err != nil {
+		return nil, err
+	}
+
+	village, err := s.villageRepo.CreateSettledVillage(march, source.Village.WorldID, fmt.Sprintf("Nueva aldea (%d|%d)", x, y), x, y)
+	if err != nil {
+		return nil, fmt.Errorf("error fundando aldea: %w", err)
+	}
+
+	s.logger.Info("Aldea fundada",
+		zap.String("player_id", march.PlayerID.String()),
+		zap.String("village_id", village.Village.ID.String()),
+		zap.Int("x", x),
+		zap.Int("y", y),
+	)
+	return village, nil
+}
+
+// checkSettleSite comprueba que la casilla esté dentro del mapa, no sea agua y no esté ocupada
+// por otra aldea, un oasis o un campamento bárbaro
+func (s *ExpansionService) checkSettleSite(worldID uuid.UUID, x, y int) error {
+	terrain, err := s.mapService.GetTerrainAt(worldID, x, y)
+	if err != nil {
+		return err
+	}
+	if terrain == models.TerrainWater {
+		return fmt.Errorf("no se puede fundar una aldea sobre el agua")
+	}
+
+	site, err := s.mapRepo.GetSiteAt(worldID, x, y)
+	if err != nil {
+		return err
+	}
+	if site != nil {
+		return fmt.Errorf("la casilla está ocupada por un oasis o un campamento bárbaro")
+	}
+
+	village, err := s.villageRepo.GetVillageByCoordinates(worldID, x, y)
+	if err != nil {
+		return err
+	}
+	if village != nil {
+		return fmt.Errorf("la casilla ya está ocupada por una aldea")
+	}
+	return nil
+}
+
+// ApplyNobles reduce la lealtad de una aldea derrotada según los nobles supervivientes. Si llega
+// a cero, la aldea pasa al atacante siempre que tenga cultura para ella y no sea la última aldea
+// del defensor.
+func (s *ExpansionService) ApplyNobles(attackerID uuid.UUID, target *models.VillageWithDetails, nobles int) (*models.ConquestResult, error) {
+	amount := 0
+	for i := 0; i < nobles; i++ {
+		amount += models.NobleLoyaltyMin + rand.Intn(models.NobleLoyaltyMax-models.NobleLoyaltyMin+1)
+	}
+
+	before, after, err := s.villageRepo.ReduceLoyalty(target.Village.ID, amount)
+	if err != nil {
+		return nil, fmt.Errorf("error reduciendo lealtad: %w", err)
+	}
+	result := &models.ConquestResult{
+		VillageID:     target.Village.ID,
+		LoyaltyBefore: before,
+		LoyaltyAfter:  after,
+		PreviousOwner: target.Village.PlayerID,
+	}
+	if after > 0 {
+		return result, nil
+	}
+
+	defenderVillages, err := s.villageRepo.GetVillagesByPlayerID(target.Village.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(defenderVillages) <= 1 {
+		result.BlockedReason = "no se puede conquistar la última aldea de un jugador"
+		return result, nil
+	}
+
+	status, err := s.GetExpansionStatus(attackerID)
+	if err != nil {
+		return nil, err
+	}
+	if status.Villages >= status.MaxVillages {
+		result.BlockedReason = "cultura insuficiente para mantener otra aldea"
+		return result, nil
+	}
+
+	if err := s.villageRepo.TransferVillage(target.Village.ID, target.Village.PlayerID, attackerID, models.LoyaltyAfterConquest); err != nil {
+		return nil, fmt.Errorf("error transfiriendo aldea: %w", err)
+	}
+	result.Conquered = true
+	result.LoyaltyAfter = models.LoyaltyAfterConquest
+
+	s.logger.Info("Aldea conquistada",
+		zap.String("village_id", target.Village.ID.String()),
+		zap.String("previous_owner", target.Village.PlayerID.String()),
+		zap.String("new_owner", attackerID.String()),
+	)
+	s.notifyConquest(attackerID, target)
+
+	return result, nil
+}
+
+// notifyConquest avisa a ambos jugadores del cambio de dueño de la aldea
+func (s *ExpansionService) notifyConquest(attackerID uuid.UUID, target *models.VillageWithDetails) {
+	if s.notificationService == nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"village_id": target.Village.ID.String(),
+		"x":          target.Village.XCoordinate,
+		"y":          target.Village.YCoordinate,
+	}
+	notifications := []*models.Notification{
+		{
+			PlayerID: attackerID.String(),
+			Type:     "village_conquered",
+			Title:    "Aldea conquistada",
+			Message:  fmt.Sprintf("Tus nobles han conquistado %s", target.Village.Name),
+			Data:     data,
+		},
+		{
+			PlayerID: target.Village.PlayerID.String(),
+			Type:     "village_lost",
+			Title:    "Aldea perdida",
+			Message:  fmt.Sprintf("%s ha sido conquistada por el enemigo", target.Village.Name),
+			Data:     data,
+		},
+	}
+	for _, notification := range notifications {
+		if err := s.notificationService.CreateNotification(notification); err != nil {
+			s.logger.Error("Error notificando conquista", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"server-backend/models"
+	"server-backend/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// mapDefaultSize coincide con el rango de coordenadas que usa VillageRepository
+	mapDefaultSize = 1000
+	// mapChunkSize es el lado de los bloques en los que se persiste el terreno
+	mapChunkSize = 32
+	// mapMaxViewportSize limita el lado del rectángulo que se puede pedir de una vez
+	mapMaxViewportSize = 100
+	// mapNoiseScale es el tamaño en casillas de las formaciones de terreno
+	mapNoiseScale = 48.0
+	// Umbrales de elevación y humedad (0-1) que deciden el terreno
+	mapWaterLevel    = 0.32
+	mapMountainLevel = 0.72
+	mapForestMoist   = 0.55
+	// Probabilidad por casilla de generar un oasis o un campamento bárbaro
+	mapOasisChance = 0.0015
+	mapCampChance  = 0.002
+	// mapMaxCampLevel es el nivel de los campamentos del centro del mapa
+	mapMaxCampLevel = 10
+	// Límites de las búsquedas espaciales de aldeas
+	mapSearchDefaultLimit = 20
+	mapSearchMaxLimit     = 100
+	mapSearchMaxRadius    = 100
+	// mapNearestStartRadius es el primer radio que prueba la búsqueda de las aldeas más cercanas
+	mapNearestStartRadius = 16
+)
+
+// Sales para derivar ruidos independientes de la misma semilla
+const (
+	noiseSaltElevation = 1
+	noiseSaltMoisture  = 2
+	noiseSaltSite      = 3
+	noiseSaltSiteKind  = 4
+	noiseSaltSiteValue = 5
+)
+
+type MapService struct {
+	mapRepo     *repository.MapRepository
+	worldRepo   *repository.WorldRepository
+	villageRepo *repository.VillageRepository
+	playerRepo  *repository.PlayerRepository
+	logger      *zap.Logger
+
+	protectionService *ProtectionService
+
+	// generating evita que dos peticiones generen el mismo mapa a la vez
+	generating sync.Mutex
+}
+
+func NewMapService(mapRepo *repository.MapRepository, worldRepo *repository.WorldRepository, villageRepo *repository.VillageRepository, playerRepo *repository.PlayerRepository, logger *zap.Logger) *MapService {
+	return &MapService{
+		mapRepo:     mapRepo,
+		worldRepo:   worldRepo,
+		villageRepo: villageRepo,
+		playerRepo:  playerRepo,
+		logger:      logger,
+	}
+}
+
+// SetProtectionService establece el servicio que indica qué aldeas del mapa están protegidas
+func (s *MapService) SetProtectionService(protectionService *ProtectionService) {
+	s.protectionService = protectionService
+}
+
+// EnsureWorldMap devuelve el mapa de un mundo generándolo y persistiéndolo la primera vez
+func (s *MapService) EnsureWorldMap(worldID uuid.UUID) (*models.WorldMap, error) {
+	worldMap, err := s.mapRepo.GetWorldMap(worldID)
+	if err != nil {
+		return nil, err
+	}
+	if worldMap != nil {
+		return worldMap, nil
+	}
+
+	s.generating.Lock()
+	defer s.generating.Unlock()
+
+	// Otra petición pudo generarlo mientras esperábamos
+	if worldMap, err = s.mapRepo.GetWorldMap(worldID); err != nil || worldMap != nil {
+		return worldMap, err
+	}
+
+	world, err := s.worldRepo.GetWorldByID(worldID)
+	if err != nil {
+		return nil, err
+	}
+	if world == nil {
+		return nil, fmt.Errorf("mundo no encontrado")
+	}
+
+	return s.GenerateWorldMap(worldID, time.Now().UnixNano())
+}
+
+// GenerateWorldMap genera el mapa de un mundo a partir de una semilla y lo persiste
+func (s *MapService) GenerateWorldMap(worldID uuid.UUID, seed int64) (*models.WorldMap, error) {
+	start := time.Now()
+	worldMap := &models.WorldMap{
+		WorldID:     worldID,
+		Seed:        seed,
+		Width:       mapDefaultSize,
+		Height:      mapDefaultSize,
+		ChunkSize:   mapChunkSize,
+		GeneratedAt: start,
+	}
+
+	// Las casillas ya ocupadas por aldeas no reciben emplazamientos
+	occupied := make(map[[2]int]bool)
+	villages, err := s.mapRepo.GetVillagesInBounds(worldID, 0, 0, worldMap.Width-1, worldMap.Height-1)
+	if err != nil {
+		return nil, err
+	}
+	for _, village := range villages {
+		occupied[[2]int{village.X, village.Y}] = true
+	}
+
+	chunksX := (worldMap.Width + mapChunkSize - 1) / mapChunkSize
+	chunksY := (worldMap.Height + mapChunkSize - 1) / mapChunkSize
+	chunks := make([]*models.MapChunk, 0, chunksX*chunksY)
+	var sites []*models.MapSite
+
+	for cy := 0; cy < chunksY; cy++ {
+		for cx := 0; cx < chunksX; cx++ {
+			var terrain strings.Builder
+			terrain.Grow(mapChunkSize * mapChunkSize)
+			for ty := 0; ty < mapChunkSize; ty++ {
+				for tx := 0; tx < mapChunkSize; tx++ {
+					x, y := cx*mapChunkSize+tx, cy*mapChunkSize+ty
+					tileTerrain := GenerateTerrain(seed, x, y)
+					terrain.WriteByte(models.TerrainCodes[tileTerrain])
+
+					if x >= worldMap.Width || y >= worldMap.Height || occupied[[2]int{x, y}] {
+						continue
+					}
+					if site := generateSite(worldMap, x, y, tileTerrain); site != nil {
+						sites = append(sites, site)
+					}
+				}
+			}
+			chunks = append(chunks, &models.MapChunk{
+				WorldID: worldID,
+				ChunkX:  cx,
+				ChunkY:  cy,
+				Terrain: terrain.String(),
+			})
+		}
+	}
+
+	if err := s.mapRepo.SaveWorldMap(worldMap, chunks, sites); err != nil {
+		return nil, fmt.Errorf("error guardando mapa: %w", err)
+	}
+
+	s.logger.Info("Mapa del mundo generado",
+		zap.String("world_id", worldID.String()),
+		zap.Int64("seed", seed),
+		zap.Int("chunks", len(chunks)),
+		zap.Int("sites", len(sites)),
+		zap.Duration("duration", time.Since(start)),
+	)
+
+	return s.mapRepo.GetWorldMap(worldID)
+}
+
+// GetViewport devuelve terreno, emplazamientos y aldeas de un rectángulo del mapa
+func (s *MapService) GetViewport(worldID uuid.UUID, minX, minY, maxX, maxY int) (*models.MapViewport, error) {
+	worldMap, err := s.EnsureWorldMap(worldID)
+	if err != nil {
+		return nil, err
+	}
+
+	if minX > maxX {
+		minX, maxX = maxX, minX
+	}
+	if minY > maxY {
+		minY, maxY = maxY, minY
+	}
+	minX, minY = clampInt(minX, 0, worldMap.Width-1), clampInt(minY, 0, worldMap.Height-1)
+	maxX, maxY = clampInt(maxX, 0, worldMap.Width-1), clampInt(maxY, 0, worldMap.Height-1)
+	if maxX-minX+1 > mapMaxViewportSize || maxY-minY+1 > mapMaxViewportSize {
+		return nil, fmt.Errorf("el viewport no puede superar %dx%d casillas", mapMaxViewportSize, mapMaxViewportSize)
+	}
+
+	chunks, err := s.mapRepo.GetChunks(worldID,
+		minX/worldMap.ChunkSize, minY/worldMap.ChunkSize, maxX/worldMap.ChunkSize, maxY/worldMap.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	chunkIndex := make(map[[2]int]string, len(chunks))
+	for _, chunk := range chunks {
+		chunkIndex[[2]int{chunk.ChunkX, chunk.ChunkY}] = chunk.Terrain
+	}
+
+	rows := make([]string, 0, maxY-minY+1)
+	for y := minY; y <= maxY; y++ {
+		row := make([]byte, 0, maxX-minX+1)
+		for x := minX; x <= maxX; x++ {
+			row = append(row, terrainCodeAt(worldMap, chunkIndex, x, y))
+		}
+		rows = append(rows, string(row))
+	}
+
+	sites, err := s.mapRepo.GetSitesInBounds(worldID, minX, minY, maxX, maxY)
+	if err != nil {
+		return nil, err
+	}
+	villages, err := s.mapRepo.GetVillagesInBounds(worldID, minX, minY, maxX, maxY)
+	if err != nil {
+		return nil, err
+	}
+	s.annotateProtection(worldID, villages)
+
+	legend := make(map[string]string, len(models.TerrainCodes))
+	for terrain, code := range models.TerrainCodes {
+		legend[string(code)] = terrain
+	}
+
+	return &models.MapViewport{
+		WorldID:  worldID,
+		MinX:     minX,
+		MinY:     minY,
+		MaxX:     maxX,
+		MaxY:     maxY,
+		Terrain:  rows,
+		Legend:   legend,
+		Sites:    sites,
+		Villages: villages,
+	}, nil
+}
+
+// GetTerrainAt devuelve el terreno de una casilla. Al ser determinista no necesita leer los chunks.
+func (s *MapService) GetTerrainAt(worldID uuid.UUID, x, y int) (string, error) {
+	worldMap, err := s.EnsureWorldMap(worldID)
+	if err != nil {
+		return "", err
+	}
+	if x < 0 || y < 0 || x >= worldMap.Width || y >= worldMap.Height {
+		return "", fmt.Errorf("coordenadas fuera del mapa")
+	}
+	return GenerateTerrain(worldMap.Seed, x, y), nil
+}
+
+// ===== BÚSQUEDAS ESPACIALES =====
+
+// SearchVillages busca aldeas por posición. Con radio o rectángulo devuelve las que caen dentro;
+// sin ninguno de los dos devuelve las más cercanas al centro ampliando el radio hasta reunir Limit.
+func (s *MapService) SearchVillages(search *models.VillageSearch) ([]*models.NearbyVillage, error) {
+	villages, err := s.searchVillages(search)
+	if err != nil {
+		return nil, err
+	}
+	mapVillages := make([]*models.MapVillage, len(villages))
+	for i, village := range villages {
+		mapVillages[i] = &village.MapVillage
+	}
+	s.annotateProtection(search.WorldID, mapVillages)
+	return villages, nil
+}
+
+func (s *MapService) searchVillages(search *models.VillageSearch) ([]*models.NearbyVillage, error) {
+	width, height := mapDefaultSize, mapDefaultSize
+	worldMap, err := s.mapRepo.GetWorldMap(search.WorldID)
+	if err != nil {
+		return nil, err
+	}
+	if worldMap != nil {
+		width, height = worldMap.Width, worldMap.Height
+	}
+
+	if search.Limit <= 0 {
+		search.Limit = mapSearchDefaultLimit
+	}
+	search.Limit = clampInt(search.Limit, 1, mapSearchMaxLimit)
+	if search.MaxScore > 0 && search.MaxScore < search.MinScore {
+		return nil, fmt.Errorf("la puntuación máxima no puede ser menor que la mínima")
+	}
+
+	switch {
+	case search.MinX != nil || search.MinY != nil || search.MaxX != nil || search.MaxY != nil:
+		if search.MinX == nil || search.MinY == nil || search.MaxX == nil || search.MaxY == nil {
+			return nil, fmt.Errorf("el rectángulo necesita min_x, min_y, max_x y max_y")
+		}
+		minX, minY, maxX, maxY := *search.MinX, *search.MinY, *search.MaxX, *search.MaxY
+		if minX > maxX {
+			minX, maxX = maxX, minX
+		}
+		if minY > maxY {
+			minY, maxY = maxY, minY
+		}
+		if maxX-minX+1 > mapMaxViewportSize || maxY-minY+1 > mapMaxViewportSize {
+			return nil, fmt.Errorf("el rectángulo no puede superar %dx%d casillas", mapMaxViewportSize, mapMaxViewportSize)
+		}
+		return s.villageRepo.SearchVillages(search, minX, minY, maxX, maxY)
+
+	case search.Radius > 0:
+		if search.Radius > mapSearchMaxRadius {
+			return nil, fmt.Errorf("el radio no puede superar %d casillas", mapSearchMaxRadius)
+		}
+		reach := int(math.Ceil(search.Radius))
+		return s.villageRepo.SearchVillages(search,
+			search.CenterX-reach, search.CenterY-reach, search.CenterX+reach, search.CenterY+reach)
+	}
+
+	// Búsqueda de las más cercanas: cada ronda dobla el radio. Dentro de un círculo el orden por
+	// distancia es exacto, así que en cuanto hay Limit resultados son los Limit más cercanos.
+	maxReach := math.Hypot(float64(width), float64(height))
+	nearest := *search
+	for radius := float64(mapNearestStartRadius); ; radius *= 2 {
+		nearest.Radius = radius
+		reach := int(math.Ceil(radius))
+		villages, err := s.villageRepo.SearchVillages(&nearest,
+			search.CenterX-reach, search.CenterY-reach, search.CenterX+reach, search.CenterY+reach)
+		if err != nil {
+			return nil, err
+		}
+		if len(villages) >= search.Limit || radius >= maxReach {
+			return villages, nil
+		}
+	}
+}
+
+// FindTargets busca aldeas objetivo para un jugador: excluye las suyas y las de su alianza
+func (s *MapService) FindTargets(playerID uuid.UUID, search *models.VillageSearch) ([]*models.NearbyVillage, error) {
+	player, err := s.playerRepo.GetPlayerByID(playerID)
+	if err != nil {
+		return nil, err
+	}
+	if player == nil {
+		return nil, fmt.Errorf("jugador no encontrado")
+	}
+
+	search.PlayerID = nil
+	search.ExcludePlayerID = &playerID
+	search.ExcludeAllianceID = player.AllianceID
+	if search.AllianceID != nil && player.AllianceID != nil && *search.AllianceID == *player.AllianceID {
+		return nil, fmt.Errorf("no puedes buscar objetivos en tu propia alianza")
+	}
+
+	return s.SearchVillages(search)
+}
+
+// annotateProtection marca las aldeas protegidas. Un fallo no impide pintar el mapa.
+func (s *MapService) annotateProtection(worldID uuid.UUID, villages []*models.MapVillage) {
+	if s.protectionService == nil {
+		return
+	}
+	if err := s.protectionService.AnnotateVillages(worldID, villages); err != nil {
+		s.logger.Warn("Error obteniendo protección de las aldeas del mapa", zap.Error(err))
+	}
+}
+
+// terrainCodeAt obtiene el carácter de terreno de una casilla a partir de los chunks cargados
+func terrainCodeAt(worldMap *models.WorldMap, chunks map[[2]int]string, x, y int) byte {
+	terrain, exists := chunks[[2]int{x / worldMap.ChunkSize, y / worldMap.ChunkSize}]
+	index := (y%worldMap.ChunkSize)*worldMap.ChunkSize + x%worldMap.ChunkSize
+	if !exists || index >= len(terrain) {
+		return models.TerrainCodes[GenerateTerrain(worldMap.Seed, x, y)]
+	}
+	return terrain[index]
+}
+
+// ===== GENERACIÓN PROCEDURAL =====
+
+// GenerateTerrain decide el terreno de una casilla a partir de dos capas de ruido (elevación y
+// humedad). Es una función pura: la misma semilla y coordenadas dan siempre el mismo terreno.
+func GenerateTerrain(seed int64, x, y int) string {
+	elevation := fractalNoise(seed, noiseSaltElevation, float64(x), float64(y))
+	switch {
+	case elevation < mapWaterLevel:
+		return models.TerrainWater
+	case elevation > mapMountainLevel:
+		return models.TerrainMountain
+	}
+	if fractalNoise(seed, noiseSaltMoisture, float64(x), float64(y)) > mapForestMoist {
+		return models.TerrainForest
+	}
+	return models.TerrainPlain
+}
+
+// generateSite decide si una casilla alberga un oasis o un campamento bárbaro
+func generateSite(worldMap *models.WorldMap, x, y int, terrain string) *models.MapSite {
+	if terrain == models.TerrainWater {
+		return nil
+	}
+
+	roll := hashNoise(worldMap.Seed, noiseSaltSite, x, y)
+	kind := hashNoise(worldMap.Seed, noiseSaltSiteKind, x, y)
+	value := hashNoise(worldMap.Seed, noiseSaltSiteValue, x, y)
+
+	switch {
+	case roll < mapOasisChance:
+		// El recurso del oasis depende del terreno; el oro aparece en cualquier terreno pero es raro
+		resource := map[string]string{
+			models.TerrainForest:   "wood",
+			models.TerrainMountain: "stone",
+			models.TerrainPlain:    "food",
+		}[terrain]
+		if kind < 0.15 {
+			resource = "gold"
+		}
+		bonus := 0.25
+		if value > 0.85 {
+			bonus = 0.5
+		}
+		return &models.MapSite{
+			ID:        uuid.New(),
+			WorldID:   worldMap.WorldID,
+			X:         x,
+			Y:         y,
+			Type:      models.MapSiteOasis,
+			Resource:  resource,
+			Bonus:     bonus,
+			CreatedAt: worldMap.GeneratedAt,
+		}
+
+	case roll < mapOasisChance+mapCampChance && terrain != models.TerrainMountain:
+		// Los campamentos son más fuertes cuanto más cerca del centro del mapa
+		centerX, centerY := float64(worldMap.Width)/2, float64(worldMap.Height)/2
+		distance := math.Hypot(float64(x)-centerX, float64(y)-centerY) / math.Hypot(centerX, centerY)
+		level := 1 + int(math.Round((1-distance)*float64(mapMaxCampLevel-1)+(value-0.5)*2))
+		level = clampInt(level, 1, mapMaxCampLevel)
+		return &models.MapSite{
+			ID:        uuid.New(),
+			WorldID:   worldMap.WorldID,
+			X:         x,
+			Y:         y,
+			Type:      models.MapSiteBarbarianCamp,
+			Level:     level,
+			Garrison:  barbarianGarrison(level),
+			CreatedAt: worldMap.GeneratedAt,
+		}
+	}
+	return nil
+}
+
+// barbarianGarrison devuelve las tropas de un campamento bárbaro según su nivel
+func barbarianGarrison(level int) map[string]int {
+	garrison := map[string]int{
+		"warrior": 10 * level,
+		"archer":  5 * level,
+	}
+	if level >= 5 {
+		garrison["knight"] = 2 * level
+	}
+	return garrison
+}
+
+// fractalNoise suma tres octavas de ruido de valor y devuelve un valor en [0, 1)
+func fractalNoise(seed int64, salt int, x, y float64) float64 {
+	total, amplitude, norm := 0.0, 1.0, 0.0
+	scale := mapNoiseScale
+	for octave := 0; octave < 3; octave++ {
+		total += valueNoise(seed, salt+octave*16, x/scale, y/scale) * amplitude
+		norm += amplitude
+		amplitude *= 0.5
+		scale /= 2
+	}
+	return total / norm
+}
+
+// valueNoise interpola suavemente los valores pseudoaleatorios de los vértices de la retícula
+func valueNoise(seed int64, salt int, x, y float64) float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := smoothstep(x-x0), smoothstep(y-y0)
+	ix, iy := int(x0), int(y0)
+
+	top := lerp(hashNoise(seed, salt, ix, iy), hashNoise(seed, salt, ix+1, iy), fx)
+	bottom := lerp(hashNoise(seed, salt, ix, iy+1), hashNoise(seed, salt, ix+1, iy+1), fx)
+	return lerp(top, bottom, fy)
+}
+
+// hashNoise devuelve un valor pseudoaleatorio en [0, 1) determinado por semilla, sal y coordenadas
+func hashNoise(seed int64, salt, x, y int) float64 {
+	h := uint64(seed) ^ uint64(salt)*0x9E3779B97F4A7C15
+	h ^= uint64(int64(x)) * 0xBF58476D1CE4E5B9
+	h ^= uint64(int64(y)) * 0x94D049BB133111EB
+	// Mezclador final de splitmix64
+	h ^= h >> 30
+	h *= 0xBF58476D1CE4E5B9
+	h ^= h >> 27
+	h *= 0x94D049BB133111EB
+	h ^= h >> 31
+	return float64(h>>11) / float64(1<<53)
+}
+
+func smoothstep(t float64) float64 {
+	return t * t * (3 - 2*t)
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+func clampInt(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
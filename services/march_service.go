@@ -0,0 +1,1001 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"server-backend/models"
+	"server-backend/repository"
+	"server-backend/websocket"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// marchSchedulerInterval es la frecuencia con la que se procesan llegadas y regresos
+	marchSchedulerInterval = 5 * time.Second
+	// marchBatchSize limita las marchas procesadas por ciclo
+	marchBatchSize = 100
+	// marchMinTravelTime evita marchas instantáneas entre aldeas muy cercanas
+	marchMinTravelTime = 30 * time.Second
+	// warehouseProtectionRatio es la fracción de la capacidad del almacén protegida del saqueo
+	// cuando la aldea no tiene escondite
+	warehouseProtectionRatio = 0.1
+	// intelMaxError es el error relativo máximo de las cifras de un informe con precisión mínima
+	intelMaxError = 0.5
+	// intelBuildingsAccuracy e intelGarrisonAccuracy son la fracción de exploradores supervivientes
+	// necesaria para revelar edificios y guarnición; los recursos se revelan siempre que haya éxito
+	intelBuildingsAccuracy = 0.5
+	intelGarrisonAccuracy  = 0.8
+)
+
+type MarchService struct {
+	marchRepo           *repository.MarchRepository
+	intelRepo           *repository.IntelRepository
+	villageRepo         *repository.VillageRepository
+	unitRepo            *repository.UnitRepository
+	buildingConfigRepo  *repository.BuildingConfigRepository
+	allianceRepo        *repository.AllianceRepository
+	battleService       *BattleService
+	notificationService *NotificationService
+	protectionService   *ProtectionService
+	expansionService    *ExpansionService
+	resourceService     *ResourceService
+	worldSettings       *WorldSettingsService
+	logger              *zap.Logger
+	wsManager           *websocket.Manager
+}
+
+func NewMarchService(marchRepo *repository.MarchRepository, intelRepo *repository.IntelRepository, villageRepo *repository.VillageRepository, unitRepo *repository.UnitRepository, buildingConfigRepo *repository.BuildingConfigRepository, allianceRepo *repository.AllianceRepository, battleService *BattleService, logger *zap.Logger) *MarchService {
+	return &MarchService{
+		marchRepo:          marchRepo,
+		intelRepo:          intelRepo,
+		villageRepo:        villageRepo,
+		unitRepo:           unitRepo,
+		buildingConfigRepo: buildingConfigRepo,
+		allianceRepo:       allianceRepo,
+		battleService:      battleService,
+		logger:             logger,
+		wsManager:          nil, // Se establecerá después con SetWebSocketManager
+	}
+}
+
+// SetWebSocketManager establece el manager de WebSocket
+func (s *MarchService) SetWebSocketManager(wsManager *websocket.Manager) {
+	s.wsManager = wsManager
+}
+
+// SetNotificationService establece el servicio de notificaciones persistentes
+func (s *MarchService) SetNotificationService(notificationService *NotificationService) {
+	s.notificationService = notificationService
+}
+
+// SetProtectionService establece el servicio de protección que valida los ataques
+func (s *MarchService) SetProtectionService(protectionService *ProtectionService) {
+	s.protectionService = protectionService
+}
+
+// SetExpansionService establece el servicio de expansión que funda y conquista aldeas
+func (s *MarchService) SetExpansionService(expansionService *ExpansionService) {
+	s.expansionService = expansionService
+}
+
+// SetResourceService establece el servicio de recursos, que materializa la producción de las
+// aldeas antes de batallas, saqueos y entregas de botín
+func (s *MarchService) SetResourceService(resourceService *ResourceService) {
+	s.resourceService = resourceService
+}
+
+// SetWorldSettingsService establece el servicio de reglas de mundo, que aplica la velocidad de las unidades
+func (s *MarchService) SetWorldSettingsService(worldSettings *WorldSettingsService) {
+	s.worldSettings = worldSettings
+}
+
+// SendMarch crea una marcha y retira las tropas de la aldea de origen
+func (s *MarchService) SendMarch(request *models.MarchRequest) (*models.March, error) {
+	switch request.Type {
+	case models.MarchTypeAttack, models.MarchTypeReinforcement, models.MarchTypeScout, models.MarchTypeSettle:
+	default:
+		return nil, fmt.Errorf("tipo de marcha inválido: %s", request.Type)
+	}
+	if request.Type != models.MarchTypeSettle && request.SourceVillageID == request.TargetVillageID {
+		return nil, fmt.Errorf("el origen y el destino no pueden ser la misma aldea")
+	}
+
+	source, err := s.villageRepo.GetVillageByID(request.SourceVillageID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo aldea de origen: %w", err)
+	}
+	if source == nil || source.Village.PlayerID != request.PlayerID {
+		return nil, fmt.Errorf("la aldea de origen no pertenece al jugador")
+	}
+
+	if request.Type == models.MarchTypeSettle {
+		return s.sendSettlers(request, source)
+	}
+
+	target, err := s.villageRepo.GetVillageByID(request.TargetVillageID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo aldea de destino: %w", err)
+	}
+	if target == nil {
+		return nil, fmt.Errorf("aldea de destino no encontrada")
+	}
+	if target.Village.WorldID != source.Village.WorldID {
+		return nil, fmt.Errorf("las aldeas pertenecen a mundos distintos")
+	}
+
+	switch request.Type {
+	case models.MarchTypeAttack, models.MarchTypeScout:
+		if target.Village.PlayerID == request.PlayerID {
+			return nil, fmt.Errorf("no puedes atacar ni espiar tus propias aldeas")
+		}
+		if request.Type == models.MarchTypeAttack && s.protectionService != nil {
+			if err := s.protectionService.CheckAttack(request.PlayerID, &target.Village); err != nil {
+				return nil, err
+			}
+		}
+	case models.MarchTypeReinforcement:
+		if target.Village.PlayerID != request.PlayerID {
+			allied, err := s.allianceRepo.ArePlayersAllied(request.PlayerID, target.Village.PlayerID)
+			if err != nil {
+				return nil, err
+			}
+			if !allied {
+				return nil, fmt.Errorf("solo puedes reforzar tus aldeas o las de miembros de tu alianza")
+			}
+		}
+	}
+
+	units, slowest, err := validateMarchUnits(request.Units)
+	if err != nil {
+		return nil, err
+	}
+	if request.Type == models.MarchTypeScout {
+		for unitType := range units {
+			if unitType != "scout" {
+				return nil, fmt.Errorf("las misiones de exploración solo admiten exploradores")
+			}
+		}
+	}
+	if request.Type == models.MarchTypeAttack && units["settler"] > 0 {
+		return nil, fmt.Errorf("los colonos no pueden participar en ataques")
+	}
+
+	distance := villageDistance(&source.Village, &target.Village)
+	now := time.Now()
+	march := &models.March{
+		ID:              uuid.New(),
+		PlayerID:        request.PlayerID,
+		SourceVillageID: source.Village.ID,
+		TargetVillageID: target.Village.ID,
+		Type:            request.Type,
+		Status:          models.MarchStatusInTransit,
+		Units:           units,
+		Distance:        distance,
+		DepartureTime:   now,
+		ArrivalTime:     now.Add(s.travelTime(source.Village.WorldID, distance, slowest)),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	if err := s.marchRepo.CreateMarch(march); err != nil {
+		return nil, fmt.Errorf("error creando marcha: %w", err)
+	}
+
+	s.logger.Info("Marcha enviada",
+		zap.String("march_id", march.ID.String()),
+		zap.String("type", march.Type),
+		zap.Float64("distance", distance),
+		zap.Time("arrival_time", march.ArrivalTime),
+	)
+
+	if march.Type == models.MarchTypeAttack && s.protectionService != nil {
+		s.protectionService.OnAttackLaunched(march.PlayerID)
+	}
+
+	s.notifyMarch(march.PlayerID, "march_started", march)
+	if march.Type == models.MarchTypeAttack || target.Village.PlayerID != march.PlayerID {
+		s.notifyMarch(target.Village.PlayerID, "march_incoming", march)
+	}
+
+	return march, nil
+}
+
+// sendSettlers envía colonos a fundar una aldea en una casilla libre del mapa
+func (s *MarchService) sendSettlers(request *models.MarchRequest, source *models.VillageWithDetails) (*models.March, error) {
+	if s.expansionService == nil {
+		return nil, fmt.Errorf("la fundación de aldeas no está disponible")
+	}
+	if request.TargetX == nil || request.TargetY == nil {
+		return nil, fmt.Errorf("debes indicar la casilla de destino de los colonos")
+	}
+
+	units, slowest, err := validateMarchUnits(request.Units)
+	if err != nil {
+		return nil, err
+	}
+	for unitType := range units {
+		if unitType != "settler" {
+			return nil, fmt.Errorf("las marchas de colonización solo admiten colonos")
+		}
+	}
+	if units["settler"] != models.SettlersPerVillage {
+		return nil, fmt.Errorf("se necesitan exactamente %d colonos para fundar una aldea", models.SettlersPerVillage)
+	}
+
+	x, y := *request.TargetX, *request.TargetY
+	if err := s.expansionService.CheckSettlement(request.PlayerID, source.Village.WorldID, x, y); err != nil {
+		return nil, err
+	}
+
+	distance := math.Hypot(float64(source.Village.XCoordinate-x), float64(source.Village.YCoordinate-y))
+	now := time.Now()
+	march := &models.March{
+		ID:              uuid.New(),
+		PlayerID:        request.PlayerID,
+		SourceVillageID: source.Village.ID,
+		TargetX:         &x,
+		TargetY:         &y,
+		Type:            models.MarchTypeSettle,
+		Status:          models.MarchStatusInTransit,
+		Units:           units,
+		Distance:        distance,
+		DepartureTime:   now,
+		ArrivalTime:     now.Add(s.travelTime(source.Village.WorldID, distance, slowest)),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	if err := s.marchRepo.CreateMarch(march); err != nil {
+		return nil, fmt.Errorf("error creando marcha: %w", err)
+	}
+
+	s.logger.Info("Colonos enviados",
+		zap.String("march_id", march.ID.String()),
+		zap.Int("x", x),
+		zap.Int("y", y),
+		zap.Time("arrival_time", march.ArrivalTime),
+	)
+	s.notifyMarch(march.PlayerID, "march_started", march)
+
+	return march, nil
+}
+
+// CancelMarch cancela una marcha en tránsito. Las tropas regresan tardando lo mismo que llevaban de viaje.
+func (s *MarchService) CancelMarch(marchID, playerID uuid.UUID) (*models.March, error) {
+	march, err := s.marchRepo.GetMarch(marchID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo marcha: %w", err)
+	}
+	if march == nil {
+		return nil, fmt.Errorf("marcha no encontrada")
+	}
+	if march.PlayerID != playerID {
+		return nil, fmt.Errorf("no tienes permisos para cancelar esta marcha")
+	}
+	if march.Status != models.MarchStatusInTransit {
+		return nil, fmt.Errorf("solo se pueden cancelar marchas en tránsito")
+	}
+
+	now := time.Now()
+	if !now.Before(march.ArrivalTime) {
+		return nil, fmt.Errorf("la marcha ya ha llegado a su destino")
+	}
+
+	returnTime := now.Add(now.Sub(march.DepartureTime))
+	march.Status = models.MarchStatusReturning
+	march.ArrivalTime = now
+	march.ReturnTime = &returnTime
+
+	// La marcha solo se cancela si el planificador no la ha reclamado entretanto; si no, la llegada
+	// ya se está aplicando con las tropas originales
+	if err := s.marchRepo.UpdateMarch(march, models.MarchStatusInTransit); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("la marcha ya ha llegado a su destino")
+		}
+		return nil, fmt.Errorf("error cancelando marcha: %w", err)
+	}
+
+	s.notifyMarch(march.PlayerID, "march_cancelled", march)
+	if march.Type == models.MarchTypeAttack {
+		if target, err := s.villageRepo.GetVillageByID(march.TargetVillageID); err == nil && target != nil {
+			s.notifyMarch(target.Village.PlayerID, "march_cancelled", march)
+		}
+	}
+
+	return march, nil
+}
+
+// GetPlayerMarches obtiene las marchas en curso de un jugador
+func (s *MarchService) GetPlayerMarches(playerID uuid.UUID) ([]*models.March, error) {
+	return s.marchRepo.GetActiveMarchesByPlayer(playerID)
+}
+
+// GetIncomingMarches obtiene las marchas que se dirigen a una aldea del jugador
+func (s *MarchService) GetIncomingMarches(villageID, playerID uuid.UUID) ([]*models.March, error) {
+	village, err := s.villageRepo.GetVillageByID(villageID)
+	if err != nil {
+		return nil, err
+	}
+	if village == nil || village.Village.PlayerID != playerID {
+		return nil, fmt.Errorf("la aldea no pertenece al jugador")
+	}
+	return s.marchRepo.GetIncomingMarches(villageID)
+}
+
+// ===== TROPAS DE APOYO =====
+
+// GetSupportSent obtiene las tropas de apoyo que el jugador mantiene en aldeas aliadas
+func (s *MarchService) GetSupportSent(playerID uuid.UUID) ([]*models.SupportTroops, error) {
+	return s.unitRepo.GetStationedTroopsByOwner(playerID)
+}
+
+// GetSupportHosted obtiene las tropas de apoyo estacionadas en las aldeas del jugador
+func (s *MarchService) GetSupportHosted(playerID uuid.UUID) ([]*models.SupportTroops, error) {
+	return s.unitRepo.GetStationedTroopsByHostPlayer(playerID)
+}
+
+// RecallSupport devuelve tropas estacionadas a su aldea de origen. Puede pedirlo tanto el dueño
+// de las tropas como el anfitrión.
+func (s *MarchService) RecallSupport(playerID uuid.UUID, request *models.SupportRecallRequest) (*models.March, error) {
+	contingent, err := s.unitRepo.GetStationedTroops(request.OriginVillageID, request.HostVillageID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo tropas de apoyo: %w", err)
+	}
+	if contingent == nil {
+		return nil, fmt.Errorf("no hay tropas de apoyo estacionadas")
+	}
+	if contingent.OwnerPlayerID != playerID && contingent.HostPlayerID != playerID {
+		return nil, fmt.Errorf("no tienes permisos sobre estas tropas de apoyo")
+	}
+
+	units := contingent.Units
+	if len(request.Units) > 0 {
+		units = make(map[string]int, len(request.Units))
+		for unitType, quantity := range request.Units {
+			if quantity <= 0 {
+				continue
+			}
+			if quantity > contingent.Units[unitType] {
+				return nil, fmt.Errorf("tropas estacionadas insuficientes de tipo %s", unitType)
+			}
+			units[unitType] = quantity
+		}
+	}
+	return s.recallContingent(contingent, units, playerID)
+}
+
+// recallContingent crea la marcha de regreso de parte o todo un contingente estacionado
+func (s *MarchService) recallContingent(contingent *models.SupportTroops, units map[string]int, requestedBy uuid.UUID) (*models.March, error) {
+	units, slowest, err := validateMarchUnits(units)
+	if err != nil {
+		return nil, err
+	}
+
+	origin, err := s.villageRepo.GetVillageByID(contingent.OriginVillageID)
+	if err != nil {
+		return nil, err
+	}
+	host, err := s.villageRepo.GetVillageByID(contingent.HostVillageID)
+	if err != nil {
+		return nil, err
+	}
+	if origin == nil || host == nil {
+		return nil, fmt.Errorf("aldea no encontrada")
+	}
+
+	distance := villageDistance(&origin.Village, &host.Village)
+	now := time.Now()
+	returnTime := now.Add(s.travelTime(origin.Village.WorldID, distance, slowest))
+	march := &models.March{
+		ID:              uuid.New(),
+		PlayerID:        contingent.OwnerPlayerID,
+		SourceVillageID: contingent.OriginVillageID,
+		TargetVillageID: contingent.HostVillageID,
+		Type:            models.MarchTypeReinforcement,
+		Status:          models.MarchStatusReturning,
+		Units:           units,
+		Distance:        distance,
+		DepartureTime:   now,
+		ArrivalTime:     now,
+		ReturnTime:      &returnTime,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	if err := s.marchRepo.CreateRecallMarch(march); err != nil {
+		return nil, fmt.Errorf("error retirando tropas de apoyo: %w", err)
+	}
+
+	s.logger.Info("Tropas de apoyo retiradas",
+		zap.String("march_id", march.ID.String()),
+		zap.String("origin_village_id", march.SourceVillageID.String()),
+		zap.String("host_village_id", march.TargetVillageID.String()),
+		zap.String("requested_by", requestedBy.String()),
+	)
+
+	s.notifyMarch(contingent.OwnerPlayerID, "support_recalled", march)
+	s.notifyMarch(contingent.HostPlayerID, "support_recalled", march)
+
+	return march, nil
+}
+
+// ===== PLANIFICADOR DE MARCHAS =====
+
+// StartMarchScheduler inicia el procesamiento periódico de llegadas y regresos hasta que se cancela el contexto
+func (s *MarchService) StartMarchScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(marchSchedulerInterval)
+		defer ticker.Stop()
+
+		s.logger.Info("Planificador de marchas iniciado",
+			zap.Duration("interval", marchSchedulerInterval),
+		)
+
+		for {
+			select {
+			case <-ctx.Done():
+				s.logger.Info("Planificador de marchas detenido")
+				return
+			case <-ticker.C:
+				s.ProcessDueMarches()
+			}
+		}
+	}()
+}
+
+// ProcessDueMarches resuelve las marchas cuya llegada o regreso ya se ha producido
+func (s *MarchService) ProcessDueMarches() {
+	now := time.Now()
+
+	arrivals, err := s.marchRepo.GetDueArrivals(now, marchBatchSize)
+	if err != nil {
+		s.logger.Error("Error obteniendo llegadas de marchas", zap.Error(err))
+	}
+	for _, march := range arrivals {
+		// Reclamar la marcha impide que otra pasada o una cancelación la resuelvan a la vez
+		if err := s.marchRepo.ClaimArrival(march); err != nil {
+			if err != sql.ErrNoRows {
+				s.logger.Error("Error reclamando llegada de marcha", zap.String("march_id", march.ID.String()), zap.Error(err))
+			}
+			continue
+		}
+		if err := s.processArrival(march); err != nil {
+			s.logger.Error("Error procesando llegada de marcha", zap.String("march_id", march.ID.String()), zap.Error(err))
+			// La marcha reclamada no vuelve a procesarse: las tropas que le queden regresan a su aldea
+			if err := s.startReturn(march, march.Units); err != nil {
+				s.logger.Error("Error devolviendo marcha a su aldea", zap.String("march_id", march.ID.String()), zap.Error(err))
+			}
+		}
+	}
+
+	returns, err := s.marchRepo.GetDueReturns(now, marchBatchSize)
+	if err != nil {
+		s.logger.Error("Error obteniendo regresos de marchas", zap.Error(err))
+	}
+	for _, march := range returns {
+		s.checkpointVillages(march.SourceVillageID)
+		if err := s.marchRepo.SettleMarch(march, march.SourceVillageID, models.MarchStatusReturning); err != nil {
+			if err != sql.ErrNoRows {
+				s.logger.Error("Error procesando regreso de marcha", zap.String("march_id", march.ID.String()), zap.Error(err))
+			}
+			continue
+		}
+		s.notifyMarch(march.PlayerID, "march_returned", march)
+	}
+}
+
+// processArrival aplica el efecto de una marcha reclamada al llegar a su destino
+func (s *MarchService) processArrival(march *models.March) error {
+	if march.Type == models.MarchTypeSettle {
+		return s.settle(march)
+	}
+
+	target, err := s.villageRepo.GetVillageByID(march.TargetVillageID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		// La aldea ya no existe: las tropas dan media vuelta
+		return s.startReturn(march, march.Units)
+	}
+
+	switch march.Type {
+	case models.MarchTypeReinforcement:
+		if target.Village.PlayerID == march.PlayerID {
+			if err := s.marchRepo.SettleMarch(march, target.Village.ID, models.MarchStatusResolving); err != nil {
+				return err
+			}
+			s.notifyMarch(march.PlayerID, "march_arrived", march)
+			return nil
+		}
+
+		// Refuerzo a un aliado: las tropas quedan estacionadas si la alianza sigue vigente
+		allied, err := s.allianceRepo.ArePlayersAllied(march.PlayerID, target.Village.PlayerID)
+		if err != nil {
+			return err
+		}
+		if !allied {
+			return s.startReturn(march, march.Units)
+		}
+		if err := s.marchRepo.StationMarch(march, models.MarchStatusResolving); err != nil {
+			return err
+		}
+		s.notifyMarch(march.PlayerID, "march_arrived", march)
+		s.notifyMarch(target.Village.PlayerID, "support_arrived", march)
+		return nil
+
+	case models.MarchTypeAttack:
+		// Las bajas cambian la manutención de ambas aldeas y el saqueo parte de las existencias reales
+		s.checkpointVillages(march.SourceVillageID, target.Village.ID)
+		if s.resourceService != nil {
+			s.resourceService.ProjectResources(target)
+		}
+		battle, result, err := s.battleService.ResolveVillageBattle(march.PlayerID, target, march.Units)
+		if err != nil {
+			return err
+		}
+		march.BattleID = &battle.ID
+		survivors := result.Outcome.AttackerSurvivors
+		if result.Winner == "attacker" {
+			s.plunder(march, battle, target, survivors)
+			if survivors["noble"] > 0 && s.expansionService != nil {
+				s.conquer(march, target, survivors)
+			}
+		}
+		return s.startReturn(march, survivors)
+
+	case models.MarchTypeScout:
+		if s.resourceService != nil {
+			s.resourceService.ProjectResources(target)
+		}
+		survivors, err := s.scout(march, target)
+		if err != nil {
+			return err
+		}
+		return s.startReturn(march, survivors)
+
+	default:
+		return s.startReturn(march, march.Units)
+	}
+}
+
+// settle funda la aldea de una marcha de colonización. Si la casilla ya no está libre o el jugador
+// se ha quedado sin cultura, los colonos regresan a su aldea.
+func (s *MarchService) settle(march *models.March) error {
+	if s.expansionService == nil {
+		return s.startReturn(march, march.Units)
+	}
+
+	village, err := s.expansionService.FoundVillage(march)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// La marcha ya no estaba reclamada: otra pasada la ha resuelto
+			return nil
+		}
+		s.logger.Info("Los colonos no pudieron fundar la aldea",
+			zap.String("march_id", march.ID.String()),
+			zap.Error(err),
+		)
+		return s.startReturn(march, march.Units)
+	}
+
+	// Los colonos se han consumido al fundar la aldea y la marcha ya está completada
+	march.TargetVillageID = village.Village.ID
+	s.notifyMarch(march.PlayerID, "march_arrived", march)
+	return nil
+}
+
+// conquer aplica el efecto de los nobles supervivientes de un ataque victorioso. Si la aldea cambia
+// de dueño, un noble se queda en ella como gobernador y el resto de tropas regresa.
+func (s *MarchService) conquer(march *models.March, target *models.VillageWithDetails, survivors map[string]int) {
+	result, err := s.expansionService.ApplyNobles(march.PlayerID, target, survivors["noble"])
+	if err != nil {
+		s.logger.Error("Error aplicando nobles", zap.String("march_id", march.ID.String()), zap.Error(err))
+		return
+	}
+
+	s.logger.Info("Lealtad reducida",
+		zap.String("march_id", march.ID.String()),
+		zap.String("village_id", target.Village.ID.String()),
+		zap.Int("loyalty_before", result.LoyaltyBefore),
+		zap.Int("loyalty_after", result.LoyaltyAfter),
+		zap.Bool("conquered", result.Conquered),
+		zap.String("blocked_reason", result.BlockedReason),
+	)
+	if result.Conquered {
+		survivors["noble"]--
+		s.sendSupportHome(target.Village.ID, march.PlayerID)
+	}
+
+	if s.wsManager != nil {
+		if err := s.wsManager.SendToUser(march.PlayerID.String(), "loyalty_update", map[string]interface{}{
+			"march_id":       march.ID.String(),
+			"village_id":     result.VillageID.String(),
+			"loyalty_before": result.LoyaltyBefore,
+			"loyalty_after":  result.LoyaltyAfter,
+			"conquered":      result.Conquered,
+			"blocked_reason": result.BlockedReason,
+		}); err != nil {
+			s.logger.Warn("Error enviando resultado de los nobles", zap.Error(err))
+		}
+	}
+}
+
+// sendSupportHome devuelve a su aldea de origen todos los apoyos estacionados en una aldea que
+// acaba de cambiar de dueño
+func (s *MarchService) sendSupportHome(villageID, newOwnerID uuid.UUID) {
+	contingents, err := s.unitRepo.GetStationedTroopsByHost(villageID)
+	if err != nil {
+		s.logger.Error("Error obteniendo apoyos de la aldea conquistada", zap.String("village_id", villageID.String()), zap.Error(err))
+		return
+	}
+	for _, contingent := range contingents {
+		if _, err := s.recallContingent(contingent, contingent.Units, newOwnerID); err != nil {
+			s.logger.Error("Error devolviendo apoyos de la aldea conquistada",
+				zap.String("village_id", villageID.String()),
+				zap.String("origin_village_id", contingent.OriginVillageID.String()),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// GetIntelReports obtiene los informes de exploración de un jugador
+func (s *MarchService) GetIntelReports(playerID uuid.UUID, limit int) ([]*models.IntelReport, error) {
+	return s.intelRepo.GetIntelReportsByPlayer(playerID, limit)
+}
+
+// GetIntelReport obtiene un informe de exploración del jugador
+func (s *MarchService) GetIntelReport(reportID, playerID uuid.UUID) (*models.IntelReport, error) {
+	report, err := s.intelRepo.GetIntelReport(reportID)
+	if err != nil {
+		return nil, err
+	}
+	if report == nil || report.PlayerID != playerID {
+		return nil, fmt.Errorf("informe no encontrado")
+	}
+	return report, nil
+}
+
+// scout resuelve una misión de exploración: los exploradores combaten contra los del defensor
+// y, si alguno sobrevive, se genera un informe cuya precisión depende de los supervivientes.
+func (s *MarchService) scout(march *models.March, target *models.VillageWithDetails) (map[string]int, error) {
+	sent := march.Units["scout"]
+	outcome, err := s.battleService.ResolveScouting(target, sent)
+	if err != nil {
+		return nil, err
+	}
+
+	survived := outcome.AttackerSurvivors["scout"]
+	report := &models.IntelReport{
+		ID:                 uuid.New(),
+		PlayerID:           march.PlayerID,
+		MarchID:            march.ID,
+		SourceVillageID:    march.SourceVillageID,
+		TargetVillageID:    target.Village.ID,
+		TargetPlayerID:     target.Village.PlayerID,
+		Success:            survived > 0,
+		ScoutsSent:         sent,
+		ScoutsLost:         sent - survived,
+		DefenderScoutsLost: outcome.DefenderLosses["scout"],
+		CreatedAt:          time.Now(),
+	}
+	if sent > 0 {
+		report.Accuracy = float64(survived) / float64(sent)
+	}
+	if report.Success {
+		s.gatherIntel(report, target)
+	}
+
+	if err := s.intelRepo.CreateIntelReport(report); err != nil {
+		s.logger.Error("Error guardando informe de exploración", zap.String("march_id", march.ID.String()), zap.Error(err))
+	}
+
+	if s.wsManager != nil {
+		if err := s.wsManager.SendToUser(march.PlayerID.String(), "intel_report", map[string]interface{}{
+			"report_id":         report.ID.String(),
+			"target_village_id": report.TargetVillageID.String(),
+			"success":           report.Success,
+			"accuracy":          report.Accuracy,
+		}); err != nil {
+			s.logger.Warn("Error enviando informe de exploración", zap.Error(err))
+		}
+	}
+	s.notifyScouted(report, target)
+
+	return map[string]int{"scout": survived}, nil
+}
+
+// gatherIntel rellena las secciones del informe según la precisión alcanzada
+func (s *MarchService) gatherIntel(report *models.IntelReport, target *models.VillageWithDetails) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	spread := (1 - report.Accuracy) * intelMaxError
+
+	report.Resources = map[string]int{
+		"wood":  fuzzIntelValue(rng, target.Resources.Wood, spread),
+		"stone": fuzzIntelValue(rng, target.Resources.Stone, spread),
+		"food":  fuzzIntelValue(rng, target.Resources.Food, spread),
+		"gold":  fuzzIntelValue(rng, target.Resources.Gold, spread),
+	}
+
+	if report.Accuracy >= intelBuildingsAccuracy {
+		report.Buildings = make(map[string]int)
+		for buildingType, building := range target.Buildings {
+			report.Buildings[buildingType] = building.Level
+		}
+	}
+
+	if report.Accuracy >= intelGarrisonAccuracy {
+		units, err := s.unitRepo.GetUnitsByVillageID(target.Village.ID)
+		if err != nil {
+			s.logger.Error("Error obteniendo guarnición para el informe", zap.Error(err))
+			return
+		}
+		support, err := s.unitRepo.GetStationedTroopsByHost(target.Village.ID)
+		if err != nil {
+			s.logger.Error("Error obteniendo tropas de apoyo para el informe", zap.Error(err))
+			return
+		}
+
+		// Los exploradores ven a todos los defensores, propios y aliados
+		defenders := make(map[string]int)
+		for _, unit := range units {
+			defenders[unit.Type] += unit.Quantity
+		}
+		for _, contingent := range support {
+			for unitType, quantity := range contingent.Units {
+				defenders[unitType] += quantity
+			}
+		}
+		report.Garrison = make(map[string]int)
+		for unitType, quantity := range defenders {
+			if quantity > 0 {
+				report.Garrison[unitType] = fuzzIntelValue(rng, quantity, spread)
+			}
+		}
+	}
+}
+
+// notifyScouted avisa al defensor de que su aldea ha sido explorada
+func (s *MarchService) notifyScouted(report *models.IntelReport, target *models.VillageWithDetails) {
+	if s.notificationService == nil {
+		return
+	}
+
+	message := fmt.Sprintf("Exploradores enemigos han espiado %s", target.Village.Name)
+	if !report.Success {
+		message = fmt.Sprintf("Tus exploradores repelieron una misión de exploración en %s", target.Village.Name)
+	}
+
+	notification := &models.Notification{
+		PlayerID: target.Village.PlayerID.String(),
+		Type:     "scouted",
+		Title:    "Aldea explorada",
+		Message:  message,
+		Data: map[string]interface{}{
+			"village_id":        target.Village.ID.String(),
+			"source_village_id": report.SourceVillageID.String(),
+			"success":           report.Success,
+			"scouts_killed":     report.ScoutsLost,
+			"scouts_lost":       report.DefenderScoutsLost,
+		},
+	}
+	if err := s.notificationService.CreateNotification(notification); err != nil {
+		s.logger.Error("Error notificando exploración al defensor", zap.Error(err))
+	}
+}
+
+// fuzzIntelValue aplica un error aleatorio de hasta ±spread a una cifra del informe
+func fuzzIntelValue(rng *rand.Rand, value int, spread float64) int {
+	if spread <= 0 || value <= 0 {
+		return value
+	}
+	fuzzed := int(math.Round(float64(value) * (1 + (rng.Float64()*2-1)*spread)))
+	if fuzzed < 0 {
+		return 0
+	}
+	return fuzzed
+}
+
+// plunder saquea la aldea derrotada hasta la capacidad de carga de los supervivientes.
+// El botín viaja con la marcha y se acredita al atacante cuando las tropas regresan.
+func (s *MarchService) plunder(march *models.March, battle *models.Battle, target *models.VillageWithDetails, survivors map[string]int) {
+	capacity := CalculateCarryCapacity(survivors)
+	if capacity <= 0 {
+		return
+	}
+
+	loot, err := s.villageRepo.PlunderResources(target.Village.ID, s.storageProtection(target), capacity)
+	if err != nil {
+		s.logger.Error("Error saqueando aldea", zap.String("village_id", target.Village.ID.String()), zap.Error(err))
+		return
+	}
+	march.Loot = loot
+
+	if err := s.battleService.RecordBattleLoot(battle, loot); err != nil {
+		s.logger.Error("Error registrando botín en el informe", zap.String("battle_id", battle.ID.String()), zap.Error(err))
+	}
+
+	s.logger.Info("Aldea saqueada",
+		zap.String("march_id", march.ID.String()),
+		zap.String("village_id", target.Village.ID.String()),
+		zap.Int("capacity", capacity),
+		zap.Any("loot", loot),
+	)
+}
+
+// storageProtection calcula la cantidad de cada recurso que no puede ser saqueada. El escondite
+// protege su capacidad completa; sin escondite se protege una fracción del almacén.
+func (s *MarchService) storageProtection(village *models.VillageWithDetails) models.Resources {
+	var protection models.Resources
+
+	if hideout, exists := village.Buildings["hideout"]; exists && hideout.Level > 0 {
+		config, err := s.buildingConfigRepo.GetBuildingConfig("hideout", hideout.Level)
+		if err != nil {
+			s.logger.Error("Error obteniendo configuración del escondite", zap.Error(err))
+		} else if config != nil {
+			protection.Wood = config.StorageCapacity
+			protection.Stone = config.StorageCapacity
+			protection.Food = config.StorageCapacity
+			protection.Gold = config.StorageCapacity
+			return protection
+		}
+	}
+
+	if warehouse, exists := village.Buildings["warehouse"]; exists && warehouse.Level > 0 {
+		config, err := s.buildingConfigRepo.GetBuildingConfig("warehouse", warehouse.Level)
+		if err != nil {
+			s.logger.Error("Error obteniendo configuración del almacén", zap.Error(err))
+		} else if config != nil {
+			protected := int(float64(config.StorageCapacity) * warehouseProtectionRatio)
+			protection.Wood = protected
+			protection.Stone = protected
+			protection.Food = protected
+			protection.Gold = protected
+		}
+	}
+
+	return protection
+}
+
+// checkpointVillages materializa los recursos de las aldeas antes de un cambio en su producción,
+// su manutención o sus existencias
+func (s *MarchService) checkpointVillages(villageIDs ...uuid.UUID) {
+	if s.resourceService == nil {
+		return
+	}
+	for _, villageID := range villageIDs {
+		if err := s.resourceService.UpdateResources(villageID); err != nil {
+			s.logger.Warn("Error materializando recursos", zap.String("village_id", villageID.String()), zap.Error(err))
+		}
+	}
+}
+
+// startReturn inicia el viaje de vuelta de una marcha reclamada con las tropas supervivientes
+func (s *MarchService) startReturn(march *models.March, survivors map[string]int) error {
+	units := make(map[string]int)
+	for unitType, quantity := range survivors {
+		if quantity > 0 {
+			units[unitType] = quantity
+		}
+	}
+	march.Units = units
+
+	if len(units) == 0 {
+		march.Status = models.MarchStatusCompleted
+	} else {
+		returnTime := march.ArrivalTime.Add(march.ArrivalTime.Sub(march.DepartureTime))
+		march.Status = models.MarchStatusReturning
+		march.ReturnTime = &returnTime
+	}
+
+	if err := s.marchRepo.UpdateMarch(march, models.MarchStatusResolving); err != nil {
+		return err
+	}
+	s.notifyMarch(march.PlayerID, "march_arrived", march)
+	return nil
+}
+
+// notifyMarch envía el estado de una marcha por WebSocket
+func (s *MarchService) notifyMarch(playerID uuid.UUID, event string, march *models.March) {
+	if s.wsManager == nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"event":             event,
+		"march_id":          march.ID.String(),
+		"type":              march.Type,
+		"status":            march.Status,
+		"source_village_id": march.SourceVillageID.String(),
+		"target_village_id": march.TargetVillageID.String(),
+		"arrival_time":      march.ArrivalTime.Unix(),
+	}
+	// El defensor solo conoce la hora de llegada, no la composición del ejército
+	if playerID == march.PlayerID {
+		data["units"] = march.Units
+		if march.ReturnTime != nil {
+			data["return_time"] = march.ReturnTime.Unix()
+		}
+		if march.BattleID != nil {
+			data["battle_id"] = march.BattleID.String()
+		}
+		if len(march.Loot) > 0 {
+			data["loot"] = march.Loot
+		}
+	}
+
+	if err := s.wsManager.SendToUser(playerID.String(), "march_update", data); err != nil {
+		s.logger.Warn("Error enviando actualización de marcha", zap.Error(err))
+	}
+}
+
+// validateMarchUnits filtra la composición y devuelve la velocidad de la unidad más lenta
+func validateMarchUnits(units map[string]int) (map[string]int, int, error) {
+	filtered := make(map[string]int)
+	slowest := 0
+	for unitType, quantity := range units {
+		if quantity <= 0 {
+			continue
+		}
+		info, exists := models.UnitTypes[unitType]
+		if !exists {
+			return nil, 0, fmt.Errorf("tipo de unidad desconocido: %s", unitType)
+		}
+		filtered[unitType] = quantity
+		if slowest == 0 || info.Speed < slowest {
+			slowest = info.Speed
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, 0, fmt.Errorf("la marcha no tiene unidades")
+	}
+	return filtered, slowest, nil
+}
+
+// CalculateCarryCapacity suma la capacidad de carga de un grupo de tropas
+func CalculateCarryCapacity(units map[string]int) int {
+	capacity := 0
+	for unitType, quantity := range units {
+		if info, exists := models.UnitTypes[unitType]; exists && quantity > 0 {
+			capacity += info.Capacity * quantity
+		}
+	}
+	return capacity
+}
+
+// villageDistance calcula la distancia euclídea entre dos aldeas en casillas del mapa
+func villageDistance(a, b *models.Village) float64 {
+	dx := float64(a.XCoordinate - b.XCoordinate)
+	dy := float64(a.YCoordinate - b.YCoordinate)
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+// travelTime calcula el tiempo de viaje aplicando la velocidad de las unidades del mundo
+func (s *MarchService) travelTime(worldID uuid.UUID, distance float64, slowest int) time.Duration {
+	speed := float64(slowest)
+	if s.worldSettings != nil {
+		speed *= s.worldSettings.SettingsFor(worldID).UnitSpeed
+	}
+	return CalculateTravelTime(distance, speed)
+}
+
+// CalculateTravelTime calcula el tiempo de viaje; la velocidad se expresa en casillas por hora
+func CalculateTravelTime(distance float64, speed float64) time.Duration {
+	if speed <= 0 {
+		speed = 1
+	}
+	travel := time.Duration(distance / speed * float64(time.Hour))
+	if travel < marchMinTravelTime {
+		travel = marchMinTravelTime
+	}
+	return travel
+}
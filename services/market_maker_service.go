@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+
+	"server-backend/models"
+	"server-backend/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var ErrMarketMakerDisabled = errors.New("el mercader NPC no está disponible")
+
+// MarketMakerService gestiona el mercader NPC de cada mundo, que cambia al momento cualquier
+// recurso por otro con precio de producto constante entre sus reservas. Da liquidez a los mundos
+// con poca población, donde la bolsa no tiene contrapartida. Sus parámetros se leen de la
+// configuración de economía.
+type MarketMakerService struct {
+	marketMakerRepo *repository.MarketMakerRepository
+	economyRepo     *repository.EconomyRepository
+	villageRepo     *repository.VillageRepository
+	resourceService *ResourceService
+	logger          *zap.Logger
+}
+
+func NewMarketMakerService(marketMakerRepo *repository.MarketMakerRepository, economyRepo *repository.EconomyRepository, villageRepo *repository.VillageRepository, resourceService *ResourceService, logger *zap.Logger) *MarketMakerService {
+	return &MarketMakerService{
+		marketMakerRepo: marketMakerRepo,
+		economyRepo:     economyRepo,
+		villageRepo:     villageRepo,
+		resourceService: resourceService,
+		logger:          logger,
+	}
+}
+
+// GetPool obtiene las reservas del mercader de un mundo
+func (s *MarketMakerService) GetPool(worldID uuid.UUID) (*models.MarketPool, error) {
+	config, err := s.config()
+	if err != nil {
+		return nil, err
+	}
+	return s.marketMakerRepo.GetPool(worldID, config)
+}
+
+// Quote calcula lo que se recibiría al cambiar amount unidades de from por to en un mundo. El
+// precio puede variar si otro jugador cambia antes; PoolSwapRequest.MinAmountOut acota cuánto.
+func (s *MarketMakerService) Quote(worldID uuid.UUID, from, to string, amount int) (*models.SwapQuote, error) {
+	config, err := s.config()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := s.marketMakerRepo.GetPool(worldID, config)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := pool.Quote(from, to, amount, config.Fee)
+	if quote == nil || quote.AmountOut <= 0 {
+		return nil, repository.ErrPoolInvalidSwap
+	}
+	if quote.PriceImpact > config.MaxPriceImpact {
+		return nil, repository.ErrPoolPriceImpact
+	}
+	return quote, nil
+}
+
+// Swap cambia recursos de una aldea del jugador con el mercader de su mundo
+func (s *MarketMakerService) Swap(playerID uuid.UUID, req *models.PoolSwapRequest) (*models.PoolSwap, *models.MarketPool, error) {
+	config, err := s.config()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	village, err := s.villageRepo.GetVillageByID(req.VillageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if village == nil || village.Village.PlayerID != playerID {
+		return nil, nil, repository.ErrTradeVillageNotOwned
+	}
+
+	if err := s.resourceService.UpdateResources(req.VillageID); err != nil {
+		return nil, nil, err
+	}
+	swap, pool, err := s.marketMakerRepo.Swap(village.Village.WorldID, playerID, req.VillageID,
+		req.FromResource, req.ToResource, req.Amount, req.MinAmountOut, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.logger.Info("Cambio con el mercader NPC",
+		zap.String("player_id", playerID.String()),
+		zap.String("village_id", req.VillageID.String()),
+		zap.String("from", swap.FromResource),
+		zap.String("to", swap.ToResource),
+		zap.Int("amount_in", swap.AmountIn),
+		zap.Int("amount_out", swap.AmountOut),
+		zap.Int("fee", swap.Fee),
+	)
+	return swap, pool, nil
+}
+
+// GetPlayerSwaps obtiene los últimos cambios del jugador con el mercader
+func (s *MarketMakerService) GetPlayerSwaps(playerID uuid.UUID, limit int) ([]*models.PoolSwap, error) {
+	return s.marketMakerRepo.GetPlayerSwaps(playerID, limit)
+}
+
+// config lee los parámetros del mercader y comprueba que esté activo
+func (s *MarketMakerService) config() (*models.MarketMakerConfig, error) {
+	config, err := s.economyRepo.GetMarketMakerConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !config.Enabled {
+		return nil, ErrMarketMakerDisabled
+	}
+	return config, nil
+}
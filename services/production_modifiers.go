@@ -0,0 +1,329 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"server-backend/models"
+	"server-backend/repository"
+)
+
+// productionResources son los recursos que producen los edificios, en el orden del desglose
+var productionResources = []string{"wood", "stone", "food", "gold"}
+
+// modifierTargets son los recursos a los que puede dirigirse un modificador
+var modifierTargets = append(append([]string{}, productionResources...), models.ProductionResourceAll)
+
+// allianceProductionBonusPerLevel es la bonificación de producción por nivel de la alianza
+const allianceProductionBonusPerLevel = 0.05
+
+// ProductionModifierProvider aporta modificadores a la producción de una aldea. Cada sistema que
+// quiera afectar a la producción (investigación, héroes, alianzas, eventos, títulos, oasis...)
+// registra un proveedor en el ResourceService.
+type ProductionModifierProvider interface {
+	ProductionModifiers(village *models.VillageWithDetails) ([]models.ProductionModifier, error)
+}
+
+// applyProductionModifiers aplica los modificadores a la producción base de los edificios. Primero
+// se suman los valores fijos, después los porcentajes sobre esa suma y por último los
+// multiplicadores en orden. Cada fuente se lleva lo que su modificador añade en su etapa.
+func applyProductionModifiers(base models.ProductionAmounts, modifiers []models.ProductionModifier) *models.ProductionBreakdown {
+	breakdown := &models.ProductionBreakdown{Base: base, Total: base, Sources: []*models.ProductionContribution{}}
+
+	sources := make(map[string]*models.ProductionContribution)
+	contribution := func(source string) *models.ProductionContribution {
+		if c, ok := sources[source]; ok {
+			return c
+		}
+		c := &models.ProductionContribution{Source: source, Modifiers: []models.ProductionModifier{}}
+		sources[source] = c
+		breakdown.Sources = append(breakdown.Sources, c)
+		return c
+	}
+	for _, modifier := range modifiers {
+		c := contribution(modifier.Source)
+		c.Modifiers = append(c.Modifiers, modifier)
+	}
+
+	for _, resource := range productionResources {
+		gains := make(map[string]float64)
+		value := float64(base.Get(resource))
+
+		for _, modifier := range modifiers {
+			if modifier.Applies(resource) && modifier.Flat != 0 {
+				gains[modifier.Source] += modifier.Flat
+				value += modifier.Flat
+			}
+		}
+		percentBase := value
+		for _, modifier := range modifiers {
+			if modifier.Applies(resource) && modifier.Percent != 0 {
+				gain := percentBase * modifier.Percent
+				gains[modifier.Source] += gain
+				value += gain
+			}
+		}
+		for _, modifier := range modifiers {
+			if modifier.Applies(resource) && modifier.Multiplier != 0 {
+				gain := value * (modifier.Multiplier - 1)
+				gains[modifier.Source] += gain
+				value += gain
+			}
+		}
+
+		// El total se obtiene de las aportaciones redondeadas para que el desglose cuadre
+		total := base.Get(resource)
+		for source, gain := range gains {
+			rounded := int(math.Round(gain))
+			sources[source].Amounts.Add(resource, rounded)
+			total += rounded
+		}
+		if total < 0 {
+			total = 0
+		}
+		breakdown.Total.Set(resource, total)
+	}
+
+	return breakdown
+}
+
+// ResearchProductionProvider aplica los efectos de producción de las tecnologías investigadas
+type ResearchProductionProvider struct {
+	researchRepo *repository.ResearchRepository
+}
+
+func NewResearchProductionProvider(researchRepo *repository.ResearchRepository) *ResearchProductionProvider {
+	return &ResearchProductionProvider{researchRepo: researchRepo}
+}
+
+// ProductionModifiers devuelve, por tecnología y recurso, el efecto del mayor nivel alcanzado. Los
+// objetivos tienen la forma "wood_production" o "all_production".
+func (p *ResearchProductionProvider) ProductionModifiers(village *models.VillageWithDetails) ([]models.ProductionModifier, error) {
+	technologies, err := p.researchRepo.GetPlayerTechnologies(village.Village.PlayerID.String())
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo tecnologías: %w", err)
+	}
+
+	var modifiers []models.ProductionModifier
+	for _, playerTech := range technologies {
+		if playerTech.Level <= 0 {
+			continue
+		}
+		effects, err := p.researchRepo.GetTechnologyEffects(playerTech.TechnologyID)
+		if err != nil {
+			return nil, err
+		}
+
+		best := make(map[string]models.TechnologyEffect)
+		for _, effect := range effects {
+			if effect.EffectType != "production" || effect.Level > playerTech.Level {
+				continue
+			}
+			if current, ok := best[effect.Target]; !ok || effect.Level > current.Level {
+				best[effect.Target] = effect
+			}
+		}
+
+		for _, resource := range modifierTargets {
+			effect, ok := best[resource+"_production"]
+			if !ok {
+				continue
+			}
+			modifier := models.ProductionModifier{
+				Source:   models.ProductionSourceResearch,
+				Name:     playerTech.TechnologyID,
+				Resource: resource,
+			}
+			if effect.IsPercentage {
+				modifier.Percent = effect.Value / 100
+			} else {
+				modifier.Flat = effect.Value
+			}
+			modifiers = append(modifiers, modifier)
+		}
+	}
+	return modifiers, nil
+}
+
+// OasisProductionProvider aplica la bonificación de los oasis que controla la aldea
+type OasisProductionProvider struct {
+	mapRepo *repository.MapRepository
+}
+
+func NewOasisProductionProvider(mapRepo *repository.MapRepository) *OasisProductionProvider {
+	return &OasisProductionProvider{mapRepo: mapRepo}
+}
+
+func (p *OasisProductionProvider) ProductionModifiers(village *models.VillageWithDetails) ([]models.ProductionModifier, error) {
+	sites, err := p.mapRepo.GetSitesByOwner(village.Village.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo oasis: %w", err)
+	}
+
+	var modifiers []models.ProductionModifier
+	for _, site := range sites {
+		if site.Type != models.MapSiteOasis || site.Resource == "" {
+			continue
+		}
+		modifiers = append(modifiers, models.ProductionModifier{
+			Source:   models.ProductionSourceOasis,
+			Name:     fmt.Sprintf("Oasis (%d|%d)", site.X, site.Y),
+			Resource: site.Resource,
+			Percent:  site.Bonus,
+		})
+	}
+	return modifiers, nil
+}
+
+// EventProductionProvider aplica las bonificaciones de producción de los eventos activos. Se leen
+// de la clave "production" de sus efectos especiales, por ejemplo {"production": {"food": 0.5}}.
+type EventProductionProvider struct {
+	eventRepo *repository.EventRepository
+}
+
+func NewEventProductionProvider(eventRepo *repository.EventRepository) *EventProductionProvider {
+	return &EventProductionProvider{eventRepo: eventRepo}
+}
+
+func (p *EventProductionProvider) ProductionModifiers(village *models.VillageWithDetails) ([]models.ProductionModifier, error) {
+	events, err := p.eventRepo.GetActiveEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var modifiers []models.ProductionModifier
+	for _, event := range events {
+		if event.SpecialEffects == "" || now.Before(event.StartDate) || now.After(event.EndDate) {
+			continue
+		}
+		production := productionEffects(event.SpecialEffects)
+		for _, resource := range modifierTargets {
+			percent, ok := production[resource]
+			if !ok {
+				continue
+			}
+			modifiers = append(modifiers, models.ProductionModifier{
+				Source:   models.ProductionSourceEvent,
+				Name:     event.Name,
+				Resource: resource,
+				Percent:  percent,
+			})
+		}
+	}
+	return modifiers, nil
+}
+
+// HeroProductionProvider aplica las habilidades de producción de los héroes activos del jugador. Se
+// leen de la clave "production" de sus habilidades como porcentaje por nivel del héroe, por ejemplo
+// {"production": {"wood": 0.01}} da +1% de madera por nivel.
+type HeroProductionProvider struct {
+	heroRepo *repository.HeroRepository
+}
+
+func NewHeroProductionProvider(heroRepo *repository.HeroRepository) *HeroProductionProvider {
+	return &HeroProductionProvider{heroRepo: heroRepo}
+}
+
+func (p *HeroProductionProvider) ProductionModifiers(village *models.VillageWithDetails) ([]models.ProductionModifier, error) {
+	heroes, err := p.heroRepo.GetActiveHeroEffects(village.Village.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var modifiers []models.ProductionModifier
+	for _, hero := range heroes {
+		production := productionEffects(hero.Effects)
+		for _, resource := range modifierTargets {
+			percent, ok := production[resource]
+			if !ok || hero.Level <= 0 {
+				continue
+			}
+			modifiers = append(modifiers, models.ProductionModifier{
+				Source:   models.ProductionSourceHero,
+				Name:     hero.Name,
+				Resource: resource,
+				Percent:  percent * float64(hero.Level),
+			})
+		}
+	}
+	return modifiers, nil
+}
+
+// AllianceProductionProvider aplica la bonificación de producción de la alianza del jugador, que
+// crece con su nivel
+type AllianceProductionProvider struct {
+	allianceRepo *repository.AllianceRepository
+}
+
+func NewAllianceProductionProvider(allianceRepo *repository.AllianceRepository) *AllianceProductionProvider {
+	return &AllianceProductionProvider{allianceRepo: allianceRepo}
+}
+
+func (p *AllianceProductionProvider) ProductionModifiers(village *models.VillageWithDetails) ([]models.ProductionModifier, error) {
+	name, level, err := p.allianceRepo.GetPlayerAllianceLevel(village.Village.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+	if level <= 0 {
+		return nil, nil
+	}
+	return []models.ProductionModifier{{
+		Source:   models.ProductionSourceAlliance,
+		Name:     name,
+		Resource: models.ProductionResourceAll,
+		Percent:  allianceProductionBonusPerLevel * float64(level),
+	}}, nil
+}
+
+// TitleProductionProvider aplica las bonificaciones de producción de los títulos equipados. Se leen
+// de la clave "production" de sus bonificaciones, por ejemplo {"production": {"gold": 0.05}}.
+type TitleProductionProvider struct {
+	titleRepo *repository.TitleRepository
+}
+
+func NewTitleProductionProvider(titleRepo *repository.TitleRepository) *TitleProductionProvider {
+	return &TitleProductionProvider{titleRepo: titleRepo}
+}
+
+func (p *TitleProductionProvider) ProductionModifiers(village *models.VillageWithDetails) ([]models.ProductionModifier, error) {
+	titles, err := p.titleRepo.GetEquippedTitleBonuses(village.Village.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var modifiers []models.ProductionModifier
+	for _, title := range titles {
+		production := productionEffects(title.Effects)
+		for _, resource := range modifierTargets {
+			percent, ok := production[resource]
+			if !ok {
+				continue
+			}
+			modifiers = append(modifiers, models.ProductionModifier{
+				Source:   models.ProductionSourceTitle,
+				Name:     title.Name,
+				Resource: resource,
+				Percent:  percent,
+			})
+		}
+	}
+	return modifiers, nil
+}
+
+// productionEffects lee los porcentajes de producción por recurso de la clave "production" de un
+// JSON de efectos. Devuelve nil si el JSON está vacío o no es válido.
+func productionEffects(raw string) map[string]float64 {
+	if raw == "" {
+		return nil
+	}
+	var effects struct {
+		Production map[string]float64 `json:"production"`
+	}
+	if err := json.Unmarshal([]byte(raw), &effects); err != nil {
+		return nil
+	}
+	return effects.Production
+}
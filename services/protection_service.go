@@ -0,0 +1,349 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"server-backend/models"
+	"server-backend/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ProtectionService aplica el escudo de principiante, el modo vacaciones y los escudos de paz
+// según las reglas del tipo de mundo
+type ProtectionService struct {
+	protectionRepo *repository.ProtectionRepository
+	worldRepo      *repository.WorldRepository
+	villageRepo    *repository.VillageRepository
+	marchRepo      *repository.MarchRepository
+	worldSettings  *WorldSettingsService
+	logger         *zap.Logger
+}
+
+func NewProtectionService(protectionRepo *repository.ProtectionRepository, worldRepo *repository.WorldRepository, villageRepo *repository.VillageRepository, marchRepo *repository.MarchRepository, logger *zap.Logger) *ProtectionService {
+	return &ProtectionService{
+		protectionRepo: protectionRepo,
+		worldRepo:      worldRepo,
+		villageRepo:    villageRepo,
+		marchRepo:      marchRepo,
+		logger:         logger,
+	}
+}
+
+// SetWorldSettingsService establece el servicio de reglas de mundo, que fija la duración del escudo
+// de principiante
+func (s *ProtectionService) SetWorldSettingsService(worldSettings *WorldSettingsService) {
+	s.worldSettings = worldSettings
+}
+
+// worldRules obtiene el tipo y las reglas de protección de un mundo
+func (s *ProtectionService) worldRules(worldID uuid.UUID) (string, models.ProtectionRules, error) {
+	world, err := s.worldRepo.GetWorldByID(worldID)
+	if err != nil {
+		return "", models.ProtectionRules{}, err
+	}
+	if world == nil {
+		return "", models.ProtectionRules{}, fmt.Errorf("mundo no encontrado")
+	}
+	rules := models.GetProtectionRules(world.WorldType)
+	if s.worldSettings != nil {
+		rules.BeginnerShield = time.Duration(s.worldSettings.SettingsFor(worldID).ProtectionHours) * time.Hour
+	}
+	return world.WorldType, rules, nil
+}
+
+// playerWorld obtiene el mundo de un jugador a partir de sus aldeas
+func (s *ProtectionService) playerWorld(playerID uuid.UUID) (uuid.UUID, []*models.VillageWithDetails, error) {
+	villages, err := s.villageRepo.GetVillagesByPlayerID(playerID)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	if len(villages) == 0 {
+		return uuid.Nil, nil, fmt.Errorf("el jugador no tiene aldeas")
+	}
+	return villages[0].Village.WorldID, villages, nil
+}
+
+// VillageProtection calcula la protección vigente de una aldea. Si varias se solapan se devuelve
+// la que dura más; nil si la aldea es atacable.
+func VillageProtection(createdAt time.Time, protection *models.PlayerProtection, rules models.ProtectionRules, now time.Time) *models.ProtectionStatus {
+	var status *models.ProtectionStatus
+	consider := func(kind string, until time.Time) {
+		if until.After(now) && (status == nil || until.After(status.Until)) {
+			status = &models.ProtectionStatus{Type: kind, Until: until}
+		}
+	}
+
+	// El escudo de principiante solo se pierde para las aldeas fundadas antes del primer ataque
+	if rules.BeginnerShield > 0 && !createdAt.IsZero() {
+		if protection == nil || protection.BeginnerForfeitedAt == nil || protection.BeginnerForfeitedAt.Before(createdAt) {
+			consider(models.ProtectionBeginner, createdAt.Add(rules.BeginnerShield))
+		}
+	}
+	if protection != nil {
+		if protection.VacationUntil != nil && protection.VacationStartedAt != nil && !protection.VacationStartedAt.After(now) {
+			consider(models.ProtectionVacation, *protection.VacationUntil)
+		}
+		if protection.PeaceUntil != nil {
+			consider(models.ProtectionPeace, *protection.PeaceUntil)
+		}
+	}
+	return status
+}
+
+// onVacation indica si el jugador está en modo vacaciones
+func onVacation(protection *models.PlayerProtection, now time.Time) bool {
+	return protection != nil && protection.VacationStartedAt != nil && protection.VacationUntil != nil &&
+		!protection.VacationStartedAt.After(now) && protection.VacationUntil.After(now)
+}
+
+// CheckAttack valida que un jugador pueda atacar una aldea según las reglas del mundo, el modo
+// vacaciones del atacante y la protección del defensor
+func (s *ProtectionService) CheckAttack(attackerID uuid.UUID, target *models.Village) error {
+	_, rules, err := s.worldRules(target.WorldID)
+	if err != nil {
+		return err
+	}
+	if !rules.AttacksAllowed {
+		return fmt.Errorf("los ataques entre jugadores no están permitidos en este mundo")
+	}
+
+	now := time.Now()
+	attackerProtection, err := s.protectionRepo.GetProtection(attackerID)
+	if err != nil {
+		return err
+	}
+	if onVacation(attackerProtection, now) {
+		return fmt.Errorf("no puedes atacar mientras estás en modo vacaciones")
+	}
+
+	defenderProtection, err := s.protectionRepo.GetProtection(target.PlayerID)
+	if err != nil {
+		return err
+	}
+	if status := VillageProtection(target.CreatedAt, defenderProtection, rules, now); status != nil {
+		return fmt.Errorf("la aldea está protegida (%s) hasta %s", status.Type, status.Until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// OnAttackLaunched retira al atacante el escudo de principiante y el de paz
+func (s *ProtectionService) OnAttackLaunched(attackerID uuid.UUID) {
+	if err := s.protectionRepo.ForfeitShields(attackerID, time.Now()); err != nil {
+		s.logger.Error("Error retirando escudos al atacante",
+			zap.String("player_id", attackerID.String()),
+			zap.Error(err),
+		)
+	}
+}
+
+// GetPlayerProtection resume la protección de un jugador y de cada una de sus aldeas
+func (s *ProtectionService) GetPlayerProtection(playerID uuid.UUID) (*models.PlayerProtectionInfo, error) {
+	worldID, villages, err := s.playerWorld(playerID)
+	if err != nil {
+		return nil, err
+	}
+	worldType, rules, err := s.worldRules(worldID)
+	if err != nil {
+		return nil, err
+	}
+	protection, err := s.protectionRepo.GetProtection(playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	info := &models.PlayerProtectionInfo{
+		WorldType:      worldType,
+		AttacksAllowed: rules.AttacksAllowed,
+		Villages:       make(map[uuid.UUID]*models.ProtectionStatus, len(villages)),
+		OnVacation:     onVacation(protection, now),
+		Rules:          rules,
+	}
+	for _, village := range villages {
+		info.Villages[village.Village.ID] = VillageProtection(village.Village.CreatedAt, protection, rules, now)
+	}
+	if protection != nil {
+		if info.OnVacation {
+			info.VacationUntil = protection.VacationUntil
+		}
+		if protection.VacationCooldownUntil != nil && protection.VacationCooldownUntil.After(now) {
+			info.VacationAvailableAt = protection.VacationCooldownUntil
+		}
+		if protection.PeaceUntil != nil && protection.PeaceUntil.After(now) {
+			info.PeaceUntil = protection.PeaceUntil
+		}
+		info.BeginnerShieldForfeit = protection.BeginnerForfeitedAt != nil
+	}
+	return info, nil
+}
+
+// AnnotateVillages rellena la protección vigente de las aldeas de un mundo para el mapa
+func (s *ProtectionService) AnnotateVillages(worldID uuid.UUID, villages []*models.MapVillage) error {
+	if len(villages) == 0 {
+		return nil
+	}
+	_, rules, err := s.worldRules(worldID)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	var playerIDs []uuid.UUID
+	for _, village := range villages {
+		if !seen[village.PlayerID] {
+			seen[village.PlayerID] = true
+			playerIDs = append(playerIDs, village.PlayerID)
+		}
+	}
+	protections, err := s.protectionRepo.GetProtections(playerIDs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, village := range villages {
+		village.Protection = VillageProtection(village.CreatedAt, protections[village.PlayerID], rules, now)
+	}
+	return nil
+}
+
+// ActivateVacation activa el modo vacaciones durante las horas indicadas
+func (s *ProtectionService) ActivateVacation(playerID uuid.UUID, hours int) (*models.PlayerProtectionInfo, error) {
+	worldID, _, err := s.playerWorld(playerID)
+	if err != nil {
+		return nil, err
+	}
+	_, rules, err := s.worldRules(worldID)
+	if err != nil {
+		return nil, err
+	}
+	if !rules.VacationEnabled {
+		return nil, fmt.Errorf("el modo vacaciones no está disponible en este mundo")
+	}
+
+	duration := time.Duration(hours) * time.Hour
+	if duration < rules.VacationMinDuration || duration > rules.VacationMaxDuration {
+		return nil, fmt.Errorf("la duración debe estar entre %.0f y %.0f horas",
+			rules.VacationMinDuration.Hours(), rules.VacationMaxDuration.Hours())
+	}
+
+	now := time.Now()
+	protection, err := s.protectionRepo.GetProtection(playerID)
+	if err != nil {
+		return nil, err
+	}
+	if onVacation(protection, now) {
+		return nil, fmt.Errorf("ya estás en modo vacaciones")
+	}
+	if protection != nil && protection.VacationCooldownUntil != nil && protection.VacationCooldownUntil.After(now) {
+		return nil, fmt.Errorf("podrás volver a activar el modo vacaciones a partir de %s",
+			protection.VacationCooldownUntil.Format(time.RFC3339))
+	}
+	if err := s.checkNoIncomingAttacks(playerID); err != nil {
+		return nil, err
+	}
+
+	until := now.Add(duration)
+	if err := s.protectionRepo.SetVacation(playerID, now, until, until.Add(rules.VacationCooldown)); err != nil {
+		return nil, fmt.Errorf("error activando modo vacaciones: %w", err)
+	}
+
+	s.logger.Info("Modo vacaciones activado",
+		zap.String("player_id", playerID.String()),
+		zap.Time("until", until),
+	)
+	return s.GetPlayerProtection(playerID)
+}
+
+// EndVacation termina el modo vacaciones antes de tiempo. El enfriamiento cuenta desde ahora.
+func (s *ProtectionService) EndVacation(playerID uuid.UUID) (*models.PlayerProtectionInfo, error) {
+	worldID, _, err := s.playerWorld(playerID)
+	if err != nil {
+		return nil, err
+	}
+	_, rules, err := s.worldRules(worldID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	protection, err := s.protectionRepo.GetProtection(playerID)
+	if err != nil {
+		return nil, err
+	}
+	if !onVacation(protection, now) {
+		return nil, fmt.Errorf("no estás en modo vacaciones")
+	}
+
+	if err := s.protectionRepo.SetVacation(playerID, *protection.VacationStartedAt, now, now.Add(rules.VacationCooldown)); err != nil {
+		return nil, fmt.Errorf("error terminando modo vacaciones: %w", err)
+	}
+	return s.GetPlayerProtection(playerID)
+}
+
+// BuyPeaceShield compra un escudo de paz con moneda global. Si ya hay uno vigente se prolonga.
+func (s *ProtectionService) BuyPeaceShield(playerID uuid.UUID, hours int) (*models.PlayerProtectionInfo, error) {
+	worldID, _, err := s.playerWorld(playerID)
+	if err != nil {
+		return nil, err
+	}
+	_, rules, err := s.worldRules(worldID)
+	if err != nil {
+		return nil, err
+	}
+
+	var option *models.PeaceShieldOption
+	for i := range rules.PeaceShields {
+		if rules.PeaceShields[i].Hours == hours {
+			option = &rules.PeaceShields[i]
+			break
+		}
+	}
+	if option == nil {
+		return nil, fmt.Errorf("escudo de paz de %d horas no disponible en este mundo", hours)
+	}
+	if err := s.checkNoIncomingAttacks(playerID); err != nil {
+		return nil, err
+	}
+
+	// El cobro y el escudo se guardan juntos: no se cobra un escudo que no llega a activarse
+	description := fmt.Sprintf("Escudo de paz de %d horas", option.Hours)
+	until, err := s.protectionRepo.BuyPeaceShield(playerID, time.Duration(option.Hours)*time.Hour, option.Cost, description)
+	if err != nil {
+		return nil, fmt.Errorf("error activando escudo de paz: %w", err)
+	}
+
+	s.logger.Info("Escudo de paz comprado",
+		zap.String("player_id", playerID.String()),
+		zap.Int("hours", option.Hours),
+		zap.Time("until", until),
+	)
+	return s.GetPlayerProtection(playerID)
+}
+
+// checkNoIncomingAttacks impide activar protecciones con ataques ya en camino
+func (s *ProtectionService) checkNoIncomingAttacks(playerID uuid.UUID) error {
+	incoming, err := s.marchRepo.CountIncomingAttacks(playerID)
+	if err != nil {
+		return err
+	}
+	if incoming > 0 {
+		return fmt.Errorf("no puedes activar una protección con %d ataques en camino", incoming)
+	}
+	return nil
+}
+
+// FrozenHours devuelve cuántas horas del intervalo [from, to] pasó el jugador en modo
+// vacaciones, durante las que la producción y el consumo de sus aldeas quedan congelados
+func (s *ProtectionService) FrozenHours(playerID uuid.UUID, from, to time.Time) float64 {
+	hours, err := s.protectionRepo.GetVacationHours(playerID, from, to)
+	if err != nil {
+		s.logger.Warn("Error obteniendo vacaciones del jugador", zap.Error(err))
+		return 0
+	}
+	return math.Max(hours, 0)
+}
@@ -2,27 +2,43 @@ package services
 
 import (
 	"fmt"
+	"math"
 	"server-backend/models"
 	"server-backend/repository"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// Manutención de ejércitos
+const (
+	// starvationDesertionRate es la fracción del ejército que deserta por cada hora sin comida
+	starvationDesertionRate = 0.1
+)
+
 type ResourceService struct {
-	villageRepo        *repository.VillageRepository
-	buildingConfigRepo *repository.BuildingConfigRepository
-	logger             *zap.Logger
-	wsManager          interface{}
-	redisService       *RedisService
-	metrics            *models.ResourceMetrics
+	villageRepo         *repository.VillageRepository
+	buildingConfigRepo  *repository.BuildingConfigRepository
+	unitRepo            *repository.UnitRepository
+	marchRepo           *repository.MarchRepository
+	productionProviders []ProductionModifierProvider
+	worldSettings       *WorldSettingsService
+	notificationService *NotificationService
+	protectionService   *ProtectionService
+	logger              *zap.Logger
+	wsManager           interface{}
+	redisService        *RedisService
+	metrics             *models.ResourceMetrics
 }
 
-func NewResourceService(villageRepo *repository.VillageRepository, buildingConfigRepo *repository.BuildingConfigRepository, logger *zap.Logger, redisService *RedisService) *ResourceService {
+func NewResourceService(villageRepo *repository.VillageRepository, buildingConfigRepo *repository.BuildingConfigRepository, unitRepo *repository.UnitRepository, marchRepo *repository.MarchRepository, logger *zap.Logger, redisService *RedisService) *ResourceService {
 	return &ResourceService{
 		villageRepo:        villageRepo,
 		buildingConfigRepo: buildingConfigRepo,
+		unitRepo:           unitRepo,
+		marchRepo:          marchRepo,
 		logger:             logger,
 		redisService:       redisService,
 		metrics: &models.ResourceMetrics{
@@ -40,16 +56,43 @@ func (s *ResourceService) SetWebSocketManager(wsManager interface{}) {
 	s.wsManager = wsManager
 }
 
-// CalculateProduction calcula la producción de recursos basada en los edificios actuales
+// SetNotificationService establece el servicio de notificaciones para avisar de deserciones
+func (s *ResourceService) SetNotificationService(notificationService *NotificationService) {
+	s.notificationService = notificationService
+}
+
+// SetProtectionService establece el servicio de protección para congelar la producción en vacaciones
+func (s *ResourceService) SetProtectionService(protectionService *ProtectionService) {
+	s.protectionService = protectionService
+}
+
+// SetWorldSettingsService establece el servicio de reglas de mundo, que aplica el multiplicador de
+// almacenamiento. El de producción se registra como una fuente de modificadores más.
+func (s *ResourceService) SetWorldSettingsService(worldSettings *WorldSettingsService) {
+	s.worldSettings = worldSettings
+}
+
+// RegisterProductionModifierProvider añade una fuente de modificadores de producción. Las fuentes
+// se consultan en el orden de registro.
+func (s *ResourceService) RegisterProductionModifierProvider(provider ProductionModifierProvider) {
+	s.productionProviders = append(s.productionProviders, provider)
+}
+
+// CalculateProduction calcula la producción de recursos basada en los edificios actuales y los
+// modificadores de todas las fuentes registradas
 func (s *ResourceService) CalculateProduction(village *models.VillageWithDetails) models.Resources {
-	production := models.Resources{
-		Wood:  0,
-		Stone: 0,
-		Food:  0,
-		Gold:  0,
-	}
+	return productionResourcesOf(s.CalculateProductionBreakdown(village))
+}
+
+// CalculateProductionBreakdown calcula la producción de la aldea desglosada por fuente
+func (s *ResourceService) CalculateProductionBreakdown(village *models.VillageWithDetails) *models.ProductionBreakdown {
+	return applyProductionModifiers(s.calculateBaseProduction(village), s.productionModifiers(village))
+}
+
+// calculateBaseProduction suma la producción por hora de cada edificio según su configuración
+func (s *ResourceService) calculateBaseProduction(village *models.VillageWithDetails) models.ProductionAmounts {
+	var production models.ProductionAmounts
 
-	// Calcular producción de cada edificio de recursos
 	for buildingType, building := range village.Buildings {
 		if building.Level > 0 {
 			// Obtener configuración del edificio para su nivel actual
@@ -62,27 +105,45 @@ func (s *ResourceService) CalculateProduction(village *models.VillageWithDetails
 				)
 				continue
 			}
-			if config == nil {
+			if config == nil || config.ProducedResource == "" {
 				continue
 			}
 
-			// Sumar producción según el tipo de edificio
-			switch buildingType {
-			case "wood_cutter":
-				production.Wood += config.ProductionPerHour
-			case "stone_quarry":
-				production.Stone += config.ProductionPerHour
-			case "farm":
-				production.Food += config.ProductionPerHour
-			case "gold_mine":
-				production.Gold += config.ProductionPerHour
-			}
+			production.Add(config.ProducedResource, config.ProductionPerHour)
 		}
 	}
 
 	return production
 }
 
+// productionModifiers reúne los modificadores de todas las fuentes. Una fuente que falla se omite
+// para que no detenga la producción de la aldea.
+func (s *ResourceService) productionModifiers(village *models.VillageWithDetails) []models.ProductionModifier {
+	var modifiers []models.ProductionModifier
+	for _, provider := range s.productionProviders {
+		providerModifiers, err := provider.ProductionModifiers(village)
+		if err != nil {
+			s.logger.Warn("Error obteniendo modificadores de producción",
+				zap.String("village_id", village.Village.ID.String()),
+				zap.Error(err),
+			)
+			continue
+		}
+		modifiers = append(modifiers, providerModifiers...)
+	}
+	return modifiers
+}
+
+// productionResourcesOf convierte el total de un desglose en recursos por hora
+func productionResourcesOf(breakdown *models.ProductionBreakdown) models.Resources {
+	return models.Resources{
+		Wood:  breakdown.Total.Wood,
+		Stone: breakdown.Total.Stone,
+		Food:  breakdown.Total.Food,
+		Gold:  breakdown.Total.Gold,
+	}
+}
+
 // CalculateStorageCapacity calcula la capacidad de almacenamiento basada en los edificios
 func (s *ResourceService) CalculateStorageCapacity(village *models.VillageWithDetails) models.Resources {
 	capacity := models.Resources{
@@ -120,329 +181,457 @@ func (s *ResourceService) CalculateStorageCapacity(village *models.VillageWithDe
 		}
 	}
 
-	return capacity
-}
-
-// UpdateResources actualiza los recursos de una aldea
-func (s *ResourceService) UpdateResources(villageID uuid.UUID) error {
-	village, err := s.villageRepo.GetVillageByID(villageID)
-	if err != nil {
-		s.logger.Error("Error obteniendo aldea para actualizar recursos",
-			zap.String("village_id", villageID.String()),
-			zap.Error(err),
-		)
-		return err
-	}
-	if village == nil {
-		s.logger.Warn("Aldea no encontrada para actualizar recursos",
-			zap.String("village_id", villageID.String()),
-		)
-		return nil
+	if s.worldSettings != nil {
+		multiplier := s.worldSettings.SettingsFor(village.Village.WorldID).StorageMultiplier
+		capacity.Wood = int(float64(capacity.Wood) * multiplier)
+		capacity.Stone = int(float64(capacity.Stone) * multiplier)
+		capacity.Food = int(float64(capacity.Food) * multiplier)
+		capacity.Gold = int(float64(capacity.Gold) * multiplier)
 	}
 
-	// Calcular producción por hora
-	production := s.CalculateProduction(village)
+	return capacity
+}
 
-	// Log de diagnóstico de producción
-	s.logger.Info("Calculando producción de recursos",
-		zap.String("village_id", villageID.String()),
-		zap.String("village_name", village.Village.Name),
-		zap.Int("wood_production", production.Wood),
-		zap.Int("stone_production", production.Stone),
-		zap.Int("food_production", production.Food),
-		zap.Int("gold_production", production.Gold),
-		zap.Int("buildings_count", len(village.Buildings)),
-	)
+// resourceAccrual es el resultado de llevar las existencias de una aldea hasta un instante
+type resourceAccrual struct {
+	Stock         models.Resources // existencias en el instante calculado
+	Generated     models.Resources // variación desde la última materialización
+	Production    models.Resources // producción bruta por hora vigente al final del periodo
+	Capacity      models.Resources // capacidad vigente al final del periodo
+	Upkeep        int
+	StarvingHours float64 // horas que la aldea ha pasado sin comida
+	ElapsedHours  float64
+}
 
-	// Calcular tiempo transcurrido desde la última actualización
-	now := time.Now()
-	lastUpdate := village.Resources.LastUpdated
-	if lastUpdate.IsZero() {
-		lastUpdate = now
-		s.logger.Info("Primera actualización de recursos - inicializando last_updated",
-			zap.String("village_id", villageID.String()),
-		)
+// buildingsAt devuelve la aldea con los niveles de edificio vigentes en un instante. El nivel de
+// un edificio sube al iniciar la mejora, así que hasta que termina cuenta como el nivel anterior.
+func buildingsAt(village *models.VillageWithDetails, at time.Time) *models.VillageWithDetails {
+	effective := *village
+	effective.Buildings = make(map[string]*models.Building, len(village.Buildings))
+	for buildingType, building := range village.Buildings {
+		if building.IsUpgrading && building.UpgradeCompletionTime != nil && building.UpgradeCompletionTime.After(at) && building.Level > 0 {
+			pending := *building
+			pending.Level--
+			effective.Buildings[buildingType] = &pending
+			continue
+		}
+		effective.Buildings[buildingType] = building
 	}
+	return &effective
+}
 
-	elapsedHours := now.Sub(lastUpdate).Hours()
-
-	// Log de diagnóstico de tiempo
-	s.logger.Info("Verificando tiempo transcurrido",
-		zap.String("village_id", villageID.String()),
-		zap.Time("last_update", lastUpdate),
-		zap.Time("now", now),
-		zap.Float64("elapsed_hours", elapsedHours),
-		zap.Float64("min_required", 0.1),
-	)
-
-	if elapsedHours < 0.1 { // Mínimo 6 minutos (más realista)
-		s.logger.Info("Recursos no actualizados - tiempo insuficiente",
-			zap.String("village_id", villageID.String()),
-			zap.Float64("elapsed_hours", elapsedHours),
-			zap.Float64("min_required", 0.1),
-			zap.String("reason", "Esperando más tiempo para generar recursos"),
-		)
-		return nil
+// accrue calcula las existencias de la aldea en el instante to partiendo de stock, guardado en
+// stock.LastUpdated. El periodo se divide en tramos en cada mejora que termina, de modo que la
+// producción y la capacidad de cada tramo son exactas aunque nadie haya materializado la aldea.
+func (s *ResourceService) accrue(village *models.VillageWithDetails, stock models.Resources, to time.Time) *resourceAccrual {
+	from := stock.LastUpdated
+	if from.IsZero() || from.After(to) {
+		from = to
 	}
 
-	// Calcular recursos generados
-	woodGenerated := int(float64(production.Wood) * elapsedHours)
-	stoneGenerated := int(float64(production.Stone) * elapsedHours)
-	foodGenerated := int(float64(production.Food) * elapsedHours)
-	goldGenerated := int(float64(production.Gold) * elapsedHours)
+	upkeep := s.CalculateFoodUpkeep(village.Village.ID)
+	modifiers := s.productionModifiers(village)
+	result := &resourceAccrual{Upkeep: upkeep, ElapsedHours: to.Sub(from).Hours()}
 
-	// Calcular capacidad de almacenamiento
-	capacity := s.CalculateStorageCapacity(village)
-
-	// Actualizar recursos respetando límites de capacidad
-	newWood := village.Resources.Wood + woodGenerated
-	if newWood > capacity.Wood {
-		newWood = capacity.Wood
+	boundaries := []time.Time{}
+	for _, building := range village.Buildings {
+		if building.IsUpgrading && building.UpgradeCompletionTime != nil &&
+			building.UpgradeCompletionTime.After(from) && building.UpgradeCompletionTime.Before(to) {
+			boundaries = append(boundaries, *building.UpgradeCompletionTime)
+		}
 	}
+	sort.Slice(boundaries, func(i, j int) bool { return boundaries[i].Before(boundaries[j]) })
+	boundaries = append(boundaries, to)
 
-	newStone := village.Resources.Stone + stoneGenerated
-	if newStone > capacity.Stone {
-		newStone = capacity.Stone
-	}
+	wood, stone, food, gold := float64(stock.Wood), float64(stock.Stone), float64(stock.Food), float64(stock.Gold)
+	start := from
+	for _, end := range boundaries {
+		if !end.After(start) {
+			continue
+		}
+		effective := buildingsAt(village, start)
+		production := productionResourcesOf(applyProductionModifiers(s.calculateBaseProduction(effective), modifiers))
+		capacity := s.CalculateStorageCapacity(effective)
+
+		// El modo vacaciones congela la producción y el consumo de la aldea
+		hours := end.Sub(start).Hours()
+		if s.protectionService != nil {
+			hours -= s.protectionService.FrozenHours(village.Village.PlayerID, start, end)
+		}
+		if hours > 0 {
+			wood = accrueCapped(wood, float64(production.Wood)*hours, capacity.Wood)
+			stone = accrueCapped(stone, float64(production.Stone)*hours, capacity.Stone)
+			gold = accrueCapped(gold, float64(production.Gold)*hours, capacity.Gold)
+
+			net := float64(production.Food - upkeep)
+			if net >= 0 {
+				food = accrueCapped(food, net*hours, capacity.Food)
+			} else {
+				food += net * hours
+				if food < 0 {
+					// El tiempo pasado con el granero vacío provoca deserciones
+					result.StarvingHours += -food / -net
+					food = 0
+				}
+			}
+		}
+		start = end
+	}
+
+	final := buildingsAt(village, to)
+	result.Production = productionResourcesOf(applyProductionModifiers(s.calculateBaseProduction(final), modifiers))
+	result.Capacity = s.CalculateStorageCapacity(final)
+	result.Stock = models.Resources{
+		ID:          stock.ID,
+		VillageID:   stock.VillageID,
+		Wood:        int(wood),
+		Stone:       int(stone),
+		Food:        int(food),
+		Gold:        int(gold),
+		LastUpdated: to,
+	}
+	result.Generated = models.Resources{
+		Wood:  result.Stock.Wood - stock.Wood,
+		Stone: result.Stock.Stone - stock.Stone,
+		Food:  result.Stock.Food - stock.Food,
+		Gold:  result.Stock.Gold - stock.Gold,
+	}
+	return result
+}
 
-	newFood := village.Resources.Food + foodGenerated
-	if newFood > capacity.Food {
-		newFood = capacity.Food
+// accrueCapped suma la producción sin superar la capacidad. Lo que ya excede la capacidad (por
+// ejemplo, un botín) se conserva pero deja de crecer.
+func accrueCapped(current, gain float64, capacity int) float64 {
+	limit := float64(capacity)
+	if current >= limit {
+		return current
 	}
+	return math.Min(current+gain, limit)
+}
 
-	newGold := village.Resources.Gold + goldGenerated
-	if newGold > capacity.Gold {
-		newGold = capacity.Gold
+// ProjectResources sustituye los recursos guardados de la aldea por los actuales sin escribir en
+// la base de datos. Sirve para todas las lecturas: el valor es exacto al segundo.
+func (s *ResourceService) ProjectResources(village *models.VillageWithDetails) {
+	if village == nil {
+		return
 	}
+	village.Resources = s.accrue(village, village.Resources, time.Now()).Stock
+}
 
-	// Actualizar en la base de datos (esto también actualiza last_updated automáticamente)
-	err = s.villageRepo.UpdateResources(villageID, newWood, newStone, newFood, newGold)
+// materialize guarda en la base de datos los recursos de la aldea en el instante at, aplicándoles
+// delta (negativo para gastos). Bloquea la fila de recursos para que la producción, los gastos y
+// los saqueos no se pisen. Si algún recurso quedaría en negativo devuelve ErrInsufficientResources.
+func (s *ResourceService) materialize(villageID uuid.UUID, at time.Time, delta models.Resources) (*models.VillageWithDetails, *resourceAccrual, error) {
+	village, err := s.villageRepo.GetVillageByID(villageID)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	if village == nil {
+		return nil, nil, fmt.Errorf("aldea no encontrada")
 	}
 
-	// Actualizar en Redis
-	if s.redisService != nil {
-		res := &models.ResourceData{
-			Wood:  newWood,
-			Stone: newStone,
-			Food:  newFood,
-			Gold:  newGold,
-			Gems:  0,
+	var accrual *resourceAccrual
+	stock, err := s.villageRepo.CheckpointResources(villageID, func(stored models.Resources) (models.Resources, time.Time, error) {
+		checkpoint := at
+		if checkpoint.Before(stored.LastUpdated) {
+			// Ya se materializó más allá de at: solo se aplica delta
+			checkpoint = stored.LastUpdated
+		}
+		accrual = s.accrue(village, stored, checkpoint)
+
+		updated := accrual.Stock
+		updated.Wood += delta.Wood
+		updated.Stone += delta.Stone
+		updated.Food += delta.Food
+		updated.Gold += delta.Gold
+		if updated.Wood < 0 || updated.Stone < 0 || updated.Food < 0 || updated.Gold < 0 {
+			return models.Resources{}, time.Time{}, ErrInsufficientResources
 		}
-		s.redisService.StorePlayerResources(villageID.String(), res)
+		return updated, checkpoint, nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	village.Resources = stock
 
-	// ✅ NUEVO: Notificación WebSocket
-	if s.wsManager != nil {
-		// Obtener información del jugador para notificación específica
-		village, err := s.villageRepo.GetVillageByID(villageID)
-		if err == nil && village != nil {
-			resourceUpdate := models.ResourceUpdate{
-				VillageID:      villageID,
-				Wood:           newWood,
-				Stone:          newStone,
-				Food:           newFood,
-				Gold:           newGold,
-				WoodGenerated:  woodGenerated,
-				StoneGenerated: stoneGenerated,
-				FoodGenerated:  foodGenerated,
-				GoldGenerated:  goldGenerated,
-				Capacity:       capacity,
-				LastUpdate:     time.Now(),
-				ElapsedHours:   elapsedHours,
-			}
-			
-			// Notificar al jugador específico
-			if wsManager, ok := s.wsManager.(interface {
-				SendResourceUpdateToUser(userID string, villageID string, resources models.ResourceUpdate) error
-			}); ok {
-				err := wsManager.SendResourceUpdateToUser(village.Village.PlayerID.String(), villageID.String(), resourceUpdate)
-				if err != nil {
-					s.logger.Warn("Error enviando notificación WebSocket de recursos",
-						zap.String("village_id", villageID.String()),
-						zap.String("player_id", village.Village.PlayerID.String()),
-						zap.Error(err),
-					)
-				} else {
-					s.metrics.WebSocketNotifications++
-					s.logger.Debug("Notificación WebSocket de recursos enviada",
-						zap.String("village_id", villageID.String()),
-						zap.String("player_id", village.Village.PlayerID.String()),
-					)
-				}
-			}
-		}
+	if accrual.StarvingHours > 0 {
+		s.applyStarvation(village, accrual.Production.Food, accrual.Upkeep, accrual.StarvingHours)
 	}
 
-	// Actualizar métricas
 	s.metrics.TotalUpdates++
 	s.metrics.LastUpdate = time.Now()
 	s.metrics.SuccessRate = float64(s.metrics.TotalUpdates-s.metrics.UpdateErrors) / float64(s.metrics.TotalUpdates) * 100
 
-	s.logger.Info("Recursos actualizados",
-		zap.String("village_id", villageID.String()),
-		zap.Int("wood_generated", woodGenerated),
-		zap.Int("stone_generated", stoneGenerated),
-		zap.Int("food_generated", foodGenerated),
-		zap.Int("gold_generated", goldGenerated),
-		zap.Float64("elapsed_hours", elapsedHours),
-		zap.Int("wood_total", newWood),
-		zap.Int("stone_total", newStone),
-		zap.Int("food_total", newFood),
-		zap.Int("gold_total", newGold),
-	)
+	return village, accrual, nil
+}
 
+// UpdateResources materializa los recursos de una aldea en el instante actual y avisa al jugador
+func (s *ResourceService) UpdateResources(villageID uuid.UUID) error {
+	village, accrual, err := s.materialize(villageID, time.Now(), models.Resources{})
+	if err != nil {
+		return err
+	}
+	s.notifyResources(village, accrual)
 	return nil
 }
 
-// GetResourceInfo obtiene información detallada de recursos
-func (s *ResourceService) GetResourceInfo(villageID uuid.UUID) (*models.ResourceProduction, error) {
-	// Primero intentar obtener de Redis
-	if s.redisService != nil {
-		resources, err := s.redisService.GetPlayerResources(villageID.String())
-		if err == nil && resources != nil {
-			return &models.ResourceProduction{
-				VillageID:  villageID,
-				Wood:       resources.Wood,
-				Stone:      resources.Stone,
-				Food:       resources.Food,
-				Gold:       resources.Gold,
-				LastUpdate: time.Now(),
-			}, nil
-		}
-	}
+// CheckpointAt materializa los recursos hasta un instante pasado. Se usa antes de cambiar la
+// producción de la aldea (por ejemplo, al completar una mejora) para cerrar el tramo anterior.
+func (s *ResourceService) CheckpointAt(villageID uuid.UUID, at time.Time) error {
+	_, _, err := s.materialize(villageID, at, models.Resources{})
+	return err
+}
 
-	// Si no está en Redis, obtener de la base de datos y cachear
-	village, err := s.villageRepo.GetVillageByID(villageID)
+// AddResources materializa la aldea y le suma recursos (reembolsos, botín, comercio)
+func (s *ResourceService) AddResources(villageID uuid.UUID, amount models.Resources) error {
+	village, accrual, err := s.materialize(villageID, time.Now(), amount)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if village == nil {
-		return nil, nil
+	s.notifyResources(village, accrual)
+	return nil
+}
+
+// notifyResources envía por WebSocket los recursos recién materializados de una aldea
+func (s *ResourceService) notifyResources(village *models.VillageWithDetails, accrual *resourceAccrual) {
+	if s.wsManager == nil {
+		return
+	}
+	wsManager, ok := s.wsManager.(interface {
+		SendResourceUpdateToUser(userID string, villageID string, resources models.ResourceUpdate) error
+	})
+	if !ok {
+		return
+	}
+
+	resourceUpdate := models.ResourceUpdate{
+		VillageID:      village.Village.ID,
+		Wood:           village.Resources.Wood,
+		Stone:          village.Resources.Stone,
+		Food:           village.Resources.Food,
+		Gold:           village.Resources.Gold,
+		WoodGenerated:  accrual.Generated.Wood,
+		StoneGenerated: accrual.Generated.Stone,
+		FoodGenerated:  accrual.Generated.Food,
+		GoldGenerated:  accrual.Generated.Gold,
+		Capacity:       accrual.Capacity,
+		LastUpdate:     village.Resources.LastUpdated,
+		ElapsedHours:   accrual.ElapsedHours,
+	}
+	if err := wsManager.SendResourceUpdateToUser(village.Village.PlayerID.String(), village.Village.ID.String(), resourceUpdate); err != nil {
+		s.logger.Warn("Error enviando notificación WebSocket de recursos",
+			zap.String("village_id", village.Village.ID.String()),
+			zap.Error(err),
+		)
+		return
 	}
+	s.metrics.WebSocketNotifications++
+}
 
-	// Actualizar recursos antes de obtener información
-	err = s.UpdateResources(villageID)
-	if err != nil {
-		return nil, err
+// CalculateFoodUpkeep calcula la comida por hora que consume el ejército de una aldea: la
+// guarnición propia, las tropas de apoyo que mantiene en aldeas aliadas y las que están en marcha
+func (s *ResourceService) CalculateFoodUpkeep(villageID uuid.UUID) int {
+	upkeep := 0
+
+	if s.unitRepo != nil {
+		units, err := s.unitRepo.GetUnitsByVillageID(villageID)
+		if err != nil {
+			s.logger.Error("Error obteniendo guarnición para la manutención", zap.Error(err))
+		}
+		for _, unit := range units {
+			upkeep += unitUpkeep(unit.Type, unit.Quantity)
+		}
+
+		support, err := s.unitRepo.GetStationedTroopsByOrigin(villageID)
+		if err != nil {
+			s.logger.Error("Error obteniendo tropas de apoyo para la manutención", zap.Error(err))
+		}
+		for _, contingent := range support {
+			for unitType, quantity := range contingent.Units {
+				upkeep += unitUpkeep(unitType, quantity)
+			}
+		}
 	}
 
-	// Guardar en Redis
-	if s.redisService != nil {
-		res := &models.ResourceData{
-			Wood:  village.Resources.Wood,
-			Stone: village.Resources.Stone,
-			Food:  village.Resources.Food,
-			Gold:  village.Resources.Gold,
-			Gems:  0,
+	if s.marchRepo != nil {
+		marches, err := s.marchRepo.GetActiveMarchesBySource(villageID)
+		if err != nil {
+			s.logger.Error("Error obteniendo marchas para la manutención", zap.Error(err))
+		}
+		for _, march := range marches {
+			for unitType, quantity := range march.Units {
+				upkeep += unitUpkeep(unitType, quantity)
+			}
 		}
-		s.redisService.StorePlayerResources(villageID.String(), res)
 	}
 
-	return &models.ResourceProduction{
-		VillageID:  villageID,
-		Wood:       village.Resources.Wood,
-		Stone:      village.Resources.Stone,
-		Food:       village.Resources.Food,
-		Gold:       village.Resources.Gold,
-		LastUpdate: time.Now(),
-	}, nil
+	return upkeep
 }
 
-// Actualizar recursos de todas las aldeas
-func (s *ResourceService) UpdateAllVillageResources() error {
-	s.logger.Info("Iniciando actualización de recursos para todas las aldeas")
+// unitUpkeep devuelve la comida por hora que consumen quantity unidades de un tipo
+func unitUpkeep(unitType string, quantity int) int {
+	info, exists := models.UnitTypes[unitType]
+	if !exists || quantity <= 0 {
+		return 0
+	}
+	return info.Upkeep * quantity
+}
 
-	villages, err := s.villageRepo.GetAllVillages()
-	if err != nil {
-		s.logger.Error("Error obteniendo aldeas para actualizar recursos", zap.Error(err))
-		return err
+// applyStarvation hace desertar parte del ejército que la aldea no puede alimentar. Desertan
+// primero las unidades más caras de mantener, en proporción a las horas pasadas sin comida y
+// nunca más de las necesarias para que la producción cubra la manutención.
+func (s *ResourceService) applyStarvation(village *models.VillageWithDetails, foodProduction, upkeep int, starvingHours float64) {
+	if s.unitRepo == nil || starvingHours <= 0 || upkeep <= foodProduction {
+		return
 	}
 
-	s.logger.Info("Aldeas encontradas para actualizar recursos",
-		zap.Int("total_villages", len(villages)),
-	)
+	units, err := s.unitRepo.GetUnitsByVillageID(village.Village.ID)
+	if err != nil {
+		s.logger.Error("Error obteniendo guarnición para deserciones", zap.Error(err))
+		return
+	}
+	support, err := s.unitRepo.GetStationedTroopsByOrigin(village.Village.ID)
+	if err != nil {
+		s.logger.Error("Error obteniendo tropas de apoyo para deserciones", zap.Error(err))
+		return
+	}
 
-	updatedCount := 0
-	errorCount := 0
+	totalUnits := 0
+	for _, unit := range units {
+		totalUnits += unit.Quantity
+	}
+	for _, contingent := range support {
+		for _, quantity := range contingent.Units {
+			totalUnits += quantity
+		}
+	}
+	if totalUnits == 0 {
+		return
+	}
 
-	for _, village := range villages {
-		s.logger.Debug("Procesando aldea para actualización de recursos",
-			zap.String("village_id", village.Village.ID.String()),
-			zap.String("village_name", village.Village.Name),
-			zap.String("player_id", village.Village.PlayerID.String()),
-		)
+	fraction := math.Min(starvationDesertionRate*starvingHours, 1)
+	maxDeserters := int(math.Ceil(float64(totalUnits) * fraction))
+	deficit := upkeep - foodProduction
 
-		err := s.UpdateResources(village.Village.ID)
-		if err != nil {
-			s.logger.Error("Error al actualizar recursos de aldea",
-				zap.String("village_id", village.Village.ID.String()),
-				zap.String("village_name", village.Village.Name),
-				zap.Error(err),
-			)
-			errorCount++
-		} else {
-			updatedCount++
+	// Candidatos ordenados por manutención descendente: primero la guarnición, después el apoyo
+	type deserterPool struct {
+		unitType  string
+		available int
+		upkeep    int
+		unit      *models.Unit
+		support   *models.SupportTroops
+	}
+	var pools []deserterPool
+	for _, unit := range units {
+		if info, exists := models.UnitTypes[unit.Type]; exists && unit.Quantity > 0 && info.Upkeep > 0 {
+			pools = append(pools, deserterPool{unitType: unit.Type, available: unit.Quantity, upkeep: info.Upkeep, unit: unit})
 		}
 	}
+	for _, contingent := range support {
+		for unitType, quantity := range contingent.Units {
+			if info, exists := models.UnitTypes[unitType]; exists && quantity > 0 && info.Upkeep > 0 {
+				pools = append(pools, deserterPool{unitType: unitType, available: quantity, upkeep: info.Upkeep, support: contingent})
+			}
+		}
+	}
+	sort.SliceStable(pools, func(i, j int) bool {
+		return pools[i].upkeep > pools[j].upkeep
+	})
 
-	s.logger.Info("Actualización de recursos completada",
-		zap.Int("total_villages", len(villages)),
-		zap.Int("updated_successfully", updatedCount),
-		zap.Int("errors", errorCount),
-	)
+	deserted := make(map[string]int)
+	totalDeserted := 0
+	for _, pool := range pools {
+		if totalDeserted >= maxDeserters || deficit <= 0 {
+			break
+		}
+		count := int(math.Ceil(float64(deficit) / float64(pool.upkeep)))
+		if count > pool.available {
+			count = pool.available
+		}
+		if count > maxDeserters-totalDeserted {
+			count = maxDeserters - totalDeserted
+		}
 
-	return nil
-}
+		// Las deserciones se restan de las cantidades actuales para no pisar entrenamientos ni regresos
+		if pool.unit != nil {
+			err = s.unitRepo.RemoveUnits(village.Village.ID, pool.unitType, count)
+		} else {
+			err = s.unitRepo.RemoveStationedUnits(pool.support.OriginVillageID, pool.support.HostVillageID, pool.unitType, count)
+		}
+		if err != nil {
+			s.logger.Error("Error aplicando deserciones", zap.String("unit_type", pool.unitType), zap.Error(err))
+			continue
+		}
+
+		deserted[pool.unitType] += count
+		totalDeserted += count
+		deficit -= count * pool.upkeep
+	}
 
-// Iniciar el servicio de generación de recursos
-func (s *ResourceService) StartResourceGeneration() {
-	ticker := time.NewTicker(5 * time.Minute) // Actualizar cada 5 minutos
-	defer ticker.Stop()
+	if totalDeserted == 0 {
+		return
+	}
 
-	s.logger.Info("Servicio de generación de recursos iniciado",
-		zap.Duration("interval", 5*time.Minute),
+	s.logger.Warn("Tropas desertan por falta de comida",
+		zap.String("village_id", village.Village.ID.String()),
+		zap.Int("deserted", totalDeserted),
+		zap.Float64("starving_hours", starvingHours),
 	)
 
-	for {
-		select {
-		case <-ticker.C:
-			s.logger.Info("Ejecutando ciclo de generación de recursos")
-			err := s.UpdateAllVillageResources()
-			if err != nil {
-				s.logger.Error("Error en la generación de recursos", zap.Error(err))
-			} else {
-				s.logger.Info("Ciclo de generación de recursos completado exitosamente")
-			}
+	if s.notificationService != nil {
+		notification := &models.Notification{
+			PlayerID: village.Village.PlayerID.String(),
+			Type:     "starvation",
+			Title:    "Tropas desertoras",
+			Message:  fmt.Sprintf("Sin comida en %s, %d unidades han desertado", village.Village.Name, totalDeserted),
+			Data: map[string]interface{}{
+				"village_id":      village.Village.ID.String(),
+				"deserted":        deserted,
+				"food_production": foodProduction,
+				"food_upkeep":     upkeep,
+			},
+		}
+		if err := s.notificationService.CreateNotification(notification); err != nil {
+			s.logger.Error("Error notificando deserciones", zap.Error(err))
 		}
 	}
 }
 
-// Consumir recursos para construcción o entrenamiento
-func (s *ResourceService) ConsumeResources(villageID uuid.UUID, wood, stone, food, gold int) error {
+// GetResourceInfo obtiene los recursos actuales de una aldea calculados en el momento de la lectura
+func (s *ResourceService) GetResourceInfo(villageID uuid.UUID) (*models.ResourceProduction, error) {
 	village, err := s.villageRepo.GetVillageByID(villageID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
 	if village == nil {
-		return nil
+		return nil, nil
 	}
+	s.ProjectResources(village)
 
-	// Verificar si hay suficientes recursos
-	if village.Resources.Wood < wood || village.Resources.Stone < stone || village.Resources.Food < food || village.Resources.Gold < gold {
-		return nil // No hay suficientes recursos
-	}
+	return &models.ResourceProduction{
+		VillageID:  villageID,
+		Wood:       village.Resources.Wood,
+		Stone:      village.Resources.Stone,
+		Food:       village.Resources.Food,
+		Gold:       village.Resources.Gold,
+		LastUpdate: village.Resources.LastUpdated,
+	}, nil
+}
 
-	// Consumir recursos
-	newWood := village.Resources.Wood - wood
-	newStone := village.Resources.Stone - stone
-	newFood := village.Resources.Food - food
-	newGold := village.Resources.Gold - gold
+// Consumir recursos para construcción o entrenamiento
+func (s *ResourceService) ConsumeResources(villageID uuid.UUID, wood, stone, food, gold int) error {
+	return s.ConsumeResourcesAt(villageID, time.Now(), wood, stone, food, gold)
+}
 
-	// Actualizar en la base de datos
-	err = s.villageRepo.UpdateResources(villageID, newWood, newStone, newFood, newGold)
+// ConsumeResourcesAt descuenta un gasto con las existencias que tenía la aldea en un instante
+// pasado. Lo usan las colas que arrancan una orden en el momento exacto en que terminó la anterior.
+func (s *ResourceService) ConsumeResourcesAt(villageID uuid.UUID, at time.Time, wood, stone, food, gold int) error {
+	village, accrual, err := s.materialize(villageID, at, models.Resources{
+		Wood:  -wood,
+		Stone: -stone,
+		Food:  -food,
+		Gold:  -gold,
+	})
 	if err != nil {
 		return err
 	}
@@ -454,6 +643,7 @@ func (s *ResourceService) ConsumeResources(villageID uuid.UUID, wood, stone, foo
 		zap.Int("food_consumed", food),
 		zap.Int("gold_consumed", gold),
 	)
+	s.notifyResources(village, accrual)
 
 	return nil
 }
@@ -468,6 +658,7 @@ func (s *ResourceService) HasEnoughResources(villageID uuid.UUID, wood, stone, f
 	if village == nil {
 		return false, nil
 	}
+	s.ProjectResources(village)
 
 	hasEnough := village.Resources.Wood >= wood && village.Resources.Stone >= stone && village.Resources.Food >= food && village.Resources.Gold >= gold
 	return hasEnough, nil
@@ -484,14 +675,19 @@ func (s *ResourceService) GetVillageProduction(villageID uuid.UUID) (*models.Res
 		return nil, nil
 	}
 
-	production := s.CalculateProduction(village)
+	breakdown := s.CalculateProductionBreakdown(buildingsAt(village, time.Now()))
+	production := breakdown.Total
+	upkeep := s.CalculateFoodUpkeep(villageID)
 	resourceProduction := &models.ResourceProduction{
-		VillageID:  villageID,
-		Wood:       production.Wood,
-		Stone:      production.Stone,
-		Food:       production.Food,
-		Gold:       production.Gold,
-		LastUpdate: village.Resources.LastUpdated,
+		VillageID:      villageID,
+		Wood:           production.Wood,
+		Stone:          production.Stone,
+		Food:           production.Food - upkeep,
+		Gold:           production.Gold,
+		FoodProduction: production.Food,
+		FoodUpkeep:     upkeep,
+		Breakdown:      breakdown,
+		LastUpdate:     village.Resources.LastUpdated,
 	}
 
 	return resourceProduction, nil
@@ -508,7 +704,7 @@ func (s *ResourceService) GetVillageStorage(villageID uuid.UUID) (*models.Resour
 		return nil, nil
 	}
 
-	capacity := s.CalculateStorageCapacity(village)
+	capacity := s.CalculateStorageCapacity(buildingsAt(village, time.Now()))
 	storage := &models.ResourceStorage{
 		VillageID:    villageID,
 		WoodStorage:  capacity.Wood,
@@ -530,14 +726,19 @@ func (s *ResourceService) GetResourceProduction(villageID uuid.UUID) (*models.Re
 		return nil, nil
 	}
 
-	production := s.CalculateProduction(village)
+	breakdown := s.CalculateProductionBreakdown(buildingsAt(village, time.Now()))
+	production := breakdown.Total
+	upkeep := s.CalculateFoodUpkeep(villageID)
 	resourceProduction := &models.ResourceProduction{
-		VillageID:  villageID,
-		Wood:       production.Wood,
-		Stone:      production.Stone,
-		Food:       production.Food,
-		Gold:       production.Gold,
-		LastUpdate: village.Resources.LastUpdated,
+		VillageID:      villageID,
+		Wood:           production.Wood,
+		Stone:          production.Stone,
+		Food:           production.Food - upkeep,
+		Gold:           production.Gold,
+		FoodProduction: production.Food,
+		FoodUpkeep:     upkeep,
+		Breakdown:      breakdown,
+		LastUpdate:     village.Resources.LastUpdated,
 	}
 
 	return resourceProduction, nil
@@ -553,7 +754,7 @@ func (s *ResourceService) GetResourceStorage(villageID uuid.UUID) (*models.Resou
 		return nil, nil
 	}
 
-	capacity := s.CalculateStorageCapacity(village)
+	capacity := s.CalculateStorageCapacity(buildingsAt(village, time.Now()))
 	storage := &models.ResourceStorage{
 		VillageID:    villageID,
 		WoodStorage:  capacity.Wood,
@@ -648,83 +849,3 @@ func (s *ResourceService) ResetResourceMetrics() {
 	}
 	s.logger.Info("Métricas de recursos reiniciadas")
 }
-
-// UpdateAllVillageResourcesWithNotifications actualiza todas las aldeas y envía notificaciones masivas
-func (s *ResourceService) UpdateAllVillageResourcesWithNotifications() error {
-	s.logger.Info("Iniciando actualización masiva de recursos con notificaciones")
-
-	villages, err := s.villageRepo.GetAllVillages()
-	if err != nil {
-		s.logger.Error("Error obteniendo aldeas para actualización masiva", zap.Error(err))
-		return err
-	}
-
-	s.logger.Info("Aldeas encontradas para actualización masiva",
-		zap.Int("total_villages", len(villages)),
-	)
-
-	updatedCount := 0
-	errorCount := 0
-	resourceUpdates := make(map[string]models.ResourceUpdate)
-
-	for _, village := range villages {
-		s.logger.Debug("Procesando aldea para actualización masiva",
-			zap.String("village_id", village.Village.ID.String()),
-			zap.String("village_name", village.Village.Name),
-			zap.String("player_id", village.Village.PlayerID.String()),
-		)
-
-		err := s.UpdateResources(village.Village.ID)
-		if err != nil {
-			s.logger.Error("Error al actualizar recursos de aldea",
-				zap.String("village_id", village.Village.ID.String()),
-				zap.String("village_name", village.Village.Name),
-				zap.Error(err),
-			)
-			errorCount++
-		} else {
-			updatedCount++
-			
-			// Preparar notificación masiva
-			if s.wsManager != nil {
-				villageInfo, err := s.villageRepo.GetVillageByID(village.Village.ID)
-				if err == nil && villageInfo != nil {
-					resourceUpdate := models.ResourceUpdate{
-						VillageID:      village.Village.ID,
-						Wood:           villageInfo.Resources.Wood,
-						Stone:          villageInfo.Resources.Stone,
-						Food:           villageInfo.Resources.Food,
-						Gold:           villageInfo.Resources.Gold,
-						LastUpdate:     time.Now(),
-					}
-					resourceUpdates[village.Village.ID.String()] = resourceUpdate
-				}
-			}
-		}
-	}
-
-	// Enviar notificaciones masivas si hay WebSocket Manager
-	if s.wsManager != nil && len(resourceUpdates) > 0 {
-		if wsManager, ok := s.wsManager.(interface {
-			SendResourceUpdateToAllVillages(resources map[string]models.ResourceUpdate) error
-		}); ok {
-			err := wsManager.SendResourceUpdateToAllVillages(resourceUpdates)
-			if err != nil {
-				s.logger.Warn("Error enviando notificaciones masivas de recursos", zap.Error(err))
-			} else {
-				s.logger.Info("Notificaciones masivas de recursos enviadas",
-					zap.Int("villages_notified", len(resourceUpdates)),
-				)
-			}
-		}
-	}
-
-	s.logger.Info("Actualización masiva de recursos completada",
-		zap.Int("total_villages", len(villages)),
-		zap.Int("updated_successfully", updatedCount),
-		zap.Int("errors", errorCount),
-		zap.Int("notifications_sent", len(resourceUpdates)),
-	)
-
-	return nil
-}
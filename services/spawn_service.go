@@ -0,0 +1,318 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"server-backend/models"
+	"server-backend/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// spawnTilesPerVillage es la superficie que se reserva por aldea al calcular la frontera
+	spawnTilesPerVillage = 120.0
+	// spawnMinFrontier es el radio de la frontera en un mundo vacío
+	spawnMinFrontier = 30.0
+	// spawnFrontierBand es el grosor del anillo exterior de la frontera
+	spawnFrontierBand = 20.0
+	// spawnMinSpacing es la distancia mínima entre la nueva aldea y cualquier otra
+	spawnMinSpacing = 3.0
+	// spawnStrongPlayers es el número de jugadores fuertes de los que se mantiene alejado al novato
+	spawnStrongPlayers = 10
+	// spawnMinStrongDistance es la distancia mínima a las aldeas de esos jugadores
+	spawnMinStrongDistance = 30.0
+	// Distancias a las que se coloca la aldea cuando el jugador quiere aparecer junto a otro
+	spawnFriendMinDistance = 3.0
+	spawnFriendMaxDistance = 12.0
+	// spawnAttempts es el número de candidatos que se prueban en cada estrategia
+	spawnAttempts = 60
+	// spawnPlacementRetries es el número de veces que se busca casilla si otra aldea se adelanta
+	spawnPlacementRetries = 3
+)
+
+// ErrNoSpawnLocation indica que no queda ninguna casilla libre que cumpla las reglas de aparición
+var ErrNoSpawnLocation = errors.New("no hay casillas libres para la aldea inicial")
+
+// SpawnService decide dónde colocar la primera aldea de un jugador que se une a un mundo
+type SpawnService struct {
+	mapService  *MapService
+	mapRepo     *repository.MapRepository
+	villageRepo *repository.VillageRepository
+	playerRepo  *repository.PlayerRepository
+	logger      *zap.Logger
+}
+
+func NewSpawnService(mapService *MapService, mapRepo *repository.MapRepository, villageRepo *repository.VillageRepository, playerRepo *repository.PlayerRepository, logger *zap.Logger) *SpawnService {
+	return &SpawnService{
+		mapService:  mapService,
+		mapRepo:     mapRepo,
+		villageRepo: villageRepo,
+		playerRepo:  playerRepo,
+		logger:      logger,
+	}
+}
+
+// spawnContext reúne los datos del mundo que se consultan una sola vez por colocación
+type spawnContext struct {
+	worldMap *models.WorldMap
+	centerX  float64
+	centerY  float64
+	frontier float64
+	strong   []*models.MapVillage
+}
+
+// FindSpawnLocation elige las coordenadas de la aldea inicial de un jugador. Prueba primero las
+// preferencias del jugador (amigo, alianza o zona del mapa) y, si no hay hueco, relaja la petición
+// hasta la frontera completa y por último anillos cada vez más alejados del centro.
+func (s *SpawnService) FindSpawnLocation(req *models.SpawnRequest) (int, int, error) {
+	ctx, err := s.loadContext(req.WorldID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Aparecer junto a un amigo o a la alianza
+	anchors, err := s.findAnchors(ctx, req)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(anchors) > 0 {
+		if x, y, ok := s.tryAnchors(ctx, req.WorldID, anchors); ok {
+			return x, y, nil
+		}
+		s.logger.Info("Sin hueco junto a los aliados, se usa la frontera",
+			zap.String("player_id", req.PlayerID.String()),
+			zap.String("world_id", req.WorldID.String()),
+		)
+	}
+
+	// Zona elegida y, si está llena, la frontera ampliada
+	location := req.Location
+	if location == "" || location == models.SpawnAlliance {
+		location = models.SpawnAuto
+	}
+	for _, candidate := range []string{location, models.SpawnAuto, models.SpawnRandom} {
+		if x, y, ok := s.tryArea(ctx, req.WorldID, candidate); ok {
+			return x, y, nil
+		}
+	}
+
+	// Mundo muy lleno: se sigue buscando en anillos cada vez más alejados con las mismas reglas
+	s.logger.Warn("Frontera de aparición llena, se amplía la búsqueda",
+		zap.String("world_id", req.WorldID.String()),
+		zap.Float64("frontier", ctx.frontier),
+	)
+	maxRadius := math.Hypot(float64(ctx.worldMap.Width), float64(ctx.worldMap.Height)) / 2
+	for outer := ctx.frontier + spawnFrontierBand; outer-spawnFrontierBand < maxRadius; outer += spawnFrontierBand {
+		if x, y, ok := s.tryRing(ctx, req.WorldID, outer-spawnFrontierBand, outer, 0, 2*math.Pi); ok {
+			return x, y, nil
+		}
+	}
+	return 0, 0, ErrNoSpawnLocation
+}
+
+// loadContext calcula la frontera del mundo y carga las aldeas de los jugadores más fuertes
+func (s *SpawnService) loadContext(worldID uuid.UUID) (*spawnContext, error) {
+	worldMap, err := s.mapService.EnsureWorldMap(worldID)
+	if err != nil {
+		return nil, err
+	}
+
+	villageCount, err := s.villageRepo.CountVillagesInWorld(worldID)
+	if err != nil {
+		return nil, err
+	}
+
+	strong, err := s.villageRepo.GetTopPlayerVillages(worldID, spawnStrongPlayers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spawnContext{
+		worldMap: worldMap,
+		centerX:  float64(worldMap.Width) / 2,
+		centerY:  float64(worldMap.Height) / 2,
+		frontier: SpawnFrontierRadius(villageCount, worldMap.Width, worldMap.Height),
+		strong:   strong,
+	}, nil
+}
+
+// SpawnFrontierRadius devuelve el radio, medido desde el centro del mapa, del círculo en el que
+// aparecen los jugadores nuevos. Crece con la raíz del número de aldeas para que el mundo se
+// llene de dentro hacia fuera.
+func SpawnFrontierRadius(villageCount, width, height int) float64 {
+	radius := math.Sqrt(float64(villageCount)*spawnTilesPerVillage/math.Pi) + spawnFrontierBand
+	return math.Min(math.Max(radius, spawnMinFrontier), math.Hypot(float64(width), float64(height))/2)
+}
+
+// findAnchors devuelve las aldeas junto a las que quiere aparecer el jugador
+func (s *SpawnService) findAnchors(ctx *spawnContext, req *models.SpawnRequest) ([]*models.NearbyVillage, error) {
+	search := &models.VillageSearch{
+		WorldID: req.WorldID,
+		CenterX: int(ctx.centerX),
+		CenterY: int(ctx.centerY),
+		Limit:   mapSearchMaxLimit,
+	}
+
+	switch {
+	case req.NearPlayerID != nil:
+		search.PlayerID = req.NearPlayerID
+	case req.Location == models.SpawnAlliance:
+		player, err := s.playerRepo.GetPlayerByID(req.PlayerID)
+		if err != nil {
+			return nil, err
+		}
+		if player == nil || player.AllianceID == nil {
+			return nil, nil
+		}
+		search.AllianceID = player.AllianceID
+	default:
+		return nil, nil
+	}
+
+	return s.mapService.SearchVillages(search)
+}
+
+// tryAnchors busca una casilla válida alrededor de alguna de las aldeas de referencia
+func (s *SpawnService) tryAnchors(ctx *spawnContext, worldID uuid.UUID, anchors []*models.NearbyVillage) (int, int, bool) {
+	minX, minY, maxX, maxY := anchors[0].X, anchors[0].Y, anchors[0].X, anchors[0].Y
+	for _, anchor := range anchors {
+		minX, minY = min(minX, anchor.X), min(minY, anchor.Y)
+		maxX, maxY = max(maxX, anchor.X), max(maxY, anchor.Y)
+	}
+	reach := int(math.Ceil(spawnFriendMaxDistance))
+	area, ok := s.loadArea(ctx, worldID, minX-reach, minY-reach, maxX+reach, maxY+reach)
+	if !ok {
+		return 0, 0, false
+	}
+
+	for attempt := 0; attempt < spawnAttempts; attempt++ {
+		anchor := anchors[rand.Intn(len(anchors))]
+		angle := rand.Float64() * 2 * math.Pi
+		distance := spawnFriendMinDistance + rand.Float64()*(spawnFriendMaxDistance-spawnFriendMinDistance)
+		x := anchor.X + int(math.Round(math.Cos(angle)*distance))
+		y := anchor.Y + int(math.Round(math.Sin(angle)*distance))
+		if s.isValidSpawn(ctx, area, x, y) {
+			return x, y, true
+		}
+	}
+	return 0, 0, false
+}
+
+// tryArea busca una casilla válida en la zona de la frontera que corresponde a la ubicación
+func (s *SpawnService) tryArea(ctx *spawnContext, worldID uuid.UUID, location string) (int, int, bool) {
+	minRadius, maxRadius := math.Max(ctx.frontier-spawnFrontierBand, 0), ctx.frontier
+	minAngle, maxAngle := 0.0, 2*math.Pi
+
+	// La Y crece hacia el sur, así que el norte corresponde a ángulos entre π y 2π
+	switch location {
+	case models.SpawnRandom:
+		minRadius = 0
+	case models.SpawnCenter:
+		minRadius, maxRadius = 0, math.Max(ctx.frontier/2, spawnFrontierBand)
+	case models.SpawnEdge:
+		minRadius = math.Max(ctx.frontier-spawnFrontierBand/2, 0)
+	case models.SpawnNorthWest:
+		minAngle, maxAngle = math.Pi, 1.5*math.Pi
+	case models.SpawnNorthEast:
+		minAngle, maxAngle = 1.5*math.Pi, 2*math.Pi
+	case models.SpawnSouthWest:
+		minAngle, maxAngle = 0.5*math.Pi, math.Pi
+	case models.SpawnSouthEast:
+		minAngle, maxAngle = 0, 0.5*math.Pi
+	}
+
+	return s.tryRing(ctx, worldID, minRadius, maxRadius, minAngle, maxAngle)
+}
+
+// tryRing busca una casilla válida en el sector de anillo alrededor del centro del mapa. Las aldeas
+// y emplazamientos del sector se cargan una sola vez para todos los candidatos.
+func (s *SpawnService) tryRing(ctx *spawnContext, worldID uuid.UUID, minRadius, maxRadius, minAngle, maxAngle float64) (int, int, bool) {
+	reach := int(math.Ceil(maxRadius))
+	area, ok := s.loadArea(ctx, worldID, int(ctx.centerX)-reach, int(ctx.centerY)-reach, int(ctx.centerX)+reach, int(ctx.centerY)+reach)
+	if !ok {
+		return 0, 0, false
+	}
+
+	for attempt := 0; attempt < spawnAttempts; attempt++ {
+		angle := minAngle + rand.Float64()*(maxAngle-minAngle)
+		// La raíz reparte los candidatos de forma uniforme por la superficie del anillo
+		radius := math.Sqrt(minRadius*minRadius + rand.Float64()*(maxRadius*maxRadius-minRadius*minRadius))
+		x := int(math.Round(ctx.centerX + math.Cos(angle)*radius))
+		y := int(math.Round(ctx.centerY + math.Sin(angle)*radius))
+		if s.isValidSpawn(ctx, area, x, y) {
+			return x, y, true
+		}
+	}
+	return 0, 0, false
+}
+
+// spawnArea guarda las casillas ocupadas por aldeas y emplazamientos de la zona donde se buscan
+// candidatos
+type spawnArea struct {
+	villages map[[2]int]bool
+	sites    map[[2]int]bool
+}
+
+// loadArea carga las aldeas y emplazamientos de un rectángulo, ampliado con la separación mínima
+// para poder comprobar a los vecinos de las casillas del borde
+func (s *SpawnService) loadArea(ctx *spawnContext, worldID uuid.UUID, minX, minY, maxX, maxY int) (*spawnArea, bool) {
+	spacing := int(math.Ceil(spawnMinSpacing))
+	minX, minY = max(minX-spacing, 0), max(minY-spacing, 0)
+	maxX, maxY = min(maxX+spacing, ctx.worldMap.Width-1), min(maxY+spacing, ctx.worldMap.Height-1)
+
+	villages, err := s.mapRepo.GetVillagesInBounds(worldID, minX, minY, maxX, maxY)
+	if err != nil {
+		s.logger.Warn("Error cargando aldeas de la zona de aparición", zap.Error(err))
+		return nil, false
+	}
+	sites, err := s.mapRepo.GetSitesInBounds(worldID, minX, minY, maxX, maxY)
+	if err != nil {
+		s.logger.Warn("Error cargando emplazamientos de la zona de aparición", zap.Error(err))
+		return nil, false
+	}
+
+	area := &spawnArea{
+		villages: make(map[[2]int]bool, len(villages)),
+		sites:    make(map[[2]int]bool, len(sites)),
+	}
+	for _, village := range villages {
+		area.villages[[2]int{village.X, village.Y}] = true
+	}
+	for _, site := range sites {
+		area.sites[[2]int{site.X, site.Y}] = true
+	}
+	return area, true
+}
+
+// isValidSpawn comprueba que la casilla esté dentro del mapa, no sea agua, no tenga emplazamiento,
+// deje espacio con las aldeas vecinas y quede lejos de los jugadores fuertes
+func (s *SpawnService) isValidSpawn(ctx *spawnContext, area *spawnArea, x, y int) bool {
+	if x < 0 || y < 0 || x >= ctx.worldMap.Width || y >= ctx.worldMap.Height {
+		return false
+	}
+	if GenerateTerrain(ctx.worldMap.Seed, x, y) == models.TerrainWater {
+		return false
+	}
+	for _, village := range ctx.strong {
+		if math.Hypot(float64(village.X-x), float64(village.Y-y)) < spawnMinStrongDistance {
+			return false
+		}
+	}
+	if area.sites[[2]int{x, y}] {
+		return false
+	}
+
+	spacing := int(math.Ceil(spawnMinSpacing))
+	for dx := -spacing; dx <= spacing; dx++ {
+		for dy := -spacing; dy <= spacing; dy++ {
+			if math.Hypot(float64(dx), float64(dy)) <= spawnMinSpacing && area.villages[[2]int{x + dx, y + dy}] {
+				return false
+			}
+		}
+	}
+	return true
+}
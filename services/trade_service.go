@@ -0,0 +1,527 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"server-backend/models"
+	"server-backend/repository"
+	"server-backend/websocket"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var ErrMarketplaceRequired = errors.New("se requiere un mercado para enviar mercaderes")
+
+const (
+	// tradeSchedulerInterval es la frecuencia con la que se entregan envíos y vuelven los mercaderes
+	tradeSchedulerInterval = 5 * time.Second
+	// tradeBatchSize limita los envíos e intercambios procesados por ciclo
+	tradeBatchSize = 100
+	// merchantSpeed es la velocidad de los mercaderes en casillas por hora
+	merchantSpeed = 12
+	// directTradeDuration es el tiempo que tiene el destinatario para responder a un intercambio
+	directTradeDuration = 24 * time.Hour
+)
+
+// TradeService gestiona el comercio entre aldeas: ofertas del mercado, intercambios directos y los
+// mercaderes que transportan la mercancía. Cada mercado da un número de mercaderes según su nivel;
+// un envío los ocupa durante la ida y la vuelta, y la mercancía solo llega a su destino al final
+// del viaje.
+type TradeService struct {
+	tradeRepo          *repository.TradeRepository
+	merchantRepo       *repository.MerchantRepository
+	villageRepo        *repository.VillageRepository
+	buildingConfigRepo *repository.BuildingConfigRepository
+	resourceService    *ResourceService
+	worldSettings      *WorldSettingsService
+	wsManager          *websocket.Manager
+	logger             *zap.Logger
+}
+
+func NewTradeService(tradeRepo *repository.TradeRepository, merchantRepo *repository.MerchantRepository, villageRepo *repository.VillageRepository, buildingConfigRepo *repository.BuildingConfigRepository, resourceService *ResourceService, logger *zap.Logger) *TradeService {
+	return &TradeService{
+		tradeRepo:          tradeRepo,
+		merchantRepo:       merchantRepo,
+		villageRepo:        villageRepo,
+		buildingConfigRepo: buildingConfigRepo,
+		resourceService:    resourceService,
+		logger:             logger,
+	}
+}
+
+// SetWebSocketManager configura el WebSocket manager
+func (s *TradeService) SetWebSocketManager(wsManager *websocket.Manager) {
+	s.wsManager = wsManager
+}
+
+// SetWorldSettingsService configura las reglas por mundo (velocidad de las unidades)
+func (s *TradeService) SetWorldSettingsService(worldSettings *WorldSettingsService) {
+	s.worldSettings = worldSettings
+}
+
+// ===== OFERTAS DEL MERCADO =====
+
+// CreateOffer publica una oferta. La aldea necesita un mercado para poder atender las compras.
+func (s *TradeService) CreateOffer(playerID uuid.UUID, offer *models.TradeOffer) (*models.TradeOffer, error) {
+	village, err := s.ownedVillage(playerID, offer.VillageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireMarketplace(village); err != nil {
+		return nil, err
+	}
+
+	if err := s.resourceService.UpdateResources(offer.VillageID); err != nil {
+		return nil, err
+	}
+	offer.SellerID = playerID
+	return s.tradeRepo.CreateTradeOffer(offer)
+}
+
+// GetOffers obtiene las ofertas activas con filtros
+func (s *TradeService) GetOffers(resourceType, sellerID, priceMin, priceMax string) ([]models.TradeOffer, error) {
+	return s.tradeRepo.GetTradeOffers(resourceType, sellerID, priceMin, priceMax)
+}
+
+// GetOffer obtiene una oferta
+func (s *TradeService) GetOffer(offerID uuid.UUID) (*models.TradeOffer, error) {
+	return s.tradeRepo.GetTradeOffer(offerID)
+}
+
+// GetPlayerOffers obtiene las ofertas publicadas por un jugador
+func (s *TradeService) GetPlayerOffers(playerID uuid.UUID) ([]models.TradeOffer, error) {
+	return s.tradeRepo.GetPlayerTradeOffers(playerID)
+}
+
+// GetHistory obtiene las compras y ventas de un jugador
+func (s *TradeService) GetHistory(playerID uuid.UUID, limit int) ([]models.TradeTransaction, error) {
+	return s.tradeRepo.GetTradeHistory(playerID, limit)
+}
+
+// BuyOffer compra parte de una oferta. El oro se cobra al momento y los mercaderes del vendedor
+// llevan la mercancía a la aldea del comprador.
+func (s *TradeService) BuyOffer(playerID, offerID, villageID uuid.UUID, amount int) (*models.TradeTransaction, *models.MerchantShipment, error) {
+	offer, err := s.tradeRepo.GetTradeOffer(offerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offer.SellerID == playerID {
+		return nil, nil, repository.ErrTradeOwnOffer
+	}
+
+	buyerVillage, err := s.ownedVillage(playerID, villageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	sellerVillage, err := s.villageRepo.GetVillageByID(offer.VillageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sellerVillage == nil {
+		return nil, nil, fmt.Errorf("aldea del vendedor no encontrada")
+	}
+
+	dispatch, err := s.merchantDispatch(sellerVillage, buyerVillage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.materializeVillages(offer.VillageID, villageID); err != nil {
+		return nil, nil, err
+	}
+
+	transaction, shipment, err := s.tradeRepo.ProcessTrade(offerID, playerID, villageID, amount, dispatch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.logger.Info("Oferta comprada",
+		zap.String("offer_id", offerID.String()),
+		zap.String("buyer_id", playerID.String()),
+		zap.Int("amount", amount),
+		zap.Int("merchants", shipment.Merchants),
+	)
+	s.notifyShipment("shipment_dispatched", shipment)
+	return transaction, shipment, nil
+}
+
+// CancelOffer cancela una oferta del jugador y le devuelve lo que quedaba en depósito
+func (s *TradeService) CancelOffer(playerID, offerID uuid.UUID) (*models.TradeOffer, error) {
+	offer, err := s.tradeRepo.GetTradeOffer(offerID)
+	if err != nil {
+		return nil, err
+	}
+	if offer.SellerID != playerID {
+		return nil, repository.ErrTradeVillageNotOwned
+	}
+
+	if err := s.resourceService.UpdateResources(offer.VillageID); err != nil {
+		return nil, err
+	}
+	return s.tradeRepo.CancelTradeOffer(offerID)
+}
+
+// ===== INTERCAMBIOS DIRECTOS =====
+
+// CreateDirectTrade propone un intercambio a la aldea de otro jugador. Lo ofrecido queda en
+// depósito hasta que el destinatario responde o el intercambio caduca.
+func (s *TradeService) CreateDirectTrade(playerID uuid.UUID, trade *models.DirectTrade) (*models.DirectTrade, error) {
+	village, err := s.ownedVillage(playerID, trade.InitiatorVillageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireMarketplace(village); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	trade.ID = uuid.New()
+	trade.InitiatorID = playerID
+	trade.Status = models.DirectTradePending
+	trade.CreatedAt = now
+	trade.ExpiresAt = now.Add(directTradeDuration)
+
+	if err := s.resourceService.UpdateResources(trade.InitiatorVillageID); err != nil {
+		return nil, err
+	}
+	if err := s.tradeRepo.CreateDirectTrade(trade); err != nil {
+		return nil, err
+	}
+
+	s.notifyDirectTrade(trade.TargetID, "direct_trade_received", trade)
+	return trade, nil
+}
+
+// GetDirectTrades obtiene los intercambios directos enviados y recibidos por el jugador
+func (s *TradeService) GetDirectTrades(playerID uuid.UUID, limit int) ([]models.DirectTrade, error) {
+	return s.tradeRepo.GetPlayerDirectTrades(playerID, limit)
+}
+
+// AcceptDirectTrade acepta un intercambio recibido. Cada aldea envía su parte con sus mercaderes,
+// así que ambas necesitan mercaderes libres.
+func (s *TradeService) AcceptDirectTrade(playerID, tradeID uuid.UUID) (*models.DirectTrade, []*models.MerchantShipment, error) {
+	trade, err := s.tradeRepo.GetDirectTrade(tradeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if trade == nil {
+		return nil, nil, repository.ErrDirectTradeNotFound
+	}
+
+	initiatorVillage, err := s.villageRepo.GetVillageByID(trade.InitiatorVillageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	targetVillage, err := s.ownedVillage(playerID, trade.TargetVillageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if initiatorVillage == nil {
+		return nil, nil, fmt.Errorf("aldea del iniciador no encontrada")
+	}
+
+	initiatorDispatch, err := s.merchantDispatch(initiatorVillage, targetVillage)
+	if err != nil {
+		return nil, nil, err
+	}
+	targetDispatch, err := s.merchantDispatch(targetVillage, initiatorVillage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.materializeVillages(trade.InitiatorVillageID, trade.TargetVillageID); err != nil {
+		return nil, nil, err
+	}
+
+	trade, shipments, err := s.tradeRepo.AcceptDirectTrade(tradeID, playerID, initiatorDispatch, targetDispatch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.notifyDirectTrade(trade.InitiatorID, "direct_trade_accepted", trade)
+	for _, shipment := range shipments {
+		s.notifyShipment("shipment_dispatched", shipment)
+	}
+	return trade, shipments, nil
+}
+
+// DeclineDirectTrade rechaza un intercambio recibido o retira uno enviado; lo ofrecido vuelve a
+// la aldea del iniciador
+func (s *TradeService) DeclineDirectTrade(playerID, tradeID uuid.UUID) (*models.DirectTrade, error) {
+	trade, err := s.tradeRepo.GetDirectTrade(tradeID)
+	if err != nil {
+		return nil, err
+	}
+	if trade == nil {
+		return nil, repository.ErrDirectTradeNotFound
+	}
+	if trade.TargetID != playerID && trade.InitiatorID != playerID {
+		return nil, repository.ErrTradeVillageNotOwned
+	}
+
+	if err := s.resourceService.UpdateResources(trade.InitiatorVillageID); err != nil {
+		return nil, err
+	}
+	trade, err = s.tradeRepo.CloseDirectTrade(tradeID, models.DirectTradeDeclined)
+	if err != nil {
+		return nil, err
+	}
+
+	other := trade.InitiatorID
+	if playerID == trade.InitiatorID {
+		other = trade.TargetID
+	}
+	s.notifyDirectTrade(other, "direct_trade_declined", trade)
+	return trade, nil
+}
+
+// ===== MERCADERES =====
+
+// GetVillageShipments obtiene los mercaderes de una aldea del jugador y los envíos que salen de
+// ella o se dirigen a ella
+func (s *TradeService) GetVillageShipments(playerID, villageID uuid.UUID) (*models.VillageShipments, error) {
+	village, err := s.ownedVillage(playerID, villageID)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := s.merchantStatus(village)
+	if err != nil {
+		return nil, err
+	}
+	outgoing, err := s.merchantRepo.GetOutgoingShipments(villageID)
+	if err != nil {
+		return nil, err
+	}
+	incoming, err := s.merchantRepo.GetIncomingShipments(villageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.VillageShipments{
+		VillageID: villageID,
+		Merchants: *status,
+		Outgoing:  outgoing,
+		Incoming:  incoming,
+	}, nil
+}
+
+// merchantStatus calcula los mercaderes de una aldea según el nivel vigente de su mercado
+func (s *TradeService) merchantStatus(village *models.VillageWithDetails) (*models.MerchantStatus, error) {
+	status := &models.MerchantStatus{}
+
+	marketplace, exists := buildingsAt(village, time.Now()).Buildings["marketplace"]
+	if !exists || marketplace.Level <= 0 {
+		return status, nil
+	}
+	config, err := s.buildingConfigRepo.GetBuildingConfig("marketplace", marketplace.Level)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return status, nil
+	}
+
+	busy, err := s.merchantRepo.GetBusyMerchants(village.Village.ID)
+	if err != nil {
+		return nil, err
+	}
+	status.Total = config.Merchants
+	status.Capacity = config.MerchantCapacity
+	status.Busy = busy
+	status.Available = status.Total - busy
+	if status.Available < 0 {
+		status.Available = 0
+	}
+	return status, nil
+}
+
+// requireMarketplace comprueba que la aldea tenga mercaderes con los que atender sus envíos
+func (s *TradeService) requireMarketplace(village *models.VillageWithDetails) error {
+	status, err := s.merchantStatus(village)
+	if err != nil {
+		return err
+	}
+	if status.Total == 0 || status.Capacity == 0 {
+		return ErrMarketplaceRequired
+	}
+	return nil
+}
+
+// merchantDispatch prepara el envío de mercaderes de source a target. Los mercaderes libres se
+// comprueban dentro de la transacción que los ocupa.
+func (s *TradeService) merchantDispatch(source, target *models.VillageWithDetails) (*models.MerchantDispatch, error) {
+	status, err := s.merchantStatus(source)
+	if err != nil {
+		return nil, err
+	}
+	if status.Total == 0 || status.Capacity == 0 {
+		return nil, ErrMarketplaceRequired
+	}
+
+	distance := villageDistance(&source.Village, &target.Village)
+	speed := float64(merchantSpeed)
+	if s.worldSettings != nil {
+		speed *= s.worldSettings.SettingsFor(source.Village.WorldID).UnitSpeed
+	}
+
+	return &models.MerchantDispatch{
+		VillageID:  source.Village.ID,
+		Merchants:  status.Total,
+		Capacity:   status.Capacity,
+		Distance:   distance,
+		TravelTime: CalculateTravelTime(distance, speed),
+	}, nil
+}
+
+// ===== PLANIFICADOR DE COMERCIO =====
+
+// StartTradeScheduler inicia el procesamiento periódico de envíos e intercambios caducados hasta que se cancela el contexto
+func (s *TradeService) StartTradeScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(tradeSchedulerInterval)
+		defer ticker.Stop()
+
+		s.logger.Info("Planificador de comercio iniciado",
+			zap.Duration("interval", tradeSchedulerInterval),
+		)
+
+		for {
+			select {
+			case <-ctx.Done():
+				s.logger.Info("Planificador de comercio detenido")
+				return
+			case <-ticker.C:
+				s.ProcessDueShipments()
+			}
+		}
+	}()
+}
+
+// ProcessDueShipments entrega la mercancía de los envíos que han llegado, libera a los mercaderes
+// que han vuelto y devuelve el depósito de los intercambios directos caducados
+func (s *TradeService) ProcessDueShipments() {
+	now := time.Now()
+
+	arrivals, err := s.merchantRepo.GetDueArrivals(now, tradeBatchSize)
+	if err != nil {
+		s.logger.Error("Error obteniendo llegadas de mercaderes", zap.Error(err))
+	}
+	for _, shipment := range arrivals {
+		if err := s.resourceService.UpdateResources(shipment.TargetVillageID); err != nil {
+			s.logger.Warn("Error materializando recursos", zap.String("village_id", shipment.TargetVillageID.String()), zap.Error(err))
+		}
+		if err := s.merchantRepo.DeliverShipment(shipment); err != nil {
+			if err != sql.ErrNoRows {
+				s.logger.Error("Error entregando envío", zap.String("shipment_id", shipment.ID.String()), zap.Error(err))
+			}
+			continue
+		}
+		s.notifyShipment("shipment_delivered", shipment)
+	}
+
+	returns, err := s.merchantRepo.GetDueReturns(now, tradeBatchSize)
+	if err != nil {
+		s.logger.Error("Error obteniendo regresos de mercaderes", zap.Error(err))
+	}
+	for _, shipment := range returns {
+		if err := s.merchantRepo.CompleteShipment(shipment); err != nil {
+			if err != sql.ErrNoRows {
+				s.logger.Error("Error completando envío", zap.String("shipment_id", shipment.ID.String()), zap.Error(err))
+			}
+			continue
+		}
+		s.notifyShipment("merchants_returned", shipment)
+	}
+
+	expired, err := s.tradeRepo.GetExpiredDirectTrades(now, tradeBatchSize)
+	if err != nil {
+		s.logger.Error("Error obteniendo intercambios caducados", zap.Error(err))
+	}
+	for _, trade := range expired {
+		if err := s.resourceService.UpdateResources(trade.InitiatorVillageID); err != nil {
+			s.logger.Warn("Error materializando recursos", zap.String("village_id", trade.InitiatorVillageID.String()), zap.Error(err))
+		}
+		closed, err := s.tradeRepo.CloseDirectTrade(trade.ID, models.DirectTradeExpired)
+		if err != nil {
+			if !errors.Is(err, repository.ErrDirectTradeNotPending) {
+				s.logger.Error("Error caducando intercambio", zap.String("trade_id", trade.ID.String()), zap.Error(err))
+			}
+			continue
+		}
+		s.notifyDirectTrade(closed.InitiatorID, "direct_trade_expired", closed)
+	}
+}
+
+// ===== AUXILIARES =====
+
+func (s *TradeService) ownedVillage(playerID, villageID uuid.UUID) (*models.VillageWithDetails, error) {
+	village, err := s.villageRepo.GetVillageByID(villageID)
+	if err != nil {
+		return nil, err
+	}
+	if village == nil || village.Village.PlayerID != playerID {
+		return nil, repository.ErrTradeVillageNotOwned
+	}
+	return village, nil
+}
+
+// materializeVillages guarda la producción acumulada de las aldeas para que el comercio trabaje
+// sobre sus existencias actuales
+func (s *TradeService) materializeVillages(villageIDs ...uuid.UUID) error {
+	for _, villageID := range villageIDs {
+		if err := s.resourceService.UpdateResources(villageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyShipment avisa por WebSocket al dueño de los mercaderes y al destinatario de un envío
+func (s *TradeService) notifyShipment(event string, shipment *models.MerchantShipment) {
+	if s.wsManager == nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"event":             event,
+		"shipment_id":       shipment.ID.String(),
+		"status":            shipment.Status,
+		"source_village_id": shipment.SourceVillageID.String(),
+		"target_village_id": shipment.TargetVillageID.String(),
+		"goods":             shipment.Goods,
+		"merchants":         shipment.Merchants,
+		"arrival_time":      shipment.ArrivalTime.Unix(),
+		"return_time":       shipment.ReturnTime.Unix(),
+	}
+
+	recipients := []uuid.UUID{shipment.PlayerID}
+	if shipment.ReceiverID != shipment.PlayerID && event != "merchants_returned" {
+		recipients = append(recipients, shipment.ReceiverID)
+	}
+	for _, playerID := range recipients {
+		if err := s.wsManager.SendToUser(playerID.String(), "shipment_update", data); err != nil {
+			s.logger.Warn("Error enviando actualización de envío", zap.Error(err))
+		}
+	}
+}
+
+// notifyDirectTrade avisa por WebSocket de un cambio en un intercambio directo
+func (s *TradeService) notifyDirectTrade(playerID uuid.UUID, event string, trade *models.DirectTrade) {
+	if s.wsManager == nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"event":    event,
+		"trade_id": trade.ID.String(),
+		"status":   trade.Status,
+		"trade":    trade,
+	}
+	if err := s.wsManager.SendToUser(playerID.String(), "direct_trade_update", data); err != nil {
+		s.logger.Warn("Error enviando actualización de intercambio", zap.Error(err))
+	}
+}
@@ -0,0 +1,436 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"server-backend/models"
+	"server-backend/repository"
+	"server-backend/websocket"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// trainingSchedulerInterval es la frecuencia con la que se entregan las unidades terminadas
+	trainingSchedulerInterval = 5 * time.Second
+	// trainingBatchSize limita los lotes procesados por ciclo
+	trainingBatchSize = 200
+	// trainingLevelsPerSlot es cada cuántos niveles del edificio se gana un lote en paralelo
+	trainingLevelsPerSlot = 10
+	// trainingMaxQueueLength limita los lotes pendientes por edificio
+	trainingMaxQueueLength = 20
+	// trainingCancelRefundRatio es la fracción devuelta por las unidades de un lote ya iniciado;
+	// los lotes que aún esperan en cola se reembolsan por completo
+	trainingCancelRefundRatio = 0.75
+	// militaryResearchSpeedBonus es la reducción de tiempo por nivel de tecnología militar
+	militaryResearchSpeedBonus = 0.01
+	// maxTrainingSpeedBonus limita la reducción total por investigación
+	maxTrainingSpeedBonus = 0.5
+)
+
+var (
+	ErrTrainingQueueFull   = errors.New("la cola de entrenamiento del edificio está llena")
+	ErrInvalidUnitType     = errors.New("tipo de unidad inválido")
+	ErrTrainingNotFound    = errors.New("lote de entrenamiento no encontrado")
+	ErrTrainingBuildingLow = errors.New("nivel de edificio insuficiente para entrenar esta unidad")
+)
+
+type TrainingService struct {
+	trainingRepo       *repository.TrainingRepository
+	unitRepo           *repository.UnitRepository
+	villageRepo        *repository.VillageRepository
+	buildingConfigRepo *repository.BuildingConfigRepository
+	researchRepo       *repository.ResearchRepository
+	resourceService    *ResourceService
+	worldSettings      *WorldSettingsService
+	logger             *zap.Logger
+	wsManager          *websocket.Manager
+}
+
+func NewTrainingService(trainingRepo *repository.TrainingRepository, unitRepo *repository.UnitRepository, villageRepo *repository.VillageRepository, buildingConfigRepo *repository.BuildingConfigRepository, researchRepo *repository.ResearchRepository, resourceService *ResourceService, logger *zap.Logger) *TrainingService {
+	return &TrainingService{
+		trainingRepo:       trainingRepo,
+		unitRepo:           unitRepo,
+		villageRepo:        villageRepo,
+		buildingConfigRepo: buildingConfigRepo,
+		researchRepo:       researchRepo,
+		resourceService:    resourceService,
+		logger:             logger,
+		wsManager:          nil, // Se establecerá después con SetWebSocketManager
+	}
+}
+
+// SetWebSocketManager establece el manager de WebSocket
+func (s *TrainingService) SetWebSocketManager(wsManager *websocket.Manager) {
+	s.wsManager = wsManager
+}
+
+// SetWorldSettingsService establece el servicio de reglas de mundo, que aplica la velocidad de juego
+func (s *TrainingService) SetWorldSettingsService(worldSettings *WorldSettingsService) {
+	s.worldSettings = worldSettings
+}
+
+// TrainUnits paga y encola un lote de unidades en el edificio correspondiente. Si el edificio tiene
+// un hueco libre el lote empieza inmediatamente; si no, espera su turno.
+func (s *TrainingService) TrainUnits(playerID, villageID uuid.UUID, unitType string, quantity int) (*models.TrainingBatch, error) {
+	info, exists := models.UnitTypes[unitType]
+	if !exists {
+		return nil, ErrInvalidUnitType
+	}
+	if quantity <= 0 {
+		return nil, fmt.Errorf("la cantidad debe ser mayor a 0")
+	}
+
+	village, err := s.villageRepo.GetVillageByID(villageID)
+	if err != nil {
+		return nil, err
+	}
+	if village == nil {
+		return nil, fmt.Errorf("aldea no encontrada")
+	}
+	if village.Village.PlayerID != playerID {
+		return nil, fmt.Errorf("la aldea no pertenece al jugador")
+	}
+
+	building, exists := village.Buildings[info.Building]
+	if !exists || building.Level < info.RequiredLevel {
+		return nil, fmt.Errorf("%w: se requiere %s nivel %d", ErrTrainingBuildingLow, info.Building, info.RequiredLevel)
+	}
+
+	queue, err := s.trainingRepo.GetVillageQueue(villageID)
+	if err != nil {
+		return nil, err
+	}
+	pending := 0
+	for _, batch := range queue {
+		if batch.Building == info.Building {
+			pending++
+		}
+	}
+	if pending >= trainingMaxQueueLength {
+		return nil, ErrTrainingQueueFull
+	}
+
+	cost := models.ResourceCostsLegacy{
+		Wood:  info.Cost.Wood,
+		Stone: info.Cost.Stone,
+		Food:  info.Cost.Food,
+		Gold:  info.Cost.Gold,
+	}
+	err = s.resourceService.ConsumeResources(villageID,
+		cost.Wood*quantity, cost.Stone*quantity, cost.Food*quantity, cost.Gold*quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	batch := &models.TrainingBatch{
+		ID:        uuid.New(),
+		VillageID: villageID,
+		Building:  info.Building,
+		UnitType:  unitType,
+		Quantity:  quantity,
+		UnitTime:  s.calculateUnitTime(info, building.Level, playerID, village.Village.WorldID),
+		UnitCost:  cost,
+		Status:    models.TrainingStatusQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := s.trainingRepo.CreateBatch(batch); err != nil {
+		// Devolver lo cobrado si no se pudo encolar
+		if refundErr := s.villageRepo.AddResources(villageID,
+			cost.Wood*quantity, cost.Stone*quantity, cost.Food*quantity, cost.Gold*quantity); refundErr != nil {
+			s.logger.Error("Error devolviendo recursos de entrenamiento", zap.Error(refundErr))
+		}
+		return nil, fmt.Errorf("error encolando entrenamiento: %w", err)
+	}
+
+	s.logger.Info("Entrenamiento encolado",
+		zap.String("village_id", villageID.String()),
+		zap.String("unit_type", unitType),
+		zap.Int("quantity", quantity),
+		zap.Int("unit_time", batch.UnitTime),
+	)
+
+	if err := s.startQueuedBatches(villageID, info.Building, building.Level, now); err != nil {
+		s.logger.Error("Error iniciando lotes en cola", zap.Error(err))
+	}
+
+	// Releer el lote por si ha arrancado inmediatamente
+	if started, err := s.trainingRepo.GetBatch(batch.ID); err == nil && started != nil {
+		batch = started
+	}
+	s.notifyTraining(playerID, "training_queued", batch)
+
+	return batch, nil
+}
+
+// GetTrainingQueue obtiene la cola de entrenamiento de una aldea del jugador
+func (s *TrainingService) GetTrainingQueue(playerID, villageID uuid.UUID) ([]*models.TrainingBatch, error) {
+	village, err := s.villageRepo.GetVillageByID(villageID)
+	if err != nil {
+		return nil, err
+	}
+	if village == nil || village.Village.PlayerID != playerID {
+		return nil, fmt.Errorf("la aldea no pertenece al jugador")
+	}
+	return s.trainingRepo.GetVillageQueue(villageID)
+}
+
+// CancelTraining cancela un lote. Las unidades ya entrenadas se conservan; las pendientes se
+// reembolsan por completo si el lote no había empezado y parcialmente si estaba en marcha.
+func (s *TrainingService) CancelTraining(playerID, batchID uuid.UUID) (*models.TrainingCancelResult, error) {
+	batch, err := s.trainingRepo.GetBatch(batchID)
+	if err != nil {
+		return nil, err
+	}
+	if batch == nil {
+		return nil, ErrTrainingNotFound
+	}
+
+	village, err := s.villageRepo.GetVillageByID(batch.VillageID)
+	if err != nil {
+		return nil, err
+	}
+	if village == nil || village.Village.PlayerID != playerID {
+		return nil, fmt.Errorf("no tienes permisos para cancelar este entrenamiento")
+	}
+	if batch.Status != models.TrainingStatusQueued && batch.Status != models.TrainingStatusTraining {
+		return nil, fmt.Errorf("el lote ya no está en la cola")
+	}
+
+	// Entregar primero las unidades que ya hayan terminado
+	now := time.Now()
+	if batch.Status == models.TrainingStatusTraining {
+		if err := s.deliverUnits(batch, now); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("el lote ya no está en la cola")
+			}
+			return nil, err
+		}
+		if batch.Status == models.TrainingStatusCompleted {
+			return nil, fmt.Errorf("el lote ya ha terminado")
+		}
+	}
+
+	refundRatio := 1.0
+	if batch.Status == models.TrainingStatusTraining {
+		refundRatio = trainingCancelRefundRatio
+	}
+	remaining := batch.Remaining()
+	refund := models.ResourceCostsLegacy{
+		Wood:  int(float64(batch.UnitCost.Wood*remaining) * refundRatio),
+		Stone: int(float64(batch.UnitCost.Stone*remaining) * refundRatio),
+		Food:  int(float64(batch.UnitCost.Food*remaining) * refundRatio),
+		Gold:  int(float64(batch.UnitCost.Gold*remaining) * refundRatio),
+	}
+
+	// El reembolso se suma sobre las existencias materializadas
+	if err := s.resourceService.UpdateResources(batch.VillageID); err != nil {
+		return nil, err
+	}
+
+	wasTraining := batch.Status == models.TrainingStatusTraining
+	if err := s.trainingRepo.CancelBatch(batch, refund); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("el lote ya no está en la cola")
+		}
+		return nil, fmt.Errorf("error cancelando entrenamiento: %w", err)
+	}
+
+	s.logger.Info("Entrenamiento cancelado",
+		zap.String("batch_id", batch.ID.String()),
+		zap.String("unit_type", batch.UnitType),
+		zap.Int("units_cancelled", remaining),
+		zap.Float64("refund_percentage", refundRatio*100),
+	)
+
+	// El hueco liberado lo ocupa el siguiente lote de la cola
+	if wasTraining {
+		if building, exists := village.Buildings[batch.Building]; exists {
+			if err := s.startQueuedBatches(batch.VillageID, batch.Building, building.Level, now); err != nil {
+				s.logger.Error("Error iniciando lotes en cola", zap.Error(err))
+			}
+		}
+	}
+	s.notifyTraining(playerID, "training_cancelled", batch)
+
+	return &models.TrainingCancelResult{
+		BatchID:          batch.ID,
+		UnitType:         batch.UnitType,
+		UnitsCancelled:   remaining,
+		UnitsTrained:     batch.Completed,
+		Refund:           refund,
+		RefundPercentage: refundRatio * 100,
+		CancelledAt:      now,
+	}, nil
+}
+
+// ===== PLANIFICADOR DE ENTRENAMIENTO =====
+
+// StartTrainingScheduler inicia la entrega periódica de unidades terminadas hasta que se cancela el contexto
+func (s *TrainingService) StartTrainingScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(trainingSchedulerInterval)
+		defer ticker.Stop()
+
+		s.logger.Info("Planificador de entrenamiento iniciado",
+			zap.Duration("interval", trainingSchedulerInterval),
+		)
+
+		for {
+			select {
+			case <-ctx.Done():
+				s.logger.Info("Planificador de entrenamiento detenido")
+				return
+			case <-ticker.C:
+				s.ProcessDueTraining()
+			}
+		}
+	}()
+}
+
+// ProcessDueTraining entrega las unidades terminadas y arranca los lotes que quedan libres
+func (s *TrainingService) ProcessDueTraining() {
+	now := time.Now()
+
+	batches, err := s.trainingRepo.GetDueBatches(now, trainingBatchSize)
+	if err != nil {
+		s.logger.Error("Error obteniendo lotes de entrenamiento", zap.Error(err))
+		return
+	}
+
+	for _, batch := range batches {
+		if err := s.deliverUnits(batch, now); err != nil {
+			if err != sql.ErrNoRows {
+				s.logger.Error("Error entregando unidades entrenadas", zap.String("batch_id", batch.ID.String()), zap.Error(err))
+			}
+			continue
+		}
+		if batch.Status != models.TrainingStatusCompleted {
+			continue
+		}
+
+		village, err := s.villageRepo.GetVillageByID(batch.VillageID)
+		if err != nil || village == nil {
+			continue
+		}
+		s.notifyTraining(village.Village.PlayerID, "training_completed", batch)
+
+		// El siguiente lote arranca en el instante en que terminó este, no cuando pasó el planificador
+		if building, exists := village.Buildings[batch.Building]; exists && batch.EndTime != nil {
+			if err := s.startQueuedBatches(batch.VillageID, batch.Building, building.Level, *batch.EndTime); err != nil {
+				s.logger.Error("Error iniciando lotes en cola", zap.Error(err))
+			}
+		}
+	}
+}
+
+// deliverUnits pasa a la guarnición las unidades del lote terminadas hasta now. Devuelve
+// sql.ErrNoRows si otra pasada ya las entregó o el lote se canceló entretanto.
+func (s *TrainingService) deliverUnits(batch *models.TrainingBatch, now time.Time) error {
+	if batch.StartTime == nil || batch.UnitTime <= 0 {
+		return nil
+	}
+
+	done := int(now.Sub(*batch.StartTime) / (time.Duration(batch.UnitTime) * time.Second))
+	if done > batch.Quantity {
+		done = batch.Quantity
+	}
+	ready := done - batch.Completed
+	if ready <= 0 {
+		return nil
+	}
+
+	batch.Completed = done
+	if batch.Completed >= batch.Quantity {
+		batch.Status = models.TrainingStatusCompleted
+		batch.NextCompletionTime = nil
+	} else {
+		next := batch.StartTime.Add(time.Duration(batch.UnitTime*(batch.Completed+1)) * time.Second)
+		batch.NextCompletionTime = &next
+	}
+
+	// Las unidades nuevas aumentan la manutención: se cierra el tramo de producción anterior
+	if err := s.resourceService.CheckpointAt(batch.VillageID, now); err != nil {
+		return err
+	}
+	if err := s.trainingRepo.CompleteUnits(batch, ready); err != nil {
+		return err
+	}
+
+	if s.wsManager != nil {
+		if unit, err := s.unitRepo.GetUnitByVillageAndType(batch.VillageID, batch.UnitType); err == nil && unit != nil {
+			s.wsManager.SendUnitUpdate(batch.VillageID.String(), *unit)
+		}
+	}
+	return nil
+}
+
+// startQueuedBatches ocupa los huecos libres del edificio con los siguientes lotes de la cola
+func (s *TrainingService) startQueuedBatches(villageID uuid.UUID, building string, buildingLevel int, startAt time.Time) error {
+	_, err := s.trainingRepo.StartQueuedBatches(villageID, building, trainingSlots(buildingLevel), startAt)
+	return err
+}
+
+// calculateUnitTime aplica al tiempo base de la unidad el modificador del nivel del edificio, la
+// bonificación de las tecnologías militares del jugador y la velocidad de juego del mundo
+func (s *TrainingService) calculateUnitTime(info models.UnitType, buildingLevel int, playerID, worldID uuid.UUID) int {
+	modifier := 1.0
+	if config, err := s.buildingConfigRepo.GetBuildingConfig(info.Building, buildingLevel); err == nil && config != nil && config.TrainingSpeedModifier > 0 {
+		modifier = config.TrainingSpeedModifier
+	}
+
+	researchBonus := 0.0
+	if technologies, err := s.researchRepo.GetPlayerTechnologies(playerID.String()); err == nil {
+		for _, playerTech := range technologies {
+			tech, err := s.researchRepo.GetTechnology(playerTech.TechnologyID)
+			if err != nil || tech == nil || tech.Category != "military" {
+				continue
+			}
+			researchBonus += float64(playerTech.Level) * militaryResearchSpeedBonus
+		}
+	}
+	if researchBonus > maxTrainingSpeedBonus {
+		researchBonus = maxTrainingSpeedBonus
+	}
+
+	speed := 1.0
+	if s.worldSettings != nil {
+		speed = s.worldSettings.SettingsFor(worldID).GameSpeed
+	}
+
+	unitTime := int(math.Round(float64(info.TrainingTime) * modifier * (1 - researchBonus) / speed))
+	if unitTime < 1 {
+		unitTime = 1
+	}
+	return unitTime
+}
+
+// trainingSlots devuelve cuántos lotes puede entrenar un edificio a la vez según su nivel
+func trainingSlots(buildingLevel int) int {
+	return 1 + buildingLevel/trainingLevelsPerSlot
+}
+
+// notifyTraining envía al jugador el estado de un lote de entrenamiento
+func (s *TrainingService) notifyTraining(playerID uuid.UUID, event string, batch *models.TrainingBatch) {
+	if s.wsManager == nil {
+		return
+	}
+
+	if err := s.wsManager.SendToUser(playerID.String(), "training_update", map[string]interface{}{
+		"event":      event,
+		"batch":      batch,
+		"village_id": batch.VillageID.String(),
+		"timestamp":  time.Now().Unix(),
+	}); err != nil {
+		s.logger.Warn("Error enviando actualización de entrenamiento", zap.Error(err))
+	}
+}
@@ -24,6 +24,7 @@ type WorldService struct {
 	allianceRepo *repository.AllianceRepository
 	battleRepo   *repository.BattleRepository
 	economyRepo  *repository.EconomyRepository
+	spawnService *SpawnService
 	logger       *zap.Logger
 }
 
@@ -34,6 +35,7 @@ func NewWorldService(
 	allianceRepo *repository.AllianceRepository,
 	battleRepo *repository.BattleRepository,
 	economyRepo *repository.EconomyRepository,
+	spawnService *SpawnService,
 	logger *zap.Logger,
 ) *WorldService {
 	return &WorldService{
@@ -43,6 +45,7 @@ func NewWorldService(
 		allianceRepo: allianceRepo,
 		battleRepo:   battleRepo,
 		economyRepo:  economyRepo,
+		spawnService: spawnService,
 		logger:       logger,
 	}
 }
@@ -83,8 +86,9 @@ func (s *WorldService) GetWorldDetails(worldID uuid.UUID) (*models.WorldClientRe
 	return &clientWorld, nil
 }
 
-// JoinWorld maneja la lógica de unirse a un mundo
-func (s *WorldService) JoinWorld(playerID, worldID uuid.UUID, villageName, startingLocation string) (*models.WorldJoinResponse, error) {
+// JoinWorld maneja la lógica de unirse a un mundo. La aldea inicial se coloca según la ubicación
+// elegida (ver SpawnService); nearPlayerID, si no es nil, pide aparecer junto a ese jugador.
+func (s *WorldService) JoinWorld(playerID, worldID uuid.UUID, villageName, startingLocation string, nearPlayerID *uuid.UUID) (*models.WorldJoinResponse, error) {
 	// Verificar que el mundo existe y está disponible
 	world, err := s.worldRepo.GetWorldByID(worldID)
 	if err != nil {
@@ -119,14 +123,21 @@ func (s *WorldService) JoinWorld(playerID, worldID uuid.UUID, villageName, start
 		return nil, err
 	}
 
-	// Crear aldea inicial con coordenadas aleatorias
-	x, y, err := s.villageRepo.GenerateRandomCoordinates(worldID)
-	if err != nil {
-		s.logger.Error("Error generando coordenadas aleatorias", zap.Error(err))
-		return nil, err
+	// Crear aldea inicial según la política de aparición. Si otra aldea ocupa la casilla entre la
+	// búsqueda y la inserción, se busca otra.
+	var village *models.VillageWithDetails
+	for attempt := 0; attempt < spawnPlacementRetries; attempt++ {
+		x, y, spawnErr := s.SpawnLocation(playerID, worldID, startingLocation, nearPlayerID)
+		if spawnErr != nil {
+			s.logger.Error("Error eligiendo ubicación de la aldea inicial", zap.Error(spawnErr))
+			return nil, spawnErr
+		}
+
+		village, err = s.villageRepo.CreateVillage(playerID, worldID, villageName, x, y)
+		if !errors.Is(err, repository.ErrVillageCoordinatesTaken) {
+			break
+		}
 	}
-	
-	village, err := s.villageRepo.CreateVillage(playerID, worldID, villageName, x, y)
 	villageID := ""
 	if err != nil {
 		s.logger.Error("Error creando aldea inicial", zap.Error(err))
@@ -155,6 +166,16 @@ func (s *WorldService) JoinWorld(playerID, worldID uuid.UUID, villageName, start
 	return response, nil
 }
 
+// SpawnLocation elige las coordenadas de la aldea inicial de un jugador en un mundo
+func (s *WorldService) SpawnLocation(playerID, worldID uuid.UUID, startingLocation string, nearPlayerID *uuid.UUID) (int, int, error) {
+	return s.spawnService.FindSpawnLocation(&models.SpawnRequest{
+		WorldID:      worldID,
+		PlayerID:     playerID,
+		Location:     startingLocation,
+		NearPlayerID: nearPlayerID,
+	})
+}
+
 // LeaveWorld maneja la lógica de salir de un mundo
 func (s *WorldService) LeaveWorld(playerID, worldID uuid.UUID) error {
 	// Verificar que el jugador esté en este mundo
@@ -323,7 +344,7 @@ func (s *WorldService) AssignToWorld(playerID uuid.UUID, villageName string) (*m
 	}
 
 	// Usar la lógica existente de JoinWorld para asignar al jugador
-	response, err := s.JoinWorld(playerID, bestWorld.ID, villageName, "auto")
+	response, err := s.JoinWorld(playerID, bestWorld.ID, villageName, models.SpawnAuto, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error asignando jugador al mundo: %w", err)
 	}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"server-backend/models"
+	"server-backend/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// WorldSettingsService gestiona las reglas de juego de cada mundo: velocidades, multiplicadores,
+// límites y condición de fin. Las reglas se guardan en memoria porque se consultan en cada
+// construcción, entrenamiento, marcha y cálculo de producción.
+type WorldSettingsService struct {
+	settingsRepo *repository.WorldSettingsRepository
+	worldRepo    *repository.WorldRepository
+	logger       *zap.Logger
+
+	mu    sync.RWMutex
+	cache map[uuid.UUID]*models.WorldSettings
+}
+
+func NewWorldSettingsService(settingsRepo *repository.WorldSettingsRepository, worldRepo *repository.WorldRepository, logger *zap.Logger) *WorldSettingsService {
+	return &WorldSettingsService{
+		settingsRepo: settingsRepo,
+		worldRepo:    worldRepo,
+		logger:       logger,
+		cache:        make(map[uuid.UUID]*models.WorldSettings),
+	}
+}
+
+// GetSettings obtiene las reglas de un mundo; si nunca se han configurado devuelve las de su tipo
+func (s *WorldSettingsService) GetSettings(worldID uuid.UUID) (*models.WorldSettings, error) {
+	s.mu.RLock()
+	cached, ok := s.cache[worldID]
+	s.mu.RUnlock()
+	if ok {
+		settings := *cached
+		return &settings, nil
+	}
+
+	settings, err := s.settingsRepo.GetSettings(worldID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo reglas del mundo: %w", err)
+	}
+	if settings == nil {
+		world, err := s.worldRepo.GetWorldByID(worldID)
+		if err != nil {
+			return nil, err
+		}
+		if world == nil {
+			return nil, fmt.Errorf("mundo no encontrado")
+		}
+		settings = models.DefaultWorldSettings(worldID, world.WorldType)
+	}
+
+	s.mu.Lock()
+	s.cache[worldID] = settings
+	s.mu.Unlock()
+
+	result := *settings
+	return &result, nil
+}
+
+// SettingsFor devuelve las reglas de un mundo para aplicarlas en el juego. Si no se pueden leer
+// se usan las reglas clásicas para no bloquear la partida.
+func (s *WorldSettingsService) SettingsFor(worldID uuid.UUID) *models.WorldSettings {
+	settings, err := s.GetSettings(worldID)
+	if err != nil {
+		s.logger.Warn("Error obteniendo reglas del mundo, se usan las reglas por defecto",
+			zap.String("world_id", worldID.String()),
+			zap.Error(err),
+		)
+		return models.DefaultWorldSettings(worldID, "normal")
+	}
+	return settings
+}
+
+// UpdateSettings cambia las reglas de un mundo. Solo se permite antes de que el mundo se inicie
+// por primera vez, para no alterar las reglas de una partida en curso.
+func (s *WorldSettingsService) UpdateSettings(worldID uuid.UUID, request *models.UpdateWorldSettingsRequest) (*models.WorldSettings, error) {
+	world, err := s.worldRepo.GetWorldByID(worldID)
+	if err != nil {
+		return nil, err
+	}
+	if world == nil {
+		return nil, fmt.Errorf("mundo no encontrado")
+	}
+	if world.LastStartedAt != nil {
+		return nil, fmt.Errorf("no se pueden cambiar las reglas de un mundo que ya ha empezado")
+	}
+
+	settings, err := s.GetSettings(worldID)
+	if err != nil {
+		return nil, err
+	}
+	request.Apply(settings)
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.settingsRepo.SaveSettings(settings); err != nil {
+		return nil, fmt.Errorf("error guardando reglas del mundo: %w", err)
+	}
+
+	s.mu.Lock()
+	saved := *settings
+	s.cache[worldID] = &saved
+	s.mu.Unlock()
+
+	s.logger.Info("Reglas del mundo actualizadas",
+		zap.String("world_id", worldID.String()),
+		zap.Float64("game_speed", settings.GameSpeed),
+		zap.Float64("unit_speed", settings.UnitSpeed),
+	)
+	return settings, nil
+}
+
+// CheckEndCondition comprueba si el mundo ha alcanzado su condición de fin
+func (s *WorldSettingsService) CheckEndCondition(worldID uuid.UUID) (*models.WorldEndStatus, error) {
+	settings, err := s.GetSettings(worldID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &models.WorldEndStatus{}
+	switch settings.EndCondition {
+	case models.WorldEndDeadline:
+		if settings.EndsAt != nil && !time.Now().Before(*settings.EndsAt) {
+			status.Ended = true
+			status.Reason = "se ha alcanzado la fecha de fin del mundo"
+		}
+	case models.WorldEndDomination:
+		allianceID, share, err := s.settingsRepo.GetLeadingAllianceShare(worldID)
+		if err != nil {
+			return nil, fmt.Errorf("error calculando dominación: %w", err)
+		}
+		status.LeadingAllianceID = allianceID
+		status.LeadingShare = share
+		if allianceID != nil && share >= settings.DominationPercent {
+			status.Ended = true
+			status.Reason = fmt.Sprintf("una alianza controla el %.0f%% de las aldeas", share*100)
+		}
+	}
+	return status, nil
+}
+
+// ProductionModifiers aplica el multiplicador de producción del mundo de la aldea
+func (s *WorldSettingsService) ProductionModifiers(village *models.VillageWithDetails) ([]models.ProductionModifier, error) {
+	settings, err := s.GetSettings(village.Village.WorldID)
+	if err != nil {
+		return nil, err
+	}
+	if settings.ProductionMultiplier == 1 {
+		return nil, nil
+	}
+	return []models.ProductionModifier{{
+		Source:     models.ProductionSourceWorld,
+		Name:       fmt.Sprintf("Velocidad del mundo x%g", settings.ProductionMultiplier),
+		Resource:   models.ProductionResourceAll,
+		Multiplier: settings.ProductionMultiplier,
+	}}, nil
+}